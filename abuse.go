@@ -0,0 +1,243 @@
+package netlistener
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// abuseTopSources is the number of sources with the most rejections listed in an AbuseReport
+	abuseTopSources = 10
+	// abuseMaxSources bounds the distinct sources tracked within a window, the sources beyond it are still counted
+	// in the rejections but not told apart
+	abuseMaxSources = 65536
+)
+
+// AbuseDetection emits EventAbuseDetected when connections of more than Sources distinct remote addresses were rejected
+// within Window, e.g. by the connection caps during a distributed attack, so upstream mitigation can be triggered.
+// Sources are keyed like the per IP state, so with a PeerPrefix the prefixes are counted instead of single addresses
+type AbuseDetection struct {
+	// Sources is the number of distinct rejected sources within a window which is still not considered abuse,
+	// it has to be below 65536, the number of sources told apart within a window
+	Sources int `json:"sources"`
+	// Window is the duration rejections are counted over, the counts start over when it ended
+	Window time.Duration `json:"window"`
+	// Reasons are the reject reasons counted, empty counts all
+	Reasons []RejectReason `json:"reasons,omitempty"`
+	// OnAbuse is called with the report of the window when abuse was detected, after the event was emitted
+	OnAbuse func(report AbuseReport) `json:"-"`
+}
+
+func (d AbuseDetection) validate() error {
+	if d.Sources <= 0 || d.Window <= 0 {
+		return fmt.Errorf("abuse detection needs a positive number of sources and window, got %d sources and a window of %s", d.Sources, d.Window)
+	}
+	if d.Sources >= abuseMaxSources {
+		return fmt.Errorf("abuse detection tells at most %d sources apart, got %d sources", abuseMaxSources, d.Sources)
+	}
+	for _, reason := range d.Reasons {
+		if reason < 0 || reason >= rejectReasons {
+			return fmt.Errorf("abuse detection counts unknown reject reason %d", reason)
+		}
+	}
+
+	return nil
+}
+
+func (d AbuseDetection) counts(reason RejectReason) bool {
+	return len(d.Reasons) == 0 || slices.Contains(d.Reasons, reason)
+}
+
+// AbuseReport summarizes the rejections of the window in which abuse was detected, up to the moment it was
+type AbuseReport struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	// Sources is the number of distinct rejected sources, at most 65536 are told apart
+	Sources    int   `json:"sources"`
+	Rejections int64 `json:"rejections"`
+	// Reasons are the counted rejections by reason
+	Reasons map[RejectReason]int64 `json:"reasons"`
+	// TopSources are the sources with the most rejections, most first
+	TopSources []AbuseSource `json:"top_sources"`
+}
+
+// AbuseSource is a rejected source of an AbuseReport
+type AbuseSource struct {
+	Source     string `json:"source"`
+	Rejections int64  `json:"rejections"`
+}
+
+// String summarizes the report in a line, it is the Details of EventAbuseDetected
+func (r AbuseReport) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d distinct sources with %d rejections within %s", r.Sources, r.Rejections, r.End.Sub(r.Start))
+
+	reasons := make([]string, 0, len(r.Reasons))
+	for reason := range rejectReasons {
+		if count := r.Reasons[reason]; count > 0 {
+			reasons = append(reasons, fmt.Sprintf("%s=%d", reason, count))
+		}
+	}
+	if len(reasons) > 0 {
+		fmt.Fprintf(&b, " (%s)", strings.Join(reasons, ", "))
+	}
+
+	if len(r.TopSources) > 0 {
+		top := make([]string, len(r.TopSources))
+		for i, source := range r.TopSources {
+			top[i] = fmt.Sprintf("%s=%d", source.Source, source.Rejections)
+		}
+		fmt.Fprintf(&b, ", top sources %s", strings.Join(top, ", "))
+	}
+
+	return b.String()
+}
+
+// abuseDetector counts the rejected sources of the current window. A window starts with its first rejection,
+// abuse is reported once per window
+type abuseDetector struct {
+	mu        sync.Mutex
+	detection *AbuseDetection
+
+	start      time.Time
+	sources    map[string]int64
+	rejections int64
+	reasons    [rejectReasons]int64
+	reported   bool
+}
+
+func (d *abuseDetector) set(detection *AbuseDetection) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.detection = detection
+	d.reset(time.Time{})
+}
+
+func (d *abuseDetector) get() *AbuseDetection {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.detection
+}
+
+func (d *abuseDetector) reset(start time.Time) {
+	d.start = start
+	d.sources = nil
+	d.rejections = 0
+	d.reasons = [rejectReasons]int64{}
+	d.reported = false
+}
+
+// record counts a rejection of the source, it returns the report and the detection to call back
+// if the rejection made the window cross the number of sources
+func (d *abuseDetector) record(now time.Time, source string, reason RejectReason) (*AbuseReport, *AbuseDetection) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.detection == nil || !d.detection.counts(reason) {
+		return nil, nil
+	}
+	if d.start.IsZero() || now.Sub(d.start) >= d.detection.Window {
+		d.reset(now)
+	}
+
+	d.rejections++
+	d.reasons[reason]++
+	if source != "" {
+		if d.sources == nil {
+			d.sources = make(map[string]int64)
+		}
+		if _, ok := d.sources[source]; ok || len(d.sources) < abuseMaxSources {
+			d.sources[source]++
+		}
+	}
+
+	if d.reported || len(d.sources) <= d.detection.Sources {
+		return nil, nil
+	}
+	d.reported = true
+
+	report := d.report(now)
+	return &report, d.detection
+}
+
+func (d *abuseDetector) report(now time.Time) AbuseReport {
+	report := AbuseReport{
+		Start:      d.start,
+		End:        now,
+		Sources:    len(d.sources),
+		Rejections: d.rejections,
+		Reasons:    make(map[RejectReason]int64),
+	}
+	for reason, count := range d.reasons {
+		if count > 0 {
+			report.Reasons[RejectReason(reason)] = count
+		}
+	}
+
+	for source, count := range d.sources {
+		report.TopSources = append(report.TopSources, AbuseSource{Source: source, Rejections: count})
+	}
+	slices.SortFunc(report.TopSources, func(a, b AbuseSource) int {
+		if a.Rejections != b.Rejections {
+			return cmp.Compare(b.Rejections, a.Rejections)
+		}
+		return strings.Compare(a.Source, b.Source)
+	})
+	if len(report.TopSources) > abuseTopSources {
+		report.TopSources = report.TopSources[:abuseTopSources]
+	}
+
+	return report
+}
+
+// detectAbuse counts a rejection of the source towards the abuse detection and emits EventAbuseDetected
+// and calls OnAbuse when it crossed the number of sources
+func (c *BandwidthConfig) detectAbuse(now time.Time, source string, reason RejectReason) {
+	report, detection := c.abuse.record(now, source, reason)
+	if report == nil {
+		return
+	}
+
+	c.emit(Event{
+		Type:    EventAbuseDetected,
+		Time:    now,
+		Details: report.String(),
+	})
+	if detection.OnAbuse != nil {
+		detection.OnAbuse(*report)
+	}
+}
+
+// SetAbuseDetection emits EventAbuseDetected when the connections of many distinct sources were rejected within a window,
+// see AbuseDetection. The counts start over. Nil disables it
+func (c *BandwidthConfig) SetAbuseDetection(detection *AbuseDetection) error {
+	if detection != nil {
+		if err := detection.validate(); err != nil {
+			return err
+		}
+		copied := *detection
+		copied.Reasons = slices.Clone(detection.Reasons)
+		detection = &copied
+	}
+
+	c.abuse.set(detection)
+
+	return nil
+}
+
+// AbuseDetection returns the abuse detection, nil if it is disabled
+func (c *BandwidthConfig) AbuseDetection() *AbuseDetection {
+	if detection := c.abuse.get(); detection != nil {
+		copied := *detection
+		copied.Reasons = slices.Clone(detection.Reasons)
+		return &copied
+	}
+
+	return nil
+}
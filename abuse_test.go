@@ -0,0 +1,158 @@
+package netlistener
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAbuseDetection_Validate(t *testing.T) {
+	tests := []struct {
+		name      string
+		detection AbuseDetection
+		wantErr   bool
+	}{
+		{name: "Valid", detection: AbuseDetection{Sources: 100, Window: time.Minute}},
+		{name: "Valid with reasons", detection: AbuseDetection{Sources: 100, Window: time.Minute, Reasons: []RejectReason{RejectPerIPCap}}},
+		{name: "No sources", detection: AbuseDetection{Window: time.Minute}, wantErr: true},
+		{name: "No window", detection: AbuseDetection{Sources: 100}, wantErr: true},
+		{name: "Largest number of sources", detection: AbuseDetection{Sources: abuseMaxSources - 1, Window: time.Minute}},
+		{name: "More sources than are told apart", detection: AbuseDetection{Sources: abuseMaxSources, Window: time.Minute}, wantErr: true},
+		{name: "Unknown reason", detection: AbuseDetection{Sources: 100, Window: time.Minute, Reasons: []RejectReason{rejectReasons}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := NewBandwidthConfig(nil, nil).SetAbuseDetection(&tt.detection); (err != nil) != tt.wantErr {
+				t.Errorf("SetAbuseDetection() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestBandwidthConfig_DetectAbuse(t *testing.T) {
+	tests := []struct {
+		name      string
+		detection AbuseDetection
+		prefix    *PeerPrefix
+		// remotes are the addresses of the connections rejected by the per IP cap
+		remotes  []string
+		expected int
+	}{
+		{
+			name:      "Sources within the limit",
+			detection: AbuseDetection{Sources: 3, Window: time.Minute},
+			remotes:   []string{"192.0.2.1", "192.0.2.2", "192.0.2.3", "192.0.2.3"},
+		},
+		{
+			name:      "Sources over the limit",
+			detection: AbuseDetection{Sources: 3, Window: time.Minute},
+			remotes:   []string{"192.0.2.1", "192.0.2.2", "192.0.2.3", "192.0.2.4"},
+			expected:  1,
+		},
+		{
+			name:      "Reported once per window",
+			detection: AbuseDetection{Sources: 1, Window: time.Minute},
+			remotes:   []string{"192.0.2.1", "192.0.2.2", "192.0.2.3", "192.0.2.4"},
+			expected:  1,
+		},
+		{
+			name:      "Other reasons are not counted",
+			detection: AbuseDetection{Sources: 1, Window: time.Minute, Reasons: []RejectReason{RejectMaxConns}},
+			remotes:   []string{"192.0.2.1", "192.0.2.2", "192.0.2.3"},
+		},
+		{
+			name:      "Sources are aggregated into prefixes",
+			detection: AbuseDetection{Sources: 1, Window: time.Minute},
+			prefix:    &PeerPrefix{IPv6: 64},
+			remotes:   []string{"2001:db8::1", "2001:db8::2", "2001:db8::3"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := NewBandwidthConfig(nil, nil)
+			if err := config.SetPeerPrefix(tt.prefix); err != nil {
+				t.Fatal(err)
+			}
+
+			var reports []AbuseReport
+			tt.detection.OnAbuse = func(report AbuseReport) { reports = append(reports, report) }
+			if err := config.SetAbuseDetection(&tt.detection); err != nil {
+				t.Fatal(err)
+			}
+
+			var events []Event
+			config.SetEventHandler(func(event Event) {
+				if event.Type == EventAbuseDetected {
+					events = append(events, event)
+				}
+			})
+
+			for _, remote := range tt.remotes {
+				server, client := net.Pipe()
+				defer client.Close()
+				conn := &addrConn{Conn: server, remoteAddr: &net.TCPAddr{IP: net.ParseIP(remote), Port: 1234}}
+				config.reject(conn, RejectPerIPCap, "test")
+			}
+
+			if len(events) != tt.expected || len(reports) != tt.expected {
+				t.Fatalf("expected %d abuse events and reports, got %d and %d", tt.expected, len(events), len(reports))
+			}
+			if tt.expected > 0 && reports[0].Sources != tt.detection.Sources+1 {
+				t.Errorf("expected the report to count %d sources, got %d", tt.detection.Sources+1, reports[0].Sources)
+			}
+		})
+	}
+}
+
+func TestAbuseDetector_Windows(t *testing.T) {
+	var detector abuseDetector
+	detector.set(&AbuseDetection{Sources: 1, Window: time.Minute})
+
+	start := time.Now()
+	if report, _ := detector.record(start, "192.0.2.1", RejectMaxConns); report != nil {
+		t.Fatal("expected no report for the first source")
+	}
+	if report, _ := detector.record(start.Add(2*time.Minute), "192.0.2.2", RejectMaxConns); report != nil {
+		t.Fatal("expected the window to start over")
+	}
+	if report, _ := detector.record(start.Add(2*time.Minute+time.Second), "192.0.2.3", RejectMaxConns); report == nil {
+		t.Fatal("expected a report in the new window")
+	}
+}
+
+func TestAbuseReport(t *testing.T) {
+	var detector abuseDetector
+	detector.set(&AbuseDetection{Sources: abuseTopSources, Window: time.Minute})
+
+	start := time.Now()
+	var report *AbuseReport
+	for i := 0; i <= abuseTopSources; i++ {
+		for j := 0; j <= i; j++ {
+			reason := RejectPerIPCap
+			if j == 0 {
+				reason = RejectMaxConns
+			}
+			if r, _ := detector.record(start.Add(time.Second), fmt.Sprintf("192.0.2.%d", i), reason); r != nil {
+				report = r
+			}
+		}
+	}
+
+	// the report is made by the first rejection of the last source
+	if report == nil {
+		t.Fatal("expected a report")
+	}
+	if len(report.TopSources) != abuseTopSources || report.TopSources[0].Source != fmt.Sprintf("192.0.2.%d", abuseTopSources-1) {
+		t.Errorf("expected the top %d sources with the most rejections first, got %+v", abuseTopSources, report.TopSources)
+	}
+	if report.Reasons[RejectMaxConns] != abuseTopSources+1 {
+		t.Errorf("expected %d rejections by max_conns, got %d", abuseTopSources+1, report.Reasons[RejectMaxConns])
+	}
+	if details := report.String(); !strings.Contains(details, "11 distinct sources") || !strings.Contains(details, "max_conns=11") {
+		t.Errorf("unexpected summary %q", details)
+	}
+}
@@ -0,0 +1,193 @@
+package netlistener
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+)
+
+// AcceptOverflow decides what happens to accepted connections when the queue of the accept pool is full
+type AcceptOverflow int
+
+const (
+	// AcceptOverflowBlock stops accepting until a worker is free, connections wait in the backlog of the kernel
+	AcceptOverflowBlock AcceptOverflow = iota
+	// AcceptOverflowReject closes connections right away, they are recorded as RejectAcceptQueueFull
+	AcceptOverflowReject
+)
+
+func (o AcceptOverflow) String() string {
+	switch o {
+	case AcceptOverflowBlock:
+		return "block"
+	case AcceptOverflowReject:
+		return "reject"
+	}
+
+	return "unknown"
+}
+
+// AcceptPool processes accepted connections with a bounded number of workers, so a slow classifier, reverse DNS
+// or connection wrapper does not delay the connections accepted after it
+type AcceptPool struct {
+	// Workers is the number of connections processed at once
+	Workers int `json:"workers"`
+	// Queue is the number of accepted connections waiting for a worker
+	Queue    int            `json:"queue"`
+	Overflow AcceptOverflow `json:"overflow"`
+}
+
+// ErrAcceptPoolStarted is returned when the accept pool is changed after the listener started accepting
+var ErrAcceptPoolStarted = errors.New("accept pool cannot be changed after Accept was called")
+
+// acceptPool accepts in the background and hands the processed connections to Accept in the order they are ready
+type acceptPool struct {
+	config AcceptPool
+
+	queue chan net.Conn
+	ready chan acceptResult
+	done  chan struct{}
+
+	startOnce sync.Once
+	started   atomic.Bool
+	closeOnce sync.Once
+}
+
+// SetAcceptPool processes accepted connections in a pool of workers, nil processes them in Accept.
+// It has to be called before Accept. Connections are handed out by Accept in the order their processing finished
+func (l *Listener) SetAcceptPool(pool *AcceptPool) error {
+	if pool != nil && (pool.Workers <= 0 || pool.Queue < 0) {
+		return fmt.Errorf("accept pool needs workers and a queue which is not negative, got %d workers and a queue of %d", pool.Workers, pool.Queue)
+	}
+
+	l.poolMu.Lock()
+	defer l.poolMu.Unlock()
+
+	if l.pool != nil && l.pool.started.Load() {
+		return ErrAcceptPoolStarted
+	}
+
+	l.pool = nil
+	if pool != nil {
+		l.pool = &acceptPool{
+			config: *pool,
+			queue:  make(chan net.Conn, pool.Queue),
+			ready:  make(chan acceptResult),
+			done:   make(chan struct{}),
+		}
+	}
+
+	return nil
+}
+
+func (l *Listener) acceptPool() *acceptPool {
+	l.poolMu.Lock()
+	defer l.poolMu.Unlock()
+
+	return l.pool
+}
+
+// acceptFrom returns the next processed connection, starting the pool on first use. The connections are wrapped
+// with the wrap of the first caller, so all Accept calls of a listener with a pool should go through the same wrapper
+func (p *acceptPool) acceptFrom(l *Listener, wrap func(conn net.Conn, throttled **ThrottledConn) net.Conn) (net.Conn, error) {
+	p.startOnce.Do(func() {
+		p.started.Store(true)
+		go p.dispatch(l)
+		for range p.config.Workers {
+			go p.work(l, wrap)
+		}
+	})
+
+	select {
+	case result := <-p.ready:
+		return result.conn, result.err
+	case <-p.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// dispatch accepts connections and queues them for the workers, until accepting fails with an error other than a timeout
+func (p *acceptPool) dispatch(l *Listener) {
+	defer close(p.queue)
+
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			select {
+			case p.ready <- acceptResult{err: err}:
+			case <-p.done:
+				return
+			}
+
+			if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+				return
+			}
+			continue
+		}
+		if l.admitted(conn) {
+			select {
+			case p.ready <- acceptResult{conn: conn}:
+			case <-p.done:
+				conn.Close()
+				return
+			}
+			continue
+		}
+
+		if p.config.Overflow == AcceptOverflowReject {
+			select {
+			case p.queue <- conn:
+			default:
+				l.config.reject(conn, RejectAcceptQueueFull, "accept queue full")
+			}
+			continue
+		}
+
+		select {
+		case p.queue <- conn:
+		case <-p.done:
+			conn.Close()
+			return
+		}
+	}
+}
+
+// work processes queued connections until the queue is closed
+func (p *acceptPool) work(l *Listener, wrap func(conn net.Conn, throttled **ThrottledConn) net.Conn) {
+	for conn := range p.queue {
+		throttled, ok := p.admit(l, conn, wrap)
+		if !ok {
+			continue
+		}
+
+		select {
+		case p.ready <- acceptResult{conn: throttled}:
+		case <-p.done:
+			throttled.Close()
+		}
+	}
+}
+
+// admit admits the connection, a panic, e.g. of the classifier, closes it and is passed to the error handler
+func (p *acceptPool) admit(l *Listener, conn net.Conn, wrap func(conn net.Conn, throttled **ThrottledConn) net.Conn) (*ThrottledConn, bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			conn.Close()
+			if !l.config.reportError(&PanicError{Op: "accept", Value: r, Stack: debug.Stack()}) {
+				panic(r)
+			}
+		}
+	}()
+
+	return l.admit(conn, wrap)
+}
+
+// close stops handing out connections, the ones processed afterwards are closed
+func (p *acceptPool) close() {
+	p.closeOnce.Do(func() {
+		close(p.done)
+	})
+}
@@ -0,0 +1,147 @@
+package netlistener
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestListener_SetAcceptPool(t *testing.T) {
+	tests := []struct {
+		name    string
+		pool    *AcceptPool
+		wantErr bool
+	}{
+		{name: "Disabled", pool: nil},
+		{name: "Workers with a queue", pool: &AcceptPool{Workers: 4, Queue: 16}},
+		{name: "No workers", pool: &AcceptPool{Queue: 16}, wantErr: true},
+		{name: "Negative queue", pool: &AcceptPool{Workers: 4, Queue: -1}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			throttledListener, _ := NewListener(listener, nil, nil)
+			defer throttledListener.Close()
+
+			if err := throttledListener.SetAcceptPool(tt.pool); (err != nil) != tt.wantErr {
+				t.Errorf("expected error %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestListener_AcceptPool(t *testing.T) {
+	tests := []struct {
+		name  string
+		pool  AcceptPool
+		conns int
+		// expectedAccepted and expectedRejected are the connections handed out by Accept and rejected for a full queue
+		expectedAccepted int
+		expectedRejected int64
+	}{
+		{name: "Slow classification in parallel", pool: AcceptPool{Workers: 4, Queue: 4}, conns: 4, expectedAccepted: 4},
+		{name: "Blocking overflow keeps connections", pool: AcceptPool{Workers: 1}, conns: 3, expectedAccepted: 3},
+		{name: "Rejecting overflow", pool: AcceptPool{Workers: 1, Overflow: AcceptOverflowReject}, conns: 3, expectedAccepted: 1, expectedRejected: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			throttledListener, err := NewListener(listener, nil, nil, WithAcceptPool(tt.pool))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer throttledListener.Close()
+
+			// classification is held until all connections were dialed, so the queue overflows deterministically
+			release := make(chan struct{})
+			throttledListener.SetClassifier(ClassifierFunc(func(meta ConnMetadata) Classification {
+				<-release
+				time.Sleep(200 * time.Millisecond)
+				return Classification{}
+			}))
+
+			accepted := make(chan net.Conn, tt.conns)
+			go func() {
+				for {
+					conn, err := throttledListener.Accept()
+					if err != nil {
+						return
+					}
+					accepted <- conn
+				}
+			}()
+
+			for range tt.conns {
+				conn, err := net.Dial("tcp", listener.Addr().String())
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer conn.Close()
+			}
+
+			// the rejections happen in the background while the worker is busy
+			deadline := time.Now().Add(2 * time.Second)
+			for throttledListener.Stats().Rejections["accept_queue_full"] < tt.expectedRejected && time.Now().Before(deadline) {
+				time.Sleep(10 * time.Millisecond)
+			}
+			start := time.Now()
+			close(release)
+
+			for range tt.expectedAccepted {
+				select {
+				case conn := <-accepted:
+					conn.Close()
+				case <-time.After(2 * time.Second):
+					t.Fatal("expected a connection to be accepted")
+				}
+			}
+			if tt.pool.Workers == tt.conns {
+				if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+					t.Errorf("expected the connections to be classified in parallel, took %v", elapsed)
+				}
+			}
+
+			if rejected := throttledListener.Stats().Rejections["accept_queue_full"]; rejected != tt.expectedRejected {
+				t.Errorf("expected %d rejected connections, got %d", tt.expectedRejected, rejected)
+			}
+		})
+	}
+}
+
+func TestListener_AcceptPoolClose(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	throttledListener, _ := NewListener(listener, nil, nil, WithAcceptPool(AcceptPool{Workers: 2}))
+
+	result := make(chan error, 1)
+	go func() {
+		_, err := throttledListener.Accept()
+		result <- err
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	if err := throttledListener.SetAcceptPool(nil); !errors.Is(err, ErrAcceptPoolStarted) {
+		t.Errorf("expected ErrAcceptPoolStarted, got %v", err)
+	}
+
+	throttledListener.Close()
+	select {
+	case err := <-result:
+		if !errors.Is(err, net.ErrClosed) {
+			t.Errorf("expected net.ErrClosed, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected Accept to return after Close")
+	}
+}
@@ -0,0 +1,97 @@
+package netlistener
+
+import (
+	"fmt"
+	"time"
+)
+
+// AccountingMode decides how often the bytes of the connections are recorded in the stats, usage windows,
+// throughput history and the counters of peers and sessions
+type AccountingMode int
+
+const (
+	// AccountingPerOperation records the bytes of every read and write right away, the stats are exact
+	AccountingPerOperation AccountingMode = iota
+	// AccountingSampled only counts the bytes in the connection on each operation, a sampler records what the
+	// connections transferred since the previous sample every interval. It saves CPU on very hot servers,
+	// while the stats lag behind by up to an interval and usage and throughput see the bytes at the time of the sample
+	AccountingSampled
+)
+
+func (m AccountingMode) String() string {
+	switch m {
+	case AccountingPerOperation:
+		return "per_operation"
+	case AccountingSampled:
+		return "sampled"
+	}
+
+	return "unknown"
+}
+
+// accountingSampler records the bytes of the open connections every interval
+type accountingSampler struct {
+	interval time.Duration
+}
+
+// SetAccountingMode chooses between exact per operation accounting and sampled accounting every interval, see AccountingMode.
+// The interval is ignored per operation. Switching back to per operation records what was not sampled yet
+func (c *BandwidthConfig) SetAccountingMode(mode AccountingMode, interval time.Duration) error {
+	switch mode {
+	case AccountingPerOperation:
+		c.accounting.Store(nil)
+		c.SampleAccounting()
+	case AccountingSampled:
+		if interval <= 0 {
+			return fmt.Errorf("sampled accounting needs a positive interval, got %s", interval)
+		}
+
+		sampler := &accountingSampler{interval: interval}
+		c.accounting.Store(sampler)
+		go c.runAccountingSampler(sampler)
+	default:
+		return fmt.Errorf("unknown accounting mode %d", mode)
+	}
+
+	return nil
+}
+
+// AccountingMode returns the accounting mode and the interval of sampled accounting, zero per operation
+func (c *BandwidthConfig) AccountingMode() (AccountingMode, time.Duration) {
+	if sampler := c.accounting.Load(); sampler != nil {
+		return AccountingSampled, sampler.interval
+	}
+
+	return AccountingPerOperation, 0
+}
+
+// SampleAccounting records what the open connections transferred since the previous sample, e.g. before reading exact stats in tests
+func (c *BandwidthConfig) SampleAccounting() {
+	now := time.Now()
+	for _, conn := range c.conns.all() {
+		conn.sample(now)
+	}
+}
+
+// runAccountingSampler samples all connections every interval until the sampler is replaced
+func (c *BandwidthConfig) runAccountingSampler(sampler *accountingSampler) {
+	ticker := time.NewTicker(sampler.interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if c.accounting.Load() != sampler {
+			return
+		}
+		c.SampleAccounting()
+	}
+}
+
+// sample records the bytes the connection transferred since the previous sample
+func (c *ThrottledConn) sample(now time.Time) {
+	if read := c.unsampledRead.Swap(0); read != 0 {
+		c.recordRead(now, int(read))
+	}
+	if written := c.unsampledWritten.Swap(0); written != 0 {
+		c.recordWrite(now, int(written))
+	}
+}
@@ -0,0 +1,119 @@
+package netlistener
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestThrottledConn_SampledAccounting(t *testing.T) {
+	tests := []struct {
+		name     string
+		interval time.Duration
+		// wait is how long the stats are read after the write
+		wait time.Duration
+		// done ends the sampled accounting of the connection
+		done func(config *BandwidthConfig, conn *ThrottledConn)
+		// expected are the bytes written in the stats before and after done
+		expected     int64
+		expectedDone int64
+	}{
+		{
+			name:         "Sampled explicitly",
+			interval:     time.Hour,
+			done:         func(config *BandwidthConfig, conn *ThrottledConn) { config.SampleAccounting() },
+			expected:     0,
+			expectedDone: 100,
+		},
+		{
+			name:         "Sampled every interval",
+			interval:     10 * time.Millisecond,
+			wait:         50 * time.Millisecond,
+			done:         func(config *BandwidthConfig, conn *ThrottledConn) {},
+			expected:     100,
+			expectedDone: 100,
+		},
+		{
+			name:         "Sampled on close",
+			interval:     time.Hour,
+			done:         func(config *BandwidthConfig, conn *ThrottledConn) { conn.Close() },
+			expected:     0,
+			expectedDone: 100,
+		},
+		{
+			name:     "Switched back to per operation",
+			interval: time.Hour,
+			done: func(config *BandwidthConfig, conn *ThrottledConn) {
+				config.SetAccountingMode(AccountingPerOperation, 0)
+			},
+			expected:     0,
+			expectedDone: 100,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := NewBandwithConfig(nil, nil)
+			if err := config.SetAccountingMode(AccountingSampled, tt.interval); err != nil {
+				t.Fatal(err)
+			}
+			defer config.SetAccountingMode(AccountingPerOperation, 0)
+
+			connRead, connWrite := net.Pipe()
+			throttledConn := NewThrottledConnection(connWrite, NewConnectionBandwithConfig(config))
+			defer throttledConn.Close()
+			go readDataFromConn(connRead)
+
+			if _, err := throttledConn.Write(make([]byte, 100)); err != nil {
+				t.Fatal(err)
+			}
+			time.Sleep(tt.wait)
+
+			if written := throttledConn.bytesWritten.Load(); written != 100 {
+				t.Errorf("expected the connection to count the bytes right away, got %d", written)
+			}
+			if written := config.Stats().BytesWritten; written != tt.expected {
+				t.Errorf("expected %d bytes written before the sample, got %d", tt.expected, written)
+			}
+			tt.done(config, throttledConn)
+			if written := config.Stats().BytesWritten; written != tt.expectedDone {
+				t.Errorf("expected %d bytes written after the sample, got %d", tt.expectedDone, written)
+			}
+		})
+	}
+}
+
+func TestBandwidthConfig_SetAccountingMode(t *testing.T) {
+	tests := []struct {
+		name             string
+		mode             AccountingMode
+		interval         time.Duration
+		wantErr          bool
+		expected         string
+		expectedInterval time.Duration
+	}{
+		{name: "Per operation", mode: AccountingPerOperation, interval: time.Second, expected: "per_operation"},
+		{name: "Sampled", mode: AccountingSampled, interval: time.Second, expected: "sampled", expectedInterval: time.Second},
+		{name: "Sampled without interval", mode: AccountingSampled, wantErr: true, expected: "per_operation"},
+		{name: "Unknown mode", mode: AccountingMode(7), interval: time.Second, wantErr: true, expected: "per_operation"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := NewBandwidthConfig(nil, nil)
+			err := config.SetAccountingMode(tt.mode, tt.interval)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SetAccountingMode() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			defer config.SetAccountingMode(AccountingPerOperation, 0)
+
+			mode, interval := config.AccountingMode()
+			if mode.String() != tt.expected || interval != tt.expectedInterval {
+				t.Errorf("AccountingMode() = %s, %s, expected %s, %s", mode, interval, tt.expected, tt.expectedInterval)
+			}
+			if stats := config.Stats(); stats.Accounting != tt.expected {
+				t.Errorf("expected the stats to report %s accounting, got %s", tt.expected, stats.Accounting)
+			}
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package netlistener
+
+import (
+	"net"
+	"strconv"
+)
+
+// remoteIP returns the IP of the remote side of the connection, or nil if it is not an IP based connection
+func remoteIP(conn net.Conn) net.IP {
+	return addrIP(conn.RemoteAddr())
+}
+
+// peerKey returns the remote IP as a string, or an empty string if the connection is not IP based
+func peerKey(conn net.Conn) string {
+	if ip := remoteIP(conn); ip != nil {
+		return ip.String()
+	}
+
+	return ""
+}
+
+func addrIP(addr net.Addr) net.IP {
+	if addr == nil {
+		return nil
+	}
+
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a.IP
+	case *net.UDPAddr:
+		return a.IP
+	case *net.IPAddr:
+		return a.IP
+	}
+
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return nil
+	}
+
+	return net.ParseIP(host)
+}
+
+// addrPort returns the port of the address, or 0 if it has none
+func addrPort(addr net.Addr) int {
+	if addr == nil {
+		return 0
+	}
+
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a.Port
+	case *net.UDPAddr:
+		return a.Port
+	}
+
+	_, port, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return 0
+	}
+
+	p, err := strconv.Atoi(port)
+	if err != nil {
+		return 0
+	}
+
+	return p
+}
@@ -0,0 +1,141 @@
+package netlistener
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// NewAdminHandler returns a handler exposing the configuration and stats of the listener as JSON.
+// It is meant to be served on an internal port only, e.g.
+//
+//	mux.Handle("/netlistener/", http.StripPrefix("/netlistener", netlistener.NewAdminHandler(l)))
+func NewAdminHandler(l *Listener) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /config", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, l.Snapshot())
+	})
+
+	// the declarative configuration is versioned, the version is its ETag and writes have to pass it in If-Match,
+	// so an operator does not overwrite changes made since they read the configuration
+	mux.HandleFunc("GET /config/listener", func(w http.ResponseWriter, r *http.Request) {
+		config, version := l.VersionedConfig()
+		w.Header().Set("ETag", formatETag(version))
+		writeJSON(w, http.StatusOK, config)
+	})
+
+	mux.HandleFunc("PUT /config/listener", func(w http.ResponseWriter, r *http.Request) {
+		ifMatch := r.Header.Get("If-Match")
+		if ifMatch == "" {
+			writeJSON(w, http.StatusPreconditionRequired, map[string]string{"error": "If-Match with the version of the configuration is required"})
+			return
+		}
+		version, err := parseETag(ifMatch)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+
+		var config ListenerConfig
+		if err := json.NewDecoder(r.Body).Decode(&config); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+
+		report, err := l.ApplyVersion(config, version)
+		switch {
+		case errors.Is(err, ErrConfigVersionConflict):
+			w.Header().Set("ETag", formatETag(report.Version))
+			writeJSON(w, http.StatusPreconditionFailed, map[string]string{"error": err.Error()})
+		case err != nil:
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		default:
+			w.Header().Set("ETag", formatETag(report.Version))
+			writeJSON(w, http.StatusOK, report)
+		}
+	})
+
+	mux.HandleFunc("GET /stats", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, l.Stats())
+	})
+
+	mux.HandleFunc("GET /stats/classes", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, l.ClassStats())
+	})
+
+	mux.HandleFunc("GET /peers", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, l.PeerStates())
+	})
+
+	mux.HandleFunc("GET /conns", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, l.ConnLifecycles())
+	})
+
+	mux.HandleFunc("GET /rejections", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, l.RecentRejections())
+	})
+
+	mux.HandleFunc("GET /throughput", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, l.ThroughputHistory())
+	})
+
+	// e.g. /top?n=10&window=10s&by=peer, the parameters default to 10 talkers over 10 seconds by connection
+	mux.HandleFunc("GET /top", func(w http.ResponseWriter, r *http.Request) {
+		n, window, by := 10, 10*time.Second, TalkersByConn
+
+		query := r.URL.Query()
+		var err error
+		if value := query.Get("n"); value != "" {
+			if n, err = strconv.Atoi(value); err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+				return
+			}
+		}
+		if value := query.Get("window"); value != "" {
+			if window, err = time.ParseDuration(value); err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+				return
+			}
+		}
+		if value := query.Get("by"); value != "" {
+			if by, err = ParseTalkerGrouping(value); err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+				return
+			}
+		}
+
+		writeJSON(w, http.StatusOK, l.TopTalkers(n, window, by))
+	})
+
+	return mux
+}
+
+func formatETag(version uint64) string {
+	return strconv.Quote(strconv.FormatUint(version, 10))
+}
+
+func parseETag(etag string) (uint64, error) {
+	unquoted, err := strconv.Unquote(strings.TrimSpace(etag))
+	if err != nil {
+		return 0, fmt.Errorf("invalid version %s, expected a quoted number", etag)
+	}
+
+	version, err := strconv.ParseUint(unquoted, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid version %s, expected a quoted number", etag)
+	}
+
+	return version, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	_ = json.NewEncoder(w).Encode(value)
+}
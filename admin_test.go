@@ -0,0 +1,112 @@
+package netlistener
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAdminHandler(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Failed to create listener", err)
+	}
+	defer listener.Close()
+
+	throttledListener, _ := NewListener(listener, ptr(1000), ptr(100))
+	handler := NewAdminHandler(throttledListener)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		status int
+	}{
+		{name: "Config", method: http.MethodGet, path: "/config", status: http.StatusOK},
+		{name: "Stats", method: http.MethodGet, path: "/stats", status: http.StatusOK},
+		{name: "Class stats", method: http.MethodGet, path: "/stats/classes", status: http.StatusOK},
+		{name: "Peers", method: http.MethodGet, path: "/peers", status: http.StatusOK},
+		{name: "Throughput", method: http.MethodGet, path: "/throughput", status: http.StatusOK},
+		{name: "Connection lifecycles", method: http.MethodGet, path: "/conns", status: http.StatusOK},
+		{name: "Rejections", method: http.MethodGet, path: "/rejections", status: http.StatusOK},
+		{name: "Top talkers", method: http.MethodGet, path: "/top?n=5&window=5s&by=peer", status: http.StatusOK},
+		{name: "Top talkers with unknown grouping", method: http.MethodGet, path: "/top?by=tenant", status: http.StatusBadRequest},
+		{name: "Unknown path", method: http.MethodGet, path: "/unknown", status: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(tt.method, tt.path, nil))
+
+			if recorder.Code != tt.status {
+				t.Errorf("expected status %d, got %d", tt.status, recorder.Code)
+			}
+		})
+	}
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/config", nil))
+
+	snapshot := ConfigSnapshot{}
+	if err := json.NewDecoder(recorder.Body).Decode(&snapshot); err != nil {
+		t.Fatal("failed to decode snapshot", err)
+	}
+	if snapshot.PerConnWriteLimit == nil || *snapshot.PerConnWriteLimit != 100 {
+		t.Errorf("expected per conn write limit of 100, got %v", snapshot.PerConnWriteLimit)
+	}
+}
+
+func TestAdminHandler_ListenerConfig(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Failed to create listener", err)
+	}
+	defer listener.Close()
+
+	throttledListener, _ := NewListener(listener, ptr(1000), ptr(100))
+	handler := NewAdminHandler(throttledListener)
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/config/listener", nil))
+	etag := recorder.Header().Get("ETag")
+	if recorder.Code != http.StatusOK || etag == "" {
+		t.Fatalf("expected config with an ETag, got status %d and ETag %q", recorder.Code, etag)
+	}
+
+	tests := []struct {
+		name    string
+		ifMatch string
+		body    string
+		status  int
+	}{
+		{name: "Missing version", body: `{"global_limit": 2000}`, status: http.StatusPreconditionRequired},
+		{name: "Malformed version", ifMatch: "latest", body: `{"global_limit": 2000}`, status: http.StatusBadRequest},
+		{name: "Invalid config", ifMatch: etag, body: `{"exempt_cidrs": ["10.0.0.0"]}`, status: http.StatusBadRequest},
+		{name: "Current version", ifMatch: etag, body: `{"global_limit": 2000, "per_conn_limit": 100}`, status: http.StatusOK},
+		{name: "Stale version", ifMatch: etag, body: `{"global_limit": 3000}`, status: http.StatusPreconditionFailed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodPut, "/config/listener", strings.NewReader(tt.body))
+			if tt.ifMatch != "" {
+				request.Header.Set("If-Match", tt.ifMatch)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, request)
+
+			if recorder.Code != tt.status {
+				t.Errorf("expected status %d, got %d: %s", tt.status, recorder.Code, recorder.Body)
+			}
+		})
+	}
+
+	if config := throttledListener.Config(); *config.GlobalLimit != 2000 {
+		t.Errorf("expected global limit of 2000, got %d", *config.GlobalLimit)
+	}
+}
@@ -0,0 +1,86 @@
+package netlistener
+
+import (
+	"errors"
+	"net"
+	"sync"
+)
+
+// AIMDConfig bounds and tunes an AIMDController, limits are in bytes per second
+type AIMDConfig struct {
+	Min int
+	Max int
+	// Initial is the limit the connection starts with, defaults to Max
+	Initial int
+	// Increase is added to the limit on every success
+	Increase int
+	// Decrease multiplies the limit on congestion, defaults to 0.5
+	Decrease float64
+}
+
+// AIMDController adjusts the per connection limit of a connection from signals of the application,
+// e.g. an adaptive streaming server reporting delivered segments and buffer underruns of the client.
+// The limit grows additively on success and shrinks multiplicatively on congestion, staying within Min and Max.
+// It replaces the per connection limit of the connection, including one set by the classification
+type AIMDController struct {
+	conn  *ThrottledConn
+	cfg   AIMDConfig
+	limit int
+
+	mu sync.Mutex
+}
+
+func NewAIMDController(conn net.Conn, cfg AIMDConfig) (*AIMDController, error) {
+	throttled, ok := conn.(*ThrottledConn)
+	if !ok {
+		return nil, ErrNotThrottled
+	}
+
+	if cfg.Min <= 0 || cfg.Max < cfg.Min {
+		return nil, errors.New("aimd bounds must satisfy 0 < Min <= Max")
+	}
+	if cfg.Decrease <= 0 || cfg.Decrease >= 1 {
+		cfg.Decrease = 0.5
+	}
+	if cfg.Initial == 0 {
+		cfg.Initial = cfg.Max
+	}
+
+	controller := &AIMDController{conn: throttled, cfg: cfg}
+	controller.apply(cfg.Initial)
+
+	return controller, nil
+}
+
+// Success reports that the connection keeps up, the limit grows by Increase
+func (c *AIMDController) Success() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.apply(c.limit + c.cfg.Increase)
+}
+
+// Congestion reports that the connection falls behind, the limit is multiplied by Decrease
+func (c *AIMDController) Congestion() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.apply(int(float64(c.limit) * c.cfg.Decrease))
+}
+
+// Limit returns the current limit of the connection
+func (c *AIMDController) Limit() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.limit
+}
+
+// apply sets the limit, bounded by Min and Max, as the per connection limit override of the connection
+func (c *AIMDController) apply(limit int) {
+	c.limit = min(max(limit, c.cfg.Min), c.cfg.Max)
+
+	classification := c.conn.config.Classification()
+	classification.PerConnLimit = &c.limit
+	c.conn.config.SetClassification(classification)
+}
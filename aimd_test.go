@@ -0,0 +1,67 @@
+package netlistener
+
+import (
+	"net"
+	"testing"
+
+	"golang.org/x/time/rate"
+)
+
+func TestAIMDController(t *testing.T) {
+	config := NewBandwithConfig(nil, ptr(10000))
+
+	connRead, connWrite := net.Pipe()
+	defer connRead.Close()
+	conn := NewThrottledConnection(connWrite, NewConnectionBandwithConfig(config))
+	defer conn.Close()
+
+	controller, err := NewAIMDController(conn, AIMDConfig{Min: 100, Max: 1000, Initial: 400, Increase: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		signal   func()
+		expected int
+	}{
+		{name: "Success increases additively", signal: controller.Success, expected: 500},
+		{name: "Congestion decreases multiplicatively", signal: controller.Congestion, expected: 250},
+		{name: "Limit does not drop below the minimum", signal: func() {
+			controller.Congestion()
+			controller.Congestion()
+		}, expected: 100},
+		{name: "Limit does not grow over the maximum", signal: func() {
+			for i := 0; i < 20; i++ {
+				controller.Success()
+			}
+		}, expected: 1000},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.signal()
+
+			if limit := controller.Limit(); limit != tt.expected {
+				t.Errorf("expected limit %d, got %d", tt.expected, limit)
+			}
+			if limit := conn.perConnLimit(config.PerConnWriteLimit(), false); limit != rate.Limit(tt.expected) {
+				t.Errorf("expected the connection to be limited to %d, got %v", tt.expected, limit)
+			}
+		})
+	}
+}
+
+func TestNewAIMDController_Validation(t *testing.T) {
+	connRead, connWrite := net.Pipe()
+	defer connRead.Close()
+	conn := NewThrottledConnection(connWrite, NewConnectionBandwithConfig(NewBandwithConfig(nil, nil)))
+	defer conn.Close()
+
+	if _, err := NewAIMDController(conn, AIMDConfig{Min: 100, Max: 50}); err == nil {
+		t.Error("expected an error for Max below Min")
+	}
+	if _, err := NewAIMDController(connRead, AIMDConfig{Min: 1, Max: 2}); err != ErrNotThrottled {
+		t.Errorf("expected ErrNotThrottled for a plain connection, got %v", err)
+	}
+}
@@ -0,0 +1,183 @@
+package netlistener
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// AlertSample is what alert conditions are evaluated against, it is taken on every evaluation
+type AlertSample struct {
+	Stats Stats
+	// Interval is the time since the previous sample, zero for the first one
+	Interval time.Duration
+	// ReadRate and WriteRate are the throughput since the previous sample in bytes per second
+	ReadRate  float64
+	WriteRate float64
+	// ReadSaturation and WriteSaturation are the rates relative to the global limits, e.g. 0.9 for 90%, zero while unlimited
+	ReadSaturation  float64
+	WriteSaturation float64
+}
+
+// AlertRule fires once its condition held in every evaluation for the duration of For, and resolves on the first evaluation it does not.
+// E.g. global read saturation above 90% for 5 minutes:
+//
+//	AlertRule{Name: "read-saturated", For: 5 * time.Minute, Condition: func(s AlertSample) bool { return s.ReadSaturation > 0.9 }}
+type AlertRule struct {
+	Name      string
+	Condition func(sample AlertSample) bool
+	For       time.Duration
+}
+
+// Alert is passed to the alert handler when a rule fires and when it resolves
+type Alert struct {
+	Rule string
+	// Firing is true when the rule fired and false when it resolved
+	Firing bool
+	// Since is when the condition started to hold
+	Since time.Time
+	Time  time.Time
+}
+
+// AlertHandler is called synchronously from the evaluation, so it should not block
+type AlertHandler func(alert Alert)
+
+type alertState struct {
+	rule    AlertRule
+	handler AlertHandler
+	// since is when the condition started to hold, zero while it does not
+	since  time.Time
+	firing bool
+}
+
+// alertEvaluator holds the alert rules and the counters of the previous sample
+type alertEvaluator struct {
+	rules []*alertState
+
+	lastTime              time.Time
+	lastRead, lastWritten int64
+
+	mu sync.Mutex
+}
+
+// AddAlertRule registers the rule, the handler may be nil if the EventAlertFiring and EventAlertResolved events are enough.
+// The rules are evaluated by RunAlerts
+func (c *BandwidthConfig) AddAlertRule(rule AlertRule, handler AlertHandler) error {
+	if rule.Name == "" || rule.Condition == nil {
+		return fmt.Errorf("alert rule needs a name and a condition")
+	}
+
+	c.alerts.mu.Lock()
+	defer c.alerts.mu.Unlock()
+
+	for _, state := range c.alerts.rules {
+		if state.rule.Name == rule.Name {
+			return fmt.Errorf("alert rule %q is already registered", rule.Name)
+		}
+	}
+
+	c.alerts.rules = append(c.alerts.rules, &alertState{rule: rule, handler: handler})
+
+	return nil
+}
+
+// RemoveAlertRule removes the rule by name, an alert which is firing is not resolved
+func (c *BandwidthConfig) RemoveAlertRule(name string) {
+	c.alerts.mu.Lock()
+	defer c.alerts.mu.Unlock()
+
+	for i, state := range c.alerts.rules {
+		if state.rule.Name == name {
+			c.alerts.rules = append(c.alerts.rules[:i], c.alerts.rules[i+1:]...)
+			return
+		}
+	}
+}
+
+// RunAlerts evaluates the alert rules every interval until the context is done
+func (c *BandwidthConfig) RunAlerts(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		c.evaluateAlerts(time.Now())
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// evaluateAlerts takes a sample and evaluates every rule against it
+func (c *BandwidthConfig) evaluateAlerts(now time.Time) {
+	sample := AlertSample{Stats: c.Stats()}
+
+	c.mu.RLock()
+	readLimit, writeLimit := c.globalReadLimiter.Limit(), c.globalWriteLimiter.Limit()
+	c.mu.RUnlock()
+
+	c.alerts.mu.Lock()
+	if !c.alerts.lastTime.IsZero() {
+		sample.Interval = now.Sub(c.alerts.lastTime)
+		seconds := sample.Interval.Seconds()
+		sample.ReadRate = float64(sample.Stats.BytesRead-c.alerts.lastRead) / seconds
+		sample.WriteRate = float64(sample.Stats.BytesWritten-c.alerts.lastWritten) / seconds
+	}
+	c.alerts.lastTime, c.alerts.lastRead, c.alerts.lastWritten = now, sample.Stats.BytesRead, sample.Stats.BytesWritten
+
+	if readLimit != rate.Inf && readLimit > 0 {
+		sample.ReadSaturation = sample.ReadRate / float64(readLimit)
+	}
+	if writeLimit != rate.Inf && writeLimit > 0 {
+		sample.WriteSaturation = sample.WriteRate / float64(writeLimit)
+	}
+
+	var alerts []Alert
+	var handlers []AlertHandler
+	for _, state := range c.alerts.rules {
+		if alert, changed := state.evaluate(sample, now); changed {
+			alerts = append(alerts, alert)
+			handlers = append(handlers, state.handler)
+		}
+	}
+	c.alerts.mu.Unlock()
+
+	// handlers are called without the lock, so they may add or remove rules
+	for i, alert := range alerts {
+		eventType := EventAlertResolved
+		if alert.Firing {
+			eventType = EventAlertFiring
+		}
+		c.emit(Event{Type: eventType, Time: now, Details: alert.Rule})
+
+		if handlers[i] != nil {
+			handlers[i](alert)
+		}
+	}
+}
+
+// evaluate applies the sample to the rule, returning the alert to report if it fired or resolved
+func (s *alertState) evaluate(sample AlertSample, now time.Time) (Alert, bool) {
+	if !s.rule.Condition(sample) {
+		since, firing := s.since, s.firing
+		s.since, s.firing = time.Time{}, false
+
+		return Alert{Rule: s.rule.Name, Since: since, Time: now}, firing
+	}
+
+	if s.since.IsZero() {
+		s.since = now
+	}
+
+	if !s.firing && now.Sub(s.since) >= s.rule.For {
+		s.firing = true
+		return Alert{Rule: s.rule.Name, Firing: true, Since: s.since, Time: now}, true
+	}
+
+	return Alert{}, false
+}
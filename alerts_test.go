@@ -0,0 +1,62 @@
+package netlistener
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBandwithConfig_EvaluateAlerts(t *testing.T) {
+	config := NewBandwithConfig(ptr(100), nil)
+
+	var alerts []Alert
+	var events []EventType
+	config.SetEventHandler(func(event Event) {
+		events = append(events, event.Type)
+	})
+	err := config.AddAlertRule(AlertRule{
+		Name:      "write-saturated",
+		For:       2 * time.Second,
+		Condition: func(sample AlertSample) bool { return sample.WriteSaturation > 0.9 },
+	}, func(alert Alert) {
+		alerts = append(alerts, alert)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := config.AddAlertRule(AlertRule{Name: "write-saturated", Condition: func(AlertSample) bool { return true }}, nil); err == nil {
+		t.Error("expected error for a duplicate rule")
+	}
+
+	// bytes written in the second before each evaluation, the limit is 100 bytes per second
+	steps := []struct {
+		written int64
+		firing  *bool
+	}{
+		{written: 0},
+		{written: 95},
+		{written: 100},
+		{written: 100, firing: ptr(true)},
+		{written: 99},
+		{written: 10, firing: ptr(false)},
+	}
+
+	start := time.Now()
+	for i, step := range steps {
+		alerts = nil
+		config.stats.bytesWritten.Add(step.written)
+		config.evaluateAlerts(start.Add(time.Duration(i) * time.Second))
+
+		switch {
+		case step.firing == nil && len(alerts) > 0:
+			t.Errorf("step %d: expected no alert, got %+v", i, alerts)
+		case step.firing != nil && (len(alerts) != 1 || alerts[0].Firing != *step.firing):
+			t.Errorf("step %d: expected alert firing %t, got %+v", i, *step.firing, alerts)
+		}
+	}
+
+	expected := []EventType{EventAlertFiring, EventAlertResolved}
+	if len(events) != len(expected) || events[0] != expected[0] || events[1] != expected[1] {
+		t.Errorf("expected events %v, got %v", expected, events)
+	}
+}
@@ -0,0 +1,282 @@
+package netlistener
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"slices"
+	"sort"
+)
+
+// ListenerConfig is the declarative part of the configuration of a listener, which Apply changes as a whole.
+// Limits are in bytes per second, nil means unlimited
+type ListenerConfig struct {
+	GlobalLimit  *int `json:"global_limit"`
+	PerConnLimit *int `json:"per_conn_limit"`
+	// FamilyLimits are the limits of the address families by "ipv4" and "ipv6", a missing family is unlimited
+	FamilyLimits map[string]*int `json:"family_limits,omitempty"`
+
+	Classes      []ClassConfig `json:"classes,omitempty"`
+	DefaultClass string        `json:"default_class,omitempty"`
+	// DefaultProfile is the name of the profile of connections the classifier did not assign one
+	DefaultProfile string `json:"default_profile,omitempty"`
+
+	ExemptCIDRs   []string `json:"exempt_cidrs,omitempty"`
+	MaxConns      int64    `json:"max_conns,omitempty"`
+	MaxConnsPerIP int      `json:"max_conns_per_ip,omitempty"`
+}
+
+// ErrConfigVersionConflict is returned by ApplyVersion when the configuration changed since the expected version was read
+var ErrConfigVersionConflict = errors.New("configuration changed since the expected version")
+
+// ConfigChange is a single setting changed by Apply. Old and New are nil for limits which are unlimited
+// and for classes which were added or removed
+type ConfigChange struct {
+	// Field is the name of the setting as in the JSON of ListenerConfig, classes and families are suffixed with their name, e.g. "classes.video"
+	Field string `json:"field"`
+	Old   any    `json:"old"`
+	New   any    `json:"new"`
+}
+
+// ChangeReport describes what Apply changed, it has no changes if the configuration was already in place
+type ChangeReport struct {
+	Changes []ConfigChange `json:"changes"`
+	// Version is the version of the configuration after applying
+	Version uint64 `json:"version"`
+}
+
+// Changed reports whether the field or any of its suffixed entries changed
+func (r ChangeReport) Changed(field string) bool {
+	for _, change := range r.Changes {
+		if change.Field == field || len(change.Field) > len(field) && change.Field[:len(field)+1] == field+"." {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Config returns the current declarative configuration, which can be changed and passed to Apply
+func (c *BandwidthConfig) Config() ListenerConfig {
+	c.mu.RLock()
+	config := ListenerConfig{
+		GlobalLimit:  limitToInt(c.globalReadLimiter.Limit()),
+		PerConnLimit: limitToInt(c.perConnReadLimit),
+	}
+	// limits being ramped are configured to their target already
+	if ramp := c.ramps[rampGlobal]; ramp != nil {
+		config.GlobalLimit = cloneLimit(ramp.target)
+	}
+	if ramp := c.ramps[rampPerConn]; ramp != nil {
+		config.PerConnLimit = cloneLimit(ramp.target)
+	}
+	c.mu.RUnlock()
+
+	config.FamilyLimits = c.families.Limits()
+	config.Classes, config.DefaultClass = c.classes.Configs()
+	config.ExemptCIDRs = c.exemptions.CIDRs()
+	config.MaxConns, config.MaxConnsPerIP = c.caps.Get()
+	config.DefaultProfile = c.profiles.Default()
+
+	return config
+}
+
+// VersionedConfig returns the current declarative configuration with its version.
+// The version grows with every change, including the ones made through the setters instead of Apply
+func (c *BandwidthConfig) VersionedConfig() (ListenerConfig, uint64) {
+	c.applyMu.Lock()
+	defer c.applyMu.Unlock()
+
+	config := c.Config()
+
+	return config, c.versionLocked(config)
+}
+
+// versionLocked returns the version of the current configuration, starting a new version if it differs from the one
+// the last version was given to. applyMu has to be held
+func (c *BandwidthConfig) versionLocked(current ListenerConfig) uint64 {
+	if c.configVersion == 0 || !reflect.DeepEqual(current, c.versionedConfig) {
+		c.configVersion++
+		c.versionedConfig = current
+	}
+
+	return c.configVersion
+}
+
+// Apply changes the configuration to the given one and reports the difference to the previous configuration.
+// The whole configuration is validated before the first setting is changed, so a failing Apply changes nothing.
+// Apply is not atomic: the settings are changed one after another, connections accepted and limits read
+// while it is applied may see a part of the change. Concurrent calls are applied one after another
+func (c *BandwidthConfig) Apply(config ListenerConfig) (ChangeReport, error) {
+	c.applyMu.Lock()
+	defer c.applyMu.Unlock()
+
+	return c.applyLocked(c.Config(), config)
+}
+
+// ApplyVersion is Apply failing with ErrConfigVersionConflict if the configuration is no longer at the expected version,
+// so concurrent writers do not overwrite each other's changes without noticing
+func (c *BandwidthConfig) ApplyVersion(config ListenerConfig, expectedVersion uint64) (ChangeReport, error) {
+	c.applyMu.Lock()
+	defer c.applyMu.Unlock()
+
+	current := c.Config()
+	if version := c.versionLocked(current); version != expectedVersion {
+		return ChangeReport{Version: version}, fmt.Errorf("%w: expected version %d, current version is %d", ErrConfigVersionConflict, expectedVersion, version)
+	}
+
+	return c.applyLocked(current, config)
+}
+
+func (c *BandwidthConfig) applyLocked(current, config ListenerConfig) (ChangeReport, error) {
+	config, err := normalizeListenerConfig(config)
+	if err != nil {
+		return ChangeReport{}, fmt.Errorf("config not applied: %w", err)
+	}
+	if config.DefaultProfile != "" && !c.profiles.Exists(config.DefaultProfile) {
+		return ChangeReport{}, fmt.Errorf("config not applied: unknown profile %q", config.DefaultProfile)
+	}
+
+	report := diffListenerConfig(current, config)
+	if len(report.Changes) == 0 {
+		report.Version = c.versionLocked(current)
+		return report, nil
+	}
+
+	// the configuration was validated as a whole, so none of the setters fails and a failing Apply changes nothing
+	if report.Changed("exempt_cidrs") {
+		c.SetExemptCIDRs(config.ExemptCIDRs...)
+	}
+	if report.Changed("classes") || report.Changed("default_class") {
+		c.SetClasses(config.Classes, config.DefaultClass)
+	}
+	if report.Changed("default_profile") {
+		c.SetDefaultProfile(config.DefaultProfile)
+	}
+	if report.Changed("global_limit") {
+		c.SetGlobalLimit(config.GlobalLimit)
+	}
+	if report.Changed("per_conn_limit") {
+		c.SetPerConnLimit(config.PerConnLimit)
+	}
+	for _, family := range []AddressFamily{FamilyIPv4, FamilyIPv6} {
+		if report.Changed("family_limits." + family.String()) {
+			c.SetFamilyLimit(family, config.FamilyLimits[family.String()])
+		}
+	}
+	if report.Changed("max_conns") || report.Changed("max_conns_per_ip") {
+		c.SetMaxConns(config.MaxConns, config.MaxConnsPerIP)
+	}
+
+	applied := c.Config()
+	report.Version = c.versionLocked(applied)
+	c.notifyConfigLocked(current, applied)
+
+	return report, nil
+}
+
+// normalizeListenerConfig validates the configuration and brings it into the form Config returns, so they can be compared
+func normalizeListenerConfig(config ListenerConfig) (ListenerConfig, error) {
+	if _, err := validateClasses(config.Classes, config.DefaultClass); err != nil {
+		return config, err
+	}
+
+	for name := range config.FamilyLimits {
+		if name != FamilyIPv4.String() && name != FamilyIPv6.String() {
+			return config, fmt.Errorf("unknown address family %q", name)
+		}
+	}
+
+	familyLimits := make(map[string]*int, len(config.FamilyLimits))
+	for name, limit := range config.FamilyLimits {
+		if limit != nil {
+			familyLimits[name] = limit
+		}
+	}
+	config.FamilyLimits = nil
+	if len(familyLimits) > 0 {
+		config.FamilyLimits = familyLimits
+	}
+
+	cidrs := make([]string, 0, len(config.ExemptCIDRs))
+	for _, cidr := range config.ExemptCIDRs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return config, fmt.Errorf("invalid exemption CIDR %q: %w", cidr, err)
+		}
+
+		cidrs = append(cidrs, ipNet.String())
+	}
+	config.ExemptCIDRs = cidrs
+
+	config.Classes = slices.Clone(config.Classes)
+	sort.SliceStable(config.Classes, func(i, j int) bool {
+		return config.Classes[i].Name < config.Classes[j].Name
+	})
+
+	return config, nil
+}
+
+// diffListenerConfig lists the settings which differ between the configurations
+func diffListenerConfig(old, new ListenerConfig) ChangeReport {
+	var report ChangeReport
+	add := func(field string, oldValue, newValue any) {
+		report.Changes = append(report.Changes, ConfigChange{Field: field, Old: oldValue, New: newValue})
+	}
+
+	if !reflect.DeepEqual(old.GlobalLimit, new.GlobalLimit) {
+		add("global_limit", old.GlobalLimit, new.GlobalLimit)
+	}
+	if !reflect.DeepEqual(old.PerConnLimit, new.PerConnLimit) {
+		add("per_conn_limit", old.PerConnLimit, new.PerConnLimit)
+	}
+
+	for _, family := range []AddressFamily{FamilyIPv4, FamilyIPv6} {
+		oldLimit, newLimit := old.FamilyLimits[family.String()], new.FamilyLimits[family.String()]
+		if !reflect.DeepEqual(oldLimit, newLimit) {
+			add("family_limits."+family.String(), oldLimit, newLimit)
+		}
+	}
+
+	oldClasses := make(map[string]ClassConfig, len(old.Classes))
+	for _, class := range old.Classes {
+		oldClasses[class.Name] = class
+	}
+	newClasses := make(map[string]ClassConfig, len(new.Classes))
+	for _, class := range new.Classes {
+		newClasses[class.Name] = class
+	}
+	for _, class := range old.Classes {
+		if _, ok := newClasses[class.Name]; !ok {
+			add("classes."+class.Name, &class, nil)
+		}
+	}
+	for _, class := range new.Classes {
+		oldClass, ok := oldClasses[class.Name]
+		switch {
+		case !ok:
+			add("classes."+class.Name, nil, &class)
+		case !reflect.DeepEqual(oldClass, class):
+			add("classes."+class.Name, &oldClass, &class)
+		}
+	}
+
+	if old.DefaultClass != new.DefaultClass {
+		add("default_class", old.DefaultClass, new.DefaultClass)
+	}
+	if old.DefaultProfile != new.DefaultProfile {
+		add("default_profile", old.DefaultProfile, new.DefaultProfile)
+	}
+	if !slices.Equal(old.ExemptCIDRs, new.ExemptCIDRs) {
+		add("exempt_cidrs", old.ExemptCIDRs, new.ExemptCIDRs)
+	}
+	if old.MaxConns != new.MaxConns {
+		add("max_conns", old.MaxConns, new.MaxConns)
+	}
+	if old.MaxConnsPerIP != new.MaxConnsPerIP {
+		add("max_conns_per_ip", old.MaxConnsPerIP, new.MaxConnsPerIP)
+	}
+
+	return report
+}
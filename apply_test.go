@@ -0,0 +1,144 @@
+package netlistener
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestBandwithConfig_Apply(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  ListenerConfig
+		changed []string
+		// expected is the configuration afterwards, the initial one if applying fails
+		expected ListenerConfig
+		wantErr  bool
+	}{
+		{
+			name: "Changes are applied and reported",
+			config: ListenerConfig{
+				GlobalLimit:  ptr(2000),
+				PerConnLimit: ptr(100),
+				FamilyLimits: map[string]*int{"ipv6": ptr(500), "ipv4": nil},
+				Classes:      []ClassConfig{{Name: "video", Rate: 800}, {Name: "bulk", Rate: 200}},
+				DefaultClass: "bulk",
+				ExemptCIDRs:  []string{"10.1.2.3/8"},
+				MaxConns:     10,
+			},
+			// the CIDR is the configured one once normalized
+			changed: []string{"global_limit", "family_limits.ipv6", "classes.bulk", "classes.video", "default_class", "max_conns"},
+			expected: ListenerConfig{
+				GlobalLimit:  ptr(2000),
+				PerConnLimit: ptr(100),
+				FamilyLimits: map[string]*int{"ipv6": ptr(500)},
+				Classes:      []ClassConfig{{Name: "bulk", Rate: 200}, {Name: "video", Rate: 800}},
+				DefaultClass: "bulk",
+				ExemptCIDRs:  []string{"10.0.0.0/8"},
+				MaxConns:     10,
+			},
+		},
+		{
+			name:     "Unchanged configuration reports nothing",
+			config:   initialListenerConfig(),
+			expected: initialListenerConfig(),
+		},
+		{
+			name: "Invalid CIDR changes nothing",
+			config: ListenerConfig{
+				GlobalLimit: ptr(2000),
+				ExemptCIDRs: []string{"10.0.0.0"},
+			},
+			expected: initialListenerConfig(),
+			wantErr:  true,
+		},
+		{
+			name: "Invalid class changes nothing",
+			config: ListenerConfig{
+				GlobalLimit:  ptr(2000),
+				Classes:      []ClassConfig{{Name: "video", Parent: "missing", Rate: 800}},
+				ExemptCIDRs:  []string{"192.168.0.0/16"},
+				DefaultClass: "video",
+			},
+			expected: initialListenerConfig(),
+			wantErr:  true,
+		},
+		{
+			name: "Unknown profile changes nothing",
+			config: ListenerConfig{
+				GlobalLimit:    ptr(2000),
+				ExemptCIDRs:    []string{"192.168.0.0/16"},
+				DefaultProfile: "missing",
+			},
+			expected: initialListenerConfig(),
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := NewBandwithConfig(nil, nil)
+			if _, err := config.Apply(initialListenerConfig()); err != nil {
+				t.Fatal(err)
+			}
+
+			report, err := config.Apply(tt.config)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expected error %t, got %v", tt.wantErr, err)
+			}
+
+			var changed []string
+			for _, change := range report.Changes {
+				changed = append(changed, change.Field)
+			}
+			if !reflect.DeepEqual(changed, tt.changed) {
+				t.Errorf("expected changes %v, got %v", tt.changed, changed)
+			}
+
+			if current := config.Config(); !reflect.DeepEqual(current, tt.expected) {
+				t.Errorf("expected config %+v, got %+v", tt.expected, current)
+			}
+		})
+	}
+}
+
+func initialListenerConfig() ListenerConfig {
+	return ListenerConfig{
+		GlobalLimit:  ptr(1000),
+		PerConnLimit: ptr(100),
+		Classes:      []ClassConfig{{Name: "video", Rate: 500}},
+		ExemptCIDRs:  []string{"10.0.0.0/8"},
+	}
+}
+
+func TestBandwithConfig_ApplyVersion(t *testing.T) {
+	config := NewBandwithConfig(ptr(1000), nil)
+
+	_, version := config.VersionedConfig()
+	if _, again := config.VersionedConfig(); again != version {
+		t.Fatalf("expected the version %d to stay without changes, got %d", version, again)
+	}
+
+	report, err := config.ApplyVersion(ListenerConfig{GlobalLimit: ptr(2000)}, version)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Version != version+1 {
+		t.Errorf("expected version %d after applying, got %d", version+1, report.Version)
+	}
+
+	// a writer still holding the old version must not overwrite the change
+	if _, err := config.ApplyVersion(ListenerConfig{GlobalLimit: ptr(3000)}, version); !errors.Is(err, ErrConfigVersionConflict) {
+		t.Errorf("expected version conflict, got %v", err)
+	}
+
+	// changes made through the setters start a new version as well
+	config.SetPerConnLimit(ptr(100))
+	if _, err := config.ApplyVersion(ListenerConfig{GlobalLimit: ptr(3000)}, report.Version); !errors.Is(err, ErrConfigVersionConflict) {
+		t.Errorf("expected version conflict after a setter changed the config, got %v", err)
+	}
+
+	if current := config.Config(); *current.GlobalLimit != 2000 {
+		t.Errorf("expected global limit to stay at 2000, got %d", *current.GlobalLimit)
+	}
+}
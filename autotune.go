@@ -0,0 +1,149 @@
+package netlistener
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// UtilizationTarget tunes TuneGlobalLimit, limits and the capacity are in bytes per second
+type UtilizationTarget struct {
+	// Capacity is the physical capacity of the link
+	Capacity int
+	// Target is the utilization of the link to keep, in percent of the capacity, e.g. 80 leaves 20% for unshaped system traffic
+	Target float64
+	// Min and Max bound the global limit, Max defaults to the target share of the capacity
+	Min int
+	Max int
+	// Gain is the part of the difference between the measured and the target utilization corrected each interval, defaults to 0.5
+	Gain float64
+}
+
+func (t UtilizationTarget) validate() (UtilizationTarget, error) {
+	if t.Capacity <= 0 {
+		return t, errors.New("link capacity must be positive")
+	}
+	if t.Target <= 0 || t.Target > 100 {
+		return t, fmt.Errorf("utilization target %v%% out of range", t.Target)
+	}
+	if t.Max == 0 {
+		t.Max = t.goal()
+	}
+	if t.Min <= 0 || t.Max < t.Min {
+		return t, errors.New("global limit bounds must satisfy 0 < Min <= Max")
+	}
+	if t.Gain <= 0 || t.Gain > 1 {
+		t.Gain = 0.5
+	}
+
+	return t, nil
+}
+
+// goal returns the link throughput to keep in bytes per second
+func (t UtilizationTarget) goal() int {
+	return int(float64(t.Capacity) * t.Target / 100)
+}
+
+// LinkCounter returns the bytes received and transmitted by the link in total, including traffic which is not shaped
+type LinkCounter func() (rx, tx uint64, err error)
+
+// InterfaceCounter reads the counters of a network interface from sysfs, e.g. "eth0". Supported on linux only
+func InterfaceCounter(name string) LinkCounter {
+	dir := filepath.Join("/sys/class/net", name, "statistics")
+
+	return func() (uint64, uint64, error) {
+		rx, err := readCounter(filepath.Join(dir, "rx_bytes"))
+		if err != nil {
+			return 0, 0, err
+		}
+		tx, err := readCounter(filepath.Join(dir, "tx_bytes"))
+		if err != nil {
+			return 0, 0, err
+		}
+
+		return rx, tx, nil
+	}
+}
+
+func readCounter(path string) (uint64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, fmt.Errorf("reading link counter: %w", err)
+	}
+
+	return strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+}
+
+// utilizationTuner adjusts the global limit from the link utilization measured between two samples
+type utilizationTuner struct {
+	target UtilizationTarget
+	limit  int
+
+	lastRx, lastTx uint64
+	lastAt         time.Time
+}
+
+// next returns the global limit after the link transferred the counters at now, false for the first sample
+func (t *utilizationTuner) next(rx, tx uint64, now time.Time) (int, bool) {
+	first := t.lastAt.IsZero()
+	elapsed := now.Sub(t.lastAt).Seconds()
+	// counters which went backwards were reset, e.g. the interface was recreated
+	reset := rx < t.lastRx || tx < t.lastTx
+	deltaRx, deltaTx := rx-t.lastRx, tx-t.lastTx
+	t.lastRx, t.lastTx, t.lastAt = rx, tx, now
+
+	if first || reset || elapsed <= 0 {
+		return t.limit, false
+	}
+
+	// the global limit applies to both directions, so the busier one decides
+	measured := float64(max(deltaRx, deltaTx)) / elapsed
+	adjusted := float64(t.limit) + t.target.Gain*(float64(t.target.goal())-measured)
+	t.limit = min(max(int(adjusted), t.target.Min), t.target.Max)
+
+	return t.limit, true
+}
+
+// TuneGlobalLimit adjusts the global limit every interval to keep the utilization of the link measured by the counter
+// near the target share of its capacity, leaving headroom for unshaped system traffic. The limit is raised while the link
+// is below the target and lowered while it is above it, within Min and Max. It blocks until the context is done or the
+// counter fails, the last limit stays in effect afterwards
+func (c *BandwidthConfig) TuneGlobalLimit(ctx context.Context, target UtilizationTarget, counter LinkCounter, interval time.Duration) error {
+	target, err := target.validate()
+	if err != nil {
+		return err
+	}
+
+	tuner := &utilizationTuner{target: target, limit: target.Max}
+	if limit := c.GlobalWriteLimiter().Limit(); limit != rate.Inf {
+		tuner.limit = min(max(int(limit), target.Min), target.Max)
+	}
+	c.SetGlobalLimit(Limit(tuner.limit))
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		rx, tx, err := counter()
+		if err != nil {
+			return err
+		}
+
+		if limit, ok := tuner.next(rx, tx, time.Now()); ok {
+			c.SetGlobalLimit(Limit(limit))
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
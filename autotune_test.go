@@ -0,0 +1,98 @@
+package netlistener
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+func TestUtilizationTuner(t *testing.T) {
+	target, err := UtilizationTarget{Capacity: 10000, Target: 80, Min: 1000}.validate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tuner := &utilizationTuner{target: target, limit: 4000}
+	start := time.Unix(0, 0)
+
+	tests := []struct {
+		name     string
+		rx, tx   uint64
+		at       time.Duration
+		expected int
+		ok       bool
+	}{
+		{name: "First sample only records the counters", rx: 0, tx: 0, at: 0, expected: 4000},
+		{name: "Limit is raised while the link is below the target", rx: 1000, tx: 4000, at: time.Second, expected: 6000, ok: true},
+		{name: "Limit is lowered while the link is above the target", rx: 2000, tx: 14000, at: 2 * time.Second, expected: 5000, ok: true},
+		{name: "Limit does not drop below the minimum", rx: 2000, tx: 34000, at: 3 * time.Second, expected: 1000, ok: true},
+		{name: "Idle link raises the limit", rx: 2000, tx: 34000, at: 4 * time.Second, expected: 5000, ok: true},
+		{name: "Limit does not grow over the target share of the capacity", rx: 2000, tx: 34000, at: 5 * time.Second, expected: 8000, ok: true},
+		{name: "Counter reset skips the sample", rx: 10, tx: 10, at: 6 * time.Second, expected: 8000},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limit, ok := tuner.next(tt.rx, tt.tx, start.Add(tt.at))
+			if limit != tt.expected || ok != tt.ok {
+				t.Errorf("expected limit %d (%v), got %d (%v)", tt.expected, tt.ok, limit, ok)
+			}
+		})
+	}
+}
+
+func TestUtilizationTargetValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		target UtilizationTarget
+		valid  bool
+	}{
+		{name: "Valid target", target: UtilizationTarget{Capacity: 1000, Target: 90, Min: 100}, valid: true},
+		{name: "Missing capacity", target: UtilizationTarget{Target: 90, Min: 100}},
+		{name: "Target over 100%", target: UtilizationTarget{Capacity: 1000, Target: 120, Min: 100}},
+		{name: "Minimum over the maximum", target: UtilizationTarget{Capacity: 1000, Target: 90, Min: 500, Max: 400}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.target.validate(); (err == nil) != tt.valid {
+				t.Errorf("expected valid %v, got %v", tt.valid, err)
+			}
+		})
+	}
+}
+
+func TestTuneGlobalLimit(t *testing.T) {
+	config := NewBandwithConfig(ptr(2000), nil)
+
+	failure := errors.New("counter unavailable")
+	var samples int
+	counter := func() (uint64, uint64, error) {
+		samples++
+		if samples > 2 {
+			return 0, 0, failure
+		}
+		return 0, 0, nil
+	}
+
+	err := config.TuneGlobalLimit(context.Background(), UtilizationTarget{Capacity: 10000, Target: 50, Min: 1000}, counter, time.Millisecond)
+	if !errors.Is(err, failure) {
+		t.Fatalf("expected the counter error, got %v", err)
+	}
+
+	// an idle link raises the limit by the gain times the missing utilization
+	if limit := config.GlobalWriteLimiter().Limit(); limit != rate.Limit(4500) {
+		t.Errorf("expected global limit 4500, got %v", limit)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = config.TuneGlobalLimit(ctx, UtilizationTarget{Capacity: 10000, Target: 50, Min: 1000}, func() (uint64, uint64, error) {
+		return 0, 0, nil
+	}, time.Millisecond)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context cancellation, got %v", err)
+	}
+}
@@ -0,0 +1,202 @@
+package netlistener
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// UsageRecord is the traffic of a tenant during an export interval, tenants are the classes of the connections
+type UsageRecord struct {
+	Start time.Time
+	End   time.Time
+	// Tenant is the name of the class, empty for the connections without a class. Child classes are reported on their own
+	Tenant       string
+	Conns        int64
+	BytesRead    int64
+	BytesWritten int64
+	// Cost is the bytes multiplied by the cost of the class, see ClassConfig.Cost
+	Cost float64
+}
+
+// UsageWriter stores the records of an export, e.g. CSVUsageWriter or a writer producing parquet for a billing pipeline
+type UsageWriter interface {
+	WriteUsage(records []UsageRecord) error
+}
+
+// UsageExporter periodically writes the traffic of every tenant since the previous export to a UsageWriter,
+// so billing pipelines can consume usage without scraping the stats
+type UsageExporter struct {
+	config *BandwidthConfig
+	writer UsageWriter
+
+	// last are the counters of the previous export by tenant, lastTime is when it happened
+	last     map[string]Stats
+	lastTime time.Time
+
+	mu sync.Mutex
+}
+
+// NewUsageExporter creates an exporter of the usage of the listener, the first export covers the traffic since its creation
+func NewUsageExporter(l *Listener, writer UsageWriter) *UsageExporter {
+	e := &UsageExporter{config: l.config, writer: writer}
+	e.last, e.lastTime = e.tenantStats(), time.Now()
+
+	return e
+}
+
+// Run exports every interval until the context is done, the usage since the last export is exported before it returns
+func (e *UsageExporter) Run(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return errors.Join(ctx.Err(), e.Export())
+		case <-ticker.C:
+			if err := e.Export(); err != nil {
+				e.config.reportError(fmt.Errorf("usage export: %w", err))
+			}
+		}
+	}
+}
+
+// Export writes the usage of every tenant with traffic or new connections since the previous export.
+// If writing fails, the usage is exported again with the next export
+func (e *UsageExporter) Export() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	now := time.Now()
+	current := e.tenantStats()
+
+	var records []UsageRecord
+	for tenant, stats := range current {
+		delta := stats.sub(e.last[tenant])
+		if delta.AcceptedConns == 0 && delta.BytesRead == 0 && delta.BytesWritten == 0 {
+			continue
+		}
+
+		records = append(records, UsageRecord{
+			Start:        e.lastTime,
+			End:          now,
+			Tenant:       tenant,
+			Conns:        delta.AcceptedConns,
+			BytesRead:    delta.BytesRead,
+			BytesWritten: delta.BytesWritten,
+			Cost:         float64(delta.BytesRead+delta.BytesWritten) * e.config.classes.Cost(e.config.classes.lookup(tenant)),
+		})
+	}
+	sortUsageRecords(records)
+
+	if len(records) > 0 {
+		if err := e.writer.WriteUsage(records); err != nil {
+			return err
+		}
+	}
+	e.last, e.lastTime = current, now
+
+	return nil
+}
+
+// tenantStats returns the own stats of every class by name, the connections without a class under ""
+func (e *UsageExporter) tenantStats() map[string]Stats {
+	stats := make(map[string]Stats)
+
+	var walk func(class ClassStats)
+	walk = func(class ClassStats) {
+		stats[class.Class] = class.Own
+		for _, child := range class.Children {
+			walk(child)
+		}
+	}
+	walk(e.config.ClassStats())
+
+	return stats
+}
+
+func sortUsageRecords(records []UsageRecord) {
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Tenant < records[j].Tenant
+	})
+}
+
+// usageCSVHeader is the first row of every file written by CSVUsageWriter
+var usageCSVHeader = []string{"start", "end", "tenant", "conns", "bytes_read", "bytes_written", "cost"}
+
+// CSVUsageWriter appends usage records to CSV files in a directory. With a rotation, a new file is started for every period,
+// named after the start of the period in UTC, e.g. usage-20240501T000000Z.csv for a daily rotation. Without one all records
+// go to usage.csv
+type CSVUsageWriter struct {
+	dir      string
+	prefix   string
+	rotation time.Duration
+}
+
+// NewCSVUsageWriter writes to dir, which is created if needed. The prefix of the file names defaults to "usage"
+func NewCSVUsageWriter(dir, prefix string, rotation time.Duration) (*CSVUsageWriter, error) {
+	if rotation < 0 {
+		return nil, fmt.Errorf("negative rotation %v", rotation)
+	}
+	if prefix == "" {
+		prefix = "usage"
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	return &CSVUsageWriter{dir: dir, prefix: prefix, rotation: rotation}, nil
+}
+
+// Path returns the file the records of an export ending at the time are written to
+func (w *CSVUsageWriter) Path(end time.Time) string {
+	if w.rotation == 0 {
+		return filepath.Join(w.dir, w.prefix+".csv")
+	}
+
+	return filepath.Join(w.dir, w.prefix+"-"+end.UTC().Truncate(w.rotation).Format("20060102T150405Z")+".csv")
+}
+
+func (w *CSVUsageWriter) WriteUsage(records []UsageRecord) error {
+	if len(records) == 0 {
+		return nil
+	}
+
+	file, err := os.OpenFile(w.Path(records[0].End), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	writer := csv.NewWriter(file)
+	if info.Size() == 0 {
+		writer.Write(usageCSVHeader)
+	}
+	for _, record := range records {
+		writer.Write([]string{
+			record.Start.UTC().Format(time.RFC3339),
+			record.End.UTC().Format(time.RFC3339),
+			record.Tenant,
+			strconv.FormatInt(record.Conns, 10),
+			strconv.FormatInt(record.BytesRead, 10),
+			strconv.FormatInt(record.BytesWritten, 10),
+			strconv.FormatFloat(record.Cost, 'f', -1, 64),
+		})
+	}
+	writer.Flush()
+
+	return errors.Join(writer.Error(), file.Close())
+}
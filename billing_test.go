@@ -0,0 +1,153 @@
+package netlistener
+
+import (
+	"encoding/csv"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// failingUsageWriter fails the first writes and keeps the records of the others
+type failingUsageWriter struct {
+	failures int
+	records  []UsageRecord
+}
+
+func (w *failingUsageWriter) WriteUsage(records []UsageRecord) error {
+	if w.failures > 0 {
+		w.failures--
+		return errors.New("billing pipeline unavailable")
+	}
+	w.records = append(w.records, records...)
+
+	return nil
+}
+
+func newBillingListener(t *testing.T) *Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	throttledListener, _ := NewListener(listener, nil, nil)
+	t.Cleanup(func() { throttledListener.Close() })
+
+	if err := throttledListener.SetClasses([]ClassConfig{{Name: "acme", Cost: 2}, {Name: "globex"}}, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	return throttledListener
+}
+
+// transfer writes the bytes on a new connection of the class, an empty class leaves the connection unclassified
+func transfer(t *testing.T, l *Listener, class string, bytes int) {
+	connConfig := NewConnectionBandwithConfig(l.config)
+	connConfig.SetClassification(Classification{Class: class})
+
+	connRead, connWrite := net.Pipe()
+	conn := NewThrottledConnection(connWrite, connConfig)
+	go readDataFromConn(connRead)
+	defer conn.Close()
+
+	if _, err := conn.Write(make([]byte, bytes)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUsageExporter_Export(t *testing.T) {
+	l := newBillingListener(t)
+	writer := &failingUsageWriter{failures: 1}
+	exporter := NewUsageExporter(l, writer)
+
+	transfer(t, l, "acme", 100)
+	transfer(t, l, "", 10)
+	// a failed export is retried with the next one
+	if err := exporter.Export(); err == nil {
+		t.Fatal("expected the export to fail")
+	}
+	transfer(t, l, "acme", 50)
+
+	if err := exporter.Export(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		record   UsageRecord
+		expected UsageRecord
+	}{
+		{name: "Unclassified", record: writer.records[0], expected: UsageRecord{Tenant: "", Conns: 1, BytesWritten: 10, Cost: 10}},
+		{name: "Tenant with a cost", record: writer.records[1], expected: UsageRecord{Tenant: "acme", Conns: 2, BytesWritten: 150, Cost: 300}},
+	}
+
+	if len(writer.records) != len(tests) {
+		t.Fatalf("expected %d records without the idle tenant, got %+v", len(tests), writer.records)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			record := tt.record
+			record.Start, record.End = time.Time{}, time.Time{}
+			if record != tt.expected {
+				t.Errorf("expected %+v, got %+v", tt.expected, record)
+			}
+		})
+	}
+
+	// nothing happened since, so nothing is written
+	if err := exporter.Export(); err != nil || len(writer.records) != len(tests) {
+		t.Errorf("expected no records for an idle interval, got %d: %v", len(writer.records), err)
+	}
+}
+
+func TestCSVUsageWriter(t *testing.T) {
+	day := time.Date(2024, 5, 1, 13, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		rotation time.Duration
+		ends     []time.Time
+		// expected are the files with their number of records
+		expected map[string]int
+	}{
+		{name: "Single file", ends: []time.Time{day, day.Add(48 * time.Hour)}, expected: map[string]int{"usage.csv": 2}},
+		{name: "Daily rotation", rotation: 24 * time.Hour, ends: []time.Time{day, day.Add(time.Hour), day.Add(24 * time.Hour)}, expected: map[string]int{
+			"usage-20240501T000000Z.csv": 2,
+			"usage-20240502T000000Z.csv": 1,
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writer, err := NewCSVUsageWriter(dir, "", tt.rotation)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, end := range tt.ends {
+				record := UsageRecord{Start: end.Add(-time.Minute), End: end, Tenant: "acme", BytesRead: 1, BytesWritten: 2, Cost: 3}
+				if err := writer.WriteUsage([]UsageRecord{record}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			for name, expected := range tt.expected {
+				file, err := os.Open(filepath.Join(dir, name))
+				if err != nil {
+					t.Fatal(err)
+				}
+				rows, err := csv.NewReader(file).ReadAll()
+				file.Close()
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if len(rows) != expected+1 || rows[0][0] != "start" {
+					t.Errorf("expected a header and %d records in %s, got %v", expected, name, rows)
+				}
+			}
+		})
+	}
+}
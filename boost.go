@@ -0,0 +1,103 @@
+package netlistener
+
+import (
+	"errors"
+	"time"
+)
+
+// Boost lets connections transfer at full speed at their start, e.g. for speed tests or the first seconds of a download,
+// and clamps them to their per connection limit afterwards. The boost ends with whichever of its bounds is reached first,
+// a zero bound does not end it. The global, class and session limits keep applying during the boost
+type Boost struct {
+	// Bytes is the amount transferred at full speed in each direction
+	Bytes int64 `json:"bytes,omitempty"`
+	// Duration is the time since the connection was accepted it transfers at full speed
+	Duration time.Duration `json:"duration,omitempty"`
+}
+
+func (b *Boost) validate() error {
+	if b == nil {
+		return nil
+	}
+	if b.Bytes < 0 || b.Duration < 0 {
+		return errors.New("boost bounds must not be negative")
+	}
+	if b.Bytes == 0 && b.Duration == 0 {
+		return errors.New("boost needs a bound in bytes or duration")
+	}
+
+	return nil
+}
+
+// active reports whether a connection accepted at acceptedAt which transferred the bytes is still boosted
+func (b *Boost) active(acceptedAt time.Time, transferred int64, now time.Time) bool {
+	if b.Bytes > 0 && transferred >= b.Bytes {
+		return false
+	}
+
+	return b.Duration <= 0 || now.Sub(acceptedAt) < b.Duration
+}
+
+func cloneBoost(boost *Boost) *Boost {
+	if boost == nil {
+		return nil
+	}
+
+	clone := *boost
+
+	return &clone
+}
+
+// SetBoost lifts the per connection limit of every connection at its start until the boost ends, see Boost.
+// The boost of a class takes precedence, nil disables it for connections without a class boost
+func (c *BandwidthConfig) SetBoost(boost *Boost) error {
+	if err := boost.validate(); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.boost = cloneBoost(boost)
+
+	return nil
+}
+
+func (c *BandwidthConfig) Boost() *Boost {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return cloneBoost(c.boost)
+}
+
+// Boost returns the boost of the class, if it has one
+func (r *classRegistry) Boost(entry *classEntry) *Boost {
+	if entry == nil {
+		return nil
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return entry.config.Boost
+}
+
+// boosted reports whether the connection is still within the boost of its class or of the config in the direction
+func (c *ThrottledConn) boosted(read bool) bool {
+	boost := c.config.globalConfig.classes.Boost(c.config.class())
+	if boost == nil {
+		c.config.globalConfig.mu.RLock()
+		boost = c.config.globalConfig.boost
+		c.config.globalConfig.mu.RUnlock()
+	}
+	if boost == nil {
+		return false
+	}
+
+	transferred := c.bytesWritten.Load()
+	if read {
+		transferred = c.bytesRead.Load()
+	}
+
+	return boost.active(c.acceptedAt, transferred, time.Now())
+}
@@ -0,0 +1,83 @@
+package netlistener
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestBoost_Active(t *testing.T) {
+	acceptedAt := time.Unix(0, 0)
+
+	tests := []struct {
+		name        string
+		boost       Boost
+		transferred int64
+		elapsed     time.Duration
+		expected    bool
+	}{
+		{name: "Within the bytes", boost: Boost{Bytes: 1000}, transferred: 999, elapsed: time.Hour, expected: true},
+		{name: "Bytes used up", boost: Boost{Bytes: 1000}, transferred: 1000},
+		{name: "Within the duration", boost: Boost{Duration: time.Second}, transferred: 1 << 30, elapsed: 999 * time.Millisecond, expected: true},
+		{name: "Duration passed", boost: Boost{Duration: time.Second}, elapsed: time.Second},
+		{name: "Bytes end the boost before the duration", boost: Boost{Bytes: 1000, Duration: time.Second}, transferred: 1000},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if active := tt.boost.active(acceptedAt, tt.transferred, acceptedAt.Add(tt.elapsed)); active != tt.expected {
+				t.Errorf("expected active %v, got %v", tt.expected, active)
+			}
+		})
+	}
+}
+
+func TestRateLimitedConnection_Boost(t *testing.T) {
+	config := NewBandwithConfig(nil, ptr(100))
+	if err := config.SetBoost(&Boost{Bytes: 1000}); err != nil {
+		t.Fatal(err)
+	}
+
+	connRead, connWrite := net.Pipe()
+	defer connRead.Close()
+	conn := NewThrottledConnection(connWrite, NewConnectionBandwithConfig(config))
+	defer conn.Close()
+	go readDataFromConn(connRead)
+
+	start := time.Now()
+	if _, err := conn.Write(make([]byte, 1000)); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 200*time.Millisecond {
+		t.Errorf("expected the boosted bytes at full speed, took %v", elapsed)
+	}
+	if reason := conn.ConnInfo().WriteLimitReason; reason != "boost" {
+		t.Errorf("expected the boost to decide the limit, got %q", reason)
+	}
+
+	// the clamped connection starts with an empty bucket, the boosted bytes are not charged on top
+	start = time.Now()
+	if _, err := conn.Write(make([]byte, 100)); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 800*time.Millisecond || elapsed > 1500*time.Millisecond {
+		t.Errorf("expected the connection clamped to its limit, took %v", elapsed)
+	}
+	if reason := conn.ConnInfo().WriteLimitReason; reason != "configured" {
+		t.Errorf("expected the configured limit after the boost, got %q", reason)
+	}
+}
+
+func TestBoost_Validate(t *testing.T) {
+	config := NewBandwithConfig(nil, nil)
+
+	if err := config.SetBoost(&Boost{}); err == nil {
+		t.Error("expected an error for a boost without bounds")
+	}
+	if err := config.SetBoost(&Boost{Bytes: -1}); err == nil {
+		t.Error("expected an error for a negative boost")
+	}
+	if err := config.SetClasses([]ClassConfig{{Name: "speedtest", Rate: 1000, Boost: &Boost{}}}, ""); err == nil {
+		t.Error("expected an error for a class boost without bounds")
+	}
+}
@@ -0,0 +1,155 @@
+package netlistener
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// BucketState is the state of a token bucket at a point in time
+type BucketState struct {
+	// Tokens available at the time of the snapshot, negative when waits were already reserved ahead
+	Tokens float64   `json:"tokens"`
+	At     time.Time `json:"at"`
+}
+
+// BucketsState is the state of the read and write token buckets of a connection or of the global limit
+type BucketsState struct {
+	Read  BucketState `json:"read"`
+	Write BucketState `json:"write"`
+}
+
+// SnapshotBuckets returns the state of the global token buckets
+func (c *BandwidthConfig) SnapshotBuckets() BucketsState {
+	now := c.now()
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return BucketsState{
+		Read:  snapshotBucket(c.globalReadLimiter, now),
+		Write: snapshotBucket(c.globalWriteLimiter, now),
+	}
+}
+
+// RestoreBuckets restores the global token buckets from a snapshot, see restoreBucket
+func (c *BandwidthConfig) RestoreBuckets(state BucketsState) {
+	now := c.now()
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	restoreBucket(c.globalReadLimiter, state.Read, now)
+	restoreBucket(c.globalWriteLimiter, state.Write, now)
+}
+
+// SnapshotBuckets returns the state of the per connection token buckets
+func (c *ConnConfig) SnapshotBuckets() BucketsState {
+	now := c.globalConfig.now()
+
+	return BucketsState{
+		Read:  snapshotBucket(c.PerConnReadLimiter(), now),
+		Write: snapshotBucket(c.PerConnWriteLimiter(), now),
+	}
+}
+
+// RestoreBuckets restores the per connection token buckets from a snapshot, see restoreBucket
+func (c *ConnConfig) RestoreBuckets(state BucketsState) {
+	now := c.globalConfig.now()
+	restoreBucket(c.PerConnReadLimiter(), state.Read, now)
+	restoreBucket(c.PerConnWriteLimiter(), state.Write, now)
+}
+
+func snapshotBucket(limiter *rate.Limiter, now time.Time) BucketState {
+	return BucketState{Tokens: limiter.TokensAt(now), At: now}
+}
+
+// restoreBucket consumes tokens from the limiter until it holds as many as the state did,
+// refilled at the current limit for the time passed since the snapshot.
+// Restoring never adds tokens, a bucket already holding less than the state is left as is
+func restoreBucket(limiter *rate.Limiter, state BucketState, now time.Time) {
+	burst := limiter.Burst()
+	if limiter.Limit() == rate.Inf || limiter.Limit() <= 0 || burst <= 0 {
+		return
+	}
+
+	elapsed := max(now.Sub(state.At), 0)
+	tokens := min(state.Tokens+elapsed.Seconds()*float64(limiter.Limit()), float64(burst))
+
+	// the debt may exceed the burst when waits were reserved ahead
+	reserve(limiter, int64(math.Round(limiter.TokensAt(now)-tokens)), now)
+}
+
+// SaveBucketsFile writes the state of token buckets to a JSON file, replacing it atomically
+func SaveBucketsFile(path string, state BucketsState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("encoding bucket state: %w", err)
+	}
+
+	return writeFileAtomic(path, data)
+}
+
+// LoadBucketsFile reads the state of token buckets saved by SaveBucketsFile. It reports false without an error
+// if the file does not exist or the state is older than maxAge, zero maxAge accepts any age
+func LoadBucketsFile(path string, maxAge time.Duration) (BucketsState, bool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return BucketsState{}, false, nil
+		}
+
+		return BucketsState{}, false, fmt.Errorf("reading bucket state file: %w", err)
+	}
+
+	var state BucketsState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return BucketsState{}, false, fmt.Errorf("decoding bucket state file: %w", err)
+	}
+
+	if maxAge > 0 && (time.Since(state.Read.At) > maxAge || time.Since(state.Write.At) > maxAge) {
+		return BucketsState{}, false, nil
+	}
+
+	return state, true, nil
+}
+
+// SetBucketPersistence saves the global token buckets to the file when the listener is closed and restores them
+// from it right away, unless the saved state is older than maxAge. A quick restart then does not grant all clients
+// a full burst at once. An empty path disables it
+func (c *BandwidthConfig) SetBucketPersistence(path string, maxAge time.Duration) error {
+	c.mu.Lock()
+	c.bucketFile = path
+	c.mu.Unlock()
+
+	if path == "" {
+		return nil
+	}
+
+	state, ok, err := LoadBucketsFile(path, maxAge)
+	if err != nil || !ok {
+		return err
+	}
+
+	c.RestoreBuckets(state)
+
+	return nil
+}
+
+// persistBuckets saves the global token buckets if persistence is enabled
+func (c *BandwidthConfig) persistBuckets() error {
+	c.mu.RLock()
+	path := c.bucketFile
+	c.mu.RUnlock()
+
+	if path == "" {
+		return nil
+	}
+
+	return SaveBucketsFile(path, c.SnapshotBuckets())
+}
@@ -0,0 +1,151 @@
+package netlistener
+
+import (
+	"math"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+func TestRestoreBucket(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name     string
+		tokens   float64
+		state    BucketState
+		expected float64
+	}{
+		{
+			name:     "Full bucket is drained to the snapshot",
+			tokens:   100,
+			state:    BucketState{Tokens: 30, At: now},
+			expected: 30,
+		},
+		{
+			name:     "Bucket is refilled for the time passed since the snapshot",
+			tokens:   100,
+			state:    BucketState{Tokens: 30, At: now.Add(-500 * time.Millisecond)},
+			expected: 80,
+		},
+		{
+			name:     "Refill does not exceed the burst",
+			tokens:   100,
+			state:    BucketState{Tokens: 30, At: now.Add(-time.Minute)},
+			expected: 100,
+		},
+		{
+			name:     "Debt over the burst is restored",
+			tokens:   100,
+			state:    BucketState{Tokens: -150, At: now},
+			expected: -150,
+		},
+		{
+			name:     "Tokens are never added",
+			tokens:   10,
+			state:    BucketState{Tokens: 60, At: now},
+			expected: 10,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limiter := rate.NewLimiter(100, 100)
+			limiter.ReserveN(now, int(100-tt.tokens))
+
+			restoreBucket(limiter, tt.state, now)
+
+			if tokens := limiter.TokensAt(now); math.Abs(tokens-tt.expected) > 1 {
+				t.Errorf("expected %f tokens, got %f", tt.expected, tokens)
+			}
+		})
+	}
+}
+
+func TestConnectionBandwithConfig_SnapshotBuckets(t *testing.T) {
+	source := NewConnectionBandwithConfig(NewBandwithConfig(nil, ptr(1000)))
+	source.PerConnWriteLimiter().AllowN(time.Now(), 600)
+
+	target := NewConnectionBandwithConfig(NewBandwithConfig(nil, ptr(1000)))
+	target.RestoreBuckets(source.SnapshotBuckets())
+
+	if tokens := target.PerConnWriteLimiter().Tokens(); tokens > 450 {
+		t.Errorf("expected the write bucket to be restored, %f tokens left", tokens)
+	}
+	if tokens := target.PerConnReadLimiter().Tokens(); tokens < 999 {
+		t.Errorf("expected the read bucket to stay full, %f tokens left", tokens)
+	}
+}
+
+func TestListener_BucketPersistence(t *testing.T) {
+	tests := []struct {
+		name   string
+		maxAge time.Duration
+		// age is how old the saved state is when the next listener starts
+		age      time.Duration
+		restored bool
+	}{
+		{name: "Fresh state is restored", maxAge: time.Minute, restored: true},
+		{name: "Stale state is ignored", maxAge: time.Minute, age: 2 * time.Minute},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "buckets.json")
+
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal("Failed to create listener", err)
+			}
+
+			throttledListener, err := NewListener(listener, ptr(1000), nil, WithBucketPersistence(path, tt.maxAge))
+			if err != nil {
+				t.Fatal(err)
+			}
+			throttledListener.config.globalReadLimiter.AllowN(time.Now(), 1000)
+			if err := throttledListener.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.age > 0 {
+				state, _, err := LoadBucketsFile(path, 0)
+				if err != nil {
+					t.Fatal(err)
+				}
+				state.Read.At = state.Read.At.Add(-tt.age)
+				state.Write.At = state.Write.At.Add(-tt.age)
+				if err := SaveBucketsFile(path, state); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			restarted, err := NewListener(nil, ptr(1000), nil, WithBucketPersistence(path, tt.maxAge))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			tokens := restarted.config.globalReadLimiter.Tokens()
+			if restored := tokens < 500; restored != tt.restored {
+				t.Errorf("expected restored %t, the read bucket holds %f tokens", tt.restored, tokens)
+			}
+			if writeTokens := restarted.config.globalWriteLimiter.Tokens(); writeTokens < 999 {
+				t.Errorf("expected the unused write bucket to be full, got %f tokens", writeTokens)
+			}
+		})
+	}
+}
+
+func TestNewListener_CorruptBucketFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "buckets.json")
+	if err := os.WriteFile(path, []byte("{"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewListener(nil, ptr(1000), nil, WithBucketPersistence(path, time.Minute)); err == nil {
+		t.Error("expected error for a corrupt bucket state file")
+	}
+}
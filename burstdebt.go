@@ -0,0 +1,152 @@
+package netlistener
+
+import (
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// burstDebt is the burst a limiter still held when its limit was lowered, paid back by charging every operation a surcharge
+type burstDebt struct {
+	// remaining is the debt in tokens, perToken the surcharge per token taken
+	remaining float64
+	perToken  float64
+	mu        sync.Mutex
+}
+
+// burstDebts holds the debts of the limiters whose limits were lowered while burst debt is enabled
+type burstDebts struct {
+	debts sync.Map
+	// count is the number of limiters in debt, so operations skip the lookups while there is none
+	count atomic.Int64
+}
+
+// charge turns the burst the limiter holds after its limit was lowered into a debt paid back within the payback period,
+// when the operations use the new limit fully
+func (d *burstDebts) charge(limiter *rate.Limiter, payback time.Duration, now time.Time) {
+	limit := limiter.Limit()
+	if payback <= 0 || limit == rate.Inf || limit <= 0 {
+		return
+	}
+
+	tokens := limiter.TokensAt(now)
+	if tokens <= 0 {
+		return
+	}
+
+	value, loaded := d.debts.LoadOrStore(limiter, &burstDebt{})
+	if !loaded {
+		d.count.Add(1)
+	}
+
+	debt := value.(*burstDebt)
+	debt.mu.Lock()
+	defer debt.mu.Unlock()
+
+	debt.remaining += tokens
+	debt.perToken = debt.remaining / (float64(limit) * payback.Seconds())
+}
+
+// clear forgives the debt of the limiter, e.g. when its limit is raised again or its connection is closed
+func (d *burstDebts) clear(limiters ...*rate.Limiter) {
+	if d.count.Load() == 0 {
+		return
+	}
+
+	for _, limiter := range limiters {
+		if _, loaded := d.debts.LoadAndDelete(limiter); loaded {
+			d.count.Add(-1)
+		}
+	}
+}
+
+// surcharge adds the surcharge of the limiters in debt to the tokens taken from them, within their burst.
+// The debt is reduced right away, an operation which is cancelled after all pays it nevertheless
+func (d *burstDebts) surcharge(limiters []*rate.Limiter, tokens []int) {
+	if d.count.Load() == 0 {
+		return
+	}
+
+	for i, limiter := range limiters {
+		value, ok := d.debts.Load(limiter)
+		if !ok {
+			continue
+		}
+
+		debt := value.(*burstDebt)
+		debt.mu.Lock()
+		extra := min(math.Ceil(float64(tokens[i])*debt.perToken), math.Ceil(debt.remaining), float64(max(limiter.Burst()-tokens[i], 0)))
+		debt.remaining -= extra
+		paid := debt.remaining <= 0
+		debt.mu.Unlock()
+
+		tokens[i] += int(extra)
+		if paid && d.debts.CompareAndDelete(limiter, debt) {
+			d.count.Add(-1)
+		}
+	}
+}
+
+// maxChunk shrinks the chunk size so a chunk and its surcharge fit the burst of the limiters in debt,
+// otherwise chunks of a whole burst would never pay anything back
+func (d *burstDebts) maxChunk(limiters []*rate.Limiter, chunkSize int) int {
+	if d.count.Load() == 0 {
+		return chunkSize
+	}
+
+	for _, limiter := range limiters {
+		value, ok := d.debts.Load(limiter)
+		if !ok {
+			continue
+		}
+
+		debt := value.(*burstDebt)
+		debt.mu.Lock()
+		perToken := debt.perToken
+		debt.mu.Unlock()
+
+		if size := int(float64(limiter.Burst()) / (1 + perToken)); size > 0 && size < chunkSize {
+			chunkSize = size
+		}
+	}
+
+	return chunkSize
+}
+
+// SetBurstDebt makes lowered limits pay back the burst their limiters still hold, for deployments which must not exceed the new limit.
+// Without it, the burst granted under the old limit lets the traffic overshoot the new one for a while. With it, the tokens
+// a limiter holds when its limit is lowered become a debt, and every operation is charged a surcharge lowering the effective rate
+// until the debt is paid back, which takes about the payback period when the new limit is fully used. Raising the limit forgives the debt.
+// It applies to the global and the per connection limits, zero disables it
+func (c *BandwidthConfig) SetBurstDebt(payback time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.burstDebtPayback = payback
+}
+
+func (c *BandwidthConfig) BurstDebt() time.Duration {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.burstDebtPayback
+}
+
+// chargeBurstDebt charges the burst debt of a per connection limiter whose limit changed
+func (c *ThrottledConn) chargeBurstDebt(limiter *rate.Limiter, previous rate.Limit) {
+	config := c.config.globalConfig
+	config.chargeLimitChange(limiter, previous, config.BurstDebt(), config.now())
+}
+
+// chargeLimitChange charges the burst debt of a limiter whose limit changed, or forgives it if the limit was raised
+func (c *BandwidthConfig) chargeLimitChange(limiter *rate.Limiter, previous rate.Limit, payback time.Duration, now time.Time) {
+	switch limit := limiter.Limit(); {
+	case limit < previous:
+		c.debts.charge(limiter, payback, now)
+	case limit > previous:
+		c.debts.clear(limiter)
+	}
+}
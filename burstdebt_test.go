@@ -0,0 +1,83 @@
+package netlistener
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+func TestRateLimitedConnection_BurstDebt(t *testing.T) {
+	tests := []struct {
+		name    string
+		payback time.Duration
+		// global lowers the global limit instead of the per connection limit
+		global   bool
+		expected time.Duration
+	}{
+		// the 100 tokens left after lowering the limit to 100 let half of the bytes through right away
+		{name: "Global limit without debt", global: true, expected: time.Second},
+		{name: "Global limit pays back the burst", payback: time.Second, global: true, expected: 2 * time.Second},
+		{name: "Per connection limit without debt", expected: time.Second},
+		{name: "Per connection limit pays back the burst", payback: time.Second, expected: 2 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var config *BandwidthConfig
+			if tt.global {
+				config = NewBandwithConfig(ptr(200), nil)
+			} else {
+				config = NewBandwithConfig(nil, ptr(200))
+			}
+			config.SetBurstDebt(tt.payback)
+
+			connRead, connWrite := net.Pipe()
+			conn := NewThrottledConnection(connWrite, NewConnectionBandwithConfig(config))
+			defer conn.Close()
+			go readDataFromConn(connRead)
+			conn.activeLimiters(false)
+
+			if tt.global {
+				config.SetGlobalLimit(ptr(100))
+			} else {
+				config.SetPerConnLimit(ptr(100))
+			}
+
+			start := time.Now()
+			if _, err := conn.Write(make([]byte, 200)); err != nil {
+				t.Fatal(err)
+			}
+
+			if elapsed := time.Since(start); elapsed < tt.expected-100*time.Millisecond || elapsed > tt.expected+300*time.Millisecond {
+				t.Errorf("expected the write to take about %v, took %v", tt.expected, elapsed)
+			}
+		})
+	}
+}
+
+func TestBurstDebts(t *testing.T) {
+	config := NewBandwithConfig(ptr(100), nil)
+	config.SetBurstDebt(time.Second)
+	config.SetGlobalLimit(ptr(50))
+
+	limiter := config.GlobalWriteLimiter()
+	if count := config.debts.count.Load(); count != 2 {
+		t.Fatalf("expected the global read and write limiters in debt, got %d", count)
+	}
+
+	tokens := []int{10}
+	config.debts.surcharge([]*rate.Limiter{limiter}, tokens)
+	if tokens[0] != 20 {
+		t.Errorf("expected a surcharge of 10 tokens, got %d", tokens[0]-10)
+	}
+
+	// raising the limit forgives the debt
+	config.SetGlobalLimit(ptr(100))
+	if count := config.debts.count.Load(); count != 0 {
+		t.Errorf("expected the debt to be forgiven, got %d limiters in debt", count)
+	}
+}
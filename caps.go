@@ -0,0 +1,140 @@
+package netlistener
+
+import (
+	"fmt"
+	"net"
+	"sync"
+)
+
+// connCaps limits the number of open connections, in total and per remote IP
+type connCaps struct {
+	maxConns int64
+	maxPerIP int
+	// perIP counts the open connections of every remote IP while the per IP cap is set
+	perIP map[string]int
+	// perIPSize is the number of remote IPs the per IP counters are sized for when they are created
+	perIPSize int
+	// prefix aggregates the remote IPs the connections are counted under, nil counts single addresses
+	prefix *PeerPrefix
+	// pending counts the connections which were admitted but are not throttled yet, they are not among the active ones
+	pending int64
+
+	mu sync.Mutex
+}
+
+func (c *connCaps) Set(maxConns int64, maxPerIP int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.maxConns = maxConns
+	c.maxPerIP = maxPerIP
+	if maxPerIP > 0 && c.perIP == nil {
+		c.perIP = make(map[string]int, c.perIPSize)
+	}
+}
+
+// presize sizes the per IP counters for the number of remote IPs, they are recreated if none are open
+func (c *connCaps) presize(peers int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.perIPSize = peers
+	if c.perIP != nil && len(c.perIP) == 0 {
+		c.perIP = make(map[string]int, peers)
+	}
+}
+
+func (c *connCaps) Get() (maxConns int64, maxPerIP int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.maxConns, c.maxPerIP
+}
+
+// capSlot is the place of an admitted connection under the caps, it is claimed by the throttled connection
+// or cancelled if the connection is rejected afterwards
+type capSlot struct {
+	// key is the remote IP the connection was counted for, empty if it was not counted
+	key     string
+	claimed bool
+}
+
+// admit checks whether one more connection may be opened while active connections are open and reserves its slot,
+// so connections admitted concurrently cannot exceed the caps before they are throttled
+func (c *connCaps) admit(conn net.Conn, active int64) (*capSlot, RejectReason, string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if open := active + c.pending; c.maxConns > 0 && open >= c.maxConns {
+		return nil, RejectMaxConns, fmt.Sprintf("%d connections are open", open), false
+	}
+
+	slot := &capSlot{}
+	if c.maxPerIP > 0 {
+		key := c.prefix.connKey(conn)
+		if open := c.perIP[key]; open >= c.maxPerIP {
+			return nil, RejectPerIPCap, fmt.Sprintf("%d connections of the peer are open", open), false
+		}
+		c.perIP[key]++
+		slot.key = key
+	}
+	c.pending++
+
+	return slot, 0, "", true
+}
+
+// cancel gives back the slot of an admitted connection which was not throttled, it does nothing once the slot was claimed
+func (c *connCaps) cancel(slot *capSlot) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if slot.claimed {
+		return
+	}
+	slot.claimed = true
+	c.pending--
+	c.releaseLocked(slot.key)
+}
+
+// track counts the connection for its remote IP, returning the key it has to be released with, empty if it was not counted.
+// A connection admitted before takes over the slot reserved for it
+func (c *connCaps) track(conn net.Conn, slot *capSlot) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if slot != nil && !slot.claimed {
+		slot.claimed = true
+		c.pending--
+		return slot.key
+	}
+
+	if c.maxPerIP <= 0 {
+		return ""
+	}
+
+	key := c.prefix.connKey(conn)
+	c.perIP[key]++
+
+	return key
+}
+
+func (c *connCaps) release(key string) {
+	if key == "" {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.releaseLocked(key)
+}
+
+func (c *connCaps) releaseLocked(key string) {
+	if key == "" {
+		return
+	}
+
+	if c.perIP[key]--; c.perIP[key] <= 0 {
+		delete(c.perIP, key)
+	}
+}
@@ -0,0 +1,266 @@
+package netlistener
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"golang.org/x/time/rate"
+)
+
+// ClassConfig describes a traffic class. All connections of a class share its limiters,
+// and classes may be nested, in which case a connection is limited by every class up to the root
+type ClassConfig struct {
+	Name   string `json:"name"`
+	Parent string `json:"parent,omitempty"`
+	// Rate is the bandwidth the class is expected to get in bytes per second
+	Rate int `json:"rate"`
+	// Ceil is the maximum bandwidth shared by all connections of the class in bytes per second, zero means Rate
+	Ceil int `json:"ceil,omitempty"`
+	// PerConnLimit is the per connection limit for connections of the class, nil keeps the configured one
+	PerConnLimit *int `json:"per_conn_limit,omitempty"`
+	// Cost multiplies the bytes of the connections of the class when they are charged to the global limiters,
+	// e.g. 2 for cross-region traffic, so the global limit is a budget rather than raw bytes. Zero means 1
+	Cost float64 `json:"cost,omitempty"`
+	// Weight multiplies the weights of the connections of the class when the global limit is split in work conserving mode,
+	// zero means 1
+	Weight float64 `json:"weight,omitempty"`
+	// Boost lets the connections of the class transfer at full speed at their start, nil uses the boost of the config
+	Boost *Boost `json:"boost,omitempty"`
+}
+
+func (c ClassConfig) ceil() int {
+	if c.Ceil > 0 {
+		return c.Ceil
+	}
+
+	return c.Rate
+}
+
+// classEntry holds the shared limiters of a class
+type classEntry struct {
+	config ClassConfig
+	parent *classEntry
+
+	readLimiter  *rate.Limiter
+	writeLimiter *rate.Limiter
+	// stats count the connections assigned to the class itself
+	stats statsCounters
+}
+
+// classRegistry keeps the configured classes and the default class for connections without one
+type classRegistry struct {
+	classes      map[string]*classEntry
+	defaultClass string
+
+	mu sync.RWMutex
+}
+
+// validateClasses checks the classes and their parent chains, it returns them by name
+func validateClasses(classes []ClassConfig, defaultClass string) (map[string]ClassConfig, error) {
+	configs := make(map[string]ClassConfig, len(classes))
+	for _, class := range classes {
+		if class.Name == "" {
+			return nil, fmt.Errorf("class without a name")
+		}
+		if _, ok := configs[class.Name]; ok {
+			return nil, fmt.Errorf("class %q is defined twice", class.Name)
+		}
+		if class.Rate < 0 || class.Ceil < 0 {
+			return nil, fmt.Errorf("class %q has a negative rate", class.Name)
+		}
+		if class.Weight < 0 || class.Cost < 0 {
+			return nil, fmt.Errorf("class %q has a negative weight or cost", class.Name)
+		}
+		if err := class.Boost.validate(); err != nil {
+			return nil, fmt.Errorf("class %q: %w", class.Name, err)
+		}
+		class.Boost = cloneBoost(class.Boost)
+
+		configs[class.Name] = class
+	}
+
+	for _, class := range configs {
+		seen := map[string]bool{class.Name: true}
+		for parent := class.Parent; parent != ""; parent = configs[parent].Parent {
+			if _, ok := configs[parent]; !ok {
+				return nil, fmt.Errorf("class %q has unknown parent %q", class.Name, parent)
+			}
+			if seen[parent] {
+				return nil, fmt.Errorf("class %q has a cyclic parent chain", class.Name)
+			}
+			seen[parent] = true
+		}
+	}
+
+	if _, ok := configs[defaultClass]; defaultClass != "" && !ok {
+		return nil, fmt.Errorf("default class %q is not defined", defaultClass)
+	}
+
+	return configs, nil
+}
+
+// Set replaces the configured classes. Classes which already exist are updated in place,
+// so connections already assigned to them pick up the new limits
+func (r *classRegistry) Set(classes []ClassConfig, defaultClass string) error {
+	configs, err := validateClasses(classes, defaultClass)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	entries := make(map[string]*classEntry, len(configs))
+	for name, config := range configs {
+		limit := rate.Limit(config.ceil())
+		if config.ceil() == 0 {
+			limit = rate.Inf
+		}
+
+		entry, ok := r.classes[name]
+		if ok {
+			entry.readLimiter.SetLimit(limit)
+			entry.readLimiter.SetBurst(parseBurstFromRateLimit(limit))
+			entry.writeLimiter.SetLimit(limit)
+			entry.writeLimiter.SetBurst(parseBurstFromRateLimit(limit))
+		} else {
+			entry = &classEntry{
+				readLimiter:  rate.NewLimiter(limit, parseBurstFromRateLimit(limit)),
+				writeLimiter: rate.NewLimiter(limit, parseBurstFromRateLimit(limit)),
+			}
+		}
+
+		entry.config = config
+		entries[name] = entry
+	}
+
+	for _, entry := range entries {
+		entry.parent = entries[entry.config.Parent]
+	}
+
+	r.classes = entries
+	r.defaultClass = defaultClass
+	invalidateFastPath()
+
+	return nil
+}
+
+// Get returns the class by name, falling back to the default class. It returns nil if there is no such class
+func (r *classRegistry) Get(name string) *classEntry {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if entry, ok := r.classes[name]; ok {
+		return entry
+	}
+
+	return r.classes[r.defaultClass]
+}
+
+// lookup returns the class by name without falling back to the default class, nil if there is no such class
+func (r *classRegistry) lookup(name string) *classEntry {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.classes[name]
+}
+
+// Configs returns the configuration of all classes and the default class
+func (r *classRegistry) Configs() ([]ClassConfig, string) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	configs := make([]ClassConfig, 0, len(r.classes))
+	for _, entry := range r.classes {
+		config := entry.config
+		config.PerConnLimit = cloneLimit(config.PerConnLimit)
+		config.Boost = cloneBoost(config.Boost)
+		configs = append(configs, config)
+	}
+
+	sort.Slice(configs, func(i, j int) bool {
+		return configs[i].Name < configs[j].Name
+	})
+
+	return configs, r.defaultClass
+}
+
+// Limiters returns the read or write limiters of the class and all of its parents
+func (r *classRegistry) Limiters(entry *classEntry, read bool) []*rate.Limiter {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var limiters []*rate.Limiter
+	for ; entry != nil; entry = entry.parent {
+		if read {
+			limiters = append(limiters, entry.readLimiter)
+		} else {
+			limiters = append(limiters, entry.writeLimiter)
+		}
+	}
+
+	return limiters
+}
+
+// Weight returns the weight of the class in work conserving mode, 1 for connections without a class
+func (r *classRegistry) Weight(entry *classEntry) float64 {
+	if entry == nil {
+		return 1
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if entry.config.Weight == 0 {
+		return 1
+	}
+
+	return entry.config.Weight
+}
+
+// Cost returns the cost multiplier of the class, 1 for connections without a class
+func (r *classRegistry) Cost(entry *classEntry) float64 {
+	if entry == nil {
+		return 1
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if entry.config.Cost == 0 {
+		return 1
+	}
+
+	return entry.config.Cost
+}
+
+// SetWeight changes the weight of a class at runtime
+func (r *classRegistry) SetWeight(name string, weight float64) error {
+	if weight < 0 {
+		return fmt.Errorf("class %q has a negative weight", name)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	entry, ok := r.classes[name]
+	if !ok {
+		return fmt.Errorf("unknown class %q", name)
+	}
+	entry.config.Weight = weight
+
+	return nil
+}
+
+// PerConnLimit returns the per connection limit of the class, if it has one
+func (r *classRegistry) PerConnLimit(entry *classEntry) *int {
+	if entry == nil {
+		return nil
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return entry.config.PerConnLimit
+}
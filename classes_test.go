@@ -0,0 +1,86 @@
+package netlistener
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestClassRegistry_Set_Invalid(t *testing.T) {
+	tests := []struct {
+		name         string
+		classes      []ClassConfig
+		defaultClass string
+	}{
+		{name: "Class without a name", classes: []ClassConfig{{Rate: 10}}},
+		{name: "Duplicate class", classes: []ClassConfig{{Name: "a", Rate: 10}, {Name: "a", Rate: 20}}},
+		{name: "Unknown parent", classes: []ClassConfig{{Name: "a", Parent: "b", Rate: 10}}},
+		{name: "Cyclic parents", classes: []ClassConfig{{Name: "a", Parent: "b", Rate: 10}, {Name: "b", Parent: "a", Rate: 10}}},
+		{name: "Unknown default class", classes: []ClassConfig{{Name: "a", Rate: 10}}, defaultClass: "b"},
+		{name: "Negative rate", classes: []ClassConfig{{Name: "a", Rate: -10}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			registry := &classRegistry{}
+			if err := registry.Set(tt.classes, tt.defaultClass); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
+
+func TestClassRegistry_UpdatedInPlace(t *testing.T) {
+	registry := &classRegistry{}
+	if err := registry.Set([]ClassConfig{{Name: "root", Rate: 100}, {Name: "web", Parent: "root", Rate: 10}}, "web"); err != nil {
+		t.Fatal(err)
+	}
+
+	entry := registry.Get("unknown")
+	if entry == nil || entry.config.Name != "web" {
+		t.Fatal("expected fallback to the default class")
+	}
+	if limiters := registry.Limiters(entry, true); len(limiters) != 2 {
+		t.Fatalf("expected limiters of the class and its parent, got %d", len(limiters))
+	}
+
+	if err := registry.Set([]ClassConfig{{Name: "web", Rate: 20}}, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if entry.readLimiter.Limit() != 20 {
+		t.Errorf("expected existing class to be updated, got limit %v", entry.readLimiter.Limit())
+	}
+	if limiters := registry.Limiters(entry, true); len(limiters) != 1 {
+		t.Errorf("expected parent to be removed, got %d limiters", len(limiters))
+	}
+}
+
+func TestRateLimitedConnection_ClassLimitIsShared(t *testing.T) {
+	config := NewBandwithConfig(nil, nil)
+	if err := config.SetClasses([]ClassConfig{{Name: "shared", Rate: 100}}, "shared"); err != nil {
+		t.Fatal(err)
+	}
+
+	wg := sync.WaitGroup{}
+	start := time.Now()
+
+	for i := 0; i < 2; i++ {
+		connRead, connWrite := net.Pipe()
+		throttledConn := NewThrottledConnection(connWrite, NewConnectionBandwithConfig(config))
+		go readDataFromConn(connRead)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			writeRandomDataToConn(throttledConn, 100)
+		}()
+	}
+
+	wg.Wait()
+
+	if elapsed := time.Since(start); elapsed < 900*time.Millisecond || elapsed > 2*time.Second {
+		t.Errorf("expected connections to share the class limit, took %s", elapsed)
+	}
+}
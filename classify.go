@@ -0,0 +1,70 @@
+package netlistener
+
+import (
+	"net"
+	"time"
+)
+
+// ConnMetadata is everything classifiers know about an accepted connection
+type ConnMetadata struct {
+	RemoteAddr net.Addr
+	// LocalAddr is the address the client connected to, with transparent proxying it is the original destination,
+	// so classifiers can select the policy by the virtual IP and port
+	LocalAddr net.Addr
+	// OriginalDst is the destination of a connection redirected to the listener, e.g. by iptables REDIRECT, it is only set
+	// when the lookup is enabled with SetOriginalDst and it succeeded. Policies match it instead of LocalAddr when it is set
+	OriginalDst net.Addr
+	// SNI is the server name requested in the TLS hello. Connections accepted through NewTLSListener are classified
+	// again with it once the hello was read, it is empty before and for other connections
+	SNI string
+	// Hostname is the reverse DNS name of the remote IP, empty unless reverse DNS is enabled and the name is known
+	Hostname   string
+	Tags       []string
+	AcceptedAt time.Time
+}
+
+// Classification is the outcome of classifying a connection
+type Classification struct {
+	// Deny closes the connection right after it was accepted
+	Deny  bool
+	Class string
+	// PerConnLimit overrides the configured per connection limit for this connection, nil keeps the configured one
+	PerConnLimit *int
+	// Profile is the name of a built-in or registered profile shaping the connection, empty uses the default profile.
+	// PerConnLimit takes precedence over the limits of the profile
+	Profile  string
+	Priority int
+	Tags     []string
+	// Throttle subjects a connection to the limits although its peer is in a local range exempt by SetLocalExemption
+	Throttle bool
+}
+
+// Classifier decides at accept time how a connection should be treated
+type Classifier interface {
+	Classify(meta ConnMetadata) Classification
+}
+
+// ClassifierFunc allows to use an ordinary function as a Classifier
+type ClassifierFunc func(meta ConnMetadata) Classification
+
+func (f ClassifierFunc) Classify(meta ConnMetadata) Classification {
+	return f(meta)
+}
+
+func newConnMetadata(conn net.Conn) ConnMetadata {
+	return ConnMetadata{
+		RemoteAddr: conn.RemoteAddr(),
+		LocalAddr:  conn.LocalAddr(),
+		AcceptedAt: time.Now(),
+	}
+}
+
+func hasTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+
+	return false
+}
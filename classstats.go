@@ -0,0 +1,122 @@
+package netlistener
+
+import "sort"
+
+// ClassStats are the stats of a traffic class rolled up with the ones of its child classes, so every level of the class tree
+// can be reported without aggregating externally. The root of the tree is the listener itself with the global stats
+type ClassStats struct {
+	// Class is the name of the class, empty for the root
+	Class string `json:"class"`
+	Stats
+	// Own are the stats of the connections assigned to the class itself, without the ones of its child classes.
+	// For the root these are the connections without a class
+	Own      Stats        `json:"own"`
+	Children []ClassStats `json:"children,omitempty"`
+}
+
+// ClassStats returns the stats of all classes as a tree rooted at the global stats
+func (c *BandwidthConfig) ClassStats() ClassStats {
+	root := ClassStats{Stats: c.Stats()}
+	root.Children = c.classes.Stats()
+
+	root.Own = root.Stats
+	for _, child := range root.Children {
+		root.Own = root.Own.sub(child.Stats)
+	}
+
+	return root
+}
+
+// Stats returns the rolled up stats of the top level classes
+func (r *classRegistry) Stats() []ClassStats {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	children := make(map[*classEntry][]*classEntry, len(r.classes))
+	var roots []*classEntry
+	for _, entry := range r.classes {
+		if entry.parent == nil {
+			roots = append(roots, entry)
+		} else {
+			children[entry.parent] = append(children[entry.parent], entry)
+		}
+	}
+
+	var rollup func(entry *classEntry) ClassStats
+	rollup = func(entry *classEntry) ClassStats {
+		stats := ClassStats{Class: entry.config.Name, Own: entry.stats.snapshot()}
+		stats.Stats = stats.Own
+		for _, child := range children[entry] {
+			childStats := rollup(child)
+			stats.Stats = stats.Stats.add(childStats.Stats)
+			stats.Children = append(stats.Children, childStats)
+		}
+		sortClassStats(stats.Children)
+
+		return stats
+	}
+
+	result := make([]ClassStats, 0, len(roots))
+	for _, entry := range roots {
+		result = append(result, rollup(entry))
+	}
+	sortClassStats(result)
+
+	return result
+}
+
+func sortClassStats(stats []ClassStats) {
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Class < stats[j].Class
+	})
+}
+
+// classCounters returns the stats counters of the class of the connection, nil if it has no class
+func (c *ThrottledConn) classCounters() *statsCounters {
+	if class := c.config.class(); class != nil {
+		return &class.stats
+	}
+
+	return nil
+}
+
+// add sums the counters of both stats
+func (s Stats) add(other Stats) Stats {
+	return s.combine(other, 1)
+}
+
+// sub subtracts the counters of the other stats
+func (s Stats) sub(other Stats) Stats {
+	return s.combine(other, -1)
+}
+
+func (s Stats) combine(other Stats, sign int64) Stats {
+	var rejections map[string]int64
+	for reason, n := range s.Rejections {
+		if rejections == nil {
+			rejections = make(map[string]int64)
+		}
+		rejections[reason] += n
+	}
+	for reason, n := range other.Rejections {
+		if rejections == nil {
+			rejections = make(map[string]int64)
+		}
+		rejections[reason] += sign * n
+	}
+
+	return Stats{
+		Rejections:    rejections,
+		AcceptedConns: s.AcceptedConns + sign*other.AcceptedConns,
+		ActiveConns:   s.ActiveConns + sign*other.ActiveConns,
+		ExemptConns:   s.ExemptConns + sign*other.ExemptConns,
+		HealthChecks:  s.HealthChecks + sign*other.HealthChecks,
+		DeniedConns:   s.DeniedConns + sign*other.DeniedConns,
+		DeadPeers:     s.DeadPeers + sign*other.DeadPeers,
+		BytesRead:     s.BytesRead + sign*other.BytesRead,
+		BytesWritten:  s.BytesWritten + sign*other.BytesWritten,
+
+		UnaccountedBytesRead:    s.UnaccountedBytesRead + sign*other.UnaccountedBytesRead,
+		UnaccountedBytesWritten: s.UnaccountedBytesWritten + sign*other.UnaccountedBytesWritten,
+	}
+}
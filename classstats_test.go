@@ -0,0 +1,67 @@
+package netlistener
+
+import (
+	"net"
+	"testing"
+)
+
+func TestBandwithConfig_ClassStats(t *testing.T) {
+	config := NewBandwithConfig(nil, nil)
+	err := config.SetClasses([]ClassConfig{
+		{Name: "tenant-a"},
+		{Name: "video", Parent: "tenant-a"},
+		{Name: "bulk", Parent: "tenant-a"},
+		{Name: "tenant-b"},
+	}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// bytes written by a connection of each class, "" is a connection without a class
+	written := map[string]int{"video": 100, "bulk": 20, "tenant-a": 3, "tenant-b": 40, "": 5}
+	for class, n := range written {
+		connRead, connWrite := net.Pipe()
+		connConfig := NewConnectionBandwithConfig(config)
+		connConfig.SetClassification(Classification{Class: class})
+		conn := NewThrottledConnection(connWrite, connConfig)
+		defer conn.Close()
+		go readDataFromConn(connRead)
+
+		if _, err := conn.Write(make([]byte, n)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	root := config.ClassStats()
+
+	tests := []struct {
+		name         string
+		stats        ClassStats
+		class        string
+		bytesWritten int64
+		activeConns  int64
+		ownWritten   int64
+	}{
+		{name: "Root rolls up everything", stats: root, bytesWritten: 168, activeConns: 5, ownWritten: 5},
+		{name: "Parent rolls up its children", stats: root.Children[0], class: "tenant-a", bytesWritten: 123, activeConns: 3, ownWritten: 3},
+		{name: "Leaf", stats: root.Children[0].Children[1], class: "video", bytesWritten: 100, activeConns: 1, ownWritten: 100},
+		{name: "Top level leaf", stats: root.Children[1], class: "tenant-b", bytesWritten: 40, activeConns: 1, ownWritten: 40},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.stats.Class != tt.class {
+				t.Fatalf("expected class %q, got %q", tt.class, tt.stats.Class)
+			}
+			if tt.stats.BytesWritten != tt.bytesWritten {
+				t.Errorf("expected %d bytes written, got %d", tt.bytesWritten, tt.stats.BytesWritten)
+			}
+			if tt.stats.ActiveConns != tt.activeConns {
+				t.Errorf("expected %d active connections, got %d", tt.activeConns, tt.stats.ActiveConns)
+			}
+			if tt.stats.Own.BytesWritten != tt.ownWritten {
+				t.Errorf("expected %d own bytes written, got %d", tt.ownWritten, tt.stats.Own.BytesWritten)
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package netlistener
+
+import "time"
+
+// Clock is the time source of the limiters. Only differences of its times matter, so it does not have to follow the wall clock,
+// it only has to be monotonic
+type Clock interface {
+	Now() time.Time
+}
+
+// SystemClock is the default clock, time.Now carries a monotonic reading, so steps of the wall clock do not affect the limiters.
+// The monotonic clock of the system may stop while it is suspended, waits then last longer by the time of the suspension
+var SystemClock Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// clockEpoch is the origin of monotonic seconds, see monotonicSecond
+var clockEpoch = time.Now()
+
+// monotonicSecond numbers the seconds since the process started, unlike Unix it does not jump when the wall clock is stepped,
+// e.g. by NTP or for a leap second. Times without a monotonic reading fall back to their wall clock
+func monotonicSecond(t time.Time) int64 {
+	d := t.Sub(clockEpoch)
+	if d < 0 {
+		return int64(d/time.Second) - 1
+	}
+
+	return int64(d / time.Second)
+}
+
+// SetClock replaces the time source of the limiters, e.g. with NewBootClock on machines which are suspended.
+// It should be set before any connection is accepted, nil restores SystemClock.
+// The clock covers the limiters and the timing acting on them: the timer wheel, limit ramps, retroactive charging,
+// the penalty box, the fair share and the elastic even split. Deadlines, statistics, events, liveness and the off-peak
+// windows, which follow the time of day, use the system time.
+// Changes of the limits are applied by golang.org/x/time/rate at the system time, which may grant up to a burst
+// when the clocks drifted apart
+func (c *BandwidthConfig) SetClock(clock Clock) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.clock = clock
+	// the ticks of the wheel are counted from its start on the previous clock, waits in progress end on the old wheel
+	if c.wheel != nil {
+		c.wheel = newTimerWheel(c.wheel.config, c.lockedClock().Now)
+	}
+}
+
+func (c *BandwidthConfig) Clock() Clock {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.lockedClock()
+}
+
+// lockedClock is Clock for callers holding the lock of the config
+func (c *BandwidthConfig) lockedClock() Clock {
+	if c.clock == nil {
+		return SystemClock
+	}
+
+	return c.clock
+}
+
+// now returns the current time of the clock of the config
+func (c *BandwidthConfig) now() time.Time {
+	return c.Clock().Now()
+}
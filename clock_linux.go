@@ -0,0 +1,47 @@
+//go:build linux
+
+package netlistener
+
+import (
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+const clockBoottime = 7
+
+// bootClock counts the time since boot including suspension, see NewBootClock
+type bootClock struct {
+	base     time.Time
+	baseBoot time.Duration
+}
+
+// NewBootClock returns a clock advancing while the system is suspended, on linux it is based on CLOCK_BOOTTIME.
+// Its times start at the wall clock when it is created and never follow steps of the wall clock.
+// On other platforms it returns SystemClock
+func NewBootClock() Clock {
+	boot, err := boottime()
+	if err != nil {
+		return SystemClock
+	}
+
+	return &bootClock{base: time.Now().Round(0), baseBoot: boot}
+}
+
+func (c *bootClock) Now() time.Time {
+	boot, err := boottime()
+	if err != nil {
+		return time.Now()
+	}
+
+	return c.base.Add(boot - c.baseBoot)
+}
+
+func boottime() (time.Duration, error) {
+	var ts syscall.Timespec
+	if _, _, errno := syscall.Syscall(syscall.SYS_CLOCK_GETTIME, clockBoottime, uintptr(unsafe.Pointer(&ts)), 0); errno != 0 {
+		return 0, errno
+	}
+
+	return time.Duration(ts.Nano()), nil
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+
+package netlistener
+
+// NewBootClock returns a clock advancing while the system is suspended on linux, it is SystemClock on other platforms
+func NewBootClock() Clock {
+	return SystemClock
+}
@@ -0,0 +1,89 @@
+package netlistener
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// manualClock only advances when told to
+type manualClock struct {
+	now time.Time
+	mu  sync.Mutex
+}
+
+func (c *manualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+func (c *manualClock) advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+}
+
+func TestRateLimitedConnection_Clock(t *testing.T) {
+	clock := &manualClock{now: time.Now()}
+	config := NewBandwithConfig(nil, ptr(10))
+	config.SetClock(clock)
+
+	connRead, connWrite := net.Pipe()
+	defer connWrite.Close()
+	conn := NewThrottledConnection(connRead, NewConnectionBandwithConfig(config))
+	defer conn.Close()
+	go connWrite.Write(make([]byte, 20))
+
+	if _, err := conn.Read(make([]byte, 10)); err != nil {
+		t.Fatal(err)
+	}
+
+	// the bucket refills by the time of the clock, e.g. time the system was suspended, not by the time of the system
+	clock.advance(time.Second)
+
+	start := time.Now()
+	if _, err := conn.Read(make([]byte, 10)); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("expected the bucket to be refilled by the clock, waited %v", elapsed)
+	}
+}
+
+func TestMonotonicSecond(t *testing.T) {
+	tests := []struct {
+		name     string
+		t        time.Time
+		expected int64
+	}{
+		{name: "Epoch", t: clockEpoch, expected: 0},
+		{name: "Later", t: clockEpoch.Add(2500 * time.Millisecond), expected: 2},
+		{name: "Earlier", t: clockEpoch.Add(-500 * time.Millisecond), expected: -1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if second := monotonicSecond(tt.t); second != tt.expected {
+				t.Errorf("expected %d, got %d", tt.expected, second)
+			}
+		})
+	}
+}
+
+func TestNewBootClock(t *testing.T) {
+	clock := NewBootClock()
+
+	start := clock.Now()
+	time.Sleep(20 * time.Millisecond)
+
+	if elapsed := clock.Now().Sub(start); elapsed < 20*time.Millisecond || elapsed > time.Second {
+		t.Errorf("expected the clock to advance with the time, advanced %v", elapsed)
+	}
+	if drift := clock.Now().Sub(time.Now()); drift < -time.Second || drift > time.Second {
+		t.Errorf("expected the clock to start at the wall clock, drifted %v", drift)
+	}
+}
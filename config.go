@@ -1,14 +1,20 @@
 package netlistener
 
 import (
+	"fmt"
 	"math"
+	"net"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"golang.org/x/time/rate"
 )
 
-// bandwithConfig is a configuration that holds the global limiters and per connection rate limit values
-type bandwithConfig struct {
+// BandwidthConfig is a configuration that holds the global limiters and per connection rate limit values.
+// It is shared by all connections of a listener and safe for concurrent use
+type BandwidthConfig struct {
 	// we assume that read and write operations are using separate limiters
 	// otherwise we would need to use a single limiter for both
 	globalWriteLimiter *rate.Limiter
@@ -21,14 +27,133 @@ type bandwithConfig struct {
 	// In this case we have a single place where perConnLimit is defined
 	perConnReadLimit rate.Limit
 
+	// connections matching the exemptions bypass all limiters, but are still counted in stats
+	exemptions  exemptionList
+	healthCheck healthCheckDetection
+	stats       statsCounters
+	// waitTimes and connThroughput are the distributions reported in Stats
+	waitTimes      histogram
+	connThroughput histogram
+	// profilerLabels attaches pprof labels to the goroutines performing throttled I/O
+	profilerLabels bool
+	// sampling bounds how many operations and connections are recorded in the histograms
+	sampling  InstrumentationSampling
+	peers     peerRegistry
+	penalties penaltyBox
+	// peerQuota caps the bytes of each peer, nil if there is none. It is read on every operation
+	peerQuota atomic.Pointer[PeerQuota]
+	classes   classRegistry
+	profiles  profileRegistry
+	families  familyLimits
+	// interfaceLimits are the per interface limits shared with the configs of other listeners, nil if there are none
+	interfaceLimits *InterfaceLimits
+
+	classifier   Classifier
+	eventHandler EventHandler
+	// limitHintHandler is told about changes of the effective per connection limits, nil if there is none
+	limitHintHandler LimitHintHandler
+	// evenSplit derives the per connection limit from the number of open connections, nil uses the configured one
+	evenSplit *EvenSplit
+	elastic   elasticLevel
+	// estimationWindow is the time constant of the throughput estimates of the connections, zero disables them
+	estimationWindow time.Duration
+	// offPeak lifts all limits during a daily time window, nil if there is none
+	offPeak *offPeakWindow
+	// rampDuration spreads changes of the global and per connection limits over time, ramps are the ones in progress
+	rampDuration time.Duration
+	ramps        [rampTargets]*limitRamp
+	// boost lifts the per connection limit at the start of connections without a class boost, nil if there is none
+	boost *Boost
+	// mirror copies the data of sampled connections to a secondary sink, nil if nothing is mirrored
+	mirror *mirrorer
+	// progress emits EventTransferProgress for long running transfers, nil disables it
+	progress     *TransferProgress
+	errorHandler ErrorHandler
+	connTracer   ConnTracer
+	// limitChangeApprover decides on RequestLimitChange, connections may request a change once per limitChangeInterval
+	limitChangeApprover LimitChangeApprover
+	limitChangeInterval time.Duration
+
+	kernelAccounting bool
+	// preambleExemption is the number of bytes at the start of each connection which are not throttled
+	preambleExemption int64
+	// warmupExemption is the number of bytes a connection may transfer in total without ever being throttled
+	warmupExemption int64
+	// pacingSpin is the final part of a wait which is busy waited instead of slept, zero sleeps for the whole wait
+	pacingSpin time.Duration
+	// accounting samples the bytes of the connections every interval, nil accounts every operation. It is read on every operation
+	accounting atomic.Pointer[accountingSampler]
+	// statsBatching makes connections count their bytes locally, it is read on every operation so it is not guarded by mu
+	statsBatching atomic.Pointer[StatsBatching]
+	// wheel wakes up the waits for the limiters, nil uses a runtime timer for each wait
+	wheel *timerWheel
+	// precisionMode shrinks the burst of the per connection limiters for accurate shaping at low rates
+	precisionMode bool
+	// waitJitter is the upper bound of the random delay added to waits which were throttled
+	waitJitter time.Duration
+	sharing    SharingMode
+	fair       fairScheduler
+	// retroactiveWindow is the recent usage charged to limiters when their limits tighten, zero disables it
+	retroactiveWindow time.Duration
+	// burstDebtPayback is the period lowered limits pay back the burst their limiters still hold, zero disables it
+	burstDebtPayback time.Duration
+	debts            burstDebts
+	// strictInterval is the accounting interval of strict mode, zero disables it
+	strictInterval   time.Duration
+	strict           strictWindows
+	conns            connRegistry
+	caps             connCaps
+	recentRejections rejectionRing
+	throughput       throughputHistory
+	alerts           alertEvaluator
+	// abuse detects many distinct sources being rejected within a window
+	abuse abuseDetector
+	// queuePacing holds back writes while too much data is queued in the socket
+	queuePacing         bool
+	writeDeadlinePolicy WriteDeadlinePolicy
+	tlsAccounting       TLSAccounting
+	// keepAlive is applied to accepted TCP connections, nil leaves the default of the listener
+	keepAlive *net.KeepAliveConfig
+	liveness  *LivenessProbe
+	// originalDst looks the original destination of redirected connections up for the classifier
+	originalDst bool
+	// clock is the time source of the limiters, nil means SystemClock
+	clock Clock
+	// bucketFile is where the global token buckets are saved on close, empty if they are not persisted
+	bucketFile string
+	// rdns resolves the names of remote IPs for the classifier, nil when reverse DNS is disabled
+	rdns *reverseDNS
+	// classExhaustion holds the budget exhaustion trackers of classes by name
+	classExhaustion map[string]*exhaustionTracker
+	// alpnClasses maps negotiated ALPN protocols to traffic classes
+	alpnClasses map[string]string
+	// classDSCP maps traffic classes to the DSCP set on the sockets of their connections
+	classDSCP map[string]int
+	// applyMu serializes Apply, so concurrent configurations are not interleaved
+	applyMu sync.Mutex
+	// configVersion is the version of versionedConfig, the configuration at the time the version was last read or applied
+	configVersion   uint64
+	versionedConfig ListenerConfig
+	// configSubscribers are told about the changes made by Apply by their ID, guarded by applyMu
+	configSubscribers map[uint64]ConfigSubscriber
+	nextSubscriber    uint64
+	// limitUpdates wakes the operations waiting for the limiters when the limits are raised
+	limitUpdates LimitUpdates
+
 	// just to be extra safe
 	mu sync.RWMutex
 }
 
+// BandwithConfig is the former name of BandwidthConfig.
+//
+// Deprecated: use BandwidthConfig
+type BandwithConfig = BandwidthConfig
+
+// NewBandwidthConfig creates the config shared by the connections of a listener.
 // Both values are optional, if none of them are set then connection will not be throttled
 // We could add additional validation for the negative values, but I am keeping it simple for now
-func NewBandwithConfig(globalLimit *int, perConnLimit *int) *bandwithConfig {
-	config := &bandwithConfig{}
+func NewBandwidthConfig(globalLimit *int, perConnLimit *int) *BandwidthConfig {
+	config := &BandwidthConfig{}
 
 	config.globalWriteLimiter = rate.NewLimiter(formatRateLimit(globalLimit), formatBurst(globalLimit))
 	config.globalReadLimiter = rate.NewLimiter(formatRateLimit(globalLimit), formatBurst(globalLimit))
@@ -39,142 +164,787 @@ func NewBandwithConfig(globalLimit *int, perConnLimit *int) *bandwithConfig {
 	return config
 }
 
-func (c *bandwithConfig) SetGlobalLimit(globalLimit *int) {
+// NewBandwithConfig creates the config shared by the connections of a listener.
+//
+// Deprecated: use NewBandwidthConfig
+func NewBandwithConfig(globalLimit *int, perConnLimit *int) *BandwidthConfig {
+	return NewBandwidthConfig(globalLimit, perConnLimit)
+}
+
+// SetGlobalLimit changes the global limit, gradually when a limit ramp is set, see SetLimitRamp
+func (c *BandwidthConfig) SetGlobalLimit(globalLimit *int) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	if c.rampLocked(rampGlobal, globalLimit) {
+		return
+	}
+	c.setGlobalLimitLocked(globalLimit)
+}
+
+// setGlobalLimitLocked updates the global limiters in place, the lock of the config has to be held
+func (c *BandwidthConfig) setGlobalLimitLocked(globalLimit *int) {
+	c.setGlobalLimitsLocked(globalLimit, globalLimit)
+}
+
+// setGlobalLimitsLocked updates the global read and write limiters in place, the lock of the config has to be held
+func (c *BandwidthConfig) setGlobalLimitsLocked(readLimit, writeLimit *int) {
+	// limits which tighten are charged the recent usage once they are updated
+	read, write := formatRateLimit(readLimit), formatRateLimit(writeLimit)
+	tightenedRead := c.retroactiveWindow > 0 && c.globalReadLimiter != nil && read < c.globalReadLimiter.Limit()
+	tightenedWrite := c.retroactiveWindow > 0 && c.globalWriteLimiter != nil && write < c.globalWriteLimiter.Limit()
+	// operations waiting for limits which were raised are woken to wait again with the new limits
+	raised := c.globalReadLimiter != nil && read > c.globalReadLimiter.Limit() ||
+		c.globalWriteLimiter != nil && write > c.globalWriteLimiter.Limit()
+
+	// the burst the limiters still hold is turned into debt after the recent usage was charged
+	previousRead, previousWrite := read, write
 	if c.globalWriteLimiter == nil {
-		c.globalWriteLimiter = rate.NewLimiter(formatRateLimit(globalLimit), formatBurst(globalLimit))
+		c.globalWriteLimiter = rate.NewLimiter(write, formatBurst(writeLimit))
 	} else {
-		c.globalWriteLimiter.SetLimit(formatRateLimit(globalLimit))
-		c.globalWriteLimiter.SetBurst(formatBurst(globalLimit))
+		previousWrite = c.globalWriteLimiter.Limit()
+		c.globalWriteLimiter.SetLimit(write)
+		c.globalWriteLimiter.SetBurst(formatBurst(writeLimit))
 	}
 
 	if c.globalReadLimiter == nil {
-		c.globalReadLimiter = rate.NewLimiter(formatRateLimit(globalLimit), formatBurst(globalLimit))
+		c.globalReadLimiter = rate.NewLimiter(read, formatBurst(readLimit))
 	} else {
-		c.globalReadLimiter.SetLimit(formatRateLimit(globalLimit))
-		c.globalReadLimiter.SetBurst(formatBurst(globalLimit))
+		previousRead = c.globalReadLimiter.Limit()
+		c.globalReadLimiter.SetLimit(read)
+		c.globalReadLimiter.SetBurst(formatBurst(readLimit))
+	}
+	invalidateFastPath()
+
+	if tightenedRead || tightenedWrite {
+		c.chargeGlobalDebt(tightenedRead, tightenedWrite, c.retroactiveWindow)
+	}
+	now := c.lockedClock().Now()
+	c.chargeLimitChange(c.globalReadLimiter, previousRead, c.burstDebtPayback, now)
+	c.chargeLimitChange(c.globalWriteLimiter, previousWrite, c.burstDebtPayback, now)
+
+	if raised {
+		c.limitUpdates.Notify()
+	}
+}
+
+// SetPerConnLimit changes the per connection limit, gradually when a limit ramp is set, see SetLimitRamp
+func (c *BandwidthConfig) SetPerConnLimit(perConnLimit *int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.rampLocked(rampPerConn, perConnLimit) {
+		return
+	}
+	c.setPerConnLimitLocked(perConnLimit)
+}
+
+// setPerConnLimitLocked sets the per connection limit picked up by the connections, the lock of the config has to be held
+func (c *BandwidthConfig) setPerConnLimitLocked(perConnLimit *int) {
+	limit := formatRateLimit(perConnLimit)
+	raised := limit > c.perConnReadLimit || limit > c.perConnWriteLimit
+
+	c.perConnReadLimit = limit
+	c.perConnWriteLimit = limit
+	invalidateFastPath()
+
+	if raised {
+		c.limitUpdates.Notify()
+	}
+}
+
+// LimitUpdates returns the notifier of raised limits, custom wrappers can pass it to WaitNUpdatable
+func (c *BandwidthConfig) LimitUpdates() *LimitUpdates {
+	return &c.limitUpdates
+}
+
+// SetFamilyLimit sets a global limit shared by the connections of an address family, on top of the global limit.
+// nil removes the limit of the family
+func (c *BandwidthConfig) SetFamilyLimit(family AddressFamily, limit *int) {
+	if c.families.Set(family, limit) {
+		c.limitUpdates.Notify()
+	}
+}
+
+// SetExemptCIDRs replaces the list of networks whose connections bypass all limiters
+func (c *BandwidthConfig) SetExemptCIDRs(cidrs ...string) error {
+	return c.exemptions.SetCIDRs(cidrs...)
+}
+
+// SetLocalExemption exempts peers in local address ranges, loopback and/or private, from all limiters. Nothing is exempt
+// unless it is set explicitly, zero exempts no range again. A classification with Throttle set overrides it for a connection,
+// e.g. for a private peer which is a tenant like any other
+func (c *BandwidthConfig) SetLocalExemption(local LocalExemption) {
+	c.exemptions.SetLocal(local)
+}
+
+// SetExemptFunc sets a predicate, connections for which it returns true bypass all limiters
+func (c *BandwidthConfig) SetExemptFunc(predicate func(conn net.Conn) bool) {
+	c.exemptions.SetFunc(predicate)
+}
+
+// SetHealthCheckDetection enables detection of health checks: connections closed within maxDuration
+// having transferred less than maxBytes are removed from connection and byte counters.
+// Passing zero maxDuration disables the detection
+func (c *BandwidthConfig) SetHealthCheckDetection(maxDuration time.Duration, maxBytes int64) {
+	c.healthCheck.Set(maxDuration, maxBytes)
+}
+
+// SetPenaltyPolicy enables the penalty box for abusive peers, nil disables it.
+// Penalties are kept per remote IP, so peer tracking is enabled as well
+func (c *BandwidthConfig) SetPenaltyPolicy(policy *PenaltyPolicy) {
+	if policy != nil {
+		c.peers.SetEnabled(true)
 	}
+
+	c.penalties.SetPolicy(policy)
 }
 
-func (c *bandwithConfig) SetPerConnLimit(perConnLimit *int) {
+// SetClassifier sets the classifier evaluated for every accepted connection, nil disables classification
+func (c *BandwidthConfig) SetClassifier(classifier Classifier) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	c.perConnReadLimit = formatRateLimit(perConnLimit)
-	c.perConnWriteLimit = formatRateLimit(perConnLimit)
+	c.classifier = classifier
 }
 
-func (c *bandwithConfig) PerConnWriteLimit() rate.Limit {
+func (c *BandwidthConfig) Classifier() Classifier {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.classifier
+}
+
+// SetClasses replaces the traffic classes, connections are assigned to them by the class of their classification
+// or to the default class. An empty default class leaves unclassified connections outside of any class
+func (c *BandwidthConfig) SetClasses(classes []ClassConfig, defaultClass string) error {
+	if err := c.classes.Set(classes, defaultClass); err != nil {
+		return err
+	}
+
+	// the limits of the classes may have been raised
+	c.limitUpdates.Notify()
+
+	return nil
+}
+
+// SetClassWeight changes the weight of a class in work conserving mode at runtime, waiting connections are woken up,
+// so the global limit is split again within one refill interval
+func (c *BandwidthConfig) SetClassWeight(class string, weight float64) error {
+	if err := c.classes.SetWeight(class, weight); err != nil {
+		return err
+	}
+
+	c.fair.reweight()
+	c.limitUpdates.Notify()
+
+	return nil
+}
+
+// RegisterProfile adds a profile or replaces the one with the same name, including the built-in presets.
+// Connections already using the profile pick up the new limits
+func (c *BandwidthConfig) RegisterProfile(profile Profile) error {
+	if err := c.profiles.Register(profile); err != nil {
+		return err
+	}
+
+	// the limits of the profile may have been raised
+	c.limitUpdates.Notify()
+
+	return nil
+}
+
+// SetDefaultProfile sets the profile of connections the classifier did not assign one, empty removes it.
+// It applies to connections accepted afterwards
+func (c *BandwidthConfig) SetDefaultProfile(name string) error {
+	return c.profiles.SetDefault(name)
+}
+
+// Profiles returns the built-in and registered profiles
+func (c *BandwidthConfig) Profiles() []Profile {
+	return c.profiles.Profiles()
+}
+
+// SetKernelAccounting enables reconciling of every closed connection with the kernel counters of its socket,
+// so bytes bypassing the wrapper show up in stats. Supported on linux only
+func (c *BandwidthConfig) SetKernelAccounting(enabled bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.kernelAccounting = enabled
+}
+
+func (c *BandwidthConfig) KernelAccounting() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.kernelAccounting
+}
+
+// SetALPNClasses maps negotiated ALPN protocols to traffic classes, e.g. "h2" to a class with a bigger per connection limit,
+// since a single HTTP/2 connection multiplexes many streams. It applies to connections accepted through NewTLSListener
+func (c *BandwidthConfig) SetALPNClasses(classes map[string]string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.alpnClasses = classes
+}
+
+// applyALPN moves the connection to the class of the negotiated protocol, if there is one
+func (c *BandwidthConfig) applyALPN(conn net.Conn, protocol string) {
+	c.mu.RLock()
+	class, ok := c.alpnClasses[protocol]
+	c.mu.RUnlock()
+
+	throttled, isThrottled := conn.(*ThrottledConn)
+	if !ok || !isThrottled {
+		return
+	}
+
+	classification := throttled.config.Classification()
+	classification.Class = class
+	throttled.reclassify(classification)
+}
+
+// applySNI classifies the connection again with the server name of the TLS hello, so policies can match it.
+// It returns an error ending the handshake when the connection is denied now
+func (c *BandwidthConfig) applySNI(conn net.Conn, serverName string) error {
+	throttled, isThrottled := conn.(*ThrottledConn)
+	classifier := c.Classifier()
+	if serverName == "" || !isThrottled || classifier == nil {
+		return nil
+	}
+	defer c.recoverPanic("SNI classification")
+
+	meta := throttled.updateMeta(func(meta *ConnMetadata) { meta.SNI = serverName })
+	classification := classifier.Classify(meta)
+	if classification.Deny {
+		c.reject(throttled, RejectDenied, "denied by classifier after TLS hello")
+		return fmt.Errorf("server name %q denied by classifier", serverName)
+	}
+
+	throttled.reclassify(classification)
+
+	return nil
+}
+
+// SetPreambleExemption exempts the first bytes of each connection in each direction from throttling,
+// so the TLS handshake or a protocol preamble is not slowed down when limits are very low. Zero disables it
+func (c *BandwidthConfig) SetPreambleExemption(bytes int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.preambleExemption = bytes
+}
+
+func (c *BandwidthConfig) PreambleExemption() int64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.preambleExemption
+}
+
+// SetWarmupExemption makes connections transferring less than bytes in total never throttled,
+// which suits APIs with many tiny requests mixed with occasional large transfers.
+// Connections exceeding it are charged for everything they transferred so far. Zero disables it
+func (c *BandwidthConfig) SetWarmupExemption(bytes int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.warmupExemption = bytes
+}
+
+func (c *BandwidthConfig) WarmupExemption() int64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.warmupExemption
+}
+
+// SetPacingSpin makes waits for the limiters sleep only until spin before the bytes are allowed and busy wait the rest.
+// On platforms with coarse timers short sleeps coalesce, so low limits produce bursts instead of a steady flow.
+// A spin of a timer resolution or two (e.g. 2ms on windows) keeps the shaping accurate at the cost of CPU. Zero disables it
+func (c *BandwidthConfig) SetPacingSpin(spin time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.pacingSpin = spin
+}
+
+func (c *BandwidthConfig) PacingSpin() time.Duration {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.pacingSpin
+}
+
+// SetPrecisionMode trades throughput for accuracy at low per connection limits.
+// A burst of a second of the limit lets a connection run at twice its limit for the first second, which at rates under 1KB/s
+// makes the measured throughput deviate by more than 20%. In precision mode the burst of the per connection limiters
+// covers only precisionInterval, so reads and writes are split into small chunks each paying its wait,
+// keeping the throughput within 2% of the limit over any transfer longer than a second.
+// It applies to the limiters created or updated afterwards, so it should be set before accepting connections
+func (c *BandwidthConfig) SetPrecisionMode(enabled bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.precisionMode = enabled
+}
+
+func (c *BandwidthConfig) PrecisionMode() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.precisionMode
+}
+
+// SetWaitJitter adds a random delay of up to jitter to every wait which was throttled.
+// Connections sharing a limit otherwise get their refills at the same moments and send in phase-locked bursts,
+// the jitter spreads them so the aggregate output is smoother. Unthrottled operations are never delayed. Zero disables it
+func (c *BandwidthConfig) SetWaitJitter(jitter time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.waitJitter = jitter
+}
+
+func (c *BandwidthConfig) WaitJitter() time.Duration {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.waitJitter
+}
+
+// SetSharingMode decides how connections share the global limit, see SharingMode
+func (c *BandwidthConfig) SetSharingMode(mode SharingMode) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.sharing = mode
+	invalidateFastPath()
+}
+
+func (c *BandwidthConfig) SharingMode() SharingMode {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.sharing
+}
+
+// SetRetroactiveCharging makes tightened limits take the usage of the last window into account.
+// Without it, connections which were transferring at the old limit get a full burst at the new one right away.
+// When a limit is lowered, the bytes transferred within the window above what the new limit allows for it are charged
+// to the limiter first. The window is rounded up to whole seconds and at most 15 seconds. Zero disables it
+func (c *BandwidthConfig) SetRetroactiveCharging(window time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.retroactiveWindow = min(window, (usageWindowSlots-1)*time.Second)
+}
+
+func (c *BandwidthConfig) RetroactiveCharging() time.Duration {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.retroactiveWindow
+}
+
+// SetMaxConns limits the number of open connections, in total and per remote IP, zero means no limit.
+// Connections over a limit are closed right after they were accepted. The per IP limit only counts connections accepted after it was set
+func (c *BandwidthConfig) SetMaxConns(maxConns int64, maxPerIP int) {
+	c.caps.Set(maxConns, maxPerIP)
+}
+
+// SetThroughputHistory keeps the bytes transferred by all connections in every second of the retention,
+// so recent trends can be shown without an external scraper. Zero disables it, which is the default
+func (c *BandwidthConfig) SetThroughputHistory(retention time.Duration) {
+	c.throughput.SetRetention(retention)
+}
+
+// ThroughputHistory returns a sample for every second of the retention, oldest first
+func (c *BandwidthConfig) ThroughputHistory() []ThroughputSample {
+	return c.throughput.Samples(time.Now())
+}
+
+// SetQueuePacing paces writes by the progress the peer acknowledges instead of only by the configured rates.
+// Before each write chunk the socket is inspected through TCP_INFO, and while more than twice the bandwidth-delay product
+// (the delivery rate times the minimum RTT) is queued, the write is held back, so no standing queue builds up in the network.
+// It complements the limiters, which still apply. It is supported for TCP connections on linux only, elsewhere it has no effect
+func (c *BandwidthConfig) SetQueuePacing(enabled bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.queuePacing = enabled
+}
+
+func (c *BandwidthConfig) QueuePacing() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.queuePacing
+}
+
+// SetWriteDeadlinePolicy decides how the write deadline applies to the chunks of a large Write, see WriteDeadlinePolicy
+func (c *BandwidthConfig) SetWriteDeadlinePolicy(policy WriteDeadlinePolicy) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.writeDeadlinePolicy = policy
+}
+
+func (c *BandwidthConfig) WriteDeadlinePolicy() WriteDeadlinePolicy {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.writeDeadlinePolicy
+}
+
+// SetTLSAccounting decides whether NewTLSListener charges wire or application bytes, see TLSAccounting
+func (c *BandwidthConfig) SetTLSAccounting(accounting TLSAccounting) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.tlsAccounting = accounting
+}
+
+func (c *BandwidthConfig) TLSAccounting() TLSAccounting {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.tlsAccounting
+}
+
+// SetEventHandler sets the handler receiving events, nil disables events
+func (c *BandwidthConfig) SetEventHandler(handler EventHandler) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.eventHandler = handler
+}
+
+func (c *BandwidthConfig) emit(event Event) {
+	c.mu.RLock()
+	handler := c.eventHandler
+	c.mu.RUnlock()
+
+	if handler != nil {
+		handler(event)
+	}
+}
+
+// SetPeerTracking enables keeping usage state per remote IP, which can be persisted with SaveState
+func (c *BandwidthConfig) SetPeerTracking(enabled bool) {
+	c.peers.SetEnabled(enabled)
+}
+
+// WarmPeers prepares the state of the expected remote IPs before they connect, e.g. loaded from a list of the clients
+// reconnecting every morning, so the reconnect wave does not allocate and grow the maps of the peers one by one.
+// It enables peer tracking, creates the missing entries at once and sizes the per IP connection counters for them.
+// It fails without changes if one of the addresses is not an IP, and returns the number of peers which were not known yet
+func (c *BandwidthConfig) WarmPeers(ips []string) (int, error) {
+	keys := make([]string, len(ips))
+	for i, ip := range ips {
+		parsed := net.ParseIP(strings.TrimSpace(ip))
+		if parsed == nil {
+			return 0, fmt.Errorf("invalid peer IP %q", ip)
+		}
+		// the keys are the ones of the remote addresses of the connections
+		keys[i] = c.peers.Prefix().key(parsed)
+	}
+
+	c.peers.SetEnabled(true)
+	c.caps.presize(len(keys))
+
+	return c.peers.Warm(keys), nil
+}
+
+// PeerStates returns the usage state of every remote IP seen since peer tracking was enabled
+func (c *BandwidthConfig) PeerStates() map[string]PeerState {
+	return c.peers.Snapshot()
+}
+
+// SaveState persists the per peer state, so it can be restored after a restart
+func (c *BandwidthConfig) SaveState(store StateStore) error {
+	return store.Save(c.peers.Snapshot())
+}
+
+// RestoreState loads previously saved per peer state, enabling peer tracking if it is not enabled yet
+func (c *BandwidthConfig) RestoreState(store StateStore) error {
+	state, err := store.Load()
+	if err != nil {
+		return err
+	}
+
+	c.peers.SetEnabled(true)
+	c.peers.Restore(state)
+
+	return nil
+}
+
+func (c *BandwidthConfig) Stats() Stats {
+	stats := c.stats.snapshot()
+	stats.WaitTimes = c.waitTimes.Percentiles()
+	stats.ConnThroughput = c.connThroughput.Percentiles()
+	mode, _ := c.AccountingMode()
+	stats.Accounting = mode.String()
+	if window := c.ThroughputEstimation(); window > 0 {
+		c.estimateRates(&stats, window)
+	}
+
+	return stats
+}
+
+func (c *BandwidthConfig) PerConnWriteLimit() rate.Limit {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
 	return c.perConnWriteLimit
 }
 
-func (c *bandwithConfig) PerConnReadLimit() rate.Limit {
+func (c *BandwidthConfig) PerConnReadLimit() rate.Limit {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
 	return c.perConnReadLimit
 }
 
-func (c *bandwithConfig) GlobalReadLimiter() *rate.Limiter {
+func (c *BandwidthConfig) GlobalReadLimiter() *rate.Limiter {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
-	return c.globalWriteLimiter
+	return c.globalReadLimiter
 }
 
-func (c *bandwithConfig) GlobalWriteLimiter() *rate.Limiter {
+func (c *BandwidthConfig) GlobalWriteLimiter() *rate.Limiter {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
 	return c.globalWriteLimiter
 }
 
-// connectionBandwithConfig is a wrapper around bandwithConfig that allows to set per connection limits, while keeping the global limits.
+// ConnConfig is a wrapper around BandwidthConfig that allows to set per connection limits, while keeping the global limits.
 // Used for connections that are created by the listener
-type connectionBandwithConfig struct {
-	globalConfig *bandwithConfig
+type ConnConfig struct {
+	globalConfig *BandwidthConfig
 
 	perConnWriteLimiter *rate.Limiter
 	perConnReadLimiter  *rate.Limiter
-	mu                  sync.RWMutex
+
+	// exempt connections skip both global and per connection limiters
+	exempt         bool
+	classification Classification
+	// assignedClass is resolved from the classification when the connection is created, nil if it does not belong to any class
+	assignedClass *classEntry
+	// assignedProfile is resolved like the class, nil if the connection has no profile
+	assignedProfile *profileEntry
+	// session the connection is bound to, nil if there is none
+	session *Session
+	// defaults are the ones of the listener view the connection was wrapped by, nil if there is none
+	defaults *connDefaults
+	// capSlot is the slot reserved under the caps when the connection was admitted, nil if it was not admitted by a listener
+	capSlot *capSlot
+	mu      sync.RWMutex
+	// changes counts the changes of the limiters and the inputs of the limits of the connection, for its fast path
+	changes atomic.Uint64
 }
 
-func NewConnectionBandwithConfig(bandwithConfig *bandwithConfig) *connectionBandwithConfig {
-	config := &connectionBandwithConfig{
-		globalConfig: bandwithConfig,
+// NewConnConfig creates the config of a connection sharing the limits of the config, nil uses DefaultConfig
+func NewConnConfig(bandwidthConfig *BandwidthConfig) *ConnConfig {
+	if bandwidthConfig == nil {
+		bandwidthConfig = DefaultConfig()
+	}
+
+	config := &ConnConfig{
+		globalConfig: bandwidthConfig,
 	}
 
-	config.perConnReadLimiter = rate.NewLimiter(bandwithConfig.perConnReadLimit, parseBurstFromRateLimit(bandwithConfig.perConnReadLimit))
-	config.perConnWriteLimiter = rate.NewLimiter(bandwithConfig.perConnReadLimit, parseBurstFromRateLimit(bandwithConfig.perConnReadLimit))
+	config.perConnReadLimiter = rate.NewLimiter(bandwidthConfig.perConnReadLimit, config.burst(bandwidthConfig.perConnReadLimit))
+	config.perConnWriteLimiter = rate.NewLimiter(bandwidthConfig.perConnReadLimit, config.burst(bandwidthConfig.perConnReadLimit))
 
 	return config
 }
 
-func (c *connectionBandwithConfig) SetPerConnWriteLimit(perConnLimit rate.Limit) {
+// NewConnectionBandwithConfig creates the config of a connection.
+//
+// Deprecated: use NewConnConfig
+func NewConnectionBandwithConfig(bandwidthConfig *BandwidthConfig) *ConnConfig {
+	return NewConnConfig(bandwidthConfig)
+}
+
+func (c *ConnConfig) SetPerConnWriteLimit(perConnLimit rate.Limit) {
+	burst := c.burst(perConnLimit)
+
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
 	if c.perConnWriteLimiter == nil {
-		c.perConnWriteLimiter = rate.NewLimiter(perConnLimit, parseBurstFromRateLimit(perConnLimit))
+		c.perConnWriteLimiter = rate.NewLimiter(perConnLimit, burst)
 	} else {
 		c.perConnWriteLimiter.SetLimit(perConnLimit)
-		c.perConnWriteLimiter.SetBurst(parseBurstFromRateLimit(perConnLimit))
+		c.perConnWriteLimiter.SetBurst(burst)
 	}
+	c.changes.Add(1)
 }
 
-func (c *connectionBandwithConfig) SetPerConnReadLimit(perConnLimit rate.Limit) {
+func (c *ConnConfig) SetPerConnReadLimit(perConnLimit rate.Limit) {
+	burst := c.burst(perConnLimit)
+
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
 	if c.perConnReadLimiter == nil {
-		c.perConnReadLimiter = rate.NewLimiter(perConnLimit, parseBurstFromRateLimit(perConnLimit))
+		c.perConnReadLimiter = rate.NewLimiter(perConnLimit, burst)
 	} else {
 		c.perConnReadLimiter.SetLimit(perConnLimit)
-		c.perConnReadLimiter.SetBurst(parseBurstFromRateLimit(perConnLimit))
+		c.perConnReadLimiter.SetBurst(burst)
+	}
+	c.changes.Add(1)
+}
+
+// burst returns the burst of a per connection limiter, a second of the limit or less in precision mode
+func (c *ConnConfig) burst(limit rate.Limit) int {
+	if profile := c.profile(); profile != nil {
+		return profile.profile.burst(limit)
+	}
+
+	if c.globalConfig.PrecisionMode() {
+		return precisionBurst(limit)
 	}
+
+	return parseBurstFromRateLimit(limit)
+}
+
+func (c *ConnConfig) SetExempt(exempt bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.exempt = exempt
+	c.changes.Add(1)
 }
 
-func (c *connectionBandwithConfig) PerConnWriteLimiter() *rate.Limiter {
+func (c *ConnConfig) Exempt() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.exempt
+}
+
+func (c *ConnConfig) SetClassification(classification Classification) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.classification = classification
+	c.changes.Add(1)
+}
+
+func (c *ConnConfig) Classification() Classification {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.classification
+}
+
+func (c *ConnConfig) setClass(class *classEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.assignedClass = class
+	c.changes.Add(1)
+}
+
+func (c *ConnConfig) class() *classEntry {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.assignedClass
+}
+
+// setProfile assigns the profile and applies its burst to the per connection limiters
+func (c *ConnConfig) setProfile(profile *profileEntry) {
+	c.mu.Lock()
+	c.assignedProfile = profile
+	c.mu.Unlock()
+
+	c.SetPerConnReadLimit(c.PerConnReadLimiter().Limit())
+	c.SetPerConnWriteLimit(c.PerConnWriteLimiter().Limit())
+}
+
+func (c *ConnConfig) profile() *profileEntry {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.assignedProfile
+}
+
+// SwapSession binds the connection to the session, returning the previous one
+func (c *ConnConfig) SwapSession(session *Session) *Session {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	previous := c.session
+	c.session = session
+	c.changes.Add(1)
+
+	return previous
+}
+
+// compareAndSwapSession binds the connection to the session if it is still bound to the old one
+func (c *ConnConfig) compareAndSwapSession(old, session *Session) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.session != old {
+		return false
+	}
+	c.session = session
+	c.changes.Add(1)
+
+	return true
+}
+
+func (c *ConnConfig) Session() *Session {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.session
+}
+
+func (c *ConnConfig) PerConnWriteLimiter() *rate.Limiter {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
 	return c.perConnWriteLimiter
 }
 
-func (c *connectionBandwithConfig) PerConnReadLimiter() *rate.Limiter {
+func (c *ConnConfig) PerConnReadLimiter() *rate.Limiter {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
 	return c.perConnReadLimiter
 }
 
-func (c *connectionBandwithConfig) PerConnWriteLimit() rate.Limit {
+func (c *ConnConfig) PerConnWriteLimit() rate.Limit {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
 	return c.globalConfig.perConnWriteLimit
 }
 
-func (c *connectionBandwithConfig) PerConnReadLimit() rate.Limit {
+func (c *ConnConfig) PerConnReadLimit() rate.Limit {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
 	return c.globalConfig.perConnReadLimit
 }
 
-func (c *connectionBandwithConfig) GlobalReadLimiter() *rate.Limiter {
+func (c *ConnConfig) GlobalReadLimiter() *rate.Limiter {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
 	return c.globalConfig.globalReadLimiter
 }
 
-func (c *connectionBandwithConfig) GlobalWriteLimiter() *rate.Limiter {
+func (c *ConnConfig) GlobalWriteLimiter() *rate.Limiter {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
@@ -197,6 +967,18 @@ func formatBurst(limit *int) int {
 	return *limit
 }
 
+// precisionInterval is the time the burst of a limiter covers in precision mode
+const precisionInterval = 10 * time.Millisecond
+
+// precisionBurst returns the bytes allowed within precisionInterval, at least one so the limiter can make progress
+func precisionBurst(limit rate.Limit) int {
+	if limit == rate.Inf {
+		return 0
+	}
+
+	return max(parseBurstFromRateLimit(limit*rate.Limit(precisionInterval.Seconds())), 1)
+}
+
 func parseBurstFromRateLimit(limit rate.Limit) int {
 	if limit == rate.Inf {
 		return 0
@@ -209,3 +991,10 @@ func parseBurstFromRateLimit(limit rate.Limit) int {
 
 	return int(limit)
 }
+
+// updateLimiter sets the limit and the burst derived from it
+func updateLimiter(limiter *rate.Limiter, limit rate.Limit) {
+	limiter.SetLimit(limit)
+	limiter.SetBurst(parseBurstFromRateLimit(limit))
+	invalidateFastPath()
+}
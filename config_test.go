@@ -0,0 +1,27 @@
+package netlistener
+
+import (
+	"testing"
+
+	"golang.org/x/time/rate"
+)
+
+func TestBandwidthConfig_GlobalLimiters(t *testing.T) {
+	config := NewBandwidthConfig(ptr(1000), nil)
+	if config.GlobalReadLimiter() == config.GlobalWriteLimiter() {
+		t.Fatal("expected separate global limiters for reads and writes")
+	}
+
+	// the coordinator may assign different shares to the directions
+	config.setGlobalShare(coordinationShare{ReadLimit: ptr(100), WriteLimit: ptr(200)})
+
+	if limit := config.GlobalReadLimiter().Limit(); limit != rate.Limit(100) {
+		t.Errorf("expected the global read limit to be 100, got %v", limit)
+	}
+	if limit := config.GlobalWriteLimiter().Limit(); limit != rate.Limit(200) {
+		t.Errorf("expected the global write limit to be 200, got %v", limit)
+	}
+	if connConfig := NewConnConfig(config); connConfig.GlobalReadLimiter() != config.GlobalReadLimiter() {
+		t.Error("expected the connections to use the global read limiter of the config")
+	}
+}
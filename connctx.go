@@ -0,0 +1,25 @@
+package netlistener
+
+import (
+	"context"
+	"net"
+)
+
+type connContextKey struct{}
+
+// ConnContext is meant for http.Server.ConnContext, it stashes the connection in the contexts of its requests,
+// so handlers can get it back with ConnFromContext
+func ConnContext(ctx context.Context, conn net.Conn) context.Context {
+	return context.WithValue(ctx, connContextKey{}, conn)
+}
+
+// ConnFromContext returns the throttled connection of a request served with ConnContext, e.g. to render the current
+// limits and usage of the client from its ConnInfo. It returns false if the connection is unknown or not throttled
+func ConnFromContext(ctx context.Context) (*ThrottledConn, bool) {
+	conn, ok := ctx.Value(connContextKey{}).(net.Conn)
+	if !ok {
+		return nil, false
+	}
+
+	return asThrottled(conn)
+}
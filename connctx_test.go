@@ -0,0 +1,51 @@
+package netlistener
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+)
+
+func TestConnFromContext(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := NewListener(ln, nil, ptr(100000))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := &http.Server{
+		ConnContext: ConnContext,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			conn, ok := ConnFromContext(r.Context())
+			if !ok {
+				http.Error(w, "connection unknown", http.StatusInternalServerError)
+				return
+			}
+
+			fmt.Fprintf(w, "%d", *conn.ConnInfo().WriteLimit)
+		}),
+	}
+	go server.Serve(listener)
+	defer server.Close()
+
+	resp, err := http.Get("http://" + ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || string(body) != "100000" {
+		t.Errorf("expected the per connection limit rendered by the handler, got %d %q", resp.StatusCode, body)
+	}
+
+	if _, ok := ConnFromContext(context.Background()); ok {
+		t.Error("expected no connection in a context without one")
+	}
+}
@@ -0,0 +1,116 @@
+package netlistener
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// ConnectTunnelConfig configures ServeConnectTunnel
+type ConnectTunnelConfig struct {
+	// Dial connects to the requested target, defaults to net.Dialer
+	Dial func(ctx context.Context, network, address string) (net.Conn, error)
+	// Authorize may reject a CONNECT request, e.g. checking Proxy-Authorization, nil allows all requests
+	Authorize func(req *http.Request) error
+	// UpstreamConfig is used to throttle the connection to the target, so both legs of the tunnel are charged.
+	// It may be the config of the listener itself or a separate one, nil leaves the upstream leg unthrottled
+	UpstreamConfig *BandwidthConfig
+	// UpstreamClass is the traffic class upstream connections are assigned to
+	UpstreamClass string
+	// HandshakeTimeout limits the time the client has to send the request, defaults to 10 seconds
+	HandshakeTimeout time.Duration
+	// IdleTimeout closes tunnels without traffic, zero disables it
+	IdleTimeout time.Duration
+}
+
+// ServeConnectTunnel reads an HTTP CONNECT request from an accepted connection, connects to the target
+// and relays data between them until either side is done. The connection is closed when it returns
+func ServeConnectTunnel(conn net.Conn, cfg *ConnectTunnelConfig) error {
+	if cfg == nil {
+		cfg = &ConnectTunnelConfig{}
+	}
+
+	handshakeTimeout := cfg.HandshakeTimeout
+	if handshakeTimeout <= 0 {
+		handshakeTimeout = 10 * time.Second
+	}
+
+	dial := cfg.Dial
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+
+	reader := bufio.NewReader(conn)
+	req, err := http.ReadRequest(reader)
+	if err != nil {
+		conn.Close()
+		recordHandshakeTimeout(conn, err)
+		return fmt.Errorf("reading CONNECT request: %w", err)
+	}
+
+	if req.Method != http.MethodConnect {
+		writeConnectResponse(conn, http.StatusMethodNotAllowed)
+		conn.Close()
+		return fmt.Errorf("unexpected %s request, only CONNECT is supported", req.Method)
+	}
+
+	if cfg.Authorize != nil {
+		if err := cfg.Authorize(req); err != nil {
+			writeConnectResponse(conn, http.StatusForbidden)
+			conn.Close()
+			return fmt.Errorf("CONNECT to %s rejected: %w", req.Host, err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), handshakeTimeout)
+	defer cancel()
+
+	upstream, err := dial(ctx, "tcp", req.Host)
+	if err != nil {
+		writeConnectResponse(conn, http.StatusBadGateway)
+		conn.Close()
+		return fmt.Errorf("connecting to %s: %w", req.Host, err)
+	}
+
+	if cfg.UpstreamConfig != nil {
+		upstreamConfig := NewConnConfig(cfg.UpstreamConfig)
+		upstreamConfig.SetClassification(Classification{Class: cfg.UpstreamClass})
+		upstream = NewThrottledConnection(upstream, upstreamConfig)
+	}
+
+	if err := writeConnectResponse(conn, http.StatusOK); err != nil {
+		upstream.Close()
+		conn.Close()
+		return err
+	}
+
+	conn.SetDeadline(time.Time{})
+
+	// the client may have sent data right after the request, e.g. a TLS client hello
+	if buffered := reader.Buffered(); buffered > 0 {
+		data, _ := reader.Peek(buffered)
+		if _, err := upstream.Write(data); err != nil {
+			upstream.Close()
+			conn.Close()
+			return err
+		}
+	}
+
+	return NewRelay(conn, upstream, &RelayConfig{IdleTimeout: cfg.IdleTimeout, HalfClose: true}).Run()
+}
+
+func writeConnectResponse(conn net.Conn, status int) error {
+	statusText := http.StatusText(status)
+	if status == http.StatusOK {
+		statusText = "Connection established"
+	}
+
+	_, err := fmt.Fprintf(conn, "HTTP/1.1 %d %s\r\n\r\n", status, statusText)
+
+	return err
+}
@@ -0,0 +1,163 @@
+package netlistener
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestServeConnectTunnel(t *testing.T) {
+	echoAddr := startEchoServer(t)
+
+	tests := []struct {
+		name           string
+		method         string
+		target         string
+		authorize      func(req *http.Request) error
+		expectedStatus int
+	}{
+		{
+			name:           "Tunnel established",
+			method:         http.MethodConnect,
+			target:         echoAddr,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Not a CONNECT request",
+			method:         http.MethodGet,
+			target:         echoAddr,
+			expectedStatus: http.StatusMethodNotAllowed,
+		},
+		{
+			name:           "Rejected by authorization",
+			method:         http.MethodConnect,
+			target:         echoAddr,
+			authorize:      func(req *http.Request) error { return fmt.Errorf("missing credentials") },
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "Unreachable target",
+			method:         http.MethodConnect,
+			target:         "127.0.0.1:1",
+			expectedStatus: http.StatusBadGateway,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, server := net.Pipe()
+			defer client.Close()
+
+			config := NewBandwithConfig(nil, ptr(100))
+			throttled := NewThrottledConnection(server, NewConnectionBandwithConfig(config))
+			go ServeConnectTunnel(throttled, &ConnectTunnelConfig{Authorize: tt.authorize})
+
+			target := tt.target
+			if tt.method != http.MethodConnect {
+				target = "/"
+			}
+			go fmt.Fprintf(client, "%s %s HTTP/1.1\r\nHost: %s\r\n\r\n", tt.method, target, tt.target)
+
+			reader := bufio.NewReader(client)
+			resp, err := http.ReadResponse(reader, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if resp.StatusCode != tt.expectedStatus {
+				t.Fatalf("expected status %d, got %d", tt.expectedStatus, resp.StatusCode)
+			}
+
+			if resp.StatusCode != http.StatusOK {
+				return
+			}
+
+			go client.Write([]byte("hello"))
+
+			echoed := make([]byte, 5)
+			if _, err := io.ReadFull(reader, echoed); err != nil {
+				t.Fatal(err)
+			}
+			if string(echoed) != "hello" {
+				t.Errorf("expected echoed data, got %q", echoed)
+			}
+		})
+	}
+}
+
+func TestServeConnectTunnel_UpstreamLegIsCharged(t *testing.T) {
+	echoAddr := startEchoServer(t)
+
+	client, server := net.Pipe()
+	defer client.Close()
+
+	upstreamConfig := NewBandwithConfig(nil, nil)
+	if err := upstreamConfig.SetClasses([]ClassConfig{{Name: "upstream", Rate: 1000}}, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	throttled := NewThrottledConnection(server, NewConnectionBandwithConfig(NewBandwithConfig(nil, nil)))
+	go ServeConnectTunnel(throttled, &ConnectTunnelConfig{UpstreamConfig: upstreamConfig, UpstreamClass: "upstream"})
+
+	go fmt.Fprintf(client, "CONNECT %s HTTP/1.1\r\nHost: %s\r\n\r\n", echoAddr, echoAddr)
+
+	reader := bufio.NewReader(client)
+	if _, err := http.ReadResponse(reader, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	// the budget is charged after each chunk, so the first two chunks of 1000 bytes pass right away
+	go client.Write(make([]byte, 3000))
+	if _, err := io.ReadFull(reader, make([]byte, 3000)); err != nil {
+		t.Fatal(err)
+	}
+
+	if elapsed := time.Since(start); elapsed < 900*time.Millisecond {
+		t.Errorf("expected upstream class limit to throttle the tunnel, took %s", elapsed)
+	}
+	if written := upstreamConfig.Stats().BytesWritten; written != 3000 {
+		t.Errorf("expected 3000 bytes written upstream, got %d", written)
+	}
+}
+
+func TestServeConnectTunnel_LowRateIsNotIdle(t *testing.T) {
+	echoAddr := startEchoServer(t)
+
+	client, server := net.Pipe()
+	defer client.Close()
+
+	throttled := NewThrottledConnection(server, NewConnConfig(NewBandwidthConfig(nil, nil)))
+	done := make(chan error, 1)
+	go func() {
+		done <- ServeConnectTunnel(throttled, &ConnectTunnelConfig{IdleTimeout: 400 * time.Millisecond})
+	}()
+
+	go fmt.Fprintf(client, "CONNECT %s HTTP/1.1\r\nHost: %s\r\n\r\n", echoAddr, echoAddr)
+
+	reader := bufio.NewReader(client)
+	if _, err := http.ReadResponse(reader, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// a few bytes every 100ms keep the tunnel open for more than twice the idle timeout
+	for i := 0; i < 10; i++ {
+		if _, err := client.Write([]byte("hello")); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.ReadFull(reader, make([]byte, 5)); err != nil {
+			t.Fatalf("expected the echo after %d writes, got %v", i, err)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	select {
+	case err := <-done:
+		t.Fatalf("expected the tunnel to stay open, it returned %v", err)
+	default:
+	}
+}
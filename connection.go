@@ -2,54 +2,824 @@ package netlistener
 
 import (
 	"context"
+	"math/rand/v2"
 	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/time/rate"
 )
 
-type throttledConnection struct {
+// operations waiting for the limiters longer than this are considered throttled
+const throttledThreshold = time.Millisecond
+
+var _ net.Conn = (*ThrottledConn)(nil)
+
+// ThrottledConn is a connection whose reads and writes wait for the limiters of its ConnConfig
+type ThrottledConn struct {
 	net.Conn
 
-	config *connectionBandwithConfig
+	config *ConnConfig
+	// peer is shared by all connections from the same remote IP, nil when peer tracking is disabled
+	peer *peerEntry
+
+	acceptedAt   time.Time
+	bytesRead    atomic.Int64
+	bytesWritten atomic.Int64
+	// pendingRead and pendingWritten are the bytes not added to the stats yet when stats are batched
+	pendingRead    atomic.Int64
+	pendingWritten atomic.Int64
+	// unsampledRead and unsampledWritten are the bytes the sampler did not record yet in sampled accounting
+	unsampledRead    atomic.Int64
+	unsampledWritten atomic.Int64
+	// warmedUp is set once the connection outgrew the warm-up exemption, warmupRead and warmupWritten are the bytes
+	// transferred during the warm-up which were not charged yet
+	warmedUp      atomic.Bool
+	warmupRead    atomic.Int64
+	warmupWritten atomic.Int64
+	// exhaustion tracks sustained throttling when the application asked to be notified about it
+	exhaustion atomic.Pointer[exhaustionTracker]
+	// readDeadline and writeDeadline are the deadlines set by the caller in unix nanoseconds, zero when there is none
+	readDeadline  atomic.Int64
+	writeDeadline atomic.Int64
+	// family selects the family limiters, it is resolved once from the remote address
+	family AddressFamily
+	// capKey is the key the connection is counted under for the per IP connection cap, empty if it is not counted
+	capKey string
+	// lastRead is when the peer was last heard from in unix nanoseconds, kept for the liveness probe
+	lastRead atomic.Int64
+	// readWaits counts the reads waiting for the limiters
+	readWaits atomic.Int32
+	lifecycle connLifecycle
+	// trace records the lifetime of the connection if a tracer is set, tracedFirstByte once the first byte was recorded
+	trace           ConnTrace
+	tracedFirstByte atomic.Bool
+	// weight is the weight of the connection in work conserving mode as float64 bits, zero means 1
+	weight atomic.Uint64
+	// lastLimitChange is when the connection last requested a limit change in unix nanoseconds
+	lastLimitChange atomic.Int64
+	// readLimitReason and writeLimitReason are the LimitReason of the per connection limits in effect
+	readLimitReason  atomic.Int32
+	writeLimitReason atomic.Int32
+	// fastRead and fastWrite are the limits generation plus one at which the direction was found unlimited, zero if it was not
+	fastRead  atomic.Uint64
+	fastWrite atomic.Uint64
+	// readRate and writeRate estimate the throughput of the connection when throughput estimation is enabled
+	readRate  rateEstimator
+	writeRate rateEstimator
+	// progressBytes and progressAt are the bytes transferred and the time in unix nanoseconds of the last EventTransferProgress
+	progressBytes atomic.Int64
+	progressAt    atomic.Int64
+	// mirrored is the sink the data of the connection is copied to, nil if it is not mirrored
+	mirrored *mirrorStream
+	// id numbers the connection within the process
+	id uint64
+	// instrumented is whether the detailed instrumentation runs for the connection, ops counts its operations for sampling
+	instrumented bool
+	ops          atomic.Uint64
+	// nested is the throttled connection this one wraps, its limiters are charged by this connection, nil if there is none
+	nested *ThrottledConn
+	// wrapped is the throttled connection wrapping this one once there is one, operations are passed through then
+	wrapped atomic.Pointer[ThrottledConn]
+	// readUsage and writeUsage count the bytes of the last seconds
+	readUsage  usageWindow
+	writeUsage usageWindow
+	// paused is the channel closed by Resume while the connection is paused, nil otherwise
+	paused atomic.Pointer[chan struct{}]
+	// meta is what the connection was classified by, completed when its server name or reverse DNS name is known
+	metaMu sync.Mutex
+	meta   ConnMetadata
+
+	// closed is closed by the first Close, waking operations blocked on the limiters
+	closed chan struct{}
+	// onClose is called once by the first Close, or right away when it is set after Close
+	onClose   atomic.Pointer[func(ConnInfo)]
+	closeOnce sync.Once
+}
+
+// NewThrottledConnection wraps the connection, a nil config uses DefaultConfig
+func NewThrottledConnection(conn net.Conn, config *ConnConfig) *ThrottledConn {
+	if config == nil {
+		config = NewConnConfig(nil)
+	}
+
+	stats := &config.globalConfig.stats
+	stats.acceptedConns.Add(1)
+	stats.activeConns.Add(1)
+
+	config.setClass(config.globalConfig.classes.Get(config.Classification().Class))
+	if profile := config.globalConfig.profiles.Get(config.Classification().Profile); profile != nil {
+		config.setProfile(profile)
+	}
+
+	if config.globalConfig.exemptions.IsExempt(conn) ||
+		!config.Classification().Throttle && config.globalConfig.exemptions.IsLocal(conn) {
+		config.SetExempt(true)
+	}
+
+	if config.Exempt() {
+		stats.exemptConns.Add(1)
+	}
+
+	if class := config.class(); class != nil {
+		class.stats.acceptedConns.Add(1)
+		class.stats.activeConns.Add(1)
+		if config.Exempt() {
+			class.stats.exemptConns.Add(1)
+		}
+	}
+
+	peer := config.globalConfig.peers.Get(conn)
+	if peer != nil {
+		peer.connections.Add(1)
+		peer.open.Add(1)
+		peer.touch()
+	}
+
+	throttled := &ThrottledConn{
+		Conn:       conn,
+		config:     config,
+		peer:       peer,
+		acceptedAt: time.Now(),
+		capKey:     config.globalConfig.caps.track(conn, config.capSlot),
+		closed:     make(chan struct{}),
+		family:     addressFamily(conn),
+
+		id:           connIDs.Add(1),
+		instrumented: config.globalConfig.InstrumentationSampling().sampleConn(),
+	}
+	throttled.lifecycle.entered[ConnAccepted].Store(throttled.acceptedAt.UnixNano())
+	throttled.startTrace()
+	if config.class() != nil {
+		throttled.setState(ConnClassified)
+	}
+	if nested := nestedThrottled(conn); nested != nil {
+		throttled.nest(nested)
+	}
+	config.globalConfig.conns.add(throttled)
+	throttled.markDSCP()
+	if mirror := config.globalConfig.mirrorer(); mirror != nil {
+		throttled.mirrored = mirror.open(throttled)
+	}
+
+	// dead peers are detected by TCP keep-alive where possible, the liveness probe covers the other connections
+	keepAlive := config.globalConfig.applyKeepAlive(conn)
+	if probe := config.globalConfig.LivenessProbe(); probe != nil && !keepAlive {
+		throttled.watchLiveness(*probe)
+	}
+
+	return throttled
+}
+
+// Read never reads more than the smallest burst of the limiters, so buffers bigger than the limit,
+// e.g. the ones of bufio.Reader, do not fail
+func (c *ThrottledConn) Read(b []byte) (n int, err error) {
+	return c.ReadContext(context.Background(), b)
+}
+
+// ReadContext is Read giving up the wait for the limiters when the context is done, the reserved tokens are refunded.
+// The context does not interrupt reading from the underlying connection once the limiters allowed it
+func (c *ThrottledConn) ReadContext(ctx context.Context, b []byte) (n int, err error) {
+	if c.config.globalConfig.ProfilerLabels() {
+		n, err = c.withProfilerLabels(ctx, b, c.readContext)
+	} else {
+		n, err = c.readContext(ctx, b)
+	}
+	c.mirror(true, b[:n])
+
+	return n, err
+}
+
+func (c *ThrottledConn) readContext(ctx context.Context, b []byte) (n int, err error) {
+	if c.isClosed() {
+		return 0, net.ErrClosed
+	}
+
+	// a wrapping throttled connection has waited for the limiters already
+	if c.wrapped.Load() != nil {
+		return c.passThroughRead(b)
+	}
+
+	if err := c.checkQuota(); err != nil {
+		return 0, &ThrottleError{Op: "read", Err: err}
+	}
+	if err := c.waitResumed(ctx, loadDeadline(&c.readDeadline)); err != nil {
+		return 0, &ThrottleError{Op: "read", Err: err}
+	}
+
+	// the preamble of the connection is read without waiting for the limiters
+	if preamble := c.remainingPreamble(c.bytesRead.Load()); preamble > 0 {
+		n, err = c.Conn.Read(b[:min(int64(len(b)), preamble)])
+		c.accountRead(n)
+
+		return n, err
+	}
+
+	warmup, err := c.inWarmup(ctx, true, loadDeadline(&c.readDeadline))
+	if err != nil {
+		return 0, &ThrottleError{Op: "read", Err: err}
+	}
+
+	// unlimited connections skip the limiters until the limits change
+	if warmup || c.fastPath(true) {
+		n, err = c.Conn.Read(b)
+		c.accountRead(n)
+
+		return n, err
+	}
+
+	for {
+		// the channel is taken before the limiters, so a change in between is not missed
+		changed := c.config.globalConfig.limitUpdates.Changed()
+		generation := c.fastPathGeneration()
+		limiters := c.activeLimiters(true)
+		c.updateFastPath(true, generation, limiters)
+		chunk := c.maxChunk(limiters, len(b))
+
+		c.readWaits.Add(1)
+		err := c.waitContext(ctx, changed, limiters, chunk, loadDeadline(&c.readDeadline))
+		c.readWaits.Add(-1)
+		if err == errLimitsChanged {
+			continue
+		}
+		if err != nil {
+			return 0, &ThrottleError{Op: "read", Err: err}
+		}
+
+		b = b[:chunk]
+		break
+	}
+
+	n, err = c.Conn.Read(b)
+	c.accountRead(n)
+
+	return n, err
+}
+
+// Write splits the buffer into chunks not exceeding the smallest burst of the limiters, waiting for each of them.
+// The write deadline bounds the waits as well, see SetWriteDeadlinePolicy for how it applies to the chunks
+func (c *ThrottledConn) Write(b []byte) (n int, err error) {
+	return c.WriteContext(context.Background(), b)
 }
 
-func NewThrottledConnection(conn net.Conn, config *connectionBandwithConfig) *throttledConnection {
-	return &throttledConnection{
-		Conn:   conn,
-		config: config,
+// WriteContext is Write giving up when the context is done while waiting for the limiters.
+// The chunks written before are reported in n, the tokens reserved for the chunk which was not written are refunded.
+// The context does not interrupt writing to the underlying connection once the limiters allowed a chunk
+func (c *ThrottledConn) WriteContext(ctx context.Context, b []byte) (n int, err error) {
+	if c.config.globalConfig.ProfilerLabels() {
+		n, err = c.withProfilerLabels(ctx, b, c.writeContext)
+	} else {
+		n, err = c.writeContext(ctx, b)
+	}
+	c.mirror(false, b[:n])
+
+	return n, err
+}
+
+func (c *ThrottledConn) writeContext(ctx context.Context, b []byte) (n int, err error) {
+	if c.isClosed() {
+		return 0, net.ErrClosed
+	}
+
+	if c.wrapped.Load() != nil {
+		return c.passThroughWrite(b)
+	}
+
+	if err := c.checkQuota(); err != nil {
+		return 0, &ThrottleError{Op: "write", Err: err}
+	}
+	if err := c.waitResumed(ctx, loadDeadline(&c.writeDeadline)); err != nil {
+		return 0, &ThrottleError{Op: "write", Err: err}
+	}
+
+	// the preamble of the connection is written without waiting for the limiters, the rest is throttled as usual
+	if preamble := c.remainingPreamble(c.bytesWritten.Load()); preamble > 0 {
+		n, err = c.Conn.Write(b[:min(int64(len(b)), preamble)])
+		c.accountWrite(n)
+		if err != nil || n == len(b) {
+			return n, err
+		}
+
+		rest, err := c.writeContext(ctx, b[n:])
+
+		return n + rest, err
+	}
+
+	warmup, err := c.inWarmup(ctx, false, loadDeadline(&c.writeDeadline))
+	if err != nil {
+		return 0, &ThrottleError{Op: "write", Err: err}
+	}
+	if warmup {
+		n, err = c.Conn.Write(b)
+		c.accountWrite(n)
+
+		return n, err
 	}
+
+	// unlimited connections skip the limiters until the limits change
+	if c.fastPath(false) {
+		if err := c.paceQueue(ctx, loadDeadline(&c.writeDeadline)); err != nil {
+			return 0, &ThrottleError{Op: "write", Err: err}
+		}
+		n, err = c.Conn.Write(b)
+		c.accountWrite(n)
+
+		return n, err
+	}
+
+	deadlines := c.newChunkDeadlines(len(b))
+	defer deadlines.restore()
+
+	for n < len(b) {
+		// the limiters are picked up for every chunk, a wait interrupted by raised limits is retried with the new ones
+		changed := c.config.globalConfig.limitUpdates.Changed()
+		generation := c.fastPathGeneration()
+		limiters := c.activeLimiters(false)
+		c.updateFastPath(false, generation, limiters)
+		chunk := b[n:][:c.maxChunk(limiters, len(b)-n)]
+
+		deadline := deadlines.next(n + len(chunk))
+		err := c.waitContext(ctx, changed, limiters, len(chunk), deadline)
+		if err == errLimitsChanged {
+			continue
+		}
+		if err == nil {
+			err = c.paceQueue(ctx, deadline)
+		}
+		if err != nil {
+			return n, &ThrottleError{Op: "write", Err: err}
+		}
+
+		written, err := c.Conn.Write(chunk)
+		c.accountWrite(written)
+		n += written
+		if err != nil {
+			return n, err
+		}
+	}
+
+	return n, nil
 }
 
-// In a real-world scenario we need to handle the case when the size of the buffer is bigger than the limit
-// In that case we would split it by chunks
-func (c *throttledConnection) Read(b []byte) (n int, err error) {
-	if err := c.config.GlobalReadLimiter().WaitN(context.TODO(), len(b)); err != nil {
-		return 0, err
+// isClosed reports whether Close was called
+func (c *ThrottledConn) isClosed() bool {
+	select {
+	case <-c.closed:
+		return true
+	default:
+		return false
 	}
+}
+
+// remainingPreamble returns how many more bytes are exempt from throttling in a direction which already transferred done bytes
+func (c *ThrottledConn) remainingPreamble(done int64) int64 {
+	return c.config.globalConfig.PreambleExemption() - done
+}
 
-	if c.config.globalConfig.PerConnReadLimit() != c.config.PerConnReadLimiter().Limit() {
-		c.config.SetPerConnReadLimit(c.config.globalConfig.perConnReadLimit)
+// activeLimiters returns the limiters an operation has to wait for, merged with the ones of a nested throttled connection
+func (c *ThrottledConn) activeLimiters(read bool) []*rate.Limiter {
+	return c.withNested(c.ownLimiters(read), read)
+}
+
+// ownLimiters returns the limiters of the connection itself, none if the connection is exempt or within the off-peak window.
+// The per connection limiter is updated first, in case the effective per connection limit has changed
+func (c *ThrottledConn) ownLimiters(read bool) []*rate.Limiter {
+	if c.config.Exempt() || c.config.globalConfig.offPeakUnlimited(time.Now()) {
+		return nil
 	}
 
-	if err := c.config.PerConnReadLimiter().WaitN(context.TODO(), len(b)); err != nil {
-		return 0, err
+	if read {
+		limit, reason := c.effectivePerConnLimit(c.config.globalConfig.PerConnReadLimit(), true)
+		previousReason := LimitReason(c.readLimitReason.Swap(int32(reason)))
+		if previous := c.config.PerConnReadLimiter().Limit(); limit != previous {
+			c.config.SetPerConnReadLimit(limit)
+			// the bytes of a boost are a gift, they are not charged once the limit is clamped
+			if limit < previous && previousReason != LimitReasonBoost {
+				c.chargeConnDebt(c.config.PerConnReadLimiter(), &c.readUsage)
+			}
+			c.chargeBurstDebt(c.config.PerConnReadLimiter(), previous)
+			c.hintLimit(true, previous, limit, reason)
+		}
+	} else {
+		limit, reason := c.effectivePerConnLimit(c.config.globalConfig.PerConnWriteLimit(), false)
+		previousReason := LimitReason(c.writeLimitReason.Swap(int32(reason)))
+		if previous := c.config.PerConnWriteLimiter().Limit(); limit != previous {
+			c.config.SetPerConnWriteLimit(limit)
+			if limit < previous && previousReason != LimitReasonBoost {
+				c.chargeConnDebt(c.config.PerConnWriteLimiter(), &c.writeUsage)
+			}
+			c.chargeBurstDebt(c.config.PerConnWriteLimiter(), previous)
+			c.hintLimit(false, previous, limit, reason)
+		}
 	}
 
-	return c.Conn.Read(b)
+	return c.limiters(read)
+}
+
+// limiters returns the limiter of the local interface, the limiter of the address family, the global limiter, the limiters of the connection class and its parents,
+// the limiter of a shared profile, the limiter of the session and the per connection limiter
+func (c *ThrottledConn) limiters(read bool) []*rate.Limiter {
+	classLimiters := c.config.globalConfig.classes.Limiters(c.config.class(), read)
+	session := c.config.Session()
+	profile := c.config.profile()
+	if profile != nil && !profile.profile.Shared {
+		profile = nil
+	}
+
+	limiters := make([]*rate.Limiter, 0, len(classLimiters)+6)
+	if iface := c.interfaceLimiter(read); iface != nil {
+		limiters = append(limiters, iface)
+	}
+	if family := c.config.globalConfig.families.Limiter(c.family, read); family != nil {
+		limiters = append(limiters, family)
+	}
+	if read {
+		limiters = append(limiters, c.config.GlobalReadLimiter())
+		limiters = append(limiters, classLimiters...)
+		if profile != nil {
+			limiters = append(limiters, profile.readLimiter)
+		}
+		if session != nil {
+			limiters = append(limiters, session.readLimiter)
+		}
+		limiters = append(limiters, c.config.PerConnReadLimiter())
+	} else {
+		limiters = append(limiters, c.config.GlobalWriteLimiter())
+		limiters = append(limiters, classLimiters...)
+		if profile != nil {
+			limiters = append(limiters, profile.writeLimiter)
+		}
+		if session != nil {
+			limiters = append(limiters, session.writeLimiter)
+		}
+		limiters = append(limiters, c.config.PerConnWriteLimiter())
+	}
+
+	return limiters
+}
+
+// wait blocks until all limiters allow n bytes.
+// If the operation had to wait, it is recorded as throttled for the penalty box
+func (c *ThrottledConn) wait(limiters []*rate.Limiter, n int) error {
+	return c.waitUntil(limiters, n, time.Time{})
+}
+
+// waitUntil is wait failing with os.ErrDeadlineExceeded when the limiters would not allow n bytes before the deadline,
+// a zero deadline waits as long as needed
+func (c *ThrottledConn) waitUntil(limiters []*rate.Limiter, n int, deadline time.Time) error {
+	return c.waitContext(context.Background(), nil, limiters, n, deadline)
+}
+
+// waitContext is waitUntil failing with the error of the context when it is done during the wait,
+// and with errLimitsChanged when changed is closed, nil never is. Tokens reserved for a wait which failed are refunded to the limiters
+func (c *ThrottledConn) waitContext(ctx context.Context, changed <-chan struct{}, limiters []*rate.Limiter, n int, deadline time.Time) error {
+	start := time.Now()
+	c.markThrottled(limiters, n)
+
+	tokens := c.tokens(limiters, n)
+	strict, err := c.waitStrict(ctx, changed, limiters, tokens, deadline)
+	if err != nil {
+		return err
+	}
+
+	if err := paceTokens(ctx, c.config.globalConfig.Clock(), c.config.globalConfig.timerWheel(), c.closed, changed, limiters, tokens, c.config.globalConfig.PacingSpin(), deadline); err != nil {
+		if strict {
+			c.config.globalConfig.strict.release(limiters, tokens, c.config.globalConfig.StrictMode(), c.config.globalConfig.now())
+		}
+		return err
+	}
+
+	waited := time.Since(start)
+	c.markActive()
+	c.observeWait(waited)
+	sampled := len(limiters) > 0 && c.sampleOp()
+	if sampled {
+		c.config.globalConfig.waitTimes.record(int64(waited))
+	}
+
+	if waited > throttledThreshold {
+		c.onThrottled()
+		if sampled {
+			c.traceWait(waited, n)
+		}
+
+		// connections sharing a limiter are woken up in the same order every refill, jitter breaks the phase lock
+		if jitter := c.config.globalConfig.WaitJitter(); jitter > 0 {
+			time.Sleep(rand.N(jitter))
+		}
+	}
+
+	return nil
+}
+
+// perConnLimit returns the per connection limit which should be applied right now.
+// The classification, the profile or the class may override the configured limit, it is capped by the fair share of the global limit
+// in work conserving mode, and it is lower while the peer is in the penalty box
+func (c *ThrottledConn) perConnLimit(configured rate.Limit, read bool) rate.Limit {
+	limit, _ := c.effectivePerConnLimit(configured, read)
+
+	return limit
+}
+
+// effectivePerConnLimit is perConnLimit also returning what decided the limit
+func (c *ThrottledConn) effectivePerConnLimit(configured rate.Limit, read bool) (rate.Limit, LimitReason) {
+	reason := LimitReasonConfigured
+	split, elastic, splitting := c.config.globalConfig.evenSplitLimit(read)
+	if splitting {
+		configured, reason = split, LimitReasonEvenSplit
+	}
+	if limit, ok := c.config.defaultPerConnLimit(); ok {
+		configured, reason = limit, LimitReasonView
+	}
+
+	// in elastic even split mode the limits of the connection cap its share instead of replacing it
+	if limit, overridden, ok := c.overrideLimit(read); ok && (!elastic || limit < configured) {
+		configured, reason = limit, overridden
+	}
+	if c.boosted(read) {
+		configured, reason = rate.Inf, LimitReasonBoost
+	}
+
+	if c.config.globalConfig.SharingMode() == SharingWorkConserving {
+		global := c.config.GlobalWriteLimiter().Limit()
+		if read {
+			global = c.config.GlobalReadLimiter().Limit()
+		}
+
+		if share := c.config.globalConfig.fair.share(c, global, read, c.config.globalConfig.now()); share < configured {
+			configured, reason = share, LimitReasonFairShare
+		}
+	}
+
+	if c.peer == nil {
+		return configured, reason
+	}
+
+	penaltyLimit, penalized, ended := c.config.globalConfig.penalties.Limit(c.peer, c.config.globalConfig.now())
+	if ended != nil {
+		ended.Peer = c.peer.key
+		c.config.globalConfig.emit(*ended)
+	}
+
+	if penalized && penaltyLimit < configured {
+		return penaltyLimit, LimitReasonPenalty
+	}
+
+	return configured, reason
+}
+
+// overrideLimit returns the limit of the classification, the profile or the class of the connection replacing the configured one,
+// false if there is none
+func (c *ThrottledConn) overrideLimit(read bool) (rate.Limit, LimitReason, bool) {
+	if override := c.config.Classification().PerConnLimit; override != nil {
+		return formatRateLimit(override), LimitReasonClassification, true
+	} else if profileLimit, ok := c.config.profile().limit(read); ok {
+		return formatRateLimit(profileLimit), LimitReasonProfile, true
+	} else if classLimit := c.config.globalConfig.classes.PerConnLimit(c.config.class()); classLimit != nil {
+		return formatRateLimit(classLimit), LimitReasonClass, true
+	}
+
+	return 0, 0, false
+}
+
+func (c *ThrottledConn) onThrottled() {
+	if event, penalized := c.config.globalConfig.penalties.RecordHit(c.peer, c.config.globalConfig.now()); penalized {
+		event.Peer = c.peer.key
+		c.config.globalConfig.emit(event)
+	}
+}
+
+// accountRead updates connection, global and peer counters after a read.
+// In sampled accounting only the counters of the connection are updated, the sampler records the rest
+func (c *ThrottledConn) accountRead(n int) {
+	now := time.Now()
+	c.bytesRead.Add(int64(n))
+	if n > 0 {
+		c.lastRead.Store(now.UnixNano())
+		c.markActive()
+	}
+	if c.config.globalConfig.accounting.Load() != nil {
+		c.unsampledRead.Add(int64(n))
+		return
+	}
+	c.recordRead(now, n)
+}
+
+// recordRead updates the global, usage, peer and session counters after n bytes were read
+func (c *ThrottledConn) recordRead(now time.Time, n int) {
+	// usage is charged to the limiters retroactively, so it is accounted on their clock
+	c.readUsage.add(c.config.globalConfig.now(), int64(n))
+	c.estimate(now, n, true)
+	c.config.globalConfig.throughput.add(now, int64(n), 0)
+	c.countBytes(n, true)
+	if c.peer != nil {
+		c.peer.bytesRead.Add(int64(n))
+		c.peer.touch()
+	}
+	if session := c.config.Session(); session != nil {
+		session.bytesRead.Add(int64(n))
+	}
+	if n > 0 {
+		c.checkProgress(now)
+	}
+}
+
+// accountWrite updates connection, global and peer counters after a write.
+// In sampled accounting only the counters of the connection are updated, the sampler records the rest
+func (c *ThrottledConn) accountWrite(n int) {
+	now := time.Now()
+	c.bytesWritten.Add(int64(n))
+	if n > 0 {
+		c.markActive()
+	}
+	if c.config.globalConfig.accounting.Load() != nil {
+		c.unsampledWritten.Add(int64(n))
+		return
+	}
+	c.recordWrite(now, n)
+}
+
+// recordWrite updates the global, usage, peer and session counters after n bytes were written
+func (c *ThrottledConn) recordWrite(now time.Time, n int) {
+	c.writeUsage.add(c.config.globalConfig.now(), int64(n))
+	c.estimate(now, n, false)
+	c.config.globalConfig.throughput.add(now, 0, int64(n))
+	c.countBytes(n, false)
+	if c.peer != nil {
+		c.peer.bytesWritten.Add(int64(n))
+		c.peer.touch()
+	}
+	if session := c.config.Session(); session != nil {
+		session.bytesWritten.Add(int64(n))
+	}
+	if n > 0 {
+		c.checkProgress(now)
+	}
+}
+
+// reclassify replaces the classification of an already accepted connection, moving it to the class of the new classification.
+// The connection is active in the new class from now on, while the bytes it transferred stay with the previous class.
+// The defaults of the listener view the connection was wrapped by fill what the classification leaves empty
+func (c *ThrottledConn) reclassify(classification Classification) {
+	classification = c.config.defaults.fill(classification)
+	c.config.SetClassification(classification)
+	// the batched bytes were transferred in the previous class
+	c.sample(time.Now())
+	c.flushStats()
+	if previous := c.classCounters(); previous != nil {
+		previous.activeConns.Add(-1)
+	}
+	c.config.setClass(c.config.globalConfig.classes.Get(classification.Class))
+	if class := c.classCounters(); class != nil {
+		class.activeConns.Add(1)
+	}
+	c.config.setProfile(c.config.globalConfig.profiles.Get(classification.Profile))
+	c.setState(ConnClassified)
+	c.markDSCP()
+}
+
+// updateMeta completes the metadata the connection is classified by and returns a copy of it
+func (c *ThrottledConn) updateMeta(update func(meta *ConnMetadata)) ConnMetadata {
+	c.metaMu.Lock()
+	defer c.metaMu.Unlock()
+
+	update(&c.meta)
+	meta := c.meta
+	meta.Tags = append([]string(nil), c.meta.Tags...)
+
+	return meta
+}
+
+// NetConn returns the underlying connection
+func (c *ThrottledConn) NetConn() net.Conn {
+	return c.Conn
+}
+
+// Reconcile compares the bytes accounted by the wrapper to the kernel counters of the socket.
+// It returns ErrKernelAccountingUnsupported on platforms other than linux and for connections which are not TCP
+func (c *ThrottledConn) Reconcile() (AccountingReport, error) {
+	counters, err := kernelCounters(c.Conn)
+	if err != nil {
+		return AccountingReport{}, err
+	}
+
+	return AccountingReport{
+		BytesRead:    c.bytesRead.Load(),
+		BytesWritten: c.bytesWritten.Load(),
+		Kernel:       counters,
+	}, nil
+}
+
+// Close is idempotent, only the first call closes the underlying connection and reports its error, later calls return nil.
+// Operations blocked on the limiters fail with net.ErrClosed and so do the ones started afterwards
+func (c *ThrottledConn) Close() (err error) {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		c.setState(ConnDraining)
+
+		stats := &c.config.globalConfig.stats
+		stats.activeConns.Add(-1)
+		c.config.globalConfig.releaseEvenSplit()
+		class := c.classCounters()
+		if class != nil {
+			class.activeConns.Add(-1)
+		}
+
+		if session := c.config.SwapSession(nil); session != nil {
+			session.release(c)
+		}
+
+		c.config.globalConfig.fair.remove(c)
+		c.config.globalConfig.conns.remove(c)
+		c.sample(time.Now())
+		c.flushStats()
+		c.config.globalConfig.debts.clear(c.config.PerConnReadLimiter(), c.config.PerConnWriteLimiter())
+		c.config.globalConfig.strict.clear(c.config.PerConnReadLimiter(), c.config.PerConnWriteLimiter())
+		c.config.globalConfig.caps.release(c.capKey)
+		if c.peer != nil {
+			c.peer.open.Add(-1)
+		}
+		if c.mirrored != nil {
+			c.mirrored.close()
+		}
+
+		// socket has to be reconciled before it is closed
+		if c.config.globalConfig.KernelAccounting() {
+			if report, err := c.Reconcile(); err == nil {
+				stats.unaccountedBytesRead.Add(report.UnaccountedRead())
+				stats.unaccountedBytesWritten.Add(report.UnaccountedWritten())
+				if class != nil {
+					class.unaccountedBytesRead.Add(report.UnaccountedRead())
+					class.unaccountedBytesWritten.Add(report.UnaccountedWritten())
+				}
+			}
+		}
+
+		read, written := c.bytesRead.Load(), c.bytesWritten.Load()
+		lifetime := time.Since(c.acceptedAt)
+		healthCheck := c.config.globalConfig.healthCheck.IsHealthCheck(lifetime, read+written)
+		if c.instrumented && !healthCheck && read+written > 0 && lifetime > 0 {
+			c.config.globalConfig.connThroughput.record(int64(float64(read+written) / lifetime.Seconds()))
+		}
+		if healthCheck {
+			stats.healthChecks.Add(1)
+			stats.acceptedConns.Add(-1)
+			stats.bytesRead.Add(-read)
+			stats.bytesWritten.Add(-written)
+			if c.config.Exempt() {
+				stats.exemptConns.Add(-1)
+			}
+			if class != nil {
+				class.healthChecks.Add(1)
+				class.acceptedConns.Add(-1)
+				class.bytesRead.Add(-read)
+				class.bytesWritten.Add(-written)
+				if c.config.Exempt() {
+					class.exemptConns.Add(-1)
+				}
+			}
+		}
+
+		hook := c.onClose.Swap(nil)
+		var info ConnInfo
+		if hook != nil {
+			// the kernel statistics are gone once the socket is closed
+			info = c.ConnInfo()
+		}
+
+		err = c.Conn.Close()
+		c.setState(ConnClosed)
+		c.finishTrace()
+
+		if hook != nil {
+			(*hook)(info)
+		}
+	})
+
+	return err
 }
 
-// In a real-world scenario we need to handle the case when the size of the buffer is bigger than the limit
-// In that case we would split it by chunks
-func (c *throttledConnection) Write(b []byte) (n int, err error) {
-	if err := c.config.GlobalWriteLimiter().WaitN(context.TODO(), len(b)); err != nil {
-		return 0, err
+// OnClose calls the hook exactly once with the final counters of the connection when it is closed,
+// right away if it is closed already. It replaces the hook set before, nil removes it
+func OnClose(conn net.Conn, hook func(info ConnInfo)) error {
+	throttled, ok := conn.(*ThrottledConn)
+	if !ok {
+		return ErrNotThrottled
 	}
 
-	if c.config.globalConfig.PerConnWriteLimit() != c.config.PerConnWriteLimiter().Limit() {
-		c.config.SetPerConnWriteLimit(c.config.globalConfig.perConnReadLimit)
+	if hook == nil {
+		throttled.onClose.Store(nil)
+		return nil
 	}
 
-	if err := c.config.PerConnWriteLimiter().WaitN(context.TODO(), len(b)); err != nil {
-		return 0, err
+	throttled.onClose.Store(&hook)
+	// Close may have swapped the hooks before this one was stored, whoever swaps it out calls it
+	if throttled.isClosed() {
+		if hook := throttled.onClose.Swap(nil); hook != nil {
+			(*hook)(throttled.ConnInfo())
+		}
 	}
 
-	return c.Conn.Write(b)
+	return nil
 }
@@ -2,9 +2,11 @@ package netlistener
 
 import (
 	"crypto/rand"
+	"errors"
 	"io"
 	"net"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -140,8 +142,9 @@ func TestRateLimitedConnection_PerConnLimit_Write(t *testing.T) {
 			start := time.Now()
 
 			for i := 0; i < tt.numberOfChunks; i++ {
-				writeRandomDataToConn(throttledConn, tt.bufSize)
+				writeRandomData(throttledConn, tt.bufSize)
 			}
+			throttledConn.Close()
 
 			elapsedTime := time.Since(start)
 
@@ -334,8 +337,9 @@ func TestRateLimitedConnection_GlobalLimiter_Write(t *testing.T) {
 					defer wg.Done()
 
 					for i := 0; i < tt.numberOfChunks; i++ {
-						writeRandomDataToConn(throttledConn, tt.randomDataSize)
+						writeRandomData(throttledConn, tt.randomDataSize)
 					}
+					throttledConn.Close()
 
 					maxElapsedTime = time.Since(start)
 				}()
@@ -352,6 +356,11 @@ func TestRateLimitedConnection_GlobalLimiter_Write(t *testing.T) {
 func writeRandomDataToConn(conn net.Conn, size int) {
 	defer conn.Close()
 
+	writeRandomData(conn, size)
+}
+
+// writeRandomData writes without closing the connection, operations after Close fail right away
+func writeRandomData(conn net.Conn, size int) {
 	buf := make([]byte, size)
 	_, _ = rand.Read(buf)
 	conn.Write(buf)
@@ -367,3 +376,67 @@ func readDataFromConn(conn net.Conn) {
 		}
 	}
 }
+
+func TestRateLimitedConnection_Close(t *testing.T) {
+	config := NewBandwithConfig(ptr(50), ptr(50))
+
+	connRead, connWrite := net.Pipe()
+	defer connWrite.Close()
+	conn := NewThrottledConnection(connRead, NewConnectionBandwithConfig(config))
+	go connWrite.Write(make([]byte, 50))
+
+	if _, err := conn.Read(make([]byte, 50)); err != nil {
+		t.Fatal(err)
+	}
+
+	var calls atomic.Int32
+	var info ConnInfo
+	if err := OnClose(conn, func(i ConnInfo) { calls.Add(1); info = i }); err != nil {
+		t.Fatal(err)
+	}
+
+	blocked := make(chan error, 1)
+	go func() {
+		_, err := conn.Read(make([]byte, 50))
+		blocked <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	start := time.Now()
+	if err := conn.Close(); err != nil {
+		t.Fatalf("expected the first close to succeed, got %v", err)
+	}
+	if err := conn.Close(); err != nil {
+		t.Errorf("expected repeated close to return nil, got %v", err)
+	}
+
+	if err := <-blocked; !errors.Is(err, net.ErrClosed) {
+		t.Errorf("expected the blocked read to fail with net.ErrClosed, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 200*time.Millisecond {
+		t.Errorf("expected the blocked read to wake up on close, took %v", elapsed)
+	}
+
+	if _, err := conn.Read(make([]byte, 1)); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("expected read after close to fail with net.ErrClosed, got %v", err)
+	}
+	if _, err := conn.Write(make([]byte, 1)); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("expected write after close to fail with net.ErrClosed, got %v", err)
+	}
+
+	if calls.Load() != 1 || info.BytesRead != 50 {
+		t.Errorf("expected the hook once with 50 bytes read, got %d calls and %d bytes", calls.Load(), info.BytesRead)
+	}
+
+	// a hook set after close is called right away
+	if err := OnClose(conn, func(ConnInfo) { calls.Add(1) }); err != nil {
+		t.Fatal(err)
+	}
+	if calls.Load() != 2 {
+		t.Errorf("expected the late hook to be called, got %d calls", calls.Load())
+	}
+
+	if err := OnClose(connRead, func(ConnInfo) {}); err != ErrNotThrottled {
+		t.Errorf("expected ErrNotThrottled for a plain connection, got %v", err)
+	}
+}
@@ -0,0 +1,64 @@
+package netlistener
+
+import "time"
+
+// ConnInfo combines the accounting of the wrapper with the kernel view of the socket,
+// so diagnostics can tell throttling apart from network problems
+type ConnInfo struct {
+	// ID numbers the connection within the process, it is the value of the ProfilerLabelConn profiler label
+	ID           uint64 `json:"id"`
+	BytesRead    int64  `json:"bytes_read"`
+	BytesWritten int64  `json:"bytes_written"`
+	// ReadLimit and WriteLimit are the per connection limits in effect, nil means unlimited
+	ReadLimit  *int `json:"read_limit"`
+	WriteLimit *int `json:"write_limit"`
+	// ReadLimitReason and WriteLimitReason tell what decided the limits, e.g. "even_split" or "class", see LimitReason
+	ReadLimitReason  string `json:"read_limit_reason"`
+	WriteLimitReason string `json:"write_limit_reason"`
+	Exempt           bool   `json:"exempt"`
+	// ReadRate and WriteRate are the estimated throughput in bytes per second, set when throughput estimation is enabled
+	ReadRate  int64 `json:"read_rate,omitempty"`
+	WriteRate int64 `json:"write_rate,omitempty"`
+
+	// TCP is nil on platforms other than linux and darwin and for connections which are not TCP
+	TCP *TCPInfo `json:"tcp,omitempty"`
+}
+
+// TCPInfo is a portable subset of the kernel statistics of a TCP socket
+type TCPInfo struct {
+	RTT    time.Duration `json:"rtt"`
+	RTTVar time.Duration `json:"rtt_var"`
+	// SndCwnd is the congestion window, in segments on linux and in bytes on darwin
+	SndCwnd     uint32 `json:"snd_cwnd"`
+	SndMSS      uint32 `json:"snd_mss"`
+	Retransmits uint64 `json:"retransmits"`
+	// PacingRate and DeliveryRate are in bytes per second, linux only
+	PacingRate   uint64 `json:"pacing_rate,omitempty"`
+	DeliveryRate uint64 `json:"delivery_rate,omitempty"`
+}
+
+// ConnInfo returns the counters and limits of the connection together with the TCP statistics of its socket
+func (c *ThrottledConn) ConnInfo() ConnInfo {
+	info := ConnInfo{
+		ID:           c.id,
+		BytesRead:    c.bytesRead.Load(),
+		BytesWritten: c.bytesWritten.Load(),
+		ReadLimit:    limitToInt(c.config.PerConnReadLimiter().Limit()),
+		WriteLimit:   limitToInt(c.config.PerConnWriteLimiter().Limit()),
+		Exempt:       c.config.Exempt(),
+
+		ReadLimitReason:  LimitReason(c.readLimitReason.Load()).String(),
+		WriteLimitReason: LimitReason(c.writeLimitReason.Load()).String(),
+	}
+
+	if window := c.config.globalConfig.ThroughputEstimation(); window > 0 {
+		now := time.Now()
+		info.ReadRate, info.WriteRate = c.readRate.rateAt(now, window), c.writeRate.rateAt(now, window)
+	}
+
+	if tcpInfo, err := tcpInfoOf(c.socket()); err == nil {
+		info.TCP = &tcpInfo
+	}
+
+	return info
+}
@@ -0,0 +1,353 @@
+package netlistener
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+const (
+	// maxResponseHeader bounds the response header buffered for parsing, a longer header stops the parsing for the connection
+	maxResponseHeader = 64 << 10
+	// maxRequestLine bounds the request line buffered to learn the method of a request, the rest of a longer line is skipped
+	maxRequestLine = 8 << 10
+	// maxPendingRequests bounds the methods of pipelined requests waiting for their responses
+	maxPendingRequests = 64
+	// maxPacedBody is the largest Content-Length paced on reservations, longer bodies are throttled like bodies of unknown length
+	maxPacedBody = 1 << 40
+	// bodyWindowBursts is the number of bursts of the per connection limiter reserved ahead of the body being written
+	bodyWindowBursts = 4
+)
+
+var headerEnd = []byte("\r\n\r\n")
+
+// ContentLengthConn paces HTTP/1.x responses of known size. It parses the response headers on the write path and reserves
+// the tokens for the declared Content-Length on the per connection limiter a few bursts ahead, then spreads the body
+// evenly over the time each reservation takes, instead of waiting for the limiter chunk by chunk. The limiters shared
+// with other connections, e.g. the global one, are waited for chunk by chunk as usual, so a large body does not hold
+// them back. Headers and bodies of unknown length are throttled as usual, the parsing stops for good at the first
+// response delimited by chunked encoding or by closing the connection, and after a protocol switch.
+// The methods of the requests are picked up on the read path, so responses to HEAD requests are known to have no body
+type ContentLengthConn struct {
+	*ThrottledConn
+
+	// header buffers a response header written in several parts
+	header []byte
+	// body is the reservation of the response body being written, nil between responses
+	body     *bodyReservation
+	disabled bool
+	mu       sync.Mutex
+
+	requests requestMethods
+}
+
+// bodyReservation is the schedule of a response body. The tokens of the window of bytes from windowStart to windowEnd were
+// reserved on limiter, byte k of it is due at start + duration*(k-windowStart)/(windowEnd-windowStart)
+type bodyReservation struct {
+	// limiter is the per connection limiter the windows are reserved on, nil if the connection has none
+	limiter *rate.Limiter
+	// reserved tells whether the tokens of the current window were taken from the limiter
+	reserved bool
+	length   int64
+	written  int64
+
+	windowStart int64
+	windowEnd   int64
+	start       time.Time
+	duration    time.Duration
+}
+
+// requestMethods keeps the methods of the requests read which were not answered yet, oldest first
+type requestMethods struct {
+	mu      sync.Mutex
+	line    []byte
+	skip    bool
+	pending []string
+}
+
+// observe scans data read from the connection for request lines
+func (r *requestMethods) observe(data []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for len(data) > 0 {
+		end := bytes.IndexByte(data, '\n')
+		if end < 0 {
+			if !r.skip && len(r.line)+len(data) <= maxRequestLine {
+				r.line = append(r.line, data...)
+			} else {
+				r.line, r.skip = r.line[:0], true
+			}
+			return
+		}
+
+		if !r.skip && len(r.line)+end <= maxRequestLine {
+			r.line = append(r.line, data[:end]...)
+			if method, ok := requestMethod(r.line); ok && len(r.pending) < maxPendingRequests {
+				r.pending = append(r.pending, method)
+			}
+		}
+		r.line, r.skip = r.line[:0], false
+		data = data[end+1:]
+	}
+}
+
+// next returns the method of the oldest request which was not answered, GET if none is known
+func (r *requestMethods) next() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.pending) == 0 {
+		return http.MethodGet
+	}
+
+	method := r.pending[0]
+	r.pending = r.pending[1:]
+
+	return method
+}
+
+// requestMethod returns the method of an HTTP/1.x request line
+func requestMethod(line []byte) (string, bool) {
+	fields := strings.Fields(string(line))
+	if len(fields) != 3 || !strings.HasPrefix(fields[2], "HTTP/1.") {
+		return "", false
+	}
+
+	return fields[0], true
+}
+
+// bodyless reports whether the response to a request with the method has no body regardless of its headers
+func bodyless(method string, status int) bool {
+	return method == http.MethodHead || status < 200 || status == http.StatusNoContent || status == http.StatusNotModified
+}
+
+// NewContentLengthConn wraps a connection accepted by the throttled listener
+func NewContentLengthConn(conn net.Conn) (*ContentLengthConn, error) {
+	throttled, ok := conn.(*ThrottledConn)
+	if !ok {
+		return nil, ErrNotThrottled
+	}
+
+	return &ContentLengthConn{ThrottledConn: throttled}, nil
+}
+
+// Read reads throttled as usual and picks up the methods of the requests
+func (c *ContentLengthConn) Read(b []byte) (int, error) {
+	n, err := c.ThrottledConn.Read(b)
+	c.requests.observe(b[:n])
+
+	return n, err
+}
+
+// Write writes the response headers throttled as usual and paces the bodies of known length on their reservation
+func (c *ContentLengthConn) Write(b []byte) (n int, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for n < len(b) && err == nil {
+		var written int
+		written, err = c.writeStep(b[n:])
+		n += written
+	}
+
+	return n, err
+}
+
+func (c *ContentLengthConn) writeStep(b []byte) (int, error) {
+	switch {
+	case c.disabled:
+		return c.ThrottledConn.Write(b)
+	case c.body != nil:
+		return c.writeBody(b)
+	default:
+		return c.writeHeader(b)
+	}
+}
+
+// writeHeader writes the part of b up to the end of the response header and prepares the pacing of the body
+func (c *ContentLengthConn) writeHeader(b []byte) (int, error) {
+	buffered := len(c.header)
+	c.header = append(c.header, b...)
+
+	end := bytes.Index(c.header, headerEnd)
+	if end < 0 {
+		if len(c.header) > maxResponseHeader {
+			c.disabled, c.header = true, nil
+		}
+
+		return c.ThrottledConn.Write(b)
+	}
+
+	end += len(headerEnd)
+	response, parseErr := http.ReadResponse(bufio.NewReader(bytes.NewReader(c.header[:end])), nil)
+	c.header = c.header[:0]
+
+	n, err := c.ThrottledConn.Write(b[:end-buffered])
+	if err != nil {
+		return n, err
+	}
+
+	switch {
+	case parseErr != nil, response.StatusCode == http.StatusSwitchingProtocols:
+		c.disabled = true
+	case response.StatusCode < 200:
+		// an informational response precedes the final response to the same request
+	case bodyless(c.requests.next(), response.StatusCode):
+		// no body follows
+	case response.ContentLength < 0, response.ContentLength > maxPacedBody:
+		c.disabled = true
+	case response.ContentLength > 0:
+		c.body = &bodyReservation{limiter: c.bodyLimiter(), length: response.ContentLength}
+	}
+	if response != nil {
+		response.Body.Close()
+	}
+
+	return n, nil
+}
+
+// bodyLimiter returns the per connection write limiter if the writes are throttled by it
+func (c *ContentLengthConn) bodyLimiter() *rate.Limiter {
+	limiter := c.config.PerConnWriteLimiter()
+	if slices.Contains(c.activeLimiters(false), limiter) {
+		return limiter
+	}
+
+	return nil
+}
+
+// reserveWindow takes the tokens of the next window of the body from the per connection limiter,
+// the window is due when they are paid back
+func (c *ContentLengthConn) reserveWindow() {
+	body := c.body
+	remaining := body.length - body.written
+	body.windowStart, body.windowEnd = body.written, body.length
+	body.start, body.duration, body.reserved = time.Now(), 0, false
+
+	limiter := body.limiter
+	if limiter == nil || limiter.Limit() == rate.Inf || limiter.Limit() <= 0 || limiter.Burst() <= 0 {
+		return
+	}
+
+	size := min(remaining, bodyWindowBursts*int64(limiter.Burst()))
+	body.windowEnd = body.written + size
+
+	now := c.config.globalConfig.now()
+	reserve(limiter, size, now)
+	body.reserved = true
+	if deficit := -limiter.TokensAt(now); deficit > 0 {
+		body.duration = time.Duration(deficit / float64(limiter.Limit()) * float64(time.Second))
+	}
+}
+
+// writeBody writes the next part of the body once it is due on its reservation and the shared limiters allow it
+func (c *ContentLengthConn) writeBody(b []byte) (int, error) {
+	body := c.body
+	if body.written == body.windowEnd {
+		c.reserveWindow()
+	}
+
+	changed := c.config.globalConfig.limitUpdates.Changed()
+	shared := slices.DeleteFunc(c.activeLimiters(false), func(limiter *rate.Limiter) bool { return limiter == body.limiter })
+	chunk := b[:c.maxChunk(shared, int(min(int64(len(b)), body.windowEnd-body.written)))]
+
+	window := body.windowEnd - body.windowStart
+	due := body.start.Add(time.Duration(float64(body.duration) * float64(body.written-body.windowStart+int64(len(chunk))) / float64(window)))
+	if err := c.waitDue(due); err != nil {
+		return 0, &ThrottleError{Op: "write", Err: err}
+	}
+
+	if len(shared) > 0 {
+		err := c.waitContext(context.Background(), changed, shared, len(chunk), loadDeadline(&c.writeDeadline))
+		if err == errLimitsChanged {
+			return 0, nil
+		}
+		if err != nil {
+			return 0, &ThrottleError{Op: "write", Err: err}
+		}
+	}
+
+	n, err := c.ThrottledConn.Conn.Write(chunk)
+	c.accountWrite(n)
+	c.mirror(false, chunk[:n])
+
+	body.written += int64(n)
+	if body.written == body.length {
+		c.body = nil
+	}
+
+	return n, err
+}
+
+func (c *ContentLengthConn) waitDue(due time.Time) error {
+	wait := time.Until(due)
+	if wait <= 0 {
+		return nil
+	}
+	if deadline := loadDeadline(&c.writeDeadline); !deadline.IsZero() && due.After(deadline) {
+		return os.ErrDeadlineExceeded
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-c.closed:
+		return net.ErrClosed
+	}
+}
+
+// releaseBody gives the tokens of the part of the window which was not written back to the per connection limiter
+func (c *ContentLengthConn) releaseBody() {
+	if remaining := c.body.windowEnd - c.body.written; c.body.reserved && remaining > 0 && c.body.limiter.Limit() != rate.Inf {
+		c.body.limiter.ReserveN(c.config.globalConfig.now(), -int(remaining))
+	}
+
+	c.body = nil
+}
+
+// Close closes the connection and refunds the tokens reserved for a body which was not written completely
+func (c *ContentLengthConn) Close() error {
+	// the connection is closed first, so a write waiting for its body to be due returns and releases the lock
+	err := c.ThrottledConn.Close()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.body != nil {
+		c.releaseBody()
+	}
+
+	return err
+}
+
+// ContentLengthListener wraps the throttled connections accepted by the listener with NewContentLengthConn,
+// so an http.Server serving it paces the responses of known size
+type ContentLengthListener struct {
+	net.Listener
+}
+
+func (l ContentLengthListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	if paced, err := NewContentLengthConn(conn); err == nil {
+		return paced, nil
+	}
+
+	return conn, nil
+}
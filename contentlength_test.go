@@ -0,0 +1,163 @@
+package netlistener
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestContentLengthConn_Parsing(t *testing.T) {
+	tests := []struct {
+		name string
+		// requests are read by the connection before the writes
+		requests string
+		writes   []string
+		body     bool
+		disabled bool
+	}{
+		{name: "Header and body in one write", writes: []string{"HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\nhello"}},
+		{name: "Header split across writes", writes: []string{"HTTP/1.1 200 OK\r\nContent-", "Length: 5\r\n\r\n"}, body: true},
+		{name: "Body written in parts", writes: []string{"HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\nhel", "lo"}},
+		{name: "Response without a body", writes: []string{"HTTP/1.1 304 Not Modified\r\nContent-Length: 5\r\n\r\n"}},
+		{name: "No content", writes: []string{"HTTP/1.1 204 No Content\r\nContent-Length: 5\r\n\r\n"}},
+		{name: "Response to HEAD", requests: "HEAD / HTTP/1.1\r\nHost: example.com\r\n\r\n", writes: []string{"HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\n"}},
+		{name: "Response to HEAD followed by the next response", requests: "HEAD / HTTP/1.1\r\nHost: example.com\r\n\r\nGET / HTTP/1.1\r\nHost: example.com\r\n\r\n", writes: []string{
+			"HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\n",
+			"HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\nhel",
+		}, body: true},
+		{name: "Informational response before the final one", writes: []string{"HTTP/1.1 100 Continue\r\n\r\n", "HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\n"}, body: true},
+		{name: "Oversized length is throttled like an unknown one", writes: []string{"HTTP/1.1 200 OK\r\nContent-Length: 9223372036854775807\r\n\r\nhello"}, disabled: true},
+		{name: "Chunked response stops the parsing", writes: []string{"HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n"}, disabled: true},
+		{name: "Protocol switch stops the parsing", writes: []string{"HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\n\r\n"}, disabled: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, server := net.Pipe()
+			received := make(chan []byte)
+			go func() {
+				data, _ := io.ReadAll(client)
+				received <- data
+			}()
+
+			conn, err := NewContentLengthConn(NewThrottledConnection(server, NewConnectionBandwithConfig(NewBandwithConfig(nil, nil))))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.requests != "" {
+				go client.Write([]byte(tt.requests))
+				if _, err := io.ReadFull(conn, make([]byte, len(tt.requests))); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			for _, write := range tt.writes {
+				if n, err := conn.Write([]byte(write)); err != nil || n != len(write) {
+					t.Fatalf("expected %d bytes written, got %d: %v", len(write), n, err)
+				}
+			}
+
+			if (conn.body != nil) != tt.body || conn.disabled != tt.disabled {
+				t.Errorf("expected body %v and disabled %v, got %v and %v", tt.body, tt.disabled, conn.body != nil, conn.disabled)
+			}
+
+			conn.Close()
+			if data := <-received; string(data) != strings.Join(tt.writes, "") {
+				t.Errorf("expected %q, got %q", strings.Join(tt.writes, ""), data)
+			}
+		})
+	}
+
+	if _, err := NewContentLengthConn(&addrConn{}); err != ErrNotThrottled {
+		t.Errorf("expected ErrNotThrottled, got %v", err)
+	}
+}
+
+func TestContentLengthConn_Pacing(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	go readDataFromConn(client)
+
+	conn, err := NewContentLengthConn(NewThrottledConnection(server, NewConnectionBandwithConfig(NewBandwithConfig(nil, ptr(500)))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	header := "HTTP/1.1 200 OK\r\nContent-Length: 1000\r\n\r\n"
+	start := time.Now()
+	if _, err := conn.Write(append([]byte(header), bytes.Repeat([]byte("x"), 1000)...)); err != nil {
+		t.Fatal(err)
+	}
+
+	// the header takes its tokens from the full burst, the body is due once the rest of the reservation is paid back
+	expected := time.Duration(float64(len(header)+1000-500) / 500 * float64(time.Second))
+	if elapsed := time.Since(start); elapsed < expected-100*time.Millisecond || elapsed > expected+500*time.Millisecond {
+		t.Errorf("expected the response to take about %v, took %v", expected, elapsed)
+	}
+}
+
+func TestContentLengthConn_SharedLimiters(t *testing.T) {
+	config := NewBandwithConfig(ptr(10000), nil)
+
+	bodyClient, bodyServer := net.Pipe()
+	defer bodyClient.Close()
+	go readDataFromConn(bodyClient)
+
+	conn, err := NewContentLengthConn(NewThrottledConnection(bodyServer, NewConnectionBandwithConfig(config)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// a body which takes about 100s at the global limit
+	go conn.Write(append([]byte("HTTP/1.1 200 OK\r\nContent-Length: 1000000\r\n\r\n"), make([]byte, 1000000)...))
+	time.Sleep(100 * time.Millisecond)
+
+	otherClient, otherServer := net.Pipe()
+	defer otherClient.Close()
+	go readDataFromConn(otherClient)
+
+	other := NewThrottledConnection(otherServer, NewConnectionBandwithConfig(config))
+	defer other.Close()
+
+	start := time.Now()
+	if _, err := other.Write(make([]byte, 100)); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("expected the write of another connection to take turns with the body, took %v", elapsed)
+	}
+}
+
+func TestRequestMethods(t *testing.T) {
+	tests := []struct {
+		name     string
+		reads    []string
+		expected []string
+	}{
+		{name: "Single request", reads: []string{"HEAD / HTTP/1.1\r\nHost: example.com\r\n\r\n"}, expected: []string{"HEAD"}},
+		{name: "Request line split across reads", reads: []string{"HE", "AD /index.html HT", "TP/1.1\r\n\r\n"}, expected: []string{"HEAD"}},
+		{name: "Pipelined requests", reads: []string{"GET / HTTP/1.1\r\n\r\nHEAD / HTTP/1.1\r\n\r\n"}, expected: []string{"GET", "HEAD"}},
+		{name: "Request body is skipped", reads: []string{"POST / HTTP/1.1\r\nContent-Length: 4\r\n\r\nbody"}, expected: []string{"POST"}},
+		{name: "Overlong line is skipped", reads: []string{strings.Repeat("x", maxRequestLine+1), " / HTTP/1.1\r\n"}, expected: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests requestMethods
+			for _, read := range tt.reads {
+				requests.observe([]byte(read))
+			}
+
+			if !slices.Equal(requests.pending, tt.expected) {
+				t.Errorf("expected methods %v, got %v", tt.expected, requests.pending)
+			}
+		})
+	}
+}
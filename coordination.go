@@ -0,0 +1,199 @@
+package netlistener
+
+import (
+	"encoding/json"
+	"net"
+	"sync"
+	"time"
+)
+
+// coordinationReport is sent by a process to the coordinator, the bytes it transferred since the previous report
+type coordinationReport struct {
+	BytesRead    int64 `json:"bytes_read"`
+	BytesWritten int64 `json:"bytes_written"`
+}
+
+// coordinationShare is the part of the host-wide global limit assigned to a process, nil means unlimited
+type coordinationShare struct {
+	ReadLimit  *int `json:"read_limit"`
+	WriteLimit *int `json:"write_limit"`
+}
+
+// GlobalLimitCoordinator enforces one global limit across processes on the same host,
+// e.g. processes accepting on the same port with SO_REUSEPORT, which otherwise enforce the global limit each on its own.
+// Processes join over a local socket and report their usage periodically, the coordinator splits the limit between them:
+// half of it evenly and the other half in proportion to the usage, so busy processes get more while idle ones can still ramp up.
+// A process is granted at most what the others were not granted with their last reports, so the shares only add up to more
+// than the limit by the floor of an eighth of the even share every process gets, e.g. while a new process waits for the others
+// to report and give up part of theirs
+type GlobalLimitCoordinator struct {
+	limit     *int
+	processes map[*coordinatedProcess]struct{}
+
+	mu sync.Mutex
+}
+
+type coordinatedProcess struct {
+	lastReport coordinationReport
+	// grantedRead and grantedWrite are the shares the process got with its last report, zero while the limit is unset
+	grantedRead  int
+	grantedWrite int
+}
+
+func NewGlobalLimitCoordinator(limit *int) *GlobalLimitCoordinator {
+	return &GlobalLimitCoordinator{
+		limit:     limit,
+		processes: make(map[*coordinatedProcess]struct{}),
+	}
+}
+
+// SetLimit changes the host-wide limit, processes get their new shares with their next report
+func (c *GlobalLimitCoordinator) SetLimit(limit *int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.limit = limit
+}
+
+// Serve accepts processes on the listener, usually a unix socket, until it fails
+func (c *GlobalLimitCoordinator) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+
+		go c.ServeConn(conn)
+	}
+}
+
+// ServeConn answers the reports of a single process until it disconnects, its share is then released to the others
+func (c *GlobalLimitCoordinator) ServeConn(conn net.Conn) error {
+	defer conn.Close()
+
+	process := &coordinatedProcess{}
+
+	c.mu.Lock()
+	c.processes[process] = struct{}{}
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		delete(c.processes, process)
+		c.mu.Unlock()
+	}()
+
+	decoder := json.NewDecoder(conn)
+	encoder := json.NewEncoder(conn)
+	for {
+		var report coordinationReport
+		if err := decoder.Decode(&report); err != nil {
+			return err
+		}
+
+		if err := encoder.Encode(c.report(process, report)); err != nil {
+			return err
+		}
+	}
+}
+
+// report records the usage of the process and returns its share
+func (c *GlobalLimitCoordinator) report(process *coordinatedProcess, report coordinationReport) coordinationShare {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// usage is never negative, whatever the process reports
+	process.lastReport = coordinationReport{BytesRead: max(report.BytesRead, 0), BytesWritten: max(report.BytesWritten, 0)}
+	if c.limit == nil {
+		process.grantedRead, process.grantedWrite = 0, 0
+		return coordinationShare{}
+	}
+
+	var totalRead, totalWritten int64
+	var othersRead, othersWritten int
+	for p := range c.processes {
+		totalRead += p.lastReport.BytesRead
+		totalWritten += p.lastReport.BytesWritten
+		if p != process {
+			othersRead += p.grantedRead
+			othersWritten += p.grantedWrite
+		}
+	}
+
+	processes := len(c.processes)
+	process.grantedRead = grantedShare(*c.limit, processes, process.lastReport.BytesRead, totalRead, othersRead)
+	process.grantedWrite = grantedShare(*c.limit, processes, process.lastReport.BytesWritten, totalWritten, othersWritten)
+	readLimit, writeLimit := process.grantedRead, process.grantedWrite
+
+	return coordinationShare{ReadLimit: &readLimit, WriteLimit: &writeLimit}
+}
+
+// grantedShare limits the share of a process to what the other processes were not granted,
+// but never below the floor of an eighth of the even share, so no process stalls
+func grantedShare(limit int, processes int, usage int64, totalUsage int64, othersGranted int) int {
+	floor := max(limit/8/processes, 1)
+	share := min(coordinatedShare(limit, processes, usage, totalUsage), limit-othersGranted)
+
+	return max(share, floor)
+}
+
+// coordinatedShare splits half of the limit evenly between the processes and the other half by usage,
+// evenly as well when no process transferred anything
+func coordinatedShare(limit int, processes int, usage int64, totalUsage int64) int {
+	if totalUsage == 0 {
+		return limit / processes
+	}
+
+	even := limit / 2 / processes
+	proportional := int(int64(limit-limit/2) * usage / totalUsage)
+
+	return even + proportional
+}
+
+// CoordinateGlobalLimit joins a GlobalLimitCoordinator over conn and reports the usage every interval,
+// applying the share of the host-wide limit it gets back as the global limit of this process.
+// It blocks until the connection fails, the last share stays in effect afterwards
+func (c *BandwidthConfig) CoordinateGlobalLimit(conn net.Conn, interval time.Duration) error {
+	defer conn.Close()
+
+	decoder := json.NewDecoder(conn)
+	encoder := json.NewEncoder(conn)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var lastRead, lastWritten int64
+	for {
+		// the counters can go back, e.g. when the traffic of health checks is subtracted, which is not negative usage
+		read, written := c.stats.bytesRead.Load(), c.stats.bytesWritten.Load()
+		report := coordinationReport{BytesRead: max(read-lastRead, 0), BytesWritten: max(written-lastWritten, 0)}
+		if err := encoder.Encode(report); err != nil {
+			return err
+		}
+		lastRead, lastWritten = read, written
+
+		var share coordinationShare
+		if err := decoder.Decode(&share); err != nil {
+			return err
+		}
+		c.setGlobalShare(share)
+
+		<-ticker.C
+	}
+}
+
+// setGlobalShare applies the limits assigned by the coordinator to the global limiters like SetGlobalLimit,
+// so waits are woken up by raised shares. A share which is the same for both directions is ramped, see SetLimitRamp
+func (c *BandwidthConfig) setGlobalShare(share coordinationShare) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if formatRateLimit(share.ReadLimit) == formatRateLimit(share.WriteLimit) {
+		if c.rampLocked(rampGlobal, share.WriteLimit) {
+			return
+		}
+	} else {
+		c.ramps[rampGlobal] = nil
+	}
+	c.setGlobalLimitsLocked(share.ReadLimit, share.WriteLimit)
+}
@@ -0,0 +1,146 @@
+package netlistener
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+func TestCoordinatedShare(t *testing.T) {
+	tests := []struct {
+		name       string
+		processes  int
+		usage      int64
+		totalUsage int64
+		expected   int
+	}{
+		{
+			name:      "Idle processes share evenly",
+			processes: 4,
+			expected:  250,
+		},
+		{
+			name:       "Busy process gets the proportional half",
+			processes:  2,
+			usage:      900,
+			totalUsage: 900,
+			expected:   750,
+		},
+		{
+			name:       "Idle process keeps its even part",
+			processes:  2,
+			totalUsage: 900,
+			expected:   250,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if share := coordinatedShare(1000, tt.processes, tt.usage, tt.totalUsage); share != tt.expected {
+				t.Errorf("expected share %d, got %d", tt.expected, share)
+			}
+		})
+	}
+}
+
+func TestGlobalLimitCoordinator(t *testing.T) {
+	coordinator := NewGlobalLimitCoordinator(ptr(1000))
+
+	configs := []*BandwidthConfig{NewBandwithConfig(nil, nil), NewBandwithConfig(nil, nil)}
+	for _, config := range configs {
+		processConn, coordinatorConn := net.Pipe()
+		go coordinator.ServeConn(coordinatorConn)
+		go config.CoordinateGlobalLimit(processConn, 20*time.Millisecond)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for _, config := range configs {
+		for config.GlobalWriteLimiter().Limit() != rate.Limit(500) && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		if limit := config.GlobalWriteLimiter().Limit(); limit != rate.Limit(500) {
+			t.Errorf("expected each process to get half of the global limit, got %v", limit)
+		}
+	}
+}
+
+func TestGrantedShare(t *testing.T) {
+	tests := []struct {
+		name          string
+		processes     int
+		usage         int64
+		totalUsage    int64
+		othersGranted int
+		expected      int
+	}{
+		{name: "Share within what is left", processes: 2, othersGranted: 500, expected: 500},
+		{name: "Share limited to what is left", processes: 2, othersGranted: 700, expected: 300},
+		{name: "Floor while the others hold the limit", processes: 2, othersGranted: 1000, expected: 62},
+		{name: "Busy process limited to what is left", processes: 2, usage: 900, totalUsage: 900, othersGranted: 500, expected: 500},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if share := grantedShare(1000, tt.processes, tt.usage, tt.totalUsage, tt.othersGranted); share != tt.expected {
+				t.Errorf("expected share %d, got %d", tt.expected, share)
+			}
+		})
+	}
+}
+
+func TestGlobalLimitCoordinator_Report(t *testing.T) {
+	coordinator := NewGlobalLimitCoordinator(ptr(1000))
+	first, second := &coordinatedProcess{}, &coordinatedProcess{}
+
+	coordinator.processes[first] = struct{}{}
+	if share := coordinator.report(first, coordinationReport{BytesRead: 900, BytesWritten: 900}); *share.WriteLimit != 1000 {
+		t.Errorf("expected a single process to get the whole limit, got %d", *share.WriteLimit)
+	}
+
+	// the second process gets the floor until the first one gave up part of its share
+	coordinator.processes[second] = struct{}{}
+	if share := coordinator.report(second, coordinationReport{BytesRead: -100, BytesWritten: -100}); *share.WriteLimit != 62 || *share.ReadLimit != 62 {
+		t.Errorf("expected the floor, got %d and %d", *share.ReadLimit, *share.WriteLimit)
+	}
+	if second.lastReport.BytesRead != 0 {
+		t.Errorf("expected negative usage to be recorded as none, got %d", second.lastReport.BytesRead)
+	}
+
+	coordinator.report(first, coordinationReport{BytesRead: 900, BytesWritten: 900})
+	coordinator.report(second, coordinationReport{})
+	if sum := first.grantedWrite + second.grantedWrite; sum > 1000 {
+		t.Errorf("expected the shares to add up to at most the limit, got %d", sum)
+	}
+}
+
+func TestBandwidthConfig_SetGlobalShareWakesWaits(t *testing.T) {
+	config := NewBandwithConfig(ptr(10), nil)
+
+	client, server := net.Pipe()
+	defer client.Close()
+	go readDataFromConn(client)
+
+	conn := NewThrottledConnection(server, NewConnectionBandwithConfig(config))
+	defer conn.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		// about 10 seconds at the initial share
+		_, err := conn.Write(make([]byte, 100))
+		done <- err
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	config.setGlobalShare(coordinationShare{ReadLimit: ptr(1000000), WriteLimit: ptr(1000000)})
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected the raised share to wake the waiting write")
+	}
+}
@@ -0,0 +1,55 @@
+package netlistener
+
+import (
+	"math"
+
+	"golang.org/x/time/rate"
+)
+
+// isGlobalLimiter reports whether the limiter is one of the global limiters of the connection, which are charged by cost
+func (c *ThrottledConn) isGlobalLimiter(limiter *rate.Limiter) bool {
+	return limiter == c.config.GlobalReadLimiter() || limiter == c.config.GlobalWriteLimiter()
+}
+
+// tokens returns the tokens n bytes take from each of the limiters, the global limiters are charged the bytes
+// multiplied by the cost of the class of the connection, limiters in burst debt a surcharge on top
+func (c *ThrottledConn) tokens(limiters []*rate.Limiter, n int) []int {
+	cost := c.config.globalConfig.classes.Cost(c.config.class())
+
+	tokens := make([]int, len(limiters))
+	for i, limiter := range limiters {
+		tokens[i] = n
+		if cost != 1 && c.isGlobalLimiter(limiter) {
+			tokens[i] = int(math.Ceil(float64(n) * cost))
+		}
+	}
+	c.config.globalConfig.debts.surcharge(limiters, tokens)
+
+	return tokens
+}
+
+// maxChunk is maxChunk also keeping the cost of the chunk and the surcharge of limiters in burst debt within the burst of the limiters,
+// and the chunk within the ceiling of an interval in strict mode
+func (c *ThrottledConn) maxChunk(limiters []*rate.Limiter, chunkSize int) int {
+	chunkSize = c.config.globalConfig.debts.maxChunk(limiters, maxChunk(limiters, chunkSize))
+	if interval := c.config.globalConfig.StrictMode(); interval > 0 {
+		chunkSize = c.config.globalConfig.strict.maxChunk(limiters, chunkSize, interval)
+	}
+
+	cost := c.config.globalConfig.classes.Cost(c.config.class())
+	if cost <= 1 {
+		return chunkSize
+	}
+
+	for _, limiter := range limiters {
+		if limiter.Limit() == rate.Inf || !c.isGlobalLimiter(limiter) {
+			continue
+		}
+
+		if burst := int(float64(limiter.Burst()) / cost); burst > 0 && burst < chunkSize {
+			chunkSize = burst
+		}
+	}
+
+	return chunkSize
+}
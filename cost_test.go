@@ -0,0 +1,49 @@
+package netlistener
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestRateLimitedConnection_ClassCost(t *testing.T) {
+	tests := []struct {
+		name  string
+		cost  float64
+		bytes int
+		// expected is how long the write takes with a global limit of 200 bytes per second
+		expected time.Duration
+	}{
+		{name: "Default cost", bytes: 400, expected: time.Second},
+		{name: "Double cost", cost: 2, bytes: 200, expected: time.Second},
+		{name: "Half cost", cost: 0.5, bytes: 400, expected: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := NewBandwithConfig(ptr(200), nil)
+			if err := config.SetClasses([]ClassConfig{{Name: "cross-region", Cost: tt.cost}}, "cross-region"); err != nil {
+				t.Fatal(err)
+			}
+
+			connRead, connWrite := net.Pipe()
+			conn := NewThrottledConnection(connWrite, NewConnectionBandwithConfig(config))
+			go readDataFromConn(connRead)
+			defer conn.Close()
+
+			start := time.Now()
+			if _, err := conn.Write(make([]byte, tt.bytes)); err != nil {
+				t.Fatal(err)
+			}
+			elapsed := time.Since(start)
+			if elapsed < tt.expected-200*time.Millisecond || elapsed > tt.expected+300*time.Millisecond {
+				t.Errorf("expected the write to take about %v, took %v", tt.expected, elapsed)
+			}
+
+			// the bytes are counted as they are, only the global limiter is charged by cost
+			if stats := config.Stats(); stats.BytesWritten != int64(tt.bytes) {
+				t.Errorf("expected %d bytes written, got %d", tt.bytes, stats.BytesWritten)
+			}
+		})
+	}
+}
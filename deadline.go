@@ -0,0 +1,105 @@
+package netlistener
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// WriteDeadlinePolicy decides how the write deadline applies to the chunks of a large Write
+type WriteDeadlinePolicy int
+
+const (
+	// WriteDeadlineTotal applies the deadline to the whole Write, the chunks may take any part of it
+	WriteDeadlineTotal WriteDeadlinePolicy = iota
+	// WriteDeadlinePerChunk slices the time left until the deadline proportionally across the chunks,
+	// each chunk has to be written within its share, so a Write stalling early fails early instead of holding
+	// the whole deadline hostage
+	WriteDeadlinePerChunk
+)
+
+func (p WriteDeadlinePolicy) String() string {
+	switch p {
+	case WriteDeadlineTotal:
+		return "total"
+	case WriteDeadlinePerChunk:
+		return "per_chunk"
+	}
+
+	return "unknown"
+}
+
+func (c *ThrottledConn) SetDeadline(t time.Time) error {
+	storeDeadline(&c.readDeadline, t)
+	storeDeadline(&c.writeDeadline, t)
+
+	return c.Conn.SetDeadline(t)
+}
+
+func (c *ThrottledConn) SetReadDeadline(t time.Time) error {
+	storeDeadline(&c.readDeadline, t)
+
+	return c.Conn.SetReadDeadline(t)
+}
+
+func (c *ThrottledConn) SetWriteDeadline(t time.Time) error {
+	storeDeadline(&c.writeDeadline, t)
+
+	return c.Conn.SetWriteDeadline(t)
+}
+
+func storeDeadline(deadline *atomic.Int64, t time.Time) {
+	if t.IsZero() {
+		deadline.Store(0)
+	} else {
+		deadline.Store(t.UnixNano())
+	}
+}
+
+func loadDeadline(deadline *atomic.Int64) time.Time {
+	if nanos := deadline.Load(); nanos != 0 {
+		return time.Unix(0, nanos)
+	}
+
+	return time.Time{}
+}
+
+// chunkDeadlines hands out the deadlines of the chunks of a single Write
+type chunkDeadlines struct {
+	conn     *ThrottledConn
+	deadline time.Time
+	start    time.Time
+	size     int
+	perChunk bool
+}
+
+func (c *ThrottledConn) newChunkDeadlines(size int) *chunkDeadlines {
+	deadline := loadDeadline(&c.writeDeadline)
+
+	return &chunkDeadlines{
+		conn:     c,
+		deadline: deadline,
+		start:    time.Now(),
+		size:     size,
+		perChunk: !deadline.IsZero() && c.config.globalConfig.WriteDeadlinePolicy() == WriteDeadlinePerChunk,
+	}
+}
+
+// next returns the deadline of the chunk ending at offset end, setting it on the underlying connection in per chunk mode
+func (d *chunkDeadlines) next(end int) time.Time {
+	if !d.perChunk {
+		return d.deadline
+	}
+
+	share := time.Duration(float64(d.deadline.Sub(d.start)) * float64(end) / float64(d.size))
+	chunkDeadline := d.start.Add(share)
+	d.conn.Conn.SetWriteDeadline(chunkDeadline)
+
+	return chunkDeadline
+}
+
+// restore sets the deadline of the caller back on the underlying connection after the Write
+func (d *chunkDeadlines) restore() {
+	if d.perChunk {
+		d.conn.Conn.SetWriteDeadline(d.deadline)
+	}
+}
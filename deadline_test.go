@@ -0,0 +1,111 @@
+package netlistener
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestRateLimitedConnection_WriteDeadlinePolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  WriteDeadlinePolicy
+		written int
+		elapsed time.Duration
+	}{
+		{
+			// the fourth chunk would be allowed only after 3s
+			name:    "Deadline applies to the whole write",
+			policy:  WriteDeadlineTotal,
+			written: 150,
+			elapsed: 2 * time.Second,
+		},
+		{
+			// the third chunk has to be written by 1.875s but would be allowed only after 2s
+			name:    "Deadline is sliced across the chunks",
+			policy:  WriteDeadlinePerChunk,
+			written: 100,
+			elapsed: time.Second,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			config := NewBandwithConfig(nil, ptr(50))
+			config.SetWriteDeadlinePolicy(tt.policy)
+
+			connRead, connWrite := net.Pipe()
+			conn := NewThrottledConnection(connWrite, NewConnectionBandwithConfig(config))
+			defer conn.Close()
+			go readDataFromConn(connRead)
+
+			start := time.Now()
+			conn.SetWriteDeadline(start.Add(2500 * time.Millisecond))
+
+			n, err := conn.Write(make([]byte, 200))
+			elapsed := time.Since(start)
+
+			if !errors.Is(err, os.ErrDeadlineExceeded) {
+				t.Errorf("expected the deadline to be exceeded, got %v", err)
+			}
+			if n != tt.written {
+				t.Errorf("expected %d bytes written, got %d", tt.written, n)
+			}
+			if elapsed < tt.elapsed-100*time.Millisecond || elapsed > tt.elapsed+300*time.Millisecond {
+				t.Errorf("expected the write to fail after %s, took %s", tt.elapsed, elapsed)
+			}
+		})
+	}
+}
+
+func TestRateLimitedConnection_ReadDeadline(t *testing.T) {
+	config := NewBandwithConfig(nil, ptr(50))
+
+	connRead, connWrite := net.Pipe()
+	conn := NewThrottledConnection(connRead, NewConnectionBandwithConfig(config))
+	defer conn.Close()
+	go connWrite.Write(make([]byte, 100))
+
+	if _, err := conn.Read(make([]byte, 50)); err != nil {
+		t.Fatal(err)
+	}
+
+	// the next 50 bytes are allowed only after a second
+	conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	start := time.Now()
+	if _, err := conn.Read(make([]byte, 50)); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("expected the deadline to be exceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Errorf("expected to fail without waiting for the limiter, took %s", elapsed)
+	}
+}
+
+func TestRateLimitedConnection_WriteContext(t *testing.T) {
+	config := NewBandwithConfig(nil, ptr(50))
+
+	connRead, connWrite := net.Pipe()
+	conn := NewThrottledConnection(connWrite, NewConnectionBandwithConfig(config))
+	defer conn.Close()
+	go readDataFromConn(connRead)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 1500*time.Millisecond)
+	defer cancel()
+
+	// the first chunk is written from the burst and the second one after a second, the third wait is cancelled
+	n, err := conn.WriteContext(ctx, make([]byte, 200))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the context error, got %v", err)
+	}
+	if n != 100 {
+		t.Errorf("expected the 100 bytes written before the cancellation, got %d", n)
+	}
+	if tokens := conn.config.PerConnWriteLimiter().Tokens(); tokens < 20 {
+		t.Errorf("expected the tokens of the cancelled chunk to be refunded, %f left", tokens)
+	}
+}
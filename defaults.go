@@ -0,0 +1,51 @@
+package netlistener
+
+import (
+	"net"
+	"sync/atomic"
+)
+
+// defaultConfig is shared by the connections created without a config, it is created on first use
+var defaultConfig atomic.Pointer[BandwidthConfig]
+
+// DefaultConfig returns the config used by connections created without one. Unless replaced with SetDefaultConfig
+// it is unlimited, so such connections are counted in its stats but never throttled. It is safe for concurrent use
+func DefaultConfig() *BandwidthConfig {
+	if config := defaultConfig.Load(); config != nil {
+		return config
+	}
+
+	// only the first of concurrent callers installs its config, so all of them share the same one
+	defaultConfig.CompareAndSwap(nil, NewBandwidthConfig(NoLimit(), NoLimit()))
+
+	return defaultConfig.Load()
+}
+
+// SetDefaultConfig replaces the config of connections created without one afterwards, nil restores an unlimited config
+func SetDefaultConfig(config *BandwidthConfig) {
+	defaultConfig.Store(config)
+}
+
+// NoLimit returns the limit meaning unlimited, e.g. NewBandwidthConfig(NoLimit(), Limit(64*1024))
+func NoLimit() *int {
+	return nil
+}
+
+// Limit returns a limit of bytes per second for the constructors taking optional limits
+func Limit(bytesPerSecond int) *int {
+	return &bytesPerSecond
+}
+
+// cloneLimit copies an optional limit, so the copy handed out cannot change the one in use
+func cloneLimit(limit *int) *int {
+	if limit == nil {
+		return nil
+	}
+
+	return Limit(*limit)
+}
+
+// Wrap counts the traffic of the connection in the stats of DefaultConfig without limiting it
+func Wrap(conn net.Conn) net.Conn {
+	return NewThrottledConnection(conn, nil)
+}
@@ -0,0 +1,69 @@
+package netlistener
+
+import (
+	"net"
+	"sync"
+	"testing"
+)
+
+func TestDefaultConfig(t *testing.T) {
+	defer SetDefaultConfig(nil)
+
+	configs := make([]*BandwidthConfig, 10)
+	var wg sync.WaitGroup
+	for i := range configs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			configs[i] = DefaultConfig()
+		}()
+	}
+	wg.Wait()
+
+	for _, config := range configs {
+		if config != configs[0] {
+			t.Fatal("expected concurrent callers to share the default config")
+		}
+	}
+
+	custom := NewBandwithConfig(Limit(1000), NoLimit())
+	SetDefaultConfig(custom)
+	if DefaultConfig() != custom {
+		t.Error("expected the custom default config")
+	}
+}
+
+func TestWrap(t *testing.T) {
+	defer SetDefaultConfig(nil)
+	SetDefaultConfig(nil)
+
+	tests := []struct {
+		name string
+		wrap func(conn net.Conn) net.Conn
+	}{
+		{name: "Wrap", wrap: Wrap},
+		{name: "Nil config", wrap: func(conn net.Conn) net.Conn { return NewThrottledConnection(conn, nil) }},
+		{name: "Nil bandwith config", wrap: func(conn net.Conn) net.Conn {
+			return NewThrottledConnection(conn, NewConnectionBandwithConfig(nil))
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := DefaultConfig().Stats().BytesWritten
+
+			connRead, connWrite := net.Pipe()
+			conn := tt.wrap(connWrite)
+			defer conn.Close()
+			go readDataFromConn(connRead)
+
+			if _, err := conn.Write(make([]byte, 1000)); err != nil {
+				t.Fatal(err)
+			}
+
+			if written := DefaultConfig().Stats().BytesWritten - before; written != 1000 {
+				t.Errorf("expected 1000 bytes counted in the default config, got %d", written)
+			}
+		})
+	}
+}
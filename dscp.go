@@ -0,0 +1,49 @@
+package netlistener
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+)
+
+// ErrDSCPUnsupported is returned on platforms or connections where the DSCP of the socket cannot be set
+var ErrDSCPUnsupported = errors.New("setting DSCP is not supported for this connection")
+
+// SetClassDSCP maps traffic classes to the DSCP (0-63) set on the sockets of their connections, e.g. {"bulk": 8} for CS1,
+// so downstream network gear applies QoS consistent with the shaping. Connections are marked when they are accepted
+// and when they move to another class, changes of the mapping apply to connections classified afterwards. nil removes the mapping
+func (c *BandwidthConfig) SetClassDSCP(mapping map[string]int) error {
+	for class, dscp := range mapping {
+		if dscp < 0 || dscp > 63 {
+			return fmt.Errorf("invalid DSCP %d of class %q, it has to be between 0 and 63", dscp, class)
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.classDSCP = maps.Clone(mapping)
+
+	return nil
+}
+
+// dscpOf returns the DSCP of the class, false if the class is not mapped
+func (c *BandwidthConfig) dscpOf(class string) (int, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	dscp, ok := c.classDSCP[class]
+
+	return dscp, ok
+}
+
+// markDSCP sets the DSCP of the class of the connection on its socket. Marking is best effort,
+// connections which are not IP based or platforms without support keep the DSCP of the socket
+func (c *ThrottledConn) markDSCP() {
+	dscp, ok := c.config.globalConfig.dscpOf(c.config.Classification().Class)
+	if !ok || c.family == familyNone {
+		return
+	}
+
+	_ = setDSCP(c.Conn, c.family, dscp)
+}
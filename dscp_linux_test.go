@@ -0,0 +1,76 @@
+package netlistener
+
+import (
+	"net"
+	"syscall"
+	"testing"
+)
+
+func TestSetClassDSCP_Invalid(t *testing.T) {
+	config := NewBandwithConfig(nil, nil)
+
+	for _, dscp := range []int{-1, 64} {
+		if err := config.SetClassDSCP(map[string]int{"bulk": dscp}); err == nil {
+			t.Errorf("expected error for DSCP %d", dscp)
+		}
+	}
+}
+
+func TestListener_ClassDSCP(t *testing.T) {
+	tests := []struct {
+		name        string
+		class       string
+		expectedTOS int
+	}{
+		{name: "Mapped class is marked", class: "bulk", expectedTOS: 8 << 2},
+		{name: "Unmapped class keeps the default", class: "interactive", expectedTOS: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			listener, err := net.Listen("tcp4", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal("Failed to create listener", err)
+			}
+			defer listener.Close()
+
+			throttledListener, _ := NewListener(listener, nil, nil)
+			throttledListener.SetClassifier(ClassifierFunc(func(ConnMetadata) Classification {
+				return Classification{Class: tt.class}
+			}))
+			if err := throttledListener.SetClassDSCP(map[string]int{"bulk": 8}); err != nil {
+				t.Fatal(err)
+			}
+
+			client, err := net.Dial("tcp4", listener.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer client.Close()
+
+			conn, err := throttledListener.Accept()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+
+			rawConn, err := syscallConn(conn.(*ThrottledConn).Conn)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var tos int
+			var sockErr error
+			rawConn.Control(func(fd uintptr) {
+				tos, sockErr = syscall.GetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_TOS)
+			})
+			if sockErr != nil {
+				t.Fatal(sockErr)
+			}
+
+			if tos != tt.expectedTOS {
+				t.Errorf("expected TOS %d, got %d", tt.expectedTOS, tos)
+			}
+		})
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !linux && !darwin
+
+package netlistener
+
+import "net"
+
+func setDSCP(conn net.Conn, family AddressFamily, dscp int) error {
+	return ErrDSCPUnsupported
+}
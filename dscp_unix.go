@@ -0,0 +1,39 @@
+//go:build linux || darwin
+
+package netlistener
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+)
+
+// setDSCP sets the traffic class of IPv6 sockets and the TOS of IPv4 ones, the DSCP is the upper six bits of both.
+// IPv4 connections of dual-stack sockets are IPv6 sockets, so the traffic class is tried when setting the TOS fails
+func setDSCP(conn net.Conn, family AddressFamily, dscp int) error {
+	rawConn, err := syscallConn(conn)
+	if err != nil {
+		return ErrDSCPUnsupported
+	}
+
+	tos := dscp << 2
+	var sockErr error
+
+	err = rawConn.Control(func(fd uintptr) {
+		if family == FamilyIPv4 {
+			if sockErr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_TOS, tos); sockErr == nil {
+				return
+			}
+		}
+
+		sockErr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_TCLASS, tos)
+	})
+	if err != nil {
+		return fmt.Errorf("accessing socket: %w", err)
+	}
+	if sockErr != nil {
+		return fmt.Errorf("setting DSCP: %w", sockErr)
+	}
+
+	return nil
+}
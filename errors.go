@@ -0,0 +1,105 @@
+package netlistener
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"runtime/debug"
+)
+
+var (
+	// ErrThrottleCancelled is returned when the context of ReadContext or WriteContext is done while waiting for the limiters,
+	// the error of the context is wrapped as well
+	ErrThrottleCancelled = errors.New("wait for the limiters cancelled")
+	// ErrLimitExceededBurst is returned when a single operation needs more tokens than a limiter can ever hold
+	ErrLimitExceededBurst = errors.New("operation exceeds the burst of a limiter")
+	// ErrConnPaused is returned when the deadline or the context of a read or write ends while the connection is paused,
+	// see ThrottledConn.Pause. The error of the deadline or the context is wrapped as well
+	ErrConnPaused = errors.New("connection paused")
+	// ErrQuotaExhausted is returned by reads and writes of connections whose peer used up its quota, see SetPeerQuota
+	ErrQuotaExhausted = errors.New("quota of the peer exhausted")
+)
+
+// ThrottleError is returned by reads and writes of throttled connections which failed while waiting for the limiters.
+// It wraps ErrThrottleCancelled, ErrLimitExceededBurst, ErrConnPaused, ErrQuotaExhausted, os.ErrDeadlineExceeded or net.ErrClosed,
+// so callers can branch with errors.Is, and it is a net.Error reporting a timeout when a deadline was exceeded
+type ThrottleError struct {
+	// Op is "read" or "write"
+	Op  string
+	Err error
+}
+
+var _ net.Error = (*ThrottleError)(nil)
+
+func (e *ThrottleError) Error() string {
+	return "throttled " + e.Op + ": " + e.Err.Error()
+}
+
+func (e *ThrottleError) Unwrap() error {
+	return e.Err
+}
+
+func (e *ThrottleError) Timeout() bool {
+	return errors.Is(e.Err, os.ErrDeadlineExceeded) || errors.Is(e.Err, context.DeadlineExceeded)
+}
+
+// Temporary is deprecated in net.Error, it reports the same as Timeout
+func (e *ThrottleError) Temporary() bool {
+	return e.Timeout()
+}
+
+// ErrorHandler receives the errors and recovered panics of the background work of a listener, e.g. reverse DNS lookups,
+// liveness probes and the accept pool, which have no caller to return them to. It is called from the goroutine of the failed work
+type ErrorHandler func(err error)
+
+// PanicError is a panic recovered in background work or in a handler run by Serve
+type PanicError struct {
+	// Op is the work which panicked, e.g. "accept" or "liveness probe"
+	Op    string
+	Value any
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic in %s: %v", e.Op, e.Value)
+}
+
+// Unwrap returns the value of the panic if it is an error
+func (e *PanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
+
+// SetErrorHandler sets the handler receiving the errors and recovered panics of background work, nil removes it.
+// Without a handler background errors are dropped and panics of background work are not recovered, they crash the program
+func (c *BandwidthConfig) SetErrorHandler(handler ErrorHandler) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.errorHandler = handler
+}
+
+// reportError passes the error to the error handler, it returns false if there is none
+func (c *BandwidthConfig) reportError(err error) bool {
+	c.mu.RLock()
+	handler := c.errorHandler
+	c.mu.RUnlock()
+
+	if handler == nil {
+		return false
+	}
+	handler(err)
+
+	return true
+}
+
+// recoverPanic is deferred by background work, it passes a panic to the error handler and panics again if there is none
+func (c *BandwidthConfig) recoverPanic(op string) {
+	if r := recover(); r != nil {
+		if !c.reportError(&PanicError{Op: op, Value: r, Stack: debug.Stack()}) {
+			panic(r)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package netlistener
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestThrottleError(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		sentinel error
+		timeout  bool
+	}{
+		{
+			name:     "Deadline exceeded is a timeout",
+			err:      &ThrottleError{Op: "write", Err: os.ErrDeadlineExceeded},
+			sentinel: os.ErrDeadlineExceeded,
+			timeout:  true,
+		},
+		{
+			name:     "Cancelled wait wraps the context error",
+			err:      &ThrottleError{Op: "read", Err: errors.Join(ErrThrottleCancelled, context.Canceled)},
+			sentinel: context.Canceled,
+		},
+		{
+			name:     "Context deadline is a timeout",
+			err:      &ThrottleError{Op: "read", Err: errors.Join(ErrThrottleCancelled, context.DeadlineExceeded)},
+			sentinel: ErrThrottleCancelled,
+			timeout:  true,
+		},
+		{
+			name:     "Burst exceeded is not a timeout",
+			err:      &ThrottleError{Op: "write", Err: ErrLimitExceededBurst},
+			sentinel: ErrLimitExceededBurst,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !errors.Is(tt.err, tt.sentinel) {
+				t.Errorf("expected %v to wrap %v", tt.err, tt.sentinel)
+			}
+
+			var netErr net.Error
+			if !errors.As(tt.err, &netErr) || netErr.Timeout() != tt.timeout {
+				t.Errorf("expected a net.Error with timeout %t, got %v", tt.timeout, tt.err)
+			}
+		})
+	}
+}
+
+func TestRateLimitedConnection_ReadContext_Cancelled(t *testing.T) {
+	config := NewBandwithConfig(nil, ptr(50))
+
+	connRead, connWrite := net.Pipe()
+	conn := NewThrottledConnection(connRead, NewConnectionBandwithConfig(config))
+	defer conn.Close()
+	go connWrite.Write(make([]byte, 100))
+
+	if _, err := conn.Read(make([]byte, 50)); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	_, err := conn.ReadContext(ctx, make([]byte, 50))
+
+	var throttleErr *ThrottleError
+	if !errors.As(err, &throttleErr) || throttleErr.Op != "read" {
+		t.Fatalf("expected a ThrottleError of a read, got %v", err)
+	}
+	if !errors.Is(err, ErrThrottleCancelled) || !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the cancellation and its cause to be wrapped, got %v", err)
+	}
+}
+
+func TestListener_ErrorHandler(t *testing.T) {
+	panicking := ClassifierFunc(func(meta ConnMetadata) Classification {
+		panic("classifier failed")
+	})
+
+	tests := []struct {
+		name  string
+		setup func(l *Listener)
+		// expectedOp is the op of the expected PanicError, empty if a plain error is expected
+		expectedOp  string
+		expectedErr bool
+	}{
+		{name: "Classifier panicking in the accept pool", setup: func(l *Listener) {
+			l.SetAcceptPool(&AcceptPool{Workers: 1})
+			l.SetClassifier(panicking)
+		}, expectedOp: "accept", expectedErr: true},
+		{name: "Classifier panicking after the lookup", setup: func(l *Listener) {
+			l.SetClassifier(ClassifierFunc(func(meta ConnMetadata) Classification {
+				if meta.Hostname != "" {
+					panic("classifier failed")
+				}
+				return Classification{}
+			}))
+			l.SetReverseDNS(&ReverseDNS{})
+			l.config.rdns.lookupAddr = func(ctx context.Context, addr string) ([]string, error) {
+				return []string{"host.example.com."}, nil
+			}
+		}, expectedOp: "reverse DNS classification", expectedErr: true},
+		{name: "Failed lookup", setup: func(l *Listener) {
+			l.SetClassifier(ClassifierFunc(func(meta ConnMetadata) Classification { return Classification{} }))
+			l.SetReverseDNS(&ReverseDNS{})
+			l.config.rdns.lookupAddr = func(ctx context.Context, addr string) ([]string, error) {
+				return nil, &net.DNSError{Err: "i/o timeout", IsTimeout: true}
+			}
+		}, expectedErr: true},
+		{name: "Name which does not exist is not an error", setup: func(l *Listener) {
+			l.SetClassifier(ClassifierFunc(func(meta ConnMetadata) Classification { return Classification{} }))
+			l.SetReverseDNS(&ReverseDNS{})
+			l.config.rdns.lookupAddr = func(ctx context.Context, addr string) ([]string, error) {
+				return nil, &net.DNSError{Err: "no such host", IsNotFound: true}
+			}
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			reported := make(chan error, 1)
+			throttledListener, _ := NewListener(listener, nil, nil, WithErrorHandler(func(err error) {
+				select {
+				case reported <- err:
+				default:
+				}
+			}))
+			defer throttledListener.Close()
+			tt.setup(throttledListener)
+
+			go func() {
+				for {
+					conn, err := throttledListener.Accept()
+					if err != nil {
+						return
+					}
+					defer conn.Close()
+				}
+			}()
+
+			client, err := net.Dial("tcp", listener.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer client.Close()
+
+			select {
+			case err := <-reported:
+				if !tt.expectedErr {
+					t.Fatalf("expected no error, got %v", err)
+				}
+				var panicErr *PanicError
+				if isPanic := errors.As(err, &panicErr); isPanic != (tt.expectedOp != "") || isPanic && panicErr.Op != tt.expectedOp {
+					t.Errorf("expected a panic in %q, got %v", tt.expectedOp, err)
+				}
+			case <-time.After(500 * time.Millisecond):
+				if tt.expectedErr {
+					t.Fatal("expected an error to be reported")
+				}
+			}
+		})
+	}
+}
+
+func TestPanicError_Unwrap(t *testing.T) {
+	err := &PanicError{Op: "serve", Value: os.ErrClosed}
+	if !errors.Is(err, os.ErrClosed) {
+		t.Errorf("expected the panic value to be unwrapped")
+	}
+	if (&PanicError{Op: "serve", Value: "failed"}).Unwrap() != nil {
+		t.Errorf("expected no error for a panic value which is not an error")
+	}
+}
@@ -0,0 +1,109 @@
+package netlistener
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// rateEstimator estimates the throughput of a connection from the timestamps of its operations, as an exponentially
+// decaying average with the estimation window as its time constant
+type rateEstimator struct {
+	// rate is the estimate in bytes per second at last, in unix nanoseconds
+	rate float64
+	last int64
+
+	mu sync.Mutex
+}
+
+// add records n bytes transferred at now
+func (e *rateEstimator) add(now time.Time, n int, window time.Duration) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.rate = e.decayed(now, window) + float64(n)/window.Seconds()
+	e.last = now.UnixNano()
+}
+
+// rateAt returns the estimate at now in bytes per second
+func (e *rateEstimator) rateAt(now time.Time, window time.Duration) int64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return int64(e.decayed(now, window))
+}
+
+// decayed returns the estimate decayed since the last operation, the lock has to be held
+func (e *rateEstimator) decayed(now time.Time, window time.Duration) float64 {
+	if e.last == 0 {
+		return 0
+	}
+
+	elapsed := max(now.UnixNano()-e.last, 0)
+
+	return e.rate * math.Exp(-float64(elapsed)/float64(window))
+}
+
+// SetThroughputEstimation estimates the throughput each connection achieves from the timestamps of its operations,
+// averaged over about the window, zero disables it. Unlike the limits, the estimates cover exempt and unlimited connections,
+// so the stats report the real utilization of the uplink, which informs the choice of the limits
+func (c *BandwidthConfig) SetThroughputEstimation(window time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.estimationWindow = max(window, 0)
+}
+
+func (c *BandwidthConfig) ThroughputEstimation() time.Duration {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.estimationWindow
+}
+
+// estimate records an operation of the connection if throughput estimation is enabled
+func (c *ThrottledConn) estimate(now time.Time, n int, read bool) {
+	window := c.config.globalConfig.ThroughputEstimation()
+	if window <= 0 || n <= 0 {
+		return
+	}
+
+	if read {
+		c.readRate.add(now, n, window)
+	} else {
+		c.writeRate.add(now, n, window)
+	}
+}
+
+// unshaped reports whether no limiter shapes the connection in the direction, because it is exempt or all its limits are unlimited
+func (c *ThrottledConn) unshaped(read bool) bool {
+	if c.config.Exempt() {
+		return true
+	}
+
+	for _, limiter := range c.limiters(read) {
+		if limiter.Limit() != rate.Inf {
+			return false
+		}
+	}
+
+	return true
+}
+
+// estimateRates sums the estimates of the open connections into the stats
+func (c *BandwidthConfig) estimateRates(stats *Stats, window time.Duration) {
+	now := time.Now()
+	for _, conn := range c.conns.all() {
+		read, written := conn.readRate.rateAt(now, window), conn.writeRate.rateAt(now, window)
+		stats.EstimatedReadRate += read
+		stats.EstimatedWriteRate += written
+		if conn.unshaped(true) {
+			stats.UnshapedReadRate += read
+		}
+		if conn.unshaped(false) {
+			stats.UnshapedWriteRate += written
+		}
+	}
+}
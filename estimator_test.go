@@ -0,0 +1,79 @@
+package netlistener
+
+import (
+	"math"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestRateEstimator(t *testing.T) {
+	var estimator rateEstimator
+	start := time.Unix(1000, 0)
+
+	// 100 bytes every 10ms are 10000 bytes per second
+	now := start
+	for range 500 {
+		now = now.Add(10 * time.Millisecond)
+		estimator.add(now, 100, time.Second)
+	}
+
+	tests := []struct {
+		name     string
+		at       time.Duration
+		expected float64
+	}{
+		{name: "Steady rate", expected: 10000},
+		{name: "Decays while idle", at: time.Second, expected: 10000 / math.E},
+		{name: "Idle for long", at: time.Minute, expected: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rate := estimator.rateAt(now.Add(tt.at), time.Second); math.Abs(float64(rate)-tt.expected) > tt.expected*0.05+1 {
+				t.Errorf("expected about %v, got %d", tt.expected, rate)
+			}
+		})
+	}
+}
+
+func TestBandwidthConfig_ThroughputEstimation(t *testing.T) {
+	tests := []struct {
+		name         string
+		perConnLimit *int
+		unshaped     bool
+	}{
+		{name: "Unlimited connection", unshaped: true},
+		{name: "Limited connection", perConnLimit: ptr(100_000)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := NewBandwithConfig(nil, tt.perConnLimit)
+			config.SetThroughputEstimation(time.Second)
+
+			connRead, connWrite := net.Pipe()
+			conn := NewThrottledConnection(connWrite, NewConnectionBandwithConfig(config))
+			defer conn.Close()
+			go readDataFromConn(connRead)
+
+			for range 10 {
+				if _, err := conn.Write(make([]byte, 1000)); err != nil {
+					t.Fatal(err)
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+
+			stats := config.Stats()
+			if stats.EstimatedWriteRate <= 0 || stats.EstimatedReadRate != 0 {
+				t.Errorf("expected a write rate only, got %d written and %d read", stats.EstimatedWriteRate, stats.EstimatedReadRate)
+			}
+			if unshaped := stats.UnshapedWriteRate == stats.EstimatedWriteRate; unshaped != tt.unshaped {
+				t.Errorf("expected unshaped %v, got %d of %d", tt.unshaped, stats.UnshapedWriteRate, stats.EstimatedWriteRate)
+			}
+			if rate := conn.ConnInfo().WriteRate; rate <= 0 {
+				t.Errorf("expected the connection info to hold the write rate, got %d", rate)
+			}
+		})
+	}
+}
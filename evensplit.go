@@ -0,0 +1,200 @@
+package netlistener
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// elasticRefresh is how long the level of the elastic even split is reused while the number of open connections stays the same
+const elasticRefresh = 100 * time.Millisecond
+
+// EvenSplit derives the per connection limit from the global limit divided by the number of open connections,
+// instead of a static number, so the limit follows the connections as they come and go
+type EvenSplit struct {
+	// Floor is the lowest per connection limit in bytes per second, so many connections do not starve each other, zero means none.
+	// With a floor, the connections together may exceed the global limit, which still caps them
+	Floor int `json:"floor,omitempty"`
+	// Ceiling is the highest per connection limit in bytes per second, so a single connection does not take all of it, zero means none
+	Ceiling int `json:"ceiling,omitempty"`
+	// Elastic redistributes the capacity left over by connections capped below their share, by the ceiling or by the limit
+	// of their classification, profile or class, to the other connections. Those limits cap the share of a connection then,
+	// instead of replacing it
+	Elastic bool `json:"elastic,omitempty"`
+}
+
+func (s EvenSplit) validate() error {
+	if s.Floor < 0 || s.Ceiling < 0 {
+		return fmt.Errorf("negative even split bounds %+v", s)
+	}
+	if s.Ceiling > 0 && s.Floor > s.Ceiling {
+		return fmt.Errorf("even split floor %d above the ceiling %d", s.Floor, s.Ceiling)
+	}
+
+	return nil
+}
+
+// limit returns the share of a connection of the global limit when count connections are open
+func (s EvenSplit) limit(global rate.Limit, count int64) rate.Limit {
+	share := global
+	if global != rate.Inf && count > 1 {
+		share = global / rate.Limit(count)
+	}
+
+	return s.band(share)
+}
+
+// band keeps a share between the floor and the ceiling
+func (s EvenSplit) band(share rate.Limit) rate.Limit {
+	if s.Ceiling > 0 {
+		share = min(share, rate.Limit(s.Ceiling))
+	}
+	if s.Floor > 0 {
+		share = max(share, rate.Limit(s.Floor))
+	}
+
+	return share
+}
+
+// SetEvenSplit replaces the configured per connection limit with the global limit split evenly between the open connections,
+// within the floor and the ceiling, nil restores the configured one. The limit is recalculated on the next operation of each
+// connection, operations waiting with a lower limit are woken up when a connection is closed.
+// Limits of the classification, the profile and the class still take precedence
+func (c *BandwidthConfig) SetEvenSplit(split *EvenSplit) error {
+	if split != nil {
+		if err := split.validate(); err != nil {
+			return err
+		}
+		copied := *split
+		split = &copied
+	}
+
+	c.mu.Lock()
+	c.evenSplit = split
+	c.mu.Unlock()
+
+	// the limits may have been raised
+	c.limitUpdates.Notify()
+
+	return nil
+}
+
+func (c *BandwidthConfig) EvenSplit() *EvenSplit {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.evenSplit == nil {
+		return nil
+	}
+	split := *c.evenSplit
+
+	return &split
+}
+
+// evenSplitLimit returns the per connection limit in even split mode and whether it is elastic, false if it is disabled
+func (c *BandwidthConfig) evenSplitLimit(read bool) (rate.Limit, bool, bool) {
+	c.mu.RLock()
+	split := c.evenSplit
+	global := rate.Inf
+	if split != nil {
+		global = c.globalWriteLimiter.Limit()
+		if read {
+			global = c.globalReadLimiter.Limit()
+		}
+	}
+	c.mu.RUnlock()
+
+	if split == nil {
+		return 0, false, false
+	}
+
+	count := max(c.stats.activeConns.Load(), 1)
+	if !split.Elastic {
+		return split.limit(global, count), false, true
+	}
+
+	return split.band(c.elastic.level(c, *split, global, count, read, c.now())), true, true
+}
+
+// elasticLevel caches the level of the elastic even split in each direction
+type elasticLevel struct {
+	levels     [2]rate.Limit
+	global     [2]rate.Limit
+	count      [2]int64
+	computedAt [2]time.Time
+
+	mu sync.Mutex
+}
+
+// level returns the share of the connections which are not capped below it, recomputed when the number of open connections
+// or the global limit changed, or after elasticRefresh
+func (l *elasticLevel) level(c *BandwidthConfig, split EvenSplit, global rate.Limit, count int64, read bool, now time.Time) rate.Limit {
+	direction := 0
+	if read {
+		direction = 1
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.count[direction] == count && l.global[direction] == global && now.Sub(l.computedAt[direction]) < elasticRefresh {
+		return l.levels[direction]
+	}
+
+	var caps []rate.Limit
+	for _, conn := range c.conns.all() {
+		if conn.config.Exempt() {
+			continue
+		}
+
+		limit := rate.Inf
+		if override, _, ok := conn.overrideLimit(read); ok {
+			limit = override
+		}
+		if split.Ceiling > 0 {
+			limit = min(limit, rate.Limit(split.Ceiling))
+		}
+		caps = append(caps, limit)
+	}
+
+	l.levels[direction] = waterLevel(global, caps)
+	l.global[direction], l.count[direction], l.computedAt[direction] = global, count, now
+
+	return l.levels[direction]
+}
+
+// waterLevel returns the level which splits the global limit between connections capped at caps, so that each connection
+// gets the level or its cap, whichever is lower, and the capacity left over by the capped ones goes to the others.
+// It is unlimited when all connections are capped below their share
+func waterLevel(global rate.Limit, caps []rate.Limit) rate.Limit {
+	if global == rate.Inf || len(caps) == 0 {
+		return global
+	}
+
+	slices.Sort(caps)
+
+	remaining := global
+	for i, limit := range caps {
+		share := remaining / rate.Limit(len(caps)-i)
+		if limit >= share {
+			return share
+		}
+		remaining -= limit
+	}
+
+	return rate.Inf
+}
+
+// releaseEvenSplit wakes the operations waiting with the share of the connections before one of them was closed
+func (c *BandwidthConfig) releaseEvenSplit() {
+	c.mu.RLock()
+	enabled := c.evenSplit != nil
+	c.mu.RUnlock()
+
+	if enabled {
+		c.limitUpdates.Notify()
+	}
+}
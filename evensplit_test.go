@@ -0,0 +1,146 @@
+package netlistener
+
+import (
+	"net"
+	"testing"
+
+	"golang.org/x/time/rate"
+)
+
+func TestEvenSplit_Limit(t *testing.T) {
+	tests := []struct {
+		name     string
+		split    EvenSplit
+		global   rate.Limit
+		count    int64
+		expected rate.Limit
+	}{
+		{name: "Single connection", global: 1000, count: 1, expected: 1000},
+		{name: "Split evenly", global: 1000, count: 4, expected: 250},
+		{name: "Floor", split: EvenSplit{Floor: 300}, global: 1000, count: 4, expected: 300},
+		{name: "Ceiling", split: EvenSplit{Ceiling: 400}, global: 1000, count: 2, expected: 400},
+		{name: "Unlimited global limit", global: rate.Inf, count: 4, expected: rate.Inf},
+		{name: "Unlimited global limit with a ceiling", split: EvenSplit{Ceiling: 400}, global: rate.Inf, count: 4, expected: 400},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if limit := tt.split.limit(tt.global, tt.count); limit != tt.expected {
+				t.Errorf("expected %v, got %v", tt.expected, limit)
+			}
+		})
+	}
+}
+
+func TestRateLimitedConnection_EvenSplit(t *testing.T) {
+	config := NewBandwithConfig(ptr(1000), ptr(800))
+	if err := config.SetEvenSplit(&EvenSplit{Floor: 100}); err != nil {
+		t.Fatal(err)
+	}
+	if err := config.SetEvenSplit(&EvenSplit{Floor: 500, Ceiling: 100}); err == nil {
+		t.Error("expected a floor above the ceiling to be rejected")
+	}
+
+	var conns []*ThrottledConn
+	for range 4 {
+		connRead, connWrite := net.Pipe()
+		defer connRead.Close()
+		conn := NewThrottledConnection(connWrite, NewConnectionBandwithConfig(config))
+		defer conn.Close()
+		conns = append(conns, conn)
+	}
+
+	expected := []rate.Limit{250, 1000.0 / 3, 500, 1000}
+	for i, conn := range conns {
+		if limit := conn.perConnLimit(config.PerConnWriteLimit(), false); limit != expected[i] {
+			t.Errorf("expected the limit %v with %d open connections, got %v", expected[i], len(conns)-i, limit)
+		}
+		conn.Close()
+	}
+
+	// the configured limit applies again
+	if err := config.SetEvenSplit(nil); err != nil {
+		t.Fatal(err)
+	}
+	if limit := conns[0].perConnLimit(config.PerConnWriteLimit(), false); limit != 800 {
+		t.Errorf("expected the configured limit once even split is disabled, got %v", limit)
+	}
+}
+
+func TestWaterLevel(t *testing.T) {
+	tests := []struct {
+		name     string
+		global   rate.Limit
+		caps     []rate.Limit
+		expected rate.Limit
+	}{
+		{name: "No caps", global: 900, caps: []rate.Limit{rate.Inf, rate.Inf, rate.Inf}, expected: 300},
+		{name: "Leftover of a capped connection", global: 1000, caps: []rate.Limit{rate.Inf, 100, rate.Inf}, expected: 450},
+		{name: "Cap above the share", global: 900, caps: []rate.Limit{500, rate.Inf, rate.Inf}, expected: 300},
+		{name: "All capped", global: 1000, caps: []rate.Limit{100, 200}, expected: rate.Inf},
+		{name: "Unlimited global limit", global: rate.Inf, caps: []rate.Limit{100}, expected: rate.Inf},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if level := waterLevel(tt.global, tt.caps); level != tt.expected {
+				t.Errorf("expected %v, got %v", tt.expected, level)
+			}
+		})
+	}
+}
+
+func TestRateLimitedConnection_ElasticEvenSplit(t *testing.T) {
+	tests := []struct {
+		name  string
+		split EvenSplit
+		// expected is the write limit of the two unclassified connections and the one capped by its class
+		expected       int
+		expectedCapped int
+	}{
+		{name: "Static split", split: EvenSplit{}, expected: 333, expectedCapped: 100},
+		{name: "Leftover is redistributed", split: EvenSplit{Elastic: true}, expected: 450, expectedCapped: 100},
+		{name: "Within the band", split: EvenSplit{Elastic: true, Floor: 150, Ceiling: 300}, expected: 300, expectedCapped: 100},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := NewBandwithConfig(ptr(1000), nil)
+			if err := config.SetClasses([]ClassConfig{{Name: "capped", Rate: 1000, PerConnLimit: ptr(100)}}, ""); err != nil {
+				t.Fatal(err)
+			}
+			if err := config.SetEvenSplit(&tt.split); err != nil {
+				t.Fatal(err)
+			}
+
+			var conns []*ThrottledConn
+			for i := range 3 {
+				connConfig := NewConnectionBandwithConfig(config)
+				if i == 0 {
+					connConfig.SetClassification(Classification{Class: "capped"})
+				}
+
+				connRead, connWrite := net.Pipe()
+				go readDataFromConn(connRead)
+				conn := NewThrottledConnection(connWrite, connConfig)
+				defer conn.Close()
+				conns = append(conns, conn)
+			}
+
+			for i, conn := range conns {
+				if _, err := conn.Write(make([]byte, 10)); err != nil {
+					t.Fatal(err)
+				}
+
+				info := conn.ConnInfo()
+				expected, reason := tt.expected, LimitReasonEvenSplit
+				if i == 0 {
+					expected, reason = tt.expectedCapped, LimitReasonClass
+				}
+				if info.WriteLimit == nil || *info.WriteLimit != expected || info.WriteLimitReason != reason.String() {
+					t.Errorf("expected connection %d to be limited to %d by %v, got %v by %s", i, expected, reason, info.WriteLimit, info.WriteLimitReason)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package netlistener
+
+import "time"
+
+type EventType int
+
+const (
+	// EventPenaltyStarted is emitted when a peer is moved to the penalty box
+	EventPenaltyStarted EventType = iota
+	// EventPenaltyEnded is emitted when the cooldown of a penalized peer is over
+	EventPenaltyEnded
+	// EventConnectionDenied is emitted when the classifier denied an accepted connection
+	EventConnectionDenied
+	// EventPeerDead is emitted when a connection is closed because its peer failed the liveness probe
+	EventPeerDead
+	// EventAlertFiring and EventAlertResolved are emitted when an alert rule fires and resolves, Details is the name of the rule
+	EventAlertFiring
+	EventAlertResolved
+	// EventConnStateChanged is emitted when a connection is classified, starts draining and is closed, Details is the new state
+	EventConnStateChanged
+	// EventHandlerPanic is emitted when a handler run by Serve panicked, Details holds the value and the stack
+	EventHandlerPanic
+	// EventTransferProgress is emitted periodically for connections which transferred more than the threshold of TransferProgress,
+	// Details holds the ID of the connection, the bytes it transferred and for how long
+	EventTransferProgress
+	// EventAbuseDetected is emitted when the connections of more distinct sources than allowed by AbuseDetection were rejected
+	// within its window, Details summarizes the AbuseReport
+	EventAbuseDetected
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventPenaltyStarted:
+		return "penalty_started"
+	case EventPenaltyEnded:
+		return "penalty_ended"
+	case EventConnectionDenied:
+		return "connection_denied"
+	case EventPeerDead:
+		return "peer_dead"
+	case EventAlertFiring:
+		return "alert_firing"
+	case EventAlertResolved:
+		return "alert_resolved"
+	case EventConnStateChanged:
+		return "conn_state_changed"
+	case EventHandlerPanic:
+		return "handler_panic"
+	case EventTransferProgress:
+		return "transfer_progress"
+	case EventAbuseDetected:
+		return "abuse_detected"
+	}
+
+	return "unknown"
+}
+
+// Event describes something noteworthy that happened to a connection or a peer
+type Event struct {
+	Type EventType
+	Time time.Time
+	// Peer is the remote IP the event relates to, empty if the event is not peer specific
+	Peer    string
+	Details string
+	// ConnState is the lifecycle state of the connection the event relates to, empty if it is not connection specific
+	ConnState string
+}
+
+// EventHandler receives events synchronously from the goroutine that caused them, so it should not block
+type EventHandler func(event Event)
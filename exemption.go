@@ -0,0 +1,138 @@
+package netlistener
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+)
+
+// LocalExemption selects peers in local address ranges which bypass all limiters, intra-host and intra-VPC traffic
+// usually should not be shaped. Flags can be combined
+type LocalExemption int
+
+const (
+	// ExemptLoopback exempts peers on 127.0.0.0/8 and ::1
+	ExemptLoopback LocalExemption = 1 << iota
+	// ExemptPrivate exempts peers in the private ranges of RFC 1918 and the IPv6 unique local addresses of RFC 4193
+	ExemptPrivate
+
+	// ExemptLocal exempts loopback and private peers
+	ExemptLocal = ExemptLoopback | ExemptPrivate
+)
+
+func (e LocalExemption) String() string {
+	var scopes []string
+	if e&ExemptLoopback != 0 {
+		scopes = append(scopes, "loopback")
+	}
+	if e&ExemptPrivate != 0 {
+		scopes = append(scopes, "private")
+	}
+
+	return strings.Join(scopes, ",")
+}
+
+// matches reports whether the IP is in one of the selected ranges
+func (e LocalExemption) matches(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+
+	return e&ExemptLoopback != 0 && ip.IsLoopback() || e&ExemptPrivate != 0 && ip.IsPrivate()
+}
+
+// exemptionList holds the rules for connections that should bypass all limiters.
+// Exempt connections are still wrapped, so they are counted in stats like any other connection
+type exemptionList struct {
+	nets      []*net.IPNet
+	predicate func(conn net.Conn) bool
+	// local exempts peers in local address ranges unless their classification asks to throttle them
+	local LocalExemption
+
+	mu sync.RWMutex
+}
+
+func (e *exemptionList) SetCIDRs(cidrs ...string) error {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return fmt.Errorf("invalid exemption CIDR %q: %w", cidr, err)
+		}
+
+		nets = append(nets, ipNet)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.nets = nets
+
+	return nil
+}
+
+func (e *exemptionList) SetFunc(predicate func(conn net.Conn) bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.predicate = predicate
+}
+
+// CIDRs returns the exempt networks in CIDR notation
+func (e *exemptionList) CIDRs() []string {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	cidrs := make([]string, 0, len(e.nets))
+	for _, ipNet := range e.nets {
+		cidrs = append(cidrs, ipNet.String())
+	}
+
+	return cidrs
+}
+
+func (e *exemptionList) SetLocal(local LocalExemption) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.local = local
+}
+
+func (e *exemptionList) Local() LocalExemption {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	return e.local
+}
+
+// IsLocal reports whether the peer of the connection is in one of the local ranges which are exempt
+func (e *exemptionList) IsLocal(conn net.Conn) bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	return e.local != 0 && e.local.matches(remoteIP(conn))
+}
+
+func (e *exemptionList) HasFunc() bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	return e.predicate != nil
+}
+
+// IsExempt reports whether the connection matches any of the exemption CIDRs or the predicate
+func (e *exemptionList) IsExempt(conn net.Conn) bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	if len(e.nets) > 0 && matchesNets(e.nets, remoteIP(conn)) {
+		return true
+	}
+
+	if e.predicate != nil {
+		return e.predicate(conn)
+	}
+
+	return false
+}
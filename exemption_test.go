@@ -0,0 +1,156 @@
+package netlistener
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+type addrConn struct {
+	net.Conn
+	remoteAddr net.Addr
+}
+
+func (c *addrConn) RemoteAddr() net.Addr {
+	return c.remoteAddr
+}
+
+func TestExemptionList_IsExempt(t *testing.T) {
+	tests := []struct {
+		name      string
+		cidrs     []string
+		predicate func(conn net.Conn) bool
+		addr      net.Addr
+		expected  bool
+	}{
+		{
+			name:     "No rules",
+			addr:     &net.TCPAddr{IP: net.ParseIP("10.0.0.1")},
+			expected: false,
+		},
+		{
+			name:     "IPv4 address within CIDR",
+			cidrs:    []string{"10.0.0.0/8"},
+			addr:     &net.TCPAddr{IP: net.ParseIP("10.1.2.3")},
+			expected: true,
+		},
+		{
+			name:     "IPv4 address outside of CIDR",
+			cidrs:    []string{"10.0.0.0/8"},
+			addr:     &net.TCPAddr{IP: net.ParseIP("192.168.0.1")},
+			expected: false,
+		},
+		{
+			name:     "IPv6 address within CIDR",
+			cidrs:    []string{"10.0.0.0/8", "fd00::/8"},
+			addr:     &net.TCPAddr{IP: net.ParseIP("fd00::1")},
+			expected: true,
+		},
+		{
+			name:      "Predicate matches",
+			predicate: func(conn net.Conn) bool { return true },
+			addr:      &net.TCPAddr{IP: net.ParseIP("192.168.0.1")},
+			expected:  true,
+		},
+		{
+			name:      "Neither CIDR nor predicate match",
+			cidrs:     []string{"10.0.0.0/8"},
+			predicate: func(conn net.Conn) bool { return false },
+			addr:      &net.TCPAddr{IP: net.ParseIP("192.168.0.1")},
+			expected:  false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exemptions := &exemptionList{}
+			if err := exemptions.SetCIDRs(tt.cidrs...); err != nil {
+				t.Fatal(err)
+			}
+			exemptions.SetFunc(tt.predicate)
+
+			if got := exemptions.IsExempt(&addrConn{remoteAddr: tt.addr}); got != tt.expected {
+				t.Errorf("expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestExemptionList_InvalidCIDR(t *testing.T) {
+	exemptions := &exemptionList{}
+	if err := exemptions.SetCIDRs("10.0.0.0/33"); err == nil {
+		t.Error("expected error for invalid CIDR")
+	}
+}
+
+func TestRateLimitedConnection_Exempt(t *testing.T) {
+	config := NewBandwithConfig(ptr(10), ptr(10))
+	config.SetExemptFunc(func(conn net.Conn) bool { return true })
+
+	connRead, connWrite := net.Pipe()
+	throttledConn := NewThrottledConnection(connWrite, NewConnectionBandwithConfig(config))
+
+	go readDataFromConn(connRead)
+
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		if _, err := throttledConn.Write(make([]byte, 100)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	throttledConn.Close()
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected exempt connection not to be throttled, took %s", elapsed)
+	}
+
+	stats := config.Stats()
+	if stats.ExemptConns != 1 || stats.AcceptedConns != 1 || stats.ActiveConns != 0 {
+		t.Errorf("unexpected connection counters: %+v", stats)
+	}
+	if stats.BytesWritten != 500 {
+		t.Errorf("expected 500 bytes written, got %d", stats.BytesWritten)
+	}
+}
+
+func TestRateLimitedConnection_LocalExemption(t *testing.T) {
+	tests := []struct {
+		name     string
+		local    LocalExemption
+		ip       string
+		throttle bool
+		expected bool
+	}{
+		{name: "Not exempt by default", ip: "127.0.0.1"},
+		{name: "IPv4 loopback", local: ExemptLoopback, ip: "127.0.0.1", expected: true},
+		{name: "IPv6 loopback", local: ExemptLoopback, ip: "::1", expected: true},
+		{name: "Private peer is not loopback", local: ExemptLoopback, ip: "10.1.2.3"},
+		{name: "RFC 1918", local: ExemptPrivate, ip: "172.16.5.4", expected: true},
+		{name: "Unique local IPv6", local: ExemptPrivate, ip: "fd12::1", expected: true},
+		{name: "Public peer", local: ExemptLocal, ip: "203.0.113.7"},
+		{name: "Overridden by the classification", local: ExemptLocal, ip: "192.168.1.1", throttle: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := NewBandwithConfig(ptr(1000), ptr(100))
+			config.SetLocalExemption(tt.local)
+
+			connConfig := NewConnectionBandwithConfig(config)
+			connConfig.SetClassification(Classification{Throttle: tt.throttle})
+
+			connRead, connWrite := net.Pipe()
+			defer connRead.Close()
+			conn := NewThrottledConnection(&addrConn{Conn: connWrite, remoteAddr: &net.TCPAddr{IP: net.ParseIP(tt.ip)}}, connConfig)
+			defer conn.Close()
+
+			if exempt := conn.config.Exempt(); exempt != tt.expected {
+				t.Errorf("expected exempt %v, got %v", tt.expected, exempt)
+			}
+		})
+	}
+
+	if scopes := ExemptLocal.String(); scopes != "loopback,private" {
+		t.Errorf("expected the scopes loopback,private, got %q", scopes)
+	}
+}
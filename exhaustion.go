@@ -0,0 +1,130 @@
+package netlistener
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// ExhaustionPolicy decides when a connection or class counts as out of budget
+type ExhaustionPolicy struct {
+	// MinWait is the wait for the limiters from which an operation counts as throttled, e.g. 500ms
+	MinWait time.Duration
+	// For is how long operations have to keep being throttled, e.g. 10s. An operation waiting less than MinWait starts over
+	For time.Duration
+}
+
+// BudgetExhaustion is passed to exhaustion handlers when sustained throttling starts and when it ends
+type BudgetExhaustion struct {
+	// Conn is the connection whose operation triggered the handler, for class handlers any connection of the class
+	Conn  net.Conn
+	Class string
+	// Since is when the throttling started
+	Since time.Time
+	// Exhausted is true when the budget ran out and false when operations are fast again
+	Exhausted bool
+}
+
+// ExhaustionHandler lets applications degrade gracefully, e.g. lower the bitrate of a video stream, instead of just getting slower.
+// It is called synchronously from the Read or Write that crossed the threshold
+type ExhaustionHandler func(exhaustion BudgetExhaustion)
+
+// exhaustionTracker follows the waits of a connection or class
+type exhaustionTracker struct {
+	policy  ExhaustionPolicy
+	handler ExhaustionHandler
+
+	// since is when the current streak of throttled operations started, zero without a streak
+	since     time.Time
+	exhausted bool
+
+	mu sync.Mutex
+}
+
+// observe records the wait of an operation, returning the state change to report if there is one
+func (t *exhaustionTracker) observe(wait time.Duration, now time.Time) (BudgetExhaustion, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if wait < t.policy.MinWait {
+		since, exhausted := t.since, t.exhausted
+		t.since, t.exhausted = time.Time{}, false
+
+		return BudgetExhaustion{Since: since}, exhausted
+	}
+
+	if t.since.IsZero() {
+		t.since = now.Add(-wait)
+	}
+
+	if !t.exhausted && now.Sub(t.since) >= t.policy.For {
+		t.exhausted = true
+		return BudgetExhaustion{Since: t.since, Exhausted: true}, true
+	}
+
+	return BudgetExhaustion{}, false
+}
+
+// OnBudgetExhausted calls the handler when the operations of the connection keep being throttled according to the policy,
+// and again once they are not anymore. It replaces the handler set before, nil removes it
+func OnBudgetExhausted(conn net.Conn, policy ExhaustionPolicy, handler ExhaustionHandler) error {
+	throttled, ok := conn.(*ThrottledConn)
+	if !ok {
+		return ErrNotThrottled
+	}
+
+	if handler == nil {
+		throttled.exhaustion.Store(nil)
+	} else {
+		throttled.exhaustion.Store(&exhaustionTracker{policy: policy, handler: handler})
+	}
+
+	return nil
+}
+
+// OnClassBudgetExhausted calls the handler when the operations of all connections of the class keep being throttled
+// according to the policy, and again once they are not anymore. It replaces the handler set before, nil removes it
+func (c *BandwidthConfig) OnClassBudgetExhausted(class string, policy ExhaustionPolicy, handler ExhaustionHandler) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if handler == nil {
+		delete(c.classExhaustion, class)
+		return
+	}
+
+	if c.classExhaustion == nil {
+		c.classExhaustion = make(map[string]*exhaustionTracker)
+	}
+	c.classExhaustion[class] = &exhaustionTracker{policy: policy, handler: handler}
+}
+
+func (c *BandwidthConfig) classExhaustionTracker(class string) *exhaustionTracker {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.classExhaustion[class]
+}
+
+// observeWait passes the wait of an operation to the exhaustion trackers of the connection and its class
+func (c *ThrottledConn) observeWait(wait time.Duration) {
+	now := time.Now()
+
+	var class string
+	if entry := c.config.class(); entry != nil {
+		class = entry.config.Name
+	}
+
+	trackers := []*exhaustionTracker{c.exhaustion.Load(), c.config.globalConfig.classExhaustionTracker(class)}
+	for _, tracker := range trackers {
+		if tracker == nil {
+			continue
+		}
+
+		if exhaustion, changed := tracker.observe(wait, now); changed {
+			exhaustion.Conn = c
+			exhaustion.Class = class
+			tracker.handler(exhaustion)
+		}
+	}
+}
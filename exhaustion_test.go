@@ -0,0 +1,86 @@
+package netlistener
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestExhaustionTracker_Observe(t *testing.T) {
+	start := time.Now()
+	tracker := &exhaustionTracker{policy: ExhaustionPolicy{MinWait: 500 * time.Millisecond, For: 10 * time.Second}}
+
+	tests := []struct {
+		name      string
+		wait      time.Duration
+		at        time.Duration
+		changed   bool
+		exhausted bool
+	}{
+		{name: "Fast operation is ignored", wait: 10 * time.Millisecond, at: 0},
+		{name: "Throttled operation starts a streak", wait: time.Second, at: time.Second},
+		{name: "Streak shorter than the policy is not reported", wait: time.Second, at: 6 * time.Second},
+		{name: "Sustained throttling is reported", wait: time.Second, at: 11 * time.Second, changed: true, exhausted: true},
+		{name: "Exhaustion is reported once", wait: time.Second, at: 12 * time.Second},
+		{name: "Recovery is reported", wait: 0, at: 13 * time.Second, changed: true},
+		{name: "Recovery is reported once", wait: 0, at: 14 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exhaustion, changed := tracker.observe(tt.wait, start.Add(tt.at))
+			if changed != tt.changed || exhaustion.Exhausted != tt.exhausted {
+				t.Errorf("expected changed %t and exhausted %t, got %t and %+v", tt.changed, tt.exhausted, changed, exhaustion)
+			}
+			if changed && !exhaustion.Since.Equal(start) {
+				t.Errorf("expected the streak to start at the beginning of the first throttled wait, got %s", exhaustion.Since)
+			}
+		})
+	}
+}
+
+func TestOnBudgetExhausted(t *testing.T) {
+	config := NewBandwithConfig(nil, ptr(50))
+	if err := config.SetClasses([]ClassConfig{{Name: "video"}}, "video"); err != nil {
+		t.Fatal(err)
+	}
+
+	connRead, connWrite := net.Pipe()
+	conn := NewThrottledConnection(connWrite, NewConnectionBandwithConfig(config))
+	defer conn.Close()
+	go readDataFromConn(connRead)
+
+	policy := ExhaustionPolicy{MinWait: 500 * time.Millisecond, For: 1500 * time.Millisecond}
+
+	exhaustions := make(chan BudgetExhaustion, 2)
+	if err := OnBudgetExhausted(conn, policy, func(exhaustion BudgetExhaustion) {
+		exhaustions <- exhaustion
+	}); err != nil {
+		t.Fatal(err)
+	}
+	config.OnClassBudgetExhausted("video", policy, func(exhaustion BudgetExhaustion) {
+		exhaustions <- exhaustion
+	})
+
+	// every write after the first one waits a second
+	for i := 0; i < 3; i++ {
+		if _, err := conn.Write(make([]byte, 50)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 0; i < 2; i++ {
+		select {
+		case exhaustion := <-exhaustions:
+			if !exhaustion.Exhausted || exhaustion.Class != "video" || exhaustion.Conn != conn {
+				t.Errorf("expected the budget of the connection in the video class to be exhausted, got %+v", exhaustion)
+			}
+		default:
+			t.Fatal("expected the connection and its class to be reported")
+		}
+	}
+
+	if err := OnBudgetExhausted(connRead, policy, nil); err != ErrNotThrottled {
+		t.Errorf("expected ErrNotThrottled for a plain connection, got %v", err)
+	}
+}
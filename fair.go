@@ -0,0 +1,168 @@
+package netlistener
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// activityWindow is how long a connection counts as active after its last read or write in work conserving mode
+const activityWindow = 200 * time.Millisecond
+
+// SharingMode decides how connections share the global limit
+type SharingMode int
+
+const (
+	// SharingFirstCome lets connections take from the global limit in the order they ask, a single connection may use all of it
+	SharingFirstCome SharingMode = iota
+	// SharingWorkConserving splits the global limit between the connections active within the activity window in proportion
+	// to their weights, evenly unless weights are set. Idle connections do not hold back a share, so the active ones can use
+	// the whole limit right away
+	SharingWorkConserving
+)
+
+func (m SharingMode) String() string {
+	switch m {
+	case SharingFirstCome:
+		return "first_come"
+	case SharingWorkConserving:
+		return "work_conserving"
+	}
+
+	return "unknown"
+}
+
+// fairScheduler tracks the connections active in each direction for the work conserving mode
+type fairScheduler struct {
+	reading activeSet
+	writing activeSet
+}
+
+// activeSet is the set of connections which were active within the activity window
+type activeSet struct {
+	active map[*ThrottledConn]activeEntry
+	// totalWeight is the sum of the weights of the active connections
+	totalWeight float64
+	// nextPrune is when the expired connections are removed next, so the set is not scanned on every operation
+	nextPrune time.Time
+
+	mu sync.Mutex
+}
+
+type activeEntry struct {
+	lastActive time.Time
+	// weight is the weight of the connection when it was last active
+	weight float64
+}
+
+// share marks the connection active and returns its part of the global limit
+func (s *fairScheduler) share(conn *ThrottledConn, global rate.Limit, read bool, now time.Time) rate.Limit {
+	set := &s.writing
+	if read {
+		set = &s.reading
+	}
+
+	if global == rate.Inf {
+		return rate.Inf
+	}
+
+	weight, total := set.touch(conn, conn.fairWeight(), now)
+
+	return global * rate.Limit(weight/total)
+}
+
+// remove forgets a closed connection
+func (s *fairScheduler) remove(conn *ThrottledConn) {
+	s.reading.remove(conn)
+	s.writing.remove(conn)
+}
+
+// reweight updates the weights of the active connections after a weight changed, so the shares of all of them change at once
+func (s *fairScheduler) reweight() {
+	s.reading.reweight()
+	s.writing.reweight()
+}
+
+// touch marks the connection active with its current weight and returns it with the total weight of the active connections
+func (s *activeSet) touch(conn *ThrottledConn, weight float64, now time.Time) (float64, float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.active == nil {
+		s.active = make(map[*ThrottledConn]activeEntry)
+	}
+
+	if now.After(s.nextPrune) {
+		// the total is summed up again while pruning, so rounding errors do not accumulate
+		s.totalWeight = 0
+		for c, entry := range s.active {
+			if now.Sub(entry.lastActive) > activityWindow {
+				delete(s.active, c)
+				continue
+			}
+			s.totalWeight += entry.weight
+		}
+		s.nextPrune = now.Add(activityWindow / 2)
+	}
+
+	s.totalWeight += weight - s.active[conn].weight
+	s.active[conn] = activeEntry{lastActive: now, weight: weight}
+
+	return weight, s.totalWeight
+}
+
+func (s *activeSet) remove(conn *ThrottledConn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if entry, ok := s.active[conn]; ok {
+		s.totalWeight -= entry.weight
+		delete(s.active, conn)
+	}
+}
+
+func (s *activeSet) reweight() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.totalWeight = 0
+	for c, entry := range s.active {
+		entry.weight = c.fairWeight()
+		s.active[c] = entry
+		s.totalWeight += entry.weight
+	}
+}
+
+// SetWeight sets the weight of the connection in work conserving mode, where the active connections split the global limit
+// in proportion to their weights multiplied by the weights of their classes, e.g. to let the application auction bandwidth.
+// The default weight is 1, weights which are not positive restore it. Waiting connections are woken up, so the global limit
+// is split again within one refill interval
+func (c *ThrottledConn) SetWeight(weight float64) {
+	if weight <= 0 {
+		weight = 1
+	}
+	c.weight.Store(math.Float64bits(weight))
+	c.config.globalConfig.fair.reweight()
+	c.config.globalConfig.limitUpdates.Notify()
+}
+
+// Weight returns the weight of the connection, without the weight of its class
+func (c *ThrottledConn) Weight() float64 {
+	if bits := c.weight.Load(); bits != 0 {
+		return math.Float64frombits(bits)
+	}
+
+	return 1
+}
+
+// fairWeight is the weight of the connection multiplied by the weight of its class
+func (c *ThrottledConn) fairWeight() float64 {
+	weight := c.Weight()
+	if c.config != nil {
+		weight *= c.config.globalConfig.classes.Weight(c.config.class())
+	}
+
+	return weight
+}
@@ -0,0 +1,153 @@
+package netlistener
+
+import (
+	"math"
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+func TestFairScheduler_Share(t *testing.T) {
+	a, b := &ThrottledConn{}, &ThrottledConn{}
+	start := time.Now()
+
+	tests := []struct {
+		name     string
+		conn     *ThrottledConn
+		at       time.Duration
+		read     bool
+		expected rate.Limit
+	}{
+		{name: "Single active connection gets the whole limit", conn: a, at: 0, expected: 1000},
+		{name: "Two active connections split the limit", conn: b, at: 50 * time.Millisecond, expected: 500},
+		{name: "Directions are tracked separately", conn: b, at: 60 * time.Millisecond, read: true, expected: 1000},
+		{name: "Idle connection gives up its share", conn: b, at: 300 * time.Millisecond, expected: 1000},
+	}
+
+	var scheduler fairScheduler
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if share := scheduler.share(tt.conn, 1000, tt.read, start.Add(tt.at)); share != tt.expected {
+				t.Errorf("expected share %v, got %v", tt.expected, share)
+			}
+		})
+	}
+}
+
+func TestRateLimitedConnection_WorkConserving(t *testing.T) {
+	config := NewBandwithConfig(ptr(1000), nil)
+	config.SetSharingMode(SharingWorkConserving)
+
+	conns := make([]*ThrottledConn, 2)
+	for i := range conns {
+		connRead, connWrite := net.Pipe()
+		conns[i] = NewThrottledConnection(connWrite, NewConnectionBandwithConfig(config))
+		defer conns[i].Close()
+		go readDataFromConn(connRead)
+	}
+
+	// the second connection is idle for longer than the activity window, so the first one gets the whole limit
+	conns[1].Write(make([]byte, 1))
+	time.Sleep(2 * activityWindow)
+
+	if _, err := conns[0].Write(make([]byte, 100)); err != nil {
+		t.Fatal(err)
+	}
+	if limit := conns[0].config.PerConnWriteLimiter().Limit(); limit != 1000 {
+		t.Errorf("expected the active connection to get the whole global limit, got %v", limit)
+	}
+
+	conns[1].Write(make([]byte, 1))
+	if _, err := conns[0].Write(make([]byte, 100)); err != nil {
+		t.Fatal(err)
+	}
+	if limit := conns[0].config.PerConnWriteLimiter().Limit(); limit != 500 {
+		t.Errorf("expected two active connections to split the global limit, got %v", limit)
+	}
+}
+
+func TestFairScheduler_WeightedShare(t *testing.T) {
+	a, b := &ThrottledConn{}, &ThrottledConn{}
+	a.weight.Store(math.Float64bits(3))
+	start := time.Now()
+
+	tests := []struct {
+		name     string
+		conn     *ThrottledConn
+		weight   float64
+		expected rate.Limit
+	}{
+		{name: "Single weighted connection gets the whole limit", conn: a, expected: 1000},
+		{name: "Default weight", conn: b, expected: 250},
+		{name: "Heavier connection", conn: a, expected: 750},
+		{name: "Changed weight", conn: a, weight: 1, expected: 500},
+	}
+
+	var scheduler fairScheduler
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.weight > 0 {
+				tt.conn.weight.Store(math.Float64bits(tt.weight))
+				scheduler.reweight()
+			}
+
+			if share := scheduler.share(tt.conn, 1000, false, start); share != tt.expected {
+				t.Errorf("expected share %v, got %v", tt.expected, share)
+			}
+		})
+	}
+}
+
+func TestRateLimitedConnection_WorkConservingWeights(t *testing.T) {
+	config := NewBandwithConfig(ptr(1200), nil)
+	config.SetSharingMode(SharingWorkConserving)
+	if err := config.SetClasses([]ClassConfig{{Name: "gold", Weight: 2}, {Name: "bronze"}}, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	conns := make(map[string]*ThrottledConn)
+	for _, class := range []string{"gold", "bronze"} {
+		connConfig := NewConnectionBandwithConfig(config)
+		connConfig.SetClassification(Classification{Class: class})
+		connRead, connWrite := net.Pipe()
+		conns[class] = NewThrottledConnection(connWrite, connConfig)
+		defer conns[class].Close()
+		go readDataFromConn(connRead)
+	}
+
+	tests := []struct {
+		name     string
+		change   func()
+		expected map[string]rate.Limit
+	}{
+		{name: "Class weights", change: func() {}, expected: map[string]rate.Limit{"gold": 800, "bronze": 400}},
+		{name: "Connection bids more", change: func() { conns["bronze"].SetWeight(4) }, expected: map[string]rate.Limit{"gold": 400, "bronze": 800}},
+		{name: "Class weight changed at runtime", change: func() {
+			if err := config.SetClassWeight("gold", 4); err != nil {
+				t.Fatal(err)
+			}
+		}, expected: map[string]rate.Limit{"gold": 600, "bronze": 600}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.change()
+
+			// both connections are touched once before their shares are checked
+			for range 2 {
+				for _, conn := range conns {
+					if _, err := conn.Write(make([]byte, 1)); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+			for class, expected := range tt.expected {
+				if limit := conns[class].config.PerConnWriteLimiter().Limit(); math.Abs(float64(limit-expected)) > 1 {
+					t.Errorf("expected %s to get %v, got %v", class, expected, limit)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,116 @@
+package netlistener
+
+import (
+	"net"
+	"sync"
+
+	"golang.org/x/time/rate"
+)
+
+// AddressFamily is the IP version of the remote address of a connection
+type AddressFamily int
+
+const (
+	// familyNone is the family of connections which are not IP based, they are not subject to family limits
+	familyNone AddressFamily = iota
+	// FamilyIPv4 includes IPv4-mapped IPv6 addresses of dual-stack sockets
+	FamilyIPv4
+	FamilyIPv6
+)
+
+func (f AddressFamily) String() string {
+	switch f {
+	case FamilyIPv4:
+		return "ipv4"
+	case FamilyIPv6:
+		return "ipv6"
+	}
+
+	return "unknown"
+}
+
+// addressFamily returns the family of the remote address of the connection
+func addressFamily(conn net.Conn) AddressFamily {
+	ip := remoteIP(conn)
+	switch {
+	case ip == nil:
+		return familyNone
+	case ip.To4() != nil:
+		return FamilyIPv4
+	default:
+		return FamilyIPv6
+	}
+}
+
+// familyLimits holds the global limiters of each address family, shared by all connections of the family
+type familyLimits struct {
+	readLimiters  map[AddressFamily]*rate.Limiter
+	writeLimiters map[AddressFamily]*rate.Limiter
+	mu            sync.RWMutex
+}
+
+// Set updates the limit of the family in place, so connections waiting for the limiters see the change.
+// It reports whether the limit was raised
+func (f *familyLimits) Set(family AddressFamily, limit *int) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.readLimiters == nil {
+		f.readLimiters = make(map[AddressFamily]*rate.Limiter)
+		f.writeLimiters = make(map[AddressFamily]*rate.Limiter)
+	}
+
+	read, ok := f.readLimiters[family]
+	if !ok {
+		f.readLimiters[family] = rate.NewLimiter(formatRateLimit(limit), formatBurst(limit))
+		f.writeLimiters[family] = rate.NewLimiter(formatRateLimit(limit), formatBurst(limit))
+		invalidateFastPath()
+
+		// without a limiter the family was unlimited, so a new limit never raises it
+		return false
+	}
+
+	raised := formatRateLimit(limit) > read.Limit()
+	updateLimiter(read, formatRateLimit(limit))
+	updateLimiter(f.writeLimiters[family], formatRateLimit(limit))
+
+	return raised
+}
+
+// Limiter returns the limiter of the family in the direction, nil if the family is unlimited
+func (f *familyLimits) Limiter(family AddressFamily, read bool) *rate.Limiter {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	limiters := f.writeLimiters
+	if read {
+		limiters = f.readLimiters
+	}
+
+	limiter := limiters[family]
+	if limiter == nil || limiter.Limit() == rate.Inf {
+		return nil
+	}
+
+	return limiter
+}
+
+// Limits returns the limits of the families which have one
+func (f *familyLimits) Limits() map[string]*int {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	var limits map[string]*int
+	for family, limiter := range f.readLimiters {
+		if limiter.Limit() == rate.Inf {
+			continue
+		}
+
+		if limits == nil {
+			limits = make(map[string]*int)
+		}
+		limits[family.String()] = limitToInt(limiter.Limit())
+	}
+
+	return limits
+}
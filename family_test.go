@@ -0,0 +1,63 @@
+package netlistener
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestAddressFamily(t *testing.T) {
+	tests := []struct {
+		name     string
+		addr     net.Addr
+		expected AddressFamily
+	}{
+		{name: "IPv4", addr: &net.TCPAddr{IP: net.ParseIP("192.0.2.1")}, expected: FamilyIPv4},
+		{name: "IPv4-mapped IPv6", addr: &net.TCPAddr{IP: net.ParseIP("::ffff:192.0.2.1")}, expected: FamilyIPv4},
+		{name: "IPv6", addr: &net.TCPAddr{IP: net.ParseIP("2001:db8::1")}, expected: FamilyIPv6},
+		{name: "Not IP based", addr: &net.UnixAddr{Name: "/tmp/sock", Net: "unix"}, expected: familyNone},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if family := addressFamily(&addrConn{remoteAddr: tt.addr}); family != tt.expected {
+				t.Errorf("expected %v, got %v", tt.expected, family)
+			}
+		})
+	}
+}
+
+func TestRateLimitedConnection_FamilyLimit(t *testing.T) {
+	tests := []struct {
+		name string
+		addr net.Addr
+		// throttled is whether the second write of 20 bytes has to wait for the IPv6 limit of 20 bytes per second
+		throttled bool
+	}{
+		{name: "IPv6 connection is limited", addr: &net.TCPAddr{IP: net.ParseIP("2001:db8::1")}, throttled: true},
+		{name: "IPv4 connection is not", addr: &net.TCPAddr{IP: net.ParseIP("192.0.2.1")}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := NewBandwithConfig(nil, nil)
+			config.SetFamilyLimit(FamilyIPv6, ptr(20))
+
+			connRead, connWrite := net.Pipe()
+			conn := NewThrottledConnection(&addrConn{Conn: connWrite, remoteAddr: tt.addr}, NewConnectionBandwithConfig(config))
+			defer conn.Close()
+			go readDataFromConn(connRead)
+
+			start := time.Now()
+			for range 2 {
+				if _, err := conn.Write(make([]byte, 20)); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if throttled := time.Since(start) > 500*time.Millisecond; throttled != tt.throttled {
+				t.Errorf("expected throttled %t, took %v", tt.throttled, time.Since(start))
+			}
+		})
+	}
+}
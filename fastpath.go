@@ -0,0 +1,71 @@
+package netlistener
+
+import (
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// limitsGeneration is bumped whenever a limit of a shared limiter, the limiters connections wait for or an input
+// of the per connection limits changes, invalidating the fast path of all connections. Changes of a single connection
+// are counted in the changes of its ConnConfig instead
+var limitsGeneration atomic.Uint64
+
+// invalidateFastPath makes all connections pick their limiters again with their next operation
+func invalidateFastPath() {
+	limitsGeneration.Add(1)
+}
+
+// fastPathGeneration returns the generation of the limiters of the connection. The counters only grow,
+// so their sum stays the same only while none of them changes
+func (c *ThrottledConn) fastPathGeneration() uint64 {
+	generation := limitsGeneration.Load() + c.config.changes.Load()
+	// the limiters of a nested connection are part of the chain
+	if c.nested != nil {
+		generation += c.nested.config.changes.Load()
+	}
+
+	return generation
+}
+
+// fastPath reports whether the last limiters of the direction were all unlimited and nothing changed since,
+// so the operation can skip picking the limiters and waiting for them
+func (c *ThrottledConn) fastPath(read bool) bool {
+	generation := &c.fastWrite
+	if read {
+		generation = &c.fastRead
+	}
+
+	return generation.Load() == c.fastPathGeneration()+1
+}
+
+// updateFastPath caches whether the limiters picked for the direction at the generation are all unlimited.
+// Limits which change without a change of the config, the ones of a boost, the even split, the fair share
+// and the penalty box, and the off-peak window are not cached, since nothing bumps the generation when they end
+func (c *ThrottledConn) updateFastPath(read bool, generation uint64, limiters []*rate.Limiter) {
+	cached := &c.fastWrite
+	reason := LimitReason(c.writeLimitReason.Load())
+	if read {
+		cached, reason = &c.fastRead, LimitReason(c.readLimitReason.Load())
+	}
+
+	for _, limiter := range limiters {
+		if limiter.Limit() != rate.Inf {
+			cached.Store(0)
+			return
+		}
+	}
+
+	switch reason {
+	case LimitReasonBoost, LimitReasonEvenSplit, LimitReasonFairShare, LimitReasonPenalty:
+		cached.Store(0)
+		return
+	}
+	if len(limiters) == 0 && !c.config.Exempt() && c.config.globalConfig.offPeakUnlimited(time.Now()) {
+		cached.Store(0)
+		return
+	}
+
+	cached.Store(generation + 1)
+}
@@ -0,0 +1,120 @@
+package netlistener
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestThrottledConn_FastPath(t *testing.T) {
+	tests := []struct {
+		name         string
+		globalLimit  *int
+		perConnLimit *int
+		// setup runs before the first write
+		setup func(config *BandwidthConfig)
+		// change runs after the first write
+		change func(config *BandwidthConfig, conn *ThrottledConn)
+		// expected tell whether the writes take the fast path after the first write and after the change
+		expected        bool
+		expectedChanged bool
+	}{
+		{name: "Unlimited", expected: true, expectedChanged: true},
+		{name: "Global limit", globalLimit: ptr(1 << 20), expected: false, expectedChanged: false},
+		{name: "Per connection limit", perConnLimit: ptr(1 << 20), expected: false, expectedChanged: false},
+		{
+			name:            "Global limit set",
+			change:          func(config *BandwidthConfig, conn *ThrottledConn) { config.SetGlobalLimit(ptr(1 << 20)) },
+			expected:        true,
+			expectedChanged: false,
+		},
+		{
+			name:            "Per connection limit set",
+			change:          func(config *BandwidthConfig, conn *ThrottledConn) { config.SetPerConnLimit(ptr(1 << 20)) },
+			expected:        true,
+			expectedChanged: false,
+		},
+		{
+			name: "Classification of the connection changed",
+			change: func(config *BandwidthConfig, conn *ThrottledConn) {
+				conn.config.SetClassification(Classification{PerConnLimit: ptr(1 << 20)})
+			},
+			expected:        true,
+			expectedChanged: false,
+		},
+		{
+			name:            "Other connection changed",
+			change:          func(config *BandwidthConfig, conn *ThrottledConn) { NewConnConfig(config).SetExempt(true) },
+			expected:        true,
+			expectedChanged: true,
+		},
+		{
+			name:            "Boost",
+			perConnLimit:    ptr(1 << 20),
+			setup:           func(config *BandwidthConfig) { config.SetBoost(&Boost{Duration: time.Hour}) },
+			expected:        false,
+			expectedChanged: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := NewBandwidthConfig(tt.globalLimit, tt.perConnLimit)
+			if tt.setup != nil {
+				tt.setup(config)
+			}
+
+			connRead, connWrite := net.Pipe()
+			throttledConn := NewThrottledConnection(connWrite, NewConnConfig(config))
+			defer throttledConn.Close()
+			go readDataFromConn(connRead)
+
+			if _, err := throttledConn.Write(make([]byte, 100)); err != nil {
+				t.Fatal(err)
+			}
+			if got := throttledConn.fastPath(false); got != tt.expected {
+				t.Errorf("expected fast path %v after the first write, got %v", tt.expected, got)
+			}
+
+			if tt.change != nil {
+				tt.change(config, throttledConn)
+			}
+			if got := throttledConn.fastPath(false); got && !tt.expectedChanged {
+				t.Error("expected the change to invalidate the fast path")
+			}
+
+			if _, err := throttledConn.Write(make([]byte, 100)); err != nil {
+				t.Fatal(err)
+			}
+			if got := throttledConn.fastPath(false); got != tt.expectedChanged {
+				t.Errorf("expected fast path %v after the change, got %v", tt.expectedChanged, got)
+			}
+		})
+	}
+}
+
+func TestThrottledConn_FastPathLimited(t *testing.T) {
+	config := NewBandwidthConfig(nil, nil)
+
+	connRead, connWrite := net.Pipe()
+	throttledConn := NewThrottledConnection(connWrite, NewConnConfig(config))
+	defer throttledConn.Close()
+	go readDataFromConn(connRead)
+
+	if _, err := throttledConn.Write(make([]byte, 100)); err != nil {
+		t.Fatal(err)
+	}
+	config.SetGlobalLimit(ptr(100))
+
+	// 200 bytes at 100 B/s take at least a second, whatever the limiter holds when the limit is set
+	start := time.Now()
+	if _, err := throttledConn.Write(make([]byte, 200)); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 900*time.Millisecond {
+		t.Errorf("expected the connection to be throttled after the limit was set, took %s", elapsed)
+	}
+	if stats := config.Stats(); stats.BytesWritten != 300 {
+		t.Errorf("expected 300 bytes written, got %d", stats.BytesWritten)
+	}
+}
@@ -0,0 +1,68 @@
+package netlistener
+
+import (
+	"errors"
+	"net"
+	"os"
+	"time"
+)
+
+// ErrHandoffUnsupported is returned on platforms without fd passing and for connections which do not expose their file
+var ErrHandoffUnsupported = errors.New("connection handoff is not supported for this connection")
+
+// maxHandoffStateSize limits the metadata accepted with a handed off connection
+const maxHandoffStateSize = 64 * 1024
+
+// HandoffState is the metadata of a throttled connection sent alongside its file descriptor,
+// so the receiving process continues with the same classification, counters and budget
+type HandoffState struct {
+	Classification Classification `json:"classification"`
+	Exempt         bool           `json:"exempt"`
+	AcceptedAt     time.Time      `json:"accepted_at"`
+	BytesRead      int64          `json:"bytes_read"`
+	BytesWritten   int64          `json:"bytes_written"`
+	Buckets        BucketsState   `json:"buckets"`
+}
+
+// handoffState captures the metadata of the connection, plain connections get the zero state
+func handoffState(conn net.Conn) HandoffState {
+	throttled, ok := conn.(*ThrottledConn)
+	if !ok {
+		return HandoffState{AcceptedAt: time.Now()}
+	}
+
+	return HandoffState{
+		Classification: throttled.config.Classification(),
+		Exempt:         throttled.config.Exempt(),
+		AcceptedAt:     throttled.acceptedAt,
+		BytesRead:      throttled.bytesRead.Load(),
+		BytesWritten:   throttled.bytesWritten.Load(),
+		Buckets:        throttled.config.SnapshotBuckets(),
+	}
+}
+
+// restoreHandoff wraps a received connection, restoring its metadata.
+// Global stats of the receiving process only count the connection, bytes transferred before the handoff stay with the sender
+func restoreHandoff(conn net.Conn, config *BandwidthConfig, state HandoffState) *ThrottledConn {
+	connConfig := NewConnConfig(config)
+	connConfig.SetClassification(state.Classification)
+	connConfig.SetExempt(state.Exempt)
+
+	throttled := NewThrottledConnection(conn, connConfig)
+	throttled.acceptedAt = state.AcceptedAt
+	throttled.bytesRead.Store(state.BytesRead)
+	throttled.bytesWritten.Store(state.BytesWritten)
+	connConfig.RestoreBuckets(state.Buckets)
+
+	return throttled
+}
+
+// connFile returns a duplicate of the file descriptor of the connection, unwrapping the throttled wrapper
+func connFile(conn net.Conn) (*os.File, error) {
+	filer, ok := unwrapThrottled(conn).(interface{ File() (*os.File, error) })
+	if !ok {
+		return nil, ErrHandoffUnsupported
+	}
+
+	return filer.File()
+}
@@ -0,0 +1,13 @@
+//go:build !unix
+
+package netlistener
+
+import "net"
+
+func SendConn(via *net.UnixConn, conn net.Conn) error {
+	return ErrHandoffUnsupported
+}
+
+func ReceiveConn(via *net.UnixConn, config *BandwidthConfig) (net.Conn, error) {
+	return nil, ErrHandoffUnsupported
+}
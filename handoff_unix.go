@@ -0,0 +1,113 @@
+//go:build unix
+
+package netlistener
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"syscall"
+)
+
+// SendConn passes the connection to another process over a unix socket together with its throttling metadata.
+// The connection stays open in this process and should be closed once it was sent, the receiving process owns a duplicate
+func SendConn(via *net.UnixConn, conn net.Conn) error {
+	file, err := connFile(conn)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	state, err := json.Marshal(handoffState(conn))
+	if err != nil {
+		return err
+	}
+
+	msg := binary.BigEndian.AppendUint32(nil, uint32(len(state)))
+	msg = append(msg, state...)
+
+	_, _, err = via.WriteMsgUnix(msg, syscall.UnixRights(int(file.Fd())), nil)
+
+	return err
+}
+
+// ReceiveConn receives a connection sent by SendConn and wraps it into a throttled connection of the config,
+// continuing with the classification, counters and token buckets it had in the sending process
+func ReceiveConn(via *net.UnixConn, config *BandwidthConfig) (net.Conn, error) {
+	buf := make([]byte, 4+maxHandoffStateSize)
+	oob := make([]byte, syscall.CmsgSpace(4))
+
+	n, oobn, _, _, err := via.ReadMsgUnix(buf, oob)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := receivedConn(oob[:oobn])
+	if err != nil {
+		return nil, err
+	}
+
+	state, err := readHandoffState(via, buf, n)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return restoreHandoff(conn, config, state), nil
+}
+
+func receivedConn(oob []byte) (net.Conn, error) {
+	messages, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return nil, err
+	}
+	if len(messages) != 1 {
+		return nil, fmt.Errorf("expected one control message, got %d", len(messages))
+	}
+
+	fds, err := syscall.ParseUnixRights(&messages[0])
+	if err != nil {
+		return nil, err
+	}
+	if len(fds) != 1 {
+		for _, fd := range fds {
+			syscall.Close(fd)
+		}
+		return nil, fmt.Errorf("expected one file descriptor, got %d", len(fds))
+	}
+
+	file := os.NewFile(uintptr(fds[0]), "handoff")
+	defer file.Close()
+
+	return net.FileConn(file)
+}
+
+// readHandoffState decodes the length prefixed metadata, reading the rest of it if the first read was short
+func readHandoffState(via io.Reader, buf []byte, n int) (HandoffState, error) {
+	var state HandoffState
+
+	if n < 4 {
+		if _, err := io.ReadFull(via, buf[n:4]); err != nil {
+			return state, err
+		}
+		n = 4
+	}
+
+	size := int(binary.BigEndian.Uint32(buf))
+	if size > maxHandoffStateSize {
+		return state, fmt.Errorf("handoff state of %d bytes exceeds the limit of %d bytes", size, maxHandoffStateSize)
+	}
+
+	if n < 4+size {
+		if _, err := io.ReadFull(via, buf[n:4+size]); err != nil {
+			return state, err
+		}
+	}
+
+	err := json.Unmarshal(buf[4:4+size], &state)
+
+	return state, err
+}
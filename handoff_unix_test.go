@@ -0,0 +1,83 @@
+//go:build unix
+
+package netlistener
+
+import (
+	"io"
+	"net"
+	"path/filepath"
+	"testing"
+)
+
+func unixPair(t *testing.T) (*net.UnixConn, *net.UnixConn) {
+	t.Helper()
+
+	listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: filepath.Join(t.TempDir(), "handoff.sock"), Net: "unix"})
+	if err != nil {
+		t.Fatal("Failed to create listener", err)
+	}
+	defer listener.Close()
+
+	accepted := make(chan *net.UnixConn)
+	go func() {
+		conn, _ := listener.AcceptUnix()
+		accepted <- conn
+	}()
+
+	client, err := net.DialUnix("unix", nil, listener.Addr().(*net.UnixAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return client, <-accepted
+}
+
+func TestSendConn(t *testing.T) {
+	client, server := tcpPair(t)
+	defer client.Close()
+
+	sending := NewBandwithConfig(nil, ptr(1000))
+	connConfig := NewConnectionBandwithConfig(sending)
+	connConfig.SetClassification(Classification{Class: "bulk", Tags: []string{"tenant-a"}})
+	conn := NewThrottledConnection(server, connConfig)
+
+	go client.Write(make([]byte, 100))
+	if _, err := io.ReadFull(conn, make([]byte, 100)); err != nil {
+		t.Fatal(err)
+	}
+
+	via, peer := unixPair(t)
+	defer via.Close()
+	defer peer.Close()
+
+	if err := SendConn(via, conn); err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	received, err := ReceiveConn(peer, NewBandwithConfig(nil, ptr(1000)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer received.Close()
+
+	throttled := received.(*ThrottledConn)
+	if throttled.bytesRead.Load() != 100 {
+		t.Errorf("expected 100 bytes read to be carried over, got %d", throttled.bytesRead.Load())
+	}
+	if classification := throttled.config.Classification(); classification.Class != "bulk" || !hasTag(classification.Tags, "tenant-a") {
+		t.Errorf("expected the classification to be carried over, got %+v", classification)
+	}
+	if tokens := throttled.config.PerConnReadLimiter().Tokens(); tokens > 950 {
+		t.Errorf("expected the read bucket to be carried over, %f tokens left", tokens)
+	}
+
+	// the received connection is still the same socket
+	if _, err := client.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(received, buf); err != nil || string(buf) != "ping" {
+		t.Errorf("expected to read from the received connection, got %q, %v", buf, err)
+	}
+}
@@ -0,0 +1,44 @@
+package netlistener
+
+import (
+	"sync"
+	"time"
+)
+
+// healthCheckDetection recognises load balancer health checks: connections which are closed shortly
+// after being accepted, having transferred almost nothing. Such connections are excluded from stats
+type healthCheckDetection struct {
+	enabled     bool
+	maxDuration time.Duration
+	maxBytes    int64
+
+	mu sync.RWMutex
+}
+
+func (h *healthCheckDetection) Set(maxDuration time.Duration, maxBytes int64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.enabled = maxDuration > 0
+	h.maxDuration = maxDuration
+	h.maxBytes = maxBytes
+}
+
+func (h *healthCheckDetection) Get() (maxDuration time.Duration, maxBytes int64, enabled bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	return h.maxDuration, h.maxBytes, h.enabled
+}
+
+// IsHealthCheck reports whether a connection with given lifetime and total transferred bytes looks like a health check
+func (h *healthCheckDetection) IsHealthCheck(lifetime time.Duration, bytes int64) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	if !h.enabled {
+		return false
+	}
+
+	return lifetime < h.maxDuration && bytes < h.maxBytes
+}
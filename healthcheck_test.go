@@ -0,0 +1,87 @@
+package netlistener
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestHealthCheckDetection_IsHealthCheck(t *testing.T) {
+	tests := []struct {
+		name        string
+		maxDuration time.Duration
+		maxBytes    int64
+		lifetime    time.Duration
+		bytes       int64
+		expected    bool
+	}{
+		{
+			name:     "Detection is disabled",
+			lifetime: time.Millisecond,
+			expected: false,
+		},
+		{
+			name:        "Short connection without data is a health check",
+			maxDuration: 100 * time.Millisecond,
+			maxBytes:    10,
+			lifetime:    time.Millisecond,
+			bytes:       0,
+			expected:    true,
+		},
+		{
+			name:        "Connection lived too long",
+			maxDuration: 100 * time.Millisecond,
+			maxBytes:    10,
+			lifetime:    time.Second,
+			bytes:       0,
+			expected:    false,
+		},
+		{
+			name:        "Connection transferred too much",
+			maxDuration: 100 * time.Millisecond,
+			maxBytes:    10,
+			lifetime:    time.Millisecond,
+			bytes:       10,
+			expected:    false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			detection := &healthCheckDetection{}
+			detection.Set(tt.maxDuration, tt.maxBytes)
+
+			if got := detection.IsHealthCheck(tt.lifetime, tt.bytes); got != tt.expected {
+				t.Errorf("expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestRateLimitedConnection_HealthCheckExcludedFromStats(t *testing.T) {
+	config := NewBandwithConfig(nil, nil)
+	config.SetHealthCheckDetection(time.Second, 10)
+
+	healthCheckRead, healthCheckWrite := net.Pipe()
+	healthCheck := NewThrottledConnection(healthCheckWrite, NewConnectionBandwithConfig(config))
+	go readDataFromConn(healthCheckRead)
+	healthCheck.Write([]byte("ok"))
+	healthCheck.Close()
+
+	connRead, connWrite := net.Pipe()
+	conn := NewThrottledConnection(connWrite, NewConnectionBandwithConfig(config))
+	go readDataFromConn(connRead)
+	conn.Write(make([]byte, 100))
+	conn.Close()
+
+	stats := config.Stats()
+	if stats.HealthChecks != 1 {
+		t.Errorf("expected 1 health check, got %d", stats.HealthChecks)
+	}
+	if stats.AcceptedConns != 1 || stats.ActiveConns != 0 {
+		t.Errorf("unexpected connection counters: %+v", stats)
+	}
+	if stats.BytesWritten != 100 {
+		t.Errorf("expected 100 bytes written, got %d", stats.BytesWritten)
+	}
+}
@@ -0,0 +1,95 @@
+package netlistener
+
+import (
+	"math"
+	"math/bits"
+	"sync/atomic"
+)
+
+// histogramSubBits is the number of bits of precision within a power of two, values are recorded exactly up to 16
+// and within 12.5% above, like an HDR histogram with one significant digit
+const histogramSubBits = 3
+
+// histogramBuckets covers all non-negative int64 values
+const histogramBuckets = (64-histogramSubBits)<<histogramSubBits + 1<<histogramSubBits
+
+// Percentiles summarize a distribution recorded in a histogram, see Stats for the unit of each distribution
+type Percentiles struct {
+	Count int64 `json:"count"`
+	P50   int64 `json:"p50"`
+	P95   int64 `json:"p95"`
+	P99   int64 `json:"p99"`
+	Max   int64 `json:"max"`
+}
+
+// histogram is a lock free log-linear histogram with a constant relative error, recording costs a single atomic add
+type histogram struct {
+	counts [histogramBuckets]atomic.Int64
+}
+
+func (h *histogram) record(value int64) {
+	h.counts[histogramIndex(uint64(max(value, 0)))].Add(1)
+}
+
+// Percentiles returns the percentiles of the recorded values, reported as the highest value of their bucket
+func (h *histogram) Percentiles() Percentiles {
+	var counts [histogramBuckets]int64
+	var total int64
+	for i := range counts {
+		counts[i] = h.counts[i].Load()
+		total += counts[i]
+	}
+
+	percentiles := Percentiles{Count: total}
+	if total == 0 {
+		return percentiles
+	}
+
+	quantile := func(q float64) int64 {
+		rank := int64(math.Ceil(q * float64(total)))
+		var seen int64
+		for i, count := range counts {
+			seen += count
+			if seen >= rank {
+				return histogramUpperBound(i)
+			}
+		}
+
+		return histogramUpperBound(histogramBuckets - 1)
+	}
+
+	percentiles.P50 = quantile(0.5)
+	percentiles.P95 = quantile(0.95)
+	percentiles.P99 = quantile(0.99)
+	percentiles.Max = quantile(1)
+
+	return percentiles
+}
+
+// histogramIndex returns the bucket of the value, values below 16 have a bucket of their own
+func histogramIndex(value uint64) int {
+	if value < 1<<(histogramSubBits+1) {
+		return int(value)
+	}
+
+	// the value shifted right by shift has histogramSubBits+1 bits, its top bit is always set
+	shift := bits.Len64(value) - histogramSubBits - 1
+
+	return (shift+1)<<histogramSubBits + int(value>>shift) - 1<<histogramSubBits
+}
+
+// histogramUpperBound returns the highest value recorded in the bucket
+func histogramUpperBound(index int) int64 {
+	if index < 1<<(histogramSubBits+1) {
+		return int64(index)
+	}
+
+	shift := index>>histogramSubBits - 1
+	mantissa := uint64(index&(1<<histogramSubBits-1) + 1<<histogramSubBits)
+	upper := (mantissa+1)<<shift - 1
+	if upper > math.MaxInt64 {
+		return math.MaxInt64
+	}
+
+	return int64(upper)
+}
@@ -0,0 +1,98 @@
+package netlistener
+
+import (
+	"math"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestHistogramIndex(t *testing.T) {
+	tests := []struct {
+		name  string
+		value uint64
+	}{
+		{name: "Zero", value: 0},
+		{name: "Exact range", value: 15},
+		{name: "First log bucket", value: 16},
+		{name: "Millisecond in nanoseconds", value: uint64(time.Millisecond)},
+		{name: "Max int64", value: math.MaxInt64},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			index := histogramIndex(tt.value)
+			if index < 0 || index >= histogramBuckets {
+				t.Fatalf("index %d out of range", index)
+			}
+
+			upper := histogramUpperBound(index)
+			if uint64(upper) < tt.value {
+				t.Errorf("expected upper bound %d to include %d", upper, tt.value)
+			}
+			if float64(upper) > float64(tt.value)*1.125+1 {
+				t.Errorf("expected upper bound %d within 12.5%% of %d", upper, tt.value)
+			}
+		})
+	}
+}
+
+func TestHistogram_Percentiles(t *testing.T) {
+	h := &histogram{}
+	if percentiles := h.Percentiles(); percentiles != (Percentiles{}) {
+		t.Errorf("expected empty percentiles, got %+v", percentiles)
+	}
+
+	// 1..1000, so the percentiles are their rank within the error of the buckets
+	for value := int64(1); value <= 1000; value++ {
+		h.record(value)
+	}
+
+	percentiles := h.Percentiles()
+	tests := []struct {
+		name     string
+		got      int64
+		expected int64
+	}{
+		{name: "Count", got: percentiles.Count, expected: 1000},
+		{name: "P50", got: percentiles.P50, expected: 500},
+		{name: "P95", got: percentiles.P95, expected: 950},
+		{name: "P99", got: percentiles.P99, expected: 990},
+		{name: "Max", got: percentiles.Max, expected: 1000},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.got < tt.expected || float64(tt.got) > float64(tt.expected)*1.125 {
+				t.Errorf("expected %d within 12.5%%, got %d", tt.expected, tt.got)
+			}
+		})
+	}
+}
+
+func TestRateLimitedConnection_WaitTimes(t *testing.T) {
+	config := NewBandwithConfig(nil, ptr(20))
+
+	connRead, connWrite := net.Pipe()
+	conn := NewThrottledConnection(connWrite, NewConnectionBandwithConfig(config))
+	go readDataFromConn(connRead)
+
+	for range 2 {
+		if _, err := conn.Write(make([]byte, 20)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	conn.Close()
+
+	stats := config.Stats()
+	if stats.WaitTimes.Count != 2 {
+		t.Errorf("expected 2 waits, got %d", stats.WaitTimes.Count)
+	}
+	// the second write waits a second for the limiter
+	if stats.WaitTimes.Max < int64(500*time.Millisecond) {
+		t.Errorf("expected the longest wait to be about a second, got %v", time.Duration(stats.WaitTimes.Max))
+	}
+	if stats.ConnThroughput.Count != 1 || stats.ConnThroughput.P50 > 45 {
+		t.Errorf("expected one connection at about 40 bytes per second, got %+v", stats.ConnThroughput)
+	}
+}
@@ -0,0 +1,224 @@
+package netlistener
+
+import (
+	"fmt"
+	"net"
+	"sync"
+
+	"golang.org/x/time/rate"
+)
+
+// InterfaceLimits enforces global limits per network interface of a host with multiple NICs. Connections are associated
+// with an interface by their local address, and all connections of an interface share its limit, on top of the global limit,
+// no matter which listener accepted them. One InterfaceLimits is usually attached to the listeners of all interfaces,
+// see SetInterfaceLimits
+type InterfaceLimits struct {
+	// interfaces are the associated interfaces by name, addrs maps local IPs to their names
+	interfaces map[string]*interfaceLimiters
+	addrs      map[string]string
+	// updates are the notifiers of the attached configs, woken when a limit is raised
+	updates map[*LimitUpdates]struct{}
+	mu      sync.RWMutex
+}
+
+type interfaceLimiters struct {
+	readLimiter  *rate.Limiter
+	writeLimiter *rate.Limiter
+}
+
+func NewInterfaceLimits() *InterfaceLimits {
+	return &InterfaceLimits{
+		interfaces: make(map[string]*interfaceLimiters),
+		addrs:      make(map[string]string),
+		updates:    make(map[*LimitUpdates]struct{}),
+	}
+}
+
+// AddInterface associates the local addresses with the interface, connections accepted on them share its limit.
+// Without addresses the ones of the host interface with the name are used, e.g. "eth0". The interface is unlimited
+// until SetLimit is called
+func (m *InterfaceLimits) AddInterface(name string, addrs ...net.IP) error {
+	if len(addrs) == 0 {
+		var err error
+		if addrs, err = interfaceAddrs(name); err != nil {
+			return err
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, addr := range addrs {
+		if other, ok := m.addrs[addr.String()]; ok && other != name {
+			return fmt.Errorf("address %v is already associated with interface %q", addr, other)
+		}
+	}
+
+	if _, ok := m.interfaces[name]; !ok {
+		m.interfaces[name] = &interfaceLimiters{
+			readLimiter:  rate.NewLimiter(rate.Inf, 0),
+			writeLimiter: rate.NewLimiter(rate.Inf, 0),
+		}
+	}
+	for _, addr := range addrs {
+		m.addrs[addr.String()] = name
+	}
+
+	return nil
+}
+
+func interfaceAddrs(name string) ([]net.IP, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, fmt.Errorf("resolving interface %q: %w", name, err)
+	}
+
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, fmt.Errorf("resolving addresses of interface %q: %w", name, err)
+	}
+
+	ips := make([]net.IP, 0, len(addrs))
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok {
+			ips = append(ips, ipNet.IP)
+		}
+	}
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("interface %q has no addresses", name)
+	}
+
+	return ips, nil
+}
+
+// SetLimit limits the connections of the interface together in both directions, nil removes the limit.
+// The limiters are updated in place, so connections waiting for them see the change
+func (m *InterfaceLimits) SetLimit(name string, limit *int) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	limiters, ok := m.interfaces[name]
+	if !ok {
+		return fmt.Errorf("unknown interface %q", name)
+	}
+
+	raised := formatRateLimit(limit) > limiters.readLimiter.Limit()
+	updateLimiter(limiters.readLimiter, formatRateLimit(limit))
+	updateLimiter(limiters.writeLimiter, formatRateLimit(limit))
+
+	if raised {
+		for updates := range m.updates {
+			updates.Notify()
+		}
+	}
+
+	return nil
+}
+
+// Interface returns the name of the interface the local address is associated with, an empty string if there is none
+func (m *InterfaceLimits) Interface(addr net.Addr) string {
+	ip := addrIP(addr)
+	if ip == nil {
+		return ""
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.addrs[ip.String()]
+}
+
+// Limits returns the limits of the interfaces which have one
+func (m *InterfaceLimits) Limits() map[string]*int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var limits map[string]*int
+	for name, limiters := range m.interfaces {
+		if limiters.readLimiter.Limit() == rate.Inf {
+			continue
+		}
+
+		if limits == nil {
+			limits = make(map[string]*int)
+		}
+		limits[name] = limitToInt(limiters.readLimiter.Limit())
+	}
+
+	return limits
+}
+
+// limiter returns the limiter of the interface in the direction, nil if the interface is unknown or unlimited
+func (m *InterfaceLimits) limiter(name string, read bool) *rate.Limiter {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	limiters := m.interfaces[name]
+	if limiters == nil {
+		return nil
+	}
+
+	limiter := limiters.writeLimiter
+	if read {
+		limiter = limiters.readLimiter
+	}
+	if limiter.Limit() == rate.Inf {
+		return nil
+	}
+
+	return limiter
+}
+
+func (m *InterfaceLimits) attach(updates *LimitUpdates) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.updates[updates] = struct{}{}
+}
+
+func (m *InterfaceLimits) detach(updates *LimitUpdates) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.updates, updates)
+}
+
+// SetInterfaceLimits attaches the per interface limits, the same InterfaceLimits can be attached to several configs
+// so the listeners on all interfaces of the host are managed together. nil detaches them
+func (c *BandwidthConfig) SetInterfaceLimits(limits *InterfaceLimits) {
+	c.mu.Lock()
+	previous := c.interfaceLimits
+	c.interfaceLimits = limits
+	c.mu.Unlock()
+
+	if previous == limits {
+		return
+	}
+	if previous != nil {
+		previous.detach(&c.limitUpdates)
+	}
+	if limits != nil {
+		limits.attach(&c.limitUpdates)
+	}
+
+	// connections pick the limiters of the new interfaces up with their next wait
+	c.limitUpdates.Notify()
+}
+
+// InterfaceLimits returns the attached per interface limits, nil if there are none
+func (c *BandwidthConfig) InterfaceLimits() *InterfaceLimits {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.interfaceLimits
+}
+
+// interfaceLimiter returns the limiter of the interface the connection was accepted on, nil if it is not limited
+func (c *ThrottledConn) interfaceLimiter(read bool) *rate.Limiter {
+	limits := c.config.globalConfig.InterfaceLimits()
+	if limits == nil {
+		return nil
+	}
+
+	return limits.limiter(limits.Interface(c.Conn.LocalAddr()), read)
+}
@@ -0,0 +1,102 @@
+package netlistener
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+type localAddrConn struct {
+	net.Conn
+	localAddr net.Addr
+}
+
+func (c *localAddrConn) LocalAddr() net.Addr {
+	return c.localAddr
+}
+
+func TestInterfaceLimits_AddInterface(t *testing.T) {
+	limits := NewInterfaceLimits()
+	if err := limits.AddInterface("eth0", net.ParseIP("192.0.2.1"), net.ParseIP("2001:db8::1")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		addr     net.Addr
+		expected string
+	}{
+		{name: "IPv4 address of the interface", addr: &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 80}, expected: "eth0"},
+		{name: "IPv6 address of the interface", addr: &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 80}, expected: "eth0"},
+		{name: "Address of no interface", addr: &net.TCPAddr{IP: net.ParseIP("198.51.100.1"), Port: 80}},
+		{name: "Not IP based", addr: &net.UnixAddr{Name: "/tmp/sock", Net: "unix"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if name := limits.Interface(tt.addr); name != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, name)
+			}
+		})
+	}
+
+	if err := limits.AddInterface("eth1", net.ParseIP("192.0.2.1")); err == nil {
+		t.Error("expected an error associating an address with a second interface")
+	}
+	if err := limits.SetLimit("eth2", ptr(100)); err == nil {
+		t.Error("expected an error limiting an unknown interface")
+	}
+}
+
+func TestRateLimitedConnection_InterfaceLimit(t *testing.T) {
+	limits := NewInterfaceLimits()
+	if err := limits.AddInterface("eth0", net.ParseIP("192.0.2.1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := limits.AddInterface("eth1", net.ParseIP("198.51.100.1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := limits.SetLimit("eth0", ptr(20)); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		addr net.Addr
+		// throttled is whether the second write of 20 bytes has to wait for the eth0 limit of 20 bytes per second
+		throttled bool
+	}{
+		{name: "Connection on the limited interface is limited", addr: &net.TCPAddr{IP: net.ParseIP("192.0.2.1")}, throttled: true},
+		{name: "Connection on another interface is not", addr: &net.TCPAddr{IP: net.ParseIP("198.51.100.1")}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// every listener has its own config, the interface limit is shared by all of them
+			config := NewBandwithConfig(nil, nil)
+			config.SetInterfaceLimits(limits)
+
+			connRead, connWrite := net.Pipe()
+			conn := NewThrottledConnection(&localAddrConn{Conn: connWrite, localAddr: tt.addr}, NewConnectionBandwithConfig(config))
+			defer conn.Close()
+			go readDataFromConn(connRead)
+
+			start := time.Now()
+			for range 2 {
+				if _, err := conn.Write(make([]byte, 20)); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if throttled := time.Since(start) > 500*time.Millisecond; throttled != tt.throttled {
+				t.Errorf("expected throttled %t, took %v", tt.throttled, time.Since(start))
+			}
+		})
+	}
+
+	config := NewBandwithConfig(nil, nil)
+	config.SetInterfaceLimits(limits)
+	if snapshot := config.Snapshot(); len(snapshot.InterfaceLimits) != 1 || *snapshot.InterfaceLimits["eth0"] != 20 {
+		t.Errorf("expected the eth0 limit in the snapshot, got %v", snapshot.InterfaceLimits)
+	}
+}
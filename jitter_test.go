@@ -0,0 +1,57 @@
+package netlistener
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestRateLimitedConnection_WaitJitter(t *testing.T) {
+	tests := []struct {
+		name          string
+		writes        int
+		assertionFunc func(t *testing.T, elapsed time.Duration)
+	}{
+		{
+			name:   "Unthrottled writes are not delayed",
+			writes: 1,
+			assertionFunc: func(t *testing.T, elapsed time.Duration) {
+				if elapsed > 50*time.Millisecond {
+					t.Errorf("expected no jitter for an unthrottled write, took %s", elapsed)
+				}
+			},
+		},
+		{
+			name:   "Throttled writes are delayed by at most the jitter",
+			writes: 2,
+			assertionFunc: func(t *testing.T, elapsed time.Duration) {
+				if elapsed < 900*time.Millisecond || elapsed > 1400*time.Millisecond {
+					t.Errorf("expected the throttled write to take between 1s and 1.3s, took %s", elapsed)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			config := NewBandwithConfig(nil, ptr(50))
+			config.SetWaitJitter(300 * time.Millisecond)
+
+			connRead, connWrite := net.Pipe()
+			conn := NewThrottledConnection(connWrite, NewConnectionBandwithConfig(config))
+			defer conn.Close()
+			go readDataFromConn(connRead)
+
+			start := time.Now()
+			for i := 0; i < tt.writes; i++ {
+				if _, err := conn.Write(make([]byte, 50)); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			tt.assertionFunc(t, time.Since(start))
+		})
+	}
+}
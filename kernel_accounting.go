@@ -0,0 +1,53 @@
+package netlistener
+
+import (
+	"errors"
+	"net"
+	"syscall"
+)
+
+// ErrKernelAccountingUnsupported is returned on platforms or connections without kernel byte counters
+var ErrKernelAccountingUnsupported = errors.New("kernel accounting is not supported for this connection")
+
+// KernelCounters are the bytes the kernel has seen on the socket, including bytes which bypassed the wrapper,
+// e.g. when the application used splice or sendfile on the underlying connection
+type KernelCounters struct {
+	BytesReceived int64
+	// BytesSent counts bytes acknowledged by the peer, bytes still in flight are not included
+	BytesSent int64
+}
+
+// AccountingReport compares userspace accounting of a connection to the kernel counters
+type AccountingReport struct {
+	BytesRead    int64
+	BytesWritten int64
+	Kernel       KernelCounters
+}
+
+// UnaccountedRead is the number of bytes the kernel received which were not read through the wrapper.
+// It includes bytes still waiting in the receive buffer
+func (r AccountingReport) UnaccountedRead() int64 {
+	return max(r.Kernel.BytesReceived-r.BytesRead, 0)
+}
+
+// UnaccountedWritten is the number of bytes the peer acknowledged which were not written through the wrapper
+func (r AccountingReport) UnaccountedWritten() int64 {
+	return max(r.Kernel.BytesSent-r.BytesWritten, 0)
+}
+
+// syscallConn finds the raw socket under the connection, unwrapping other wrappers if needed
+func syscallConn(conn net.Conn) (syscall.RawConn, error) {
+	for conn != nil {
+		if sc, ok := conn.(syscall.Conn); ok {
+			return sc.SyscallConn()
+		}
+
+		unwrapper, ok := conn.(interface{ NetConn() net.Conn })
+		if !ok {
+			break
+		}
+		conn = unwrapper.NetConn()
+	}
+
+	return nil, ErrKernelAccountingUnsupported
+}
@@ -0,0 +1,119 @@
+package netlistener
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestRateLimitedConnection_Reconcile(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Failed to create listener", err)
+	}
+	defer listener.Close()
+
+	throttledListener, _ := NewListener(listener, nil, nil)
+	throttledListener.SetKernelAccounting(true)
+
+	client, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	conn, err := throttledListener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	throttledConn := conn.(*ThrottledConn)
+
+	client.Write(make([]byte, 1000))
+
+	if _, err := io.ReadFull(throttledConn, make([]byte, 400)); err != nil {
+		t.Fatal(err)
+	}
+	// reading from the underlying connection bypasses the wrapper
+	if _, err := io.ReadFull(throttledConn.NetConn(), make([]byte, 600)); err != nil {
+		t.Fatal(err)
+	}
+
+	throttledConn.NetConn().Write(make([]byte, 500))
+	if _, err := io.ReadFull(client, make([]byte, 500)); err != nil {
+		t.Fatal(err)
+	}
+	// give the kernel a moment to process the acknowledgement
+	time.Sleep(50 * time.Millisecond)
+
+	report, err := throttledConn.Reconcile()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.UnaccountedRead() != 600 {
+		t.Errorf("expected 600 unaccounted bytes read, got %d", report.UnaccountedRead())
+	}
+	if report.UnaccountedWritten() != 500 {
+		t.Errorf("expected 500 unaccounted bytes written, got %d", report.UnaccountedWritten())
+	}
+
+	throttledConn.Close()
+
+	stats := throttledListener.Stats()
+	if stats.UnaccountedBytesRead != 600 || stats.UnaccountedBytesWritten != 500 {
+		t.Errorf("expected unaccounted bytes in stats, got %+v", stats)
+	}
+}
+
+func TestRateLimitedConnection_Reconcile_Unsupported(t *testing.T) {
+	connRead, connWrite := net.Pipe()
+	defer connRead.Close()
+
+	throttledConn := NewThrottledConnection(connWrite, NewConnectionBandwithConfig(NewBandwithConfig(nil, nil)))
+	if _, err := throttledConn.Reconcile(); err != ErrKernelAccountingUnsupported {
+		t.Errorf("expected ErrKernelAccountingUnsupported, got %v", err)
+	}
+}
+
+func TestSocketQueueOf(t *testing.T) {
+	client, server := tcpPair(t)
+	defer client.Close()
+	defer server.Close()
+
+	go io.Copy(io.Discard, client)
+	if _, err := server.Write(make([]byte, 100000)); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	queue, err := socketQueueOf(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if queue.DeliveryRate == 0 || queue.MinRTT <= 0 {
+		t.Errorf("expected the kernel to estimate the delivery rate and RTT, got %+v", queue)
+	}
+}
+
+func TestRateLimitedConnection_ConnInfo(t *testing.T) {
+	client, server := tcpPair(t)
+	defer client.Close()
+
+	conn := NewThrottledConnection(server, NewConnectionBandwithConfig(NewBandwithConfig(nil, ptr(1000))))
+	defer conn.Close()
+
+	go io.Copy(io.Discard, client)
+	if _, err := conn.Write(make([]byte, 500)); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	info := conn.ConnInfo()
+	if info.BytesWritten != 500 || info.WriteLimit == nil || *info.WriteLimit != 1000 {
+		t.Errorf("expected the counters and limits of the wrapper, got %+v", info)
+	}
+	if info.TCP == nil || info.TCP.RTT <= 0 || info.TCP.SndCwnd == 0 {
+		t.Errorf("expected the TCP statistics of the socket, got %+v", info.TCP)
+	}
+}
@@ -0,0 +1,171 @@
+package netlistener
+
+import (
+	"net"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// ConnState is a stage in the lifetime of a throttled connection
+type ConnState int32
+
+const (
+	// ConnAccepted is the state of a connection which was wrapped but not classified
+	ConnAccepted ConnState = iota
+	// ConnClassified is the state of a connection the classifier assigned a classification, before it transferred any data
+	ConnClassified
+	// ConnActive is the state of a connection which transferred data and is not waiting for the limiters
+	ConnActive
+	// ConnThrottled is the state of a connection with an operation waiting for the limiters
+	ConnThrottled
+	// ConnDraining is the state of a connection which is being closed
+	ConnDraining
+	ConnClosed
+
+	connStates
+)
+
+func (s ConnState) String() string {
+	switch s {
+	case ConnAccepted:
+		return "accepted"
+	case ConnClassified:
+		return "classified"
+	case ConnActive:
+		return "active"
+	case ConnThrottled:
+		return "throttled"
+	case ConnDraining:
+		return "draining"
+	case ConnClosed:
+		return "closed"
+	}
+
+	return "unknown"
+}
+
+// ConnLifecycle is the state of a connection with the times it entered the states it went through
+type ConnLifecycle struct {
+	RemoteAddr string `json:"remote_addr"`
+	State      string `json:"state"`
+	// Since is when the connection entered its current state
+	Since time.Time `json:"since"`
+	// Entered holds the last time the connection entered each state it went through, by state name
+	Entered map[string]time.Time `json:"entered"`
+}
+
+// connLifecycle tracks the state of a connection lock free, it is updated from the hot path of reads and writes
+type connLifecycle struct {
+	state atomic.Int32
+	// entered is when each state was last entered in unix nanoseconds, zero if it never was
+	entered [connStates]atomic.Int64
+}
+
+// transition moves to the state, reporting whether the state changed. States only move forward,
+// except a throttled connection becoming active again, so a closing connection does not look active
+func (l *connLifecycle) transition(to ConnState, now time.Time) bool {
+	for {
+		from := ConnState(l.state.Load())
+		if from == to || to < from && (from != ConnThrottled || to != ConnActive) {
+			return false
+		}
+
+		if l.state.CompareAndSwap(int32(from), int32(to)) {
+			l.entered[to].Store(now.UnixNano())
+			return true
+		}
+	}
+}
+
+func (l *connLifecycle) Load() ConnState {
+	return ConnState(l.state.Load())
+}
+
+// setState moves the connection to the state. Transitions other than the ones between active and throttled,
+// which happen on every throttled operation, are emitted as EventConnStateChanged
+func (c *ThrottledConn) setState(state ConnState) {
+	now := time.Now()
+	if !c.lifecycle.transition(state, now) {
+		return
+	}
+	c.traceState(state)
+
+	if state != ConnActive && state != ConnThrottled {
+		c.config.globalConfig.emit(Event{
+			Type:      EventConnStateChanged,
+			Time:      now,
+			Peer:      peerKey(c.Conn),
+			Details:   state.String(),
+			ConnState: state.String(),
+		})
+	}
+}
+
+// markActive moves the connection to the active state after it transferred data, skipping the atomic write when it is already active
+func (c *ThrottledConn) markActive() {
+	if c.lifecycle.Load() != ConnActive {
+		c.setState(ConnActive)
+	}
+}
+
+// markThrottled moves the connection to the throttled state if any of the limiters does not have the tokens for n bytes right away
+func (c *ThrottledConn) markThrottled(limiters []*rate.Limiter, n int) {
+	now := time.Now()
+	for _, limiter := range limiters {
+		if limiter.Limit() != rate.Inf && limiter.TokensAt(now) < float64(n) {
+			c.setState(ConnThrottled)
+			return
+		}
+	}
+}
+
+// Lifecycle returns the state of the connection and when it entered the states it went through
+func (c *ThrottledConn) Lifecycle() ConnLifecycle {
+	state := c.lifecycle.Load()
+	lifecycle := ConnLifecycle{
+		State:   state.String(),
+		Entered: make(map[string]time.Time),
+	}
+	if addr := c.RemoteAddr(); addr != nil {
+		lifecycle.RemoteAddr = addr.String()
+	}
+
+	for s := range connStates {
+		if entered := c.lifecycle.entered[s].Load(); entered != 0 {
+			lifecycle.Entered[s.String()] = time.Unix(0, entered)
+		}
+	}
+	lifecycle.Since = lifecycle.Entered[state.String()]
+
+	return lifecycle
+}
+
+// ConnLifecycleOf returns the lifecycle of a throttled connection, ErrNotThrottled for other connections
+func ConnLifecycleOf(conn net.Conn) (ConnLifecycle, error) {
+	throttled, ok := conn.(*ThrottledConn)
+	if !ok {
+		return ConnLifecycle{}, ErrNotThrottled
+	}
+
+	return throttled.Lifecycle(), nil
+}
+
+// ConnLifecycles returns the lifecycles of the open connections, the ones in their current state the longest first,
+// so connections stuck in a state come up on top
+func (c *BandwidthConfig) ConnLifecycles() []ConnLifecycle {
+	conns := c.conns.all()
+
+	lifecycles := make([]ConnLifecycle, 0, len(conns))
+	for _, conn := range conns {
+		lifecycles = append(lifecycles, conn.Lifecycle())
+	}
+
+	sort.Slice(lifecycles, func(i, j int) bool {
+		return lifecycles[i].Since.Before(lifecycles[j].Since)
+	})
+
+	return lifecycles
+}
@@ -0,0 +1,101 @@
+package netlistener
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestConnLifecycle_Transition(t *testing.T) {
+	tests := []struct {
+		name     string
+		from     ConnState
+		to       ConnState
+		expected ConnState
+	}{
+		{name: "Forward", from: ConnAccepted, to: ConnClassified, expected: ConnClassified},
+		{name: "Throttled becomes active again", from: ConnThrottled, to: ConnActive, expected: ConnActive},
+		{name: "Active connection is not classified again", from: ConnActive, to: ConnClassified, expected: ConnActive},
+		{name: "Draining connection does not become active", from: ConnDraining, to: ConnActive, expected: ConnDraining},
+		{name: "Closed is final", from: ConnClosed, to: ConnThrottled, expected: ConnClosed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lifecycle := &connLifecycle{}
+			lifecycle.state.Store(int32(tt.from))
+
+			lifecycle.transition(tt.to, time.Now())
+			if state := lifecycle.Load(); state != tt.expected {
+				t.Errorf("expected %v, got %v", tt.expected, state)
+			}
+		})
+	}
+}
+
+func TestRateLimitedConnection_Lifecycle(t *testing.T) {
+	config := NewBandwithConfig(nil, ptr(20))
+	if err := config.SetClasses([]ClassConfig{{Name: "video"}}, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	var states []string
+	config.SetEventHandler(func(event Event) {
+		if event.Type == EventConnStateChanged {
+			mu.Lock()
+			states = append(states, event.ConnState)
+			mu.Unlock()
+		}
+	})
+
+	connRead, connWrite := net.Pipe()
+	connConfig := NewConnectionBandwithConfig(config)
+	connConfig.SetClassification(Classification{Class: "video"})
+	conn := NewThrottledConnection(connWrite, connConfig)
+	go readDataFromConn(connRead)
+
+	if lifecycle := conn.Lifecycle(); lifecycle.State != "classified" {
+		t.Errorf("expected classified connection, got %+v", lifecycle)
+	}
+
+	if _, err := conn.Write(make([]byte, 20)); err != nil {
+		t.Fatal(err)
+	}
+	if lifecycle := conn.Lifecycle(); lifecycle.State != "active" {
+		t.Errorf("expected active connection, got %+v", lifecycle)
+	}
+
+	// the second write has to wait for the per connection limit
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		conn.Write(make([]byte, 20))
+	}()
+	time.Sleep(200 * time.Millisecond)
+
+	lifecycles := config.ConnLifecycles()
+	if len(lifecycles) != 1 || lifecycles[0].State != "throttled" {
+		t.Errorf("expected the connection to be throttled, got %+v", lifecycles)
+	}
+	<-done
+
+	conn.Close()
+	lifecycle, err := ConnLifecycleOf(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, state := range []string{"accepted", "classified", "active", "throttled", "draining", "closed"} {
+		if lifecycle.Entered[state].IsZero() {
+			t.Errorf("expected the connection to have entered %s, got %+v", state, lifecycle.Entered)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	expected := []string{"classified", "draining", "closed"}
+	if len(states) != len(expected) || states[0] != expected[0] || states[1] != expected[1] || states[2] != expected[2] {
+		t.Errorf("expected state events %v, got %v", expected, states)
+	}
+}
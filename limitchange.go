@@ -0,0 +1,105 @@
+package netlistener
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// defaultLimitChangeInterval is the minimum time between the limit change requests of a connection
+const defaultLimitChangeInterval = time.Second
+
+var (
+	// ErrLimitChangeDenied is returned by RequestLimitChange when no approver is set or the approver denied the request,
+	// the error of the approver is wrapped as well
+	ErrLimitChangeDenied = errors.New("limit change denied")
+	// ErrLimitChangeTooFrequent is returned by RequestLimitChange when the connection requested a change too recently
+	ErrLimitChangeTooFrequent = errors.New("limit change requested too frequently")
+)
+
+// LimitChangeRequest is a request of the application to change the per connection limit of a connection mid-stream,
+// e.g. after the client logged in to an account with a higher tier
+type LimitChangeRequest struct {
+	Conn *ThrottledConn
+	// Current is the per connection limit override of the connection, nil if it has none
+	Current *int
+	// Requested is the limit asked for in bytes per second, nil asks for no override
+	Requested      *int
+	Classification Classification
+}
+
+// LimitChangeApprover decides on limit change requests. It returns the granted limit, which may be lower than the requested one,
+// or an error denying the request
+type LimitChangeApprover func(request LimitChangeRequest) (granted *int, err error)
+
+// SetLimitChangeApprover sets the policy for RequestLimitChange, a connection may request a change once per interval,
+// zero defaults to a second. Without an approver all requests are denied
+func (c *BandwidthConfig) SetLimitChangeApprover(approver LimitChangeApprover, interval time.Duration) {
+	if interval <= 0 {
+		interval = defaultLimitChangeInterval
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.limitChangeApprover = approver
+	c.limitChangeInterval = interval
+}
+
+func (c *BandwidthConfig) limitChangePolicy() (LimitChangeApprover, time.Duration) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.limitChangeApprover, c.limitChangeInterval
+}
+
+// RequestLimitChange asks the approver of the listener to change the per connection limit of the connection, returning the granted limit.
+// The granted limit replaces the override of the classification, like a classifier setting PerConnLimit, and applies from the next operation
+func (c *ThrottledConn) RequestLimitChange(limit *int) (*int, error) {
+	if limit != nil && *limit < 0 {
+		return nil, fmt.Errorf("negative limit %d", *limit)
+	}
+
+	approver, interval := c.config.globalConfig.limitChangePolicy()
+	if approver == nil {
+		return nil, ErrLimitChangeDenied
+	}
+
+	// the slot is taken before asking the approver, so concurrent requests of the connection do not pass together
+	now := time.Now().UnixNano()
+	last := c.lastLimitChange.Load()
+	if last != 0 && now-last < int64(interval) || !c.lastLimitChange.CompareAndSwap(last, now) {
+		return nil, ErrLimitChangeTooFrequent
+	}
+
+	classification := c.config.Classification()
+	granted, err := approver(LimitChangeRequest{
+		Conn:           c,
+		Current:        classification.PerConnLimit,
+		Requested:      limit,
+		Classification: classification,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrLimitChangeDenied, err)
+	}
+	if granted != nil {
+		granted = Limit(*granted)
+	}
+
+	classification = c.config.Classification()
+	classification.PerConnLimit = granted
+	c.config.SetClassification(classification)
+
+	return granted, nil
+}
+
+// RequestLimitChange is the method of a throttled connection, ErrNotThrottled for other connections
+func RequestLimitChange(conn net.Conn, limit *int) (*int, error) {
+	throttled, ok := conn.(*ThrottledConn)
+	if !ok {
+		return nil, ErrNotThrottled
+	}
+
+	return throttled.RequestLimitChange(limit)
+}
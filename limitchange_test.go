@@ -0,0 +1,79 @@
+package netlistener
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestRateLimitedConnection_RequestLimitChange(t *testing.T) {
+	errTier := errors.New("account tier too low")
+	capAt := func(maximum int) LimitChangeApprover {
+		return func(request LimitChangeRequest) (*int, error) {
+			if request.Requested == nil || *request.Requested > maximum {
+				return Limit(maximum), nil
+			}
+			return request.Requested, nil
+		}
+	}
+
+	tests := []struct {
+		name     string
+		approver LimitChangeApprover
+		requests []*int
+		// expected is the per connection limit after the requests, wantErr the error of the last request
+		expected *int
+		wantErr  error
+	}{
+		{name: "No approver", requests: []*int{Limit(1000)}, wantErr: ErrLimitChangeDenied},
+		{name: "Granted", approver: capAt(5000), requests: []*int{Limit(1000)}, expected: Limit(1000)},
+		{name: "Granted lower than requested", approver: capAt(500), requests: []*int{Limit(1000)}, expected: Limit(500)},
+		{name: "Denied by the approver", approver: func(request LimitChangeRequest) (*int, error) {
+			return nil, errTier
+		}, requests: []*int{Limit(1000)}, wantErr: errTier},
+		{name: "Too frequent", approver: capAt(5000), requests: []*int{Limit(1000), Limit(2000)}, expected: Limit(1000), wantErr: ErrLimitChangeTooFrequent},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := NewBandwithConfig(nil, ptr(100))
+			config.SetLimitChangeApprover(tt.approver, time.Minute)
+
+			connRead, connWrite := net.Pipe()
+			conn := NewThrottledConnection(connWrite, NewConnectionBandwithConfig(config))
+			go readDataFromConn(connRead)
+			defer conn.Close()
+
+			var err error
+			for _, limit := range tt.requests {
+				_, err = conn.RequestLimitChange(limit)
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+			}
+
+			// the limit applies from the next operation
+			if _, err := conn.Write(make([]byte, 10)); err != nil {
+				t.Fatal(err)
+			}
+			expected := ptr(100)
+			if tt.expected != nil {
+				expected = tt.expected
+			}
+			if limit := conn.ConnInfo().WriteLimit; limit == nil || *limit != *expected {
+				t.Errorf("expected the limit %d, got %v", *expected, limit)
+			}
+		})
+	}
+}
+
+func TestRequestLimitChange_NotThrottled(t *testing.T) {
+	connRead, connWrite := net.Pipe()
+	defer connRead.Close()
+	defer connWrite.Close()
+
+	if _, err := RequestLimitChange(connWrite, Limit(1000)); !errors.Is(err, ErrNotThrottled) {
+		t.Errorf("expected ErrNotThrottled, got %v", err)
+	}
+}
@@ -0,0 +1,95 @@
+package netlistener
+
+import "golang.org/x/time/rate"
+
+// LimitReason tells what decided the effective per connection limit of a connection
+type LimitReason int
+
+const (
+	// LimitReasonConfigured is the per connection limit of the config
+	LimitReasonConfigured LimitReason = iota
+	// LimitReasonEvenSplit is the share of the global limit of the connection in even split mode
+	LimitReasonEvenSplit
+	// LimitReasonView is the default per connection limit of the listener view the connection was wrapped by
+	LimitReasonView
+	// LimitReasonClassification is the per connection limit of the classification, e.g. granted by RequestLimitChange
+	LimitReasonClassification
+	// LimitReasonProfile and LimitReasonClass are the limits of the profile and the class of the connection
+	LimitReasonProfile
+	LimitReasonClass
+	// LimitReasonFairShare is the share of the global limit of the connection in work conserving mode
+	LimitReasonFairShare
+	// LimitReasonPenalty is the reduced limit of a peer in the penalty box
+	LimitReasonPenalty
+	// LimitReasonBoost is the lifted limit of a connection at its start, see Boost
+	LimitReasonBoost
+)
+
+func (r LimitReason) String() string {
+	switch r {
+	case LimitReasonConfigured:
+		return "configured"
+	case LimitReasonEvenSplit:
+		return "even_split"
+	case LimitReasonView:
+		return "view"
+	case LimitReasonClassification:
+		return "classification"
+	case LimitReasonProfile:
+		return "profile"
+	case LimitReasonClass:
+		return "class"
+	case LimitReasonFairShare:
+		return "fair_share"
+	case LimitReasonPenalty:
+		return "penalty"
+	case LimitReasonBoost:
+		return "boost"
+	}
+
+	return "unknown"
+}
+
+// LimitHint describes a change of the effective per connection limit of a connection,
+// so the application can tell the client in its protocol, e.g. with HTTP 429 headers or FTP messages
+type LimitHint struct {
+	Conn *ThrottledConn
+	// Read tells whether the limit of reads or of writes changed
+	Read bool
+	// Previous and Current are the limits in bytes per second, nil means unlimited
+	Previous *int
+	Current  *int
+	// Reason is what decided the current limit
+	Reason LimitReason
+}
+
+// LimitHintHandler receives the changes of the effective per connection limits synchronously from the operation
+// which noticed them, before it waits for the new limit. It should not block or transfer on the connection itself,
+// e.g. queue the hint for the protocol handler to send instead
+type LimitHintHandler func(hint LimitHint)
+
+// SetLimitHintHandler sets the handler of changes of the effective per connection limits, nil removes it.
+// Changes are noticed by the next operation in the direction, the first one also reports the limit of a connection
+// whose profile, class or classification replaces the configured one. In work conserving mode the fair share changes
+// whenever connections become active or idle, so a handler should rate limit the hints it passes on
+func (c *BandwidthConfig) SetLimitHintHandler(handler LimitHintHandler) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.limitHintHandler = handler
+}
+
+// hintLimit passes a change of the effective per connection limit to the handler, if there is one
+func (c *ThrottledConn) hintLimit(read bool, previous, current rate.Limit, reason LimitReason) {
+	config := c.config.globalConfig
+	config.mu.RLock()
+	handler := config.limitHintHandler
+	config.mu.RUnlock()
+
+	if handler == nil {
+		return
+	}
+
+	defer config.recoverPanic("limit hint handler")
+	handler(LimitHint{Conn: c, Read: read, Previous: limitToInt(previous), Current: limitToInt(current), Reason: reason})
+}
@@ -0,0 +1,84 @@
+package netlistener
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestRateLimitedConnection_LimitHints(t *testing.T) {
+	tests := []struct {
+		name string
+		// change changes the effective limit of the connection after its first write
+		change   func(config *BandwidthConfig, conn *ThrottledConn)
+		expected *LimitHint
+	}{
+		{name: "Unchanged", change: func(config *BandwidthConfig, conn *ThrottledConn) {}},
+		{name: "Configured limit lowered", change: func(config *BandwidthConfig, conn *ThrottledConn) {
+			config.SetPerConnLimit(ptr(50))
+		}, expected: &LimitHint{Previous: ptr(100), Current: ptr(50), Reason: LimitReasonConfigured}},
+		{name: "Configured limit removed", change: func(config *BandwidthConfig, conn *ThrottledConn) {
+			config.SetPerConnLimit(NoLimit())
+		}, expected: &LimitHint{Previous: ptr(100), Reason: LimitReasonConfigured}},
+		{name: "Granted by the approver", change: func(config *BandwidthConfig, conn *ThrottledConn) {
+			config.SetLimitChangeApprover(func(request LimitChangeRequest) (*int, error) {
+				return request.Requested, nil
+			}, time.Minute)
+			if _, err := conn.RequestLimitChange(ptr(1000)); err != nil {
+				t.Fatal(err)
+			}
+		}, expected: &LimitHint{Previous: ptr(100), Current: ptr(1000), Reason: LimitReasonClassification}},
+		{name: "Class limit", change: func(config *BandwidthConfig, conn *ThrottledConn) {
+			if err := config.SetClasses([]ClassConfig{{Name: "bulk", Rate: 1000, PerConnLimit: ptr(20)}}, ""); err != nil {
+				t.Fatal(err)
+			}
+			conn.reclassify(Classification{Class: "bulk"})
+		}, expected: &LimitHint{Previous: ptr(100), Current: ptr(20), Reason: LimitReasonClass}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := NewBandwithConfig(nil, ptr(100))
+
+			var hints []LimitHint
+			config.SetLimitHintHandler(func(hint LimitHint) {
+				hints = append(hints, hint)
+			})
+
+			connRead, connWrite := net.Pipe()
+			conn := NewThrottledConnection(connWrite, NewConnectionBandwithConfig(config))
+			defer conn.Close()
+			go readDataFromConn(connRead)
+
+			if _, err := conn.Write(make([]byte, 10)); err != nil {
+				t.Fatal(err)
+			}
+			tt.change(config, conn)
+			if _, err := conn.Write(make([]byte, 10)); err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.expected == nil {
+				if len(hints) != 0 {
+					t.Errorf("expected no hints, got %+v", hints)
+				}
+				return
+			}
+			if len(hints) != 1 {
+				t.Fatalf("expected a hint, got %+v", hints)
+			}
+
+			hint := hints[0]
+			if hint.Conn != conn || hint.Read || hint.Reason != tt.expected.Reason {
+				t.Errorf("expected a write hint of the connection for %v, got %+v", tt.expected.Reason, hint)
+			}
+			if !equalLimits(hint.Previous, tt.expected.Previous) || !equalLimits(hint.Current, tt.expected.Current) {
+				t.Errorf("expected the limit to change from %v to %v, got %v to %v", tt.expected.Previous, tt.expected.Current, hint.Previous, hint.Current)
+			}
+		})
+	}
+}
+
+func equalLimits(a, b *int) bool {
+	return a == nil && b == nil || a != nil && b != nil && *a == *b
+}
@@ -1,21 +1,42 @@
 package netlistener
 
 import (
+	"context"
+	"errors"
 	"net"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type (
 	Listener struct {
 		net.Listener
-		config *bandwithConfig
+		config *BandwidthConfig
+
+		// pool processes accepted connections in the background, nil processes them in Accept
+		pool   *acceptPool
+		poolMu sync.Mutex
+		// defaults are applied to the connections of a view created by WithOptions, nil for the listener itself
+		defaults *connDefaults
+		// serveConcurrency is the number of handlers Serve runs at once, zero is unlimited
+		serveConcurrency atomic.Int64
 	}
 )
 
-func NewListener(l net.Listener, globalLimit *int, perConnLimit *int) (*Listener, error) {
-	return &Listener{
+func NewListener(l net.Listener, globalLimit *int, perConnLimit *int, opts ...Option) (*Listener, error) {
+	listener := &Listener{
 		Listener: l,
-		config:   NewBandwithConfig(globalLimit, perConnLimit),
-	}, nil
+		config:   NewBandwidthConfig(globalLimit, perConnLimit),
+	}
+
+	for _, opt := range opts {
+		if err := opt(listener); err != nil {
+			return nil, err
+		}
+	}
+
+	return listener, nil
 }
 
 func (l *Listener) SetLimits(globalLimit int, perConnLimit int) {
@@ -23,14 +44,587 @@ func (l *Listener) SetLimits(globalLimit int, perConnLimit int) {
 	l.config.SetPerConnLimit(&perConnLimit)
 }
 
+// Config returns the limits, classes, exemptions and connection caps of the listener as a ListenerConfig
+func (l *Listener) Config() ListenerConfig {
+	return l.config.Config()
+}
+
+// Apply changes the limits, classes, exemptions and connection caps to the given configuration at once, reporting what changed.
+// If any of the changes fails, the listener keeps its previous configuration
+func (l *Listener) Apply(config ListenerConfig) (ChangeReport, error) {
+	return l.config.Apply(config)
+}
+
+// SubscribeConfig calls the subscriber after every change made by Apply, e.g. through the admin handler,
+// with the configuration before and after the change. The returned function removes the subscriber
+func (l *Listener) SubscribeConfig(subscriber ConfigSubscriber) (unsubscribe func()) {
+	return l.config.SubscribeConfig(subscriber)
+}
+
+// VersionedConfig returns the configuration of the listener with its version, to be passed to ApplyVersion
+func (l *Listener) VersionedConfig() (ListenerConfig, uint64) {
+	return l.config.VersionedConfig()
+}
+
+// ApplyVersion is Apply failing with ErrConfigVersionConflict if the configuration changed since the version was read,
+// e.g. by another operator
+func (l *Listener) ApplyVersion(config ListenerConfig, expectedVersion uint64) (ChangeReport, error) {
+	return l.config.ApplyVersion(config, expectedVersion)
+}
+
+// RegisterProfile adds a custom profile, which classifiers and the default profile can select by name.
+// Registering a profile named like a built-in preset ("dialup", "3g", "lte", "100mbit-shared") replaces the preset
+func (l *Listener) RegisterProfile(profile Profile) error {
+	return l.config.RegisterProfile(profile)
+}
+
+// SetDefaultProfile shapes the connections which were not assigned a profile by the classifier with the named profile
+func (l *Listener) SetDefaultProfile(name string) error {
+	return l.config.SetDefaultProfile(name)
+}
+
+// Profiles returns the built-in and registered profiles
+func (l *Listener) Profiles() []Profile {
+	return l.config.Profiles()
+}
+
+// LimitUpdates notifies when the limits of the listener are raised, so custom connection wrappers waiting with WaitNUpdatable
+// on the limiters of the listener pick up the new limits right away
+func (l *Listener) LimitUpdates() *LimitUpdates {
+	return l.config.LimitUpdates()
+}
+
+// SetFamilyLimit limits the IPv4 or IPv6 connections together, in addition to the global limit,
+// e.g. when the traffic of the families is billed differently. nil removes the limit of the family
+func (l *Listener) SetFamilyLimit(family AddressFamily, limit *int) {
+	l.config.SetFamilyLimit(family, limit)
+}
+
+// SetExemptCIDRs sets the networks whose connections bypass all limiters, e.g. health checkers or internal replication peers
+func (l *Listener) SetExemptCIDRs(cidrs ...string) error {
+	return l.config.SetExemptCIDRs(cidrs...)
+}
+
+// SetLocalExemption exempts loopback and/or private peers from all limiters, a classification with Throttle set overrides it
+func (l *Listener) SetLocalExemption(local LocalExemption) {
+	l.config.SetLocalExemption(local)
+}
+
+// SetExemptFunc sets a predicate for connections that should bypass all limiters
+func (l *Listener) SetExemptFunc(predicate func(conn net.Conn) bool) {
+	l.config.SetExemptFunc(predicate)
+}
+
+// SetHealthCheckDetection excludes connections closed within maxDuration having transferred less than maxBytes from stats
+func (l *Listener) SetHealthCheckDetection(maxDuration time.Duration, maxBytes int64) {
+	l.config.SetHealthCheckDetection(maxDuration, maxBytes)
+}
+
+// SetPenaltyPolicy enables the penalty box, peers repeatedly hitting limits or tripping connection caps get a reduced limit for a cooldown period
+func (l *Listener) SetPenaltyPolicy(policy *PenaltyPolicy) {
+	l.config.SetPenaltyPolicy(policy)
+}
+
+// SetPeerQuota caps the bytes each peer may transfer, see BandwidthConfig.SetPeerQuota
+func (l *Listener) SetPeerQuota(quota *PeerQuota) error {
+	return l.config.SetPeerQuota(quota)
+}
+
+// LoadClassifierPlugin loads a classifier from a Go plugin, see BandwidthConfig.LoadClassifierPlugin
+func (l *Listener) LoadClassifierPlugin(path string) (Classifier, error) {
+	return l.config.LoadClassifierPlugin(path)
+}
+
+// SetEventHandler sets the handler receiving events about connections and peers
+func (l *Listener) SetEventHandler(handler EventHandler) {
+	l.config.SetEventHandler(handler)
+}
+
+// SetErrorHandler sets the handler receiving the errors and recovered panics of background work of the listener,
+// e.g. failed reverse DNS lookups or a classifier panicking in the accept pool
+func (l *Listener) SetErrorHandler(handler ErrorHandler) {
+	l.config.SetErrorHandler(handler)
+}
+
+// SetLimitHintHandler sets the handler told about changes of the effective per connection limits with their reason,
+// so the application can pass them on to the clients in its protocol
+func (l *Listener) SetLimitHintHandler(handler LimitHintHandler) {
+	l.config.SetLimitHintHandler(handler)
+}
+
+// SetLimitChangeApprover sets the policy deciding on RequestLimitChange of the connections, which may request a change once per interval
+func (l *Listener) SetLimitChangeApprover(approver LimitChangeApprover, interval time.Duration) {
+	l.config.SetLimitChangeApprover(approver, interval)
+}
+
+// SetConnTracer traces the accept, classification, first byte, throttled waits and close of every connection,
+// e.g. with golang.org/x/net/trace or a TraceRecorder
+func (l *Listener) SetConnTracer(tracer ConnTracer) {
+	l.config.SetConnTracer(tracer)
+}
+
+// SetAbuseDetection emits EventAbuseDetected when the connections of many distinct sources were rejected within a window,
+// see BandwidthConfig.SetAbuseDetection
+func (l *Listener) SetAbuseDetection(detection *AbuseDetection) error {
+	return l.config.SetAbuseDetection(detection)
+}
+
+// SetPeerTracking enables keeping usage state per remote IP
+func (l *Listener) SetPeerTracking(enabled bool) {
+	l.config.SetPeerTracking(enabled)
+}
+
+// WarmPeers prepares the state of the expected remote IPs before accepting connections, see BandwidthConfig.WarmPeers
+func (l *Listener) WarmPeers(ips []string) (int, error) {
+	return l.config.WarmPeers(ips)
+}
+
+// SetPeerPrefix aggregates remote IPs into prefixes, e.g. IPv6 /64s, for the per IP state and caps, see BandwidthConfig.SetPeerPrefix
+func (l *Listener) SetPeerPrefix(prefix *PeerPrefix) error {
+	return l.config.SetPeerPrefix(prefix)
+}
+
+// SetPeerEviction bounds the per IP state by its number of entries and their idle time, see BandwidthConfig.SetPeerEviction
+func (l *Listener) SetPeerEviction(eviction *PeerEviction) error {
+	return l.config.SetPeerEviction(eviction)
+}
+
+// PeerStates returns the usage state of every remote IP seen by the listener
+func (l *Listener) PeerStates() map[string]PeerState {
+	return l.config.PeerStates()
+}
+
+// SaveState persists the per peer state to the store, usually called on shutdown
+func (l *Listener) SaveState(store StateStore) error {
+	return l.config.SaveState(store)
+}
+
+// RestoreState loads the per peer state from the store, usually called on startup before accepting connections
+func (l *Listener) RestoreState(store StateStore) error {
+	return l.config.RestoreState(store)
+}
+
+// SnapshotBuckets returns the state of the global token buckets, so a process taking over the listener can continue with the same budget
+func (l *Listener) SnapshotBuckets() BucketsState {
+	return l.config.SnapshotBuckets()
+}
+
+// RestoreBuckets restores the global token buckets from a snapshot taken by another process
+func (l *Listener) RestoreBuckets(state BucketsState) {
+	l.config.RestoreBuckets(state)
+}
+
+// CoordinateGlobalLimit shares the global limit with other processes on the host through a GlobalLimitCoordinator reachable over conn,
+// reporting the usage every interval. It blocks until the connection fails
+func (l *Listener) CoordinateGlobalLimit(conn net.Conn, interval time.Duration) error {
+	return l.config.CoordinateGlobalLimit(conn, interval)
+}
+
+// Snapshot returns the fully resolved current configuration of the listener
+func (l *Listener) Snapshot() ConfigSnapshot {
+	return l.config.Snapshot()
+}
+
+// Stats returns the counters for all connections accepted by the listener, including exempt ones
+func (l *Listener) Stats() Stats {
+	return l.config.Stats()
+}
+
+// ClassStats returns the stats of every traffic class rolled up along the class tree up to the global stats, in one call
+func (l *Listener) ClassStats() ClassStats {
+	return l.config.ClassStats()
+}
+
+// AddAlertRule registers a rule evaluated over the stats by RunAlerts, the handler is called when it fires and when it resolves
+func (l *Listener) AddAlertRule(rule AlertRule, handler AlertHandler) error {
+	return l.config.AddAlertRule(rule, handler)
+}
+
+// RemoveAlertRule removes the alert rule by name
+func (l *Listener) RemoveAlertRule(name string) {
+	l.config.RemoveAlertRule(name)
+}
+
+// RunAlerts evaluates the alert rules every interval until the context is done, e.g.
+//
+//	go l.RunAlerts(ctx, 10*time.Second)
+func (l *Listener) RunAlerts(ctx context.Context, interval time.Duration) error {
+	return l.config.RunAlerts(ctx, interval)
+}
+
+// ConnLifecycles returns the lifecycle states of the open connections, the ones in their state the longest first
+func (l *Listener) ConnLifecycles() []ConnLifecycle {
+	return l.config.ConnLifecycles()
+}
+
+// SetClasses replaces the traffic classes whose limiters are shared by all connections of the class
+func (l *Listener) SetClasses(classes []ClassConfig, defaultClass string) error {
+	return l.config.SetClasses(classes, defaultClass)
+}
+
+// SetClassWeight changes the weight of a class when the global limit is split between connections in work conserving mode
+func (l *Listener) SetClassWeight(class string, weight float64) error {
+	return l.config.SetClassWeight(class, weight)
+}
+
+// SetKernelAccounting enables reconciling closed connections with kernel counters, see Stats.UnaccountedBytesRead
+func (l *Listener) SetKernelAccounting(enabled bool) {
+	l.config.SetKernelAccounting(enabled)
+}
+
+// SetALPNClasses maps negotiated ALPN protocols to traffic classes for connections accepted through NewTLSListener
+func (l *Listener) SetALPNClasses(classes map[string]string) {
+	l.config.SetALPNClasses(classes)
+}
+
+// SetInstrumentationSampling records only a sample of the operations or connections in the wait time and throughput histograms,
+// bounding their cost on busy listeners without affecting shaping
+func (l *Listener) SetInstrumentationSampling(sampling InstrumentationSampling) error {
+	return l.config.SetInstrumentationSampling(sampling)
+}
+
+// SetProfilerLabels attaches pprof labels with the id, class and tags of the connection to the goroutines performing throttled I/O,
+// so CPU profiles can be attributed per tenant
+func (l *Listener) SetProfilerLabels(enabled bool) {
+	l.config.SetProfilerLabels(enabled)
+}
+
+// SetPreambleExemption exempts the first bytes of each connection in each direction from throttling, e.g. the TLS handshake
+func (l *Listener) SetPreambleExemption(bytes int64) {
+	l.config.SetPreambleExemption(bytes)
+}
+
+// SetWarmupExemption makes connections transferring less than bytes in total never throttled,
+// larger ones are charged retroactively once they exceed it
+func (l *Listener) SetWarmupExemption(bytes int64) {
+	l.config.SetWarmupExemption(bytes)
+}
+
+// SetAccountingMode trades exact per operation accounting for cheaper sampled accounting, see BandwidthConfig.SetAccountingMode
+func (l *Listener) SetAccountingMode(mode AccountingMode, interval time.Duration) error {
+	return l.config.SetAccountingMode(mode, interval)
+}
+
+// SetStatsBatching makes the connections add their bytes to the stats in batches, see BandwidthConfig.SetStatsBatching
+func (l *Listener) SetStatsBatching(batching *StatsBatching) error {
+	return l.config.SetStatsBatching(batching)
+}
+
+// FlushStats adds the bytes the open connections batched to the stats, so Stats is exact
+func (l *Listener) FlushStats() {
+	l.config.FlushStats()
+}
+
+// SetTimerWheel wakes the waits of the connections from a timer wheel served by a few goroutines instead of a timer each,
+// for listeners with a huge number of throttled connections, see BandwidthConfig.SetTimerWheel
+func (l *Listener) SetTimerWheel(wheel *TimerWheel) error {
+	return l.config.SetTimerWheel(wheel)
+}
+
+// SetPacingSpin makes waits busy wait for their final spin instead of sleeping, for accurate shaping with coarse timers
+func (l *Listener) SetPacingSpin(spin time.Duration) {
+	l.config.SetPacingSpin(spin)
+}
+
+// SetPrecisionMode shrinks the burst of the per connection limiters, keeping the throughput within 2% of low limits
+func (l *Listener) SetPrecisionMode(enabled bool) {
+	l.config.SetPrecisionMode(enabled)
+}
+
+// SetWaitJitter adds a random delay of up to jitter to throttled waits, so connections sharing a limit do not send in phase-locked bursts
+func (l *Listener) SetWaitJitter(jitter time.Duration) {
+	l.config.SetWaitJitter(jitter)
+}
+
+// SetSharingMode decides how connections share the global limit, e.g. splitting it between the active connections only
+func (l *Listener) SetSharingMode(mode SharingMode) {
+	l.config.SetSharingMode(mode)
+}
+
+// SetEvenSplit derives the per connection limit from the global limit divided by the open connections, within a floor
+// and a ceiling, nil restores the static per connection limit
+func (l *Listener) SetEvenSplit(split *EvenSplit) error {
+	return l.config.SetEvenSplit(split)
+}
+
+// SetRetroactiveCharging makes limits lowered by SetLimits charge the usage of the last window first,
+// so connections do not get a fresh burst right after the limits tightened
+func (l *Listener) SetRetroactiveCharging(window time.Duration) {
+	l.config.SetRetroactiveCharging(window)
+}
+
+// SetBurstDebt makes limits lowered by SetLimits pay back the burst their limiters still hold within the payback period,
+// so the traffic does not overshoot the new limits
+func (l *Listener) SetBurstDebt(payback time.Duration) {
+	l.config.SetBurstDebt(payback)
+}
+
+// SetStrictMode guarantees that no more than limit×interval bytes pass any limit within an accounting interval,
+// without a burst carried over from a previous interval. Zero disables it
+func (l *Listener) SetStrictMode(interval time.Duration) {
+	l.config.SetStrictMode(interval)
+}
+
+// SetTransferProgress emits EventTransferProgress every so many bytes or so much time for connections which transferred
+// more than the threshold, nil disables it
+func (l *Listener) SetTransferProgress(progress *TransferProgress) error {
+	return l.config.SetTransferProgress(progress)
+}
+
+// SetThroughputEstimation estimates the throughput the connections achieve, including exempt and unlimited ones,
+// averaged over about the window, so the stats report the real utilization of the uplink. Zero disables it
+func (l *Listener) SetThroughputEstimation(window time.Duration) {
+	l.config.SetThroughputEstimation(window)
+}
+
+// TuneGlobalLimit adjusts the global limit of the listener every interval to keep the measured utilization of the link
+// near a target share of its capacity, see BandwidthConfig.TuneGlobalLimit. It blocks until the context is done
+func (l *Listener) TuneGlobalLimit(ctx context.Context, target UtilizationTarget, counter LinkCounter, interval time.Duration) error {
+	return l.config.TuneGlobalLimit(ctx, target, counter, interval)
+}
+
+// SetInterfaceLimits attaches per interface limits, shared with the listeners on the other interfaces of the host,
+// see InterfaceLimits. nil detaches them
+func (l *Listener) SetInterfaceLimits(limits *InterfaceLimits) {
+	l.config.SetInterfaceLimits(limits)
+}
+
+// SetOriginalDst passes the original destination of redirected connections to the classifier, see BandwidthConfig.SetOriginalDst
+func (l *Listener) SetOriginalDst(enabled bool) {
+	l.config.SetOriginalDst(enabled)
+}
+
+// SetMirror copies the data of a sample of the connections to a secondary sink with its own limit, see Mirror.
+// nil stops mirroring
+func (l *Listener) SetMirror(mirror *Mirror) error {
+	return l.config.SetMirror(mirror)
+}
+
+// MirrorStats returns the counters of the mirrored connections
+func (l *Listener) MirrorStats() MirrorStats {
+	return l.config.MirrorStats()
+}
+
+// SetBoost lets connections transfer at full speed at their start and clamps them to their limit afterwards, see Boost.
+// nil disables it
+func (l *Listener) SetBoost(boost *Boost) error {
+	return l.config.SetBoost(boost)
+}
+
+// SetOffPeakUnlimited lifts all limits every day between start and end in the location, see BandwidthConfig.SetOffPeakUnlimited
+func (l *Listener) SetOffPeakUnlimited(start, end string, location *time.Location) error {
+	return l.config.SetOffPeakUnlimited(start, end, location)
+}
+
+// SetLimitRamp moves the global and per connection limits to new values gradually over the duration, see BandwidthConfig.SetLimitRamp
+func (l *Listener) SetLimitRamp(duration time.Duration) {
+	l.config.SetLimitRamp(duration)
+}
+
+// SetMaxConns limits the number of open connections, in total and per remote IP, zero means no limit
+func (l *Listener) SetMaxConns(maxConns int64, maxPerIP int) {
+	l.config.SetMaxConns(maxConns, maxPerIP)
+}
+
+// RecentRejections returns samples of the last rejected connections with the reason, oldest first
+func (l *Listener) RecentRejections() []Rejection {
+	return l.config.RecentRejections()
+}
+
+// TopTalkers returns the n open connections, peers or classes with the highest throughput within the last window
+func (l *Listener) TopTalkers(n int, window time.Duration, by TalkerGrouping) []Talker {
+	return l.config.TopTalkers(n, window, by)
+}
+
+// SetThroughputHistory keeps per second samples of the aggregate throughput for the retention, zero disables it
+func (l *Listener) SetThroughputHistory(retention time.Duration) {
+	l.config.SetThroughputHistory(retention)
+}
+
+// ThroughputHistory returns the per second samples of the aggregate throughput, oldest first
+func (l *Listener) ThroughputHistory() []ThroughputSample {
+	return l.config.ThroughputHistory()
+}
+
+// OnClassBudgetExhausted calls the handler when the connections of the class keep being throttled and when they recover
+func (l *Listener) OnClassBudgetExhausted(class string, policy ExhaustionPolicy, handler ExhaustionHandler) {
+	l.config.OnClassBudgetExhausted(class, policy, handler)
+}
+
+// SetQueuePacing holds back writes while more than twice the bandwidth-delay product is queued in the socket, linux only
+func (l *Listener) SetQueuePacing(enabled bool) {
+	l.config.SetQueuePacing(enabled)
+}
+
+// SetWriteDeadlinePolicy decides whether the write deadline applies to a large Write as a whole or is sliced across its chunks
+func (l *Listener) SetWriteDeadlinePolicy(policy WriteDeadlinePolicy) {
+	l.config.SetWriteDeadlinePolicy(policy)
+}
+
+// SetTLSAccounting decides whether connections of NewTLSListener are charged the bytes on the wire, including the TLS overhead,
+// or the plaintext bytes of the application. It applies to the TLS listeners created afterwards
+func (l *Listener) SetTLSAccounting(accounting TLSAccounting) {
+	l.config.SetTLSAccounting(accounting)
+}
+
+// SetClassDSCP maps traffic classes to the DSCP (0-63) marked on the sockets of their connections, on linux and darwin,
+// so downstream network gear can apply QoS consistent with the class
+func (l *Listener) SetClassDSCP(mapping map[string]int) error {
+	return l.config.SetClassDSCP(mapping)
+}
+
+// SetTCPKeepAlive enables keep-alive probes on the TCP connections accepted afterwards, nil leaves the default of the listener.
+// Dead peers are detected regardless of how long the connection is throttled
+func (l *Listener) SetTCPKeepAlive(config *net.KeepAliveConfig) {
+	l.config.SetTCPKeepAlive(config)
+}
+
+// SetLivenessProbe detects dead peers of connections without TCP keep-alive, e.g. unix sockets, at the application level,
+// nil disables it. See LivenessProbe
+func (l *Listener) SetLivenessProbe(probe *LivenessProbe) {
+	l.config.SetLivenessProbe(probe)
+}
+
+// SetBucketPersistence saves the global token buckets to the file on Close and restores them from it now,
+// unless the saved state is older than maxAge, so a quick restart does not grant everyone a fresh burst at once
+func (l *Listener) SetBucketPersistence(path string, maxAge time.Duration) error {
+	return l.config.SetBucketPersistence(path, maxAge)
+}
+
+// Close saves the global token buckets if persistence is enabled and closes the underlying listener
+func (l *Listener) Close() error {
+	if pool := l.acceptPool(); pool != nil {
+		pool.close()
+	}
+	err := l.config.persistBuckets()
+
+	return errors.Join(l.Listener.Close(), err)
+}
+
+// SetClock replaces the time source of the limiters, e.g. with NewBootClock so waits account for the time
+// the machine was suspended. It should be set before connections are accepted
+func (l *Listener) SetClock(clock Clock) {
+	l.config.SetClock(clock)
+}
+
+// SetReverseDNS resolves the names of remote IPs for the classifier, so policies can match hostnames, nil disables it.
+// Lookups are cached and never block Accept, see ReverseDNS
+func (l *Listener) SetReverseDNS(config *ReverseDNS) {
+	l.config.SetReverseDNS(config)
+}
+
+// SetClassifier sets the classifier deciding at accept time how connections are treated, e.g. a Policy loaded with LoadPolicyFile
+func (l *Listener) SetClassifier(classifier Classifier) {
+	l.config.SetClassifier(classifier)
+}
+
+// Accept waits for the next connection which is not denied by the classifier
 func (l *Listener) Accept() (net.Conn, error) {
-	conn, err := l.Listener.Accept()
-	if err != nil {
-		return nil, err
+	return l.accept(nil)
+}
+
+// ErrConnRejected is returned by WrapConn when the connection caps or the classifier rejected the connection, it was closed
+var ErrConnRejected = errors.New("connection rejected")
+
+// WrapConn makes a connection obtained other than by Accept, e.g. from a TLS upgrade or an inherited file descriptor,
+// subject to the caps, classifier, limits, registry and stats of the listener as if it had been accepted.
+// Connections wrapping one of its throttled connections already are returned as they are.
+// It fails with ErrConnRejected if the connection was rejected, the connection is closed then
+func (l *Listener) WrapConn(conn net.Conn) (net.Conn, error) {
+	if l.admitted(conn) {
+		return conn, nil
+	}
+
+	throttled, ok := l.admit(conn, nil)
+	if !ok {
+		return nil, ErrConnRejected
+	}
+
+	return throttled, nil
+}
+
+// accept admits and classifies the next connection, wrap replaces the accepted connection before it is throttled, e.g. with TLS.
+// It gets the location the throttled connection is stored at once it is created
+func (l *Listener) accept(wrap func(conn net.Conn, throttled **ThrottledConn) net.Conn) (net.Conn, error) {
+	if pool := l.acceptPool(); pool != nil {
+		return pool.acceptFrom(l, wrap)
+	}
+
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if l.admitted(conn) {
+			return conn, nil
+		}
+
+		if throttled, ok := l.admit(conn, wrap); ok {
+			return throttled, nil
+		}
+	}
+}
+
+// admit checks the caps, classifies and throttles the connection, it returns false if the connection was rejected and closed
+func (l *Listener) admit(conn net.Conn, wrap func(conn net.Conn, throttled **ThrottledConn) net.Conn) (*ThrottledConn, bool) {
+	slot, reason, details, ok := l.config.caps.admit(conn, l.config.stats.activeConns.Load())
+	if !ok {
+		l.config.reject(conn, reason, details)
+		return nil, false
+	}
+	// the slot is given back unless the throttled connection took it over, e.g. when the classifier denied or panicked
+	defer l.config.caps.cancel(slot)
+
+	connConfig := NewConnConfig(l.config)
+	connConfig.capSlot = slot
+
+	classifier := l.config.Classifier()
+	meta := newConnMetadata(conn)
+	// names which are not cached yet are resolved in the background and the connection is classified again
+	rdns := l.config.reverseDNS()
+	lookup := false
+
+	if classifier != nil {
+		if l.config.OriginalDst() {
+			if dst, err := OriginalDst(conn); err == nil {
+				meta.OriginalDst = dst
+			}
+		}
+		if ip := remoteIP(conn); rdns != nil && ip != nil {
+			hostname, cached := rdns.Cached(ip.String())
+			meta.Hostname, lookup = hostname, !cached
+		}
+
+		classification := classifier.Classify(meta)
+		if classification.Deny {
+			l.config.reject(conn, RejectDenied, "denied by classifier")
+			return nil, false
+		}
+
+		connConfig.SetClassification(classification)
+	}
+	l.defaults.apply(connConfig)
+
+	if l.config.quotaExhausted(conn, connConfig.Classification()) {
+		l.config.reject(conn, RejectQuotaExhausted, "quota of the peer exhausted")
+		return nil, false
+	}
+
+	throttled := new(*ThrottledConn)
+	if wrap == nil {
+		*throttled = NewThrottledConnection(conn, connConfig)
+	} else {
+		*throttled = NewThrottledConnection(wrap(conn, throttled), connConfig)
+	}
+
+	(*throttled).meta = meta
+	if classifier != nil {
+		(*throttled).setState(ConnClassified)
+	}
+
+	if lookup {
+		l.config.classifyLater(rdns, *throttled, classifier)
 	}
 
-	return NewThrottledConnection(
-		conn,
-		NewConnectionBandwithConfig(l.config),
-	), nil
+	return *throttled, true
 }
@@ -3,6 +3,7 @@ package netlistener
 import (
 	"context"
 	"crypto/rand"
+	"errors"
 	"fmt"
 	"io"
 	"math"
@@ -191,3 +192,64 @@ func writeDataToServer(listener net.Listener, size int) {
 	rand.Read(buf)
 	conn.Write(buf)
 }
+
+func TestListener_WrapConn(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Failed to create listener", err)
+	}
+	defer listener.Close()
+
+	throttledListener, _ := NewListener(listener, ptr(1000), ptr(100))
+	throttledListener.SetClassifier(ClassifierFunc(func(meta ConnMetadata) Classification {
+		return Classification{Deny: meta.RemoteAddr.String() == "192.0.2.1:1234"}
+	}))
+
+	tests := []struct {
+		name    string
+		conn    func(conn net.Conn) net.Conn
+		wantErr error
+	}{
+		{name: "Out of band connection", conn: func(conn net.Conn) net.Conn { return conn }},
+		{name: "Connection of the listener is kept", conn: func(conn net.Conn) net.Conn {
+			wrapped, _ := throttledListener.WrapConn(conn)
+			return wrapped
+		}},
+		{name: "Denied connection", conn: func(conn net.Conn) net.Conn {
+			return &addrConn{Conn: conn, remoteAddr: &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 1234}}
+		}, wantErr: ErrConnRejected},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := throttledListener.Stats()
+
+			connRead, connWrite := net.Pipe()
+			defer connRead.Close()
+			conn := tt.conn(connWrite)
+
+			wrapped, err := throttledListener.WrapConn(conn)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+			}
+			if err != nil {
+				if denied := throttledListener.Stats().DeniedConns - before.DeniedConns; denied != 1 {
+					t.Errorf("expected the connection to be counted as denied, got %d", denied)
+				}
+				return
+			}
+			defer wrapped.Close()
+
+			if throttled, ok := conn.(*ThrottledConn); ok && wrapped != net.Conn(throttled) {
+				t.Error("expected the throttled connection of the listener to be returned as is")
+			}
+			if _, ok := wrapped.(*ThrottledConn); !ok {
+				t.Fatalf("expected a throttled connection, got %T", wrapped)
+			}
+
+			if active := throttledListener.Stats().ActiveConns; active != 1 {
+				t.Errorf("expected the connection to be active in the listener stats, got %d", active)
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package netlistener
+
+import (
+	"errors"
+	"net"
+)
+
+// ErrNotThrottledListener is returned by ShareListener when the listener does not wrap a throttled listener
+var ErrNotThrottledListener = errors.New("listener does not wrap a throttled listener")
+
+// Unwrap returns the listener the throttled listener accepts from.
+//
+// Listeners wrapping a throttled listener, e.g. one parsing the PROXY protocol, should expose the listener they wrap
+// with an Unwrap() net.Listener method and the connections they wrap with NetConn() or Unwrap() net.Conn, so
+// FindListener and ShareListener can find the throttled listener and its connections are not admitted twice
+func (l *Listener) Unwrap() net.Listener {
+	return l.Listener
+}
+
+// FindListener returns the throttled listener l is or wraps, directly or through wrappers exposing the listener
+// they wrap with Unwrap. It returns false if there is none
+func FindListener(l net.Listener) (*Listener, bool) {
+	for range maxNestingDepth {
+		switch w := l.(type) {
+		case *Listener:
+			return w, true
+		case interface{ Unwrap() net.Listener }:
+			l = w.Unwrap()
+		default:
+			return nil, false
+		}
+	}
+
+	return nil, false
+}
+
+// ShareListener returns a throttled listener accepting from the wrapper l which shares the limits, caps, classifier,
+// registry and stats of the throttled listener l wraps, instead of creating a second nested budget with NewListener.
+// The connections it accepts were admitted by the wrapped listener already and are returned as they are, so they
+// are counted once, while its setters change the shared budget. Closing it closes the wrapper.
+// It fails with ErrNotThrottledListener if l does not wrap a throttled listener, see FindListener
+func ShareListener(l net.Listener) (*Listener, error) {
+	inner, ok := FindListener(l)
+	if !ok {
+		return nil, ErrNotThrottledListener
+	}
+	if inner == l {
+		return inner, nil
+	}
+
+	return &Listener{Listener: l, config: inner.config, defaults: inner.defaults}, nil
+}
+
+// admitted reports whether the connection wraps a connection throttled by the listener already, e.g. when the
+// listener accepts from a wrapper of itself, so it is not admitted and counted a second time
+func (l *Listener) admitted(conn net.Conn) bool {
+	throttled := nestedThrottled(conn)
+
+	return throttled != nil && throttled.config.globalConfig == l.config
+}
@@ -0,0 +1,140 @@
+package netlistener
+
+import (
+	"errors"
+	"net"
+	"testing"
+)
+
+// wrappingListener wraps the connections it accepts, like a listener parsing the PROXY protocol
+type wrappingListener struct {
+	net.Listener
+}
+
+func (l wrappingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	return wrappingConn{Conn: conn}, nil
+}
+
+func (l wrappingListener) Unwrap() net.Listener {
+	return l.Listener
+}
+
+type wrappingConn struct {
+	net.Conn
+}
+
+func (c wrappingConn) NetConn() net.Conn {
+	return c.Conn
+}
+
+func TestFindListener(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Failed to create listener", err)
+	}
+	defer listener.Close()
+
+	throttledListener, _ := NewListener(listener, nil, nil)
+
+	tests := []struct {
+		name     string
+		listener net.Listener
+		expected *Listener
+	}{
+		{name: "Throttled listener", listener: throttledListener, expected: throttledListener},
+		{name: "Wrapped throttled listener", listener: wrappingListener{throttledListener}, expected: throttledListener},
+		{name: "Twice wrapped throttled listener", listener: wrappingListener{wrappingListener{throttledListener}}, expected: throttledListener},
+		{name: "Plain listener", listener: listener},
+		{name: "Wrapped plain listener", listener: wrappingListener{listener}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			found, ok := FindListener(tt.listener)
+			if ok != (tt.expected != nil) || found != tt.expected {
+				t.Errorf("FindListener() = %p, %v, expected %p", found, ok, tt.expected)
+			}
+		})
+	}
+}
+
+func TestShareListener(t *testing.T) {
+	tests := []struct {
+		name string
+		// pool processes the connections of the wrapped listener in the background
+		pool *AcceptPool
+	}{
+		{name: "Accept"},
+		{name: "Accept pool", pool: &AcceptPool{Workers: 1, Queue: 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal("Failed to create listener", err)
+			}
+
+			throttledListener, _ := NewListener(listener, ptr(1000), nil)
+			shared, err := ShareListener(wrappingListener{throttledListener})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer shared.Close()
+			if tt.pool != nil {
+				if err := shared.SetAcceptPool(tt.pool); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			client, err := net.Dial("tcp", listener.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer client.Close()
+
+			conn, err := shared.Accept()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+
+			if _, ok := conn.(wrappingConn); !ok {
+				t.Errorf("Accept() = %T, expected the connection of the wrapper", conn)
+			}
+			if wrapped, err := shared.WrapConn(conn); err != nil || wrapped != conn {
+				t.Errorf("WrapConn() = %T, %v, expected the connection as is", wrapped, err)
+			}
+			if stats := throttledListener.Stats(); stats.AcceptedConns != 1 || stats.ActiveConns != 1 {
+				t.Errorf("Stats() = %d accepted, %d active, expected the connection to be counted once", stats.AcceptedConns, stats.ActiveConns)
+			}
+
+			shared.SetLimits(500, 100)
+			if config := throttledListener.Config(); *config.GlobalLimit != 500 || *config.PerConnLimit != 100 {
+				t.Errorf("Config() = %d, %d, expected the limits set on the shared listener", *config.GlobalLimit, *config.PerConnLimit)
+			}
+		})
+	}
+}
+
+func TestShareListener_NotThrottled(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Failed to create listener", err)
+	}
+	defer listener.Close()
+
+	if _, err := ShareListener(wrappingListener{listener}); !errors.Is(err, ErrNotThrottledListener) {
+		t.Errorf("ShareListener() error = %v, expected %v", err, ErrNotThrottledListener)
+	}
+
+	throttledListener, _ := NewListener(listener, nil, nil)
+	if shared, err := ShareListener(throttledListener); err != nil || shared != throttledListener {
+		t.Errorf("ShareListener() = %p, %v, expected the throttled listener itself", shared, err)
+	}
+}
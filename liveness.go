@@ -0,0 +1,163 @@
+package netlistener
+
+import (
+	"math"
+	"net"
+	"time"
+)
+
+// LivenessProbe detects dead peers at the application level, for transports without TCP keep-alive.
+// A connection whose peer did not send anything for Idle is probed, and closed if the peer stays silent for Timeout afterwards.
+// The time a Read spends waiting for the limiters does not count as idle, since the peer may have sent data which was not read yet
+type LivenessProbe struct {
+	Idle    time.Duration `json:"idle"`
+	Timeout time.Duration `json:"timeout"`
+	// Probe sends an application level ping the peer is expected to answer, nil only waits for the peer to send something.
+	// It is called from a separate goroutine, an error closes the connection
+	Probe func(conn net.Conn) error `json:"-"`
+}
+
+// SetTCPKeepAlive sets the keep-alive config of accepted TCP connections, nil leaves the default of the listener
+func (c *BandwidthConfig) SetTCPKeepAlive(config *net.KeepAliveConfig) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if config == nil {
+		c.keepAlive = nil
+		return
+	}
+
+	keepAlive := *config
+	c.keepAlive = &keepAlive
+}
+
+func (c *BandwidthConfig) TCPKeepAlive() *net.KeepAliveConfig {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.keepAlive
+}
+
+// SetLivenessProbe probes the peers of the connections accepted afterwards which are not TCP connections with keep-alive
+// configured by SetTCPKeepAlive, nil disables it. A zero Timeout defaults to Idle
+func (c *BandwidthConfig) SetLivenessProbe(probe *LivenessProbe) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if probe == nil || probe.Idle <= 0 {
+		c.liveness = nil
+		return
+	}
+
+	liveness := *probe
+	if liveness.Timeout <= 0 {
+		liveness.Timeout = liveness.Idle
+	}
+	c.liveness = &liveness
+}
+
+func (c *BandwidthConfig) LivenessProbe() *LivenessProbe {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.liveness
+}
+
+// applyKeepAlive sets the configured keep-alive on an accepted TCP connection, possibly wrapped e.g. by TLS,
+// reporting whether it did
+func (c *BandwidthConfig) applyKeepAlive(conn net.Conn) bool {
+	config := c.TCPKeepAlive()
+	if config == nil {
+		return false
+	}
+
+	for conn != nil {
+		if tcpConn, ok := conn.(*net.TCPConn); ok {
+			return tcpConn.SetKeepAliveConfig(*config) == nil
+		}
+
+		unwrapper, ok := conn.(interface{ NetConn() net.Conn })
+		if !ok {
+			break
+		}
+		conn = unwrapper.NetConn()
+	}
+
+	return false
+}
+
+// livenessWatch probes the peer of a connection whenever it was silent for the idle time of the probe,
+// closing the connection when the peer does not answer in time. It runs on a timer, so idle connections do not hold a goroutine
+type livenessWatch struct {
+	conn  *ThrottledConn
+	probe LivenessProbe
+	timer *time.Timer
+	// probedAt is when the peer was probed, zero when it is not waited for
+	probedAt time.Time
+}
+
+func (c *ThrottledConn) watchLiveness(probe LivenessProbe) {
+	c.lastRead.Store(time.Now().UnixNano())
+
+	w := &livenessWatch{conn: c, probe: probe}
+	// the timer is armed only once it is assigned, the callback resets it
+	w.timer = time.AfterFunc(math.MaxInt64, w.check)
+	w.timer.Reset(probe.Idle)
+}
+
+func (w *livenessWatch) check() {
+	c := w.conn
+	defer c.config.globalConfig.recoverPanic("liveness probe")
+
+	if c.isClosed() {
+		return
+	}
+
+	now := time.Now()
+	lastRead := time.Unix(0, c.lastRead.Load())
+
+	if c.readWaits.Load() > 0 {
+		// the data of the peer may be waiting for the limiters
+		w.probedAt = time.Time{}
+		w.timer.Reset(w.probe.Idle)
+		return
+	}
+
+	if !w.probedAt.IsZero() && lastRead.After(w.probedAt) {
+		w.probedAt = time.Time{}
+	}
+
+	if idle := now.Sub(lastRead); idle < w.probe.Idle {
+		w.timer.Reset(w.probe.Idle - idle)
+		return
+	}
+
+	if w.probedAt.IsZero() {
+		w.probedAt = now
+		if w.probe.Probe != nil && w.probe.Probe(c) != nil {
+			c.reapDeadPeer("liveness probe failed")
+			return
+		}
+
+		w.timer.Reset(w.probe.Timeout)
+		return
+	}
+
+	c.reapDeadPeer("no answer to liveness probe")
+}
+
+// reapDeadPeer closes a connection whose peer failed the liveness probe
+func (c *ThrottledConn) reapDeadPeer(details string) {
+	config := c.config.globalConfig
+	config.stats.deadPeers.Add(1)
+	if class := c.classCounters(); class != nil {
+		class.deadPeers.Add(1)
+	}
+	config.emit(Event{Type: EventPeerDead, Time: time.Now(), Peer: peerKey(c.Conn), Details: details, ConnState: c.lifecycle.Load().String()})
+	if c.trace != nil {
+		c.trace.LazyPrintf("peer dead: %s", details)
+		c.trace.SetError()
+	}
+
+	c.Close()
+}
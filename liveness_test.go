@@ -0,0 +1,122 @@
+package netlistener
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRateLimitedConnection_LivenessProbe(t *testing.T) {
+	tests := []struct {
+		name string
+		// peer runs the remote side of the connection
+		peer  func(conn net.Conn)
+		probe func(conn net.Conn) error
+		dead  bool
+	}{
+		{
+			name: "Silent peer is reaped",
+			peer: func(conn net.Conn) {},
+			dead: true,
+		},
+		{
+			name: "Failing probe reaps the connection",
+			peer: func(conn net.Conn) {},
+			probe: func(conn net.Conn) error {
+				return errors.New("peer gone")
+			},
+			dead: true,
+		},
+		{
+			name: "Answered probe keeps the connection",
+			peer: func(conn net.Conn) {
+				buf := make([]byte, 4)
+				for {
+					if _, err := io.ReadFull(conn, buf); err != nil {
+						return
+					}
+					if _, err := conn.Write([]byte("pong")); err != nil {
+						return
+					}
+				}
+			},
+			probe: func(conn net.Conn) error {
+				go conn.Write([]byte("ping"))
+				return nil
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := NewBandwithConfig(nil, nil)
+			config.SetLivenessProbe(&LivenessProbe{Idle: 50 * time.Millisecond, Timeout: 50 * time.Millisecond, Probe: tt.probe})
+
+			var events atomic.Int32
+			config.SetEventHandler(func(event Event) {
+				if event.Type == EventPeerDead {
+					events.Add(1)
+				}
+			})
+
+			connRead, connWrite := net.Pipe()
+			defer connWrite.Close()
+			go tt.peer(connWrite)
+
+			conn := NewThrottledConnection(connRead, NewConnectionBandwithConfig(config))
+			defer conn.Close()
+			go io.Copy(io.Discard, conn)
+
+			time.Sleep(400 * time.Millisecond)
+
+			if dead := conn.isClosed(); dead != tt.dead {
+				t.Errorf("expected closed %t, got %t", tt.dead, dead)
+			}
+
+			expected := int64(0)
+			if tt.dead {
+				expected = 1
+			}
+			if deadPeers := config.Stats().DeadPeers; deadPeers != expected || int64(events.Load()) != expected {
+				t.Errorf("expected %d dead peers and events, got %d and %d", expected, deadPeers, events.Load())
+			}
+		})
+	}
+}
+
+func TestListener_TCPKeepAliveSkipsLivenessProbe(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Failed to create listener", err)
+	}
+	defer listener.Close()
+
+	throttledListener, _ := NewListener(listener, nil, nil,
+		WithTCPKeepAlive(net.KeepAliveConfig{Enable: true, Idle: time.Minute, Interval: 10 * time.Second, Count: 3}),
+		WithLivenessProbe(LivenessProbe{Idle: 20 * time.Millisecond, Timeout: 20 * time.Millisecond}),
+	)
+
+	client, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	conn, err := throttledListener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	time.Sleep(100 * time.Millisecond)
+
+	if conn.(*ThrottledConn).isClosed() {
+		t.Error("expected the keep-alive connection not to be probed at the application level")
+	}
+	if snapshot := throttledListener.config.Snapshot(); snapshot.TCPKeepAlive == nil || snapshot.LivenessProbe == nil {
+		t.Errorf("expected keep-alive and liveness probe in the snapshot, got %+v and %+v", snapshot.TCPKeepAlive, snapshot.LivenessProbe)
+	}
+}
@@ -0,0 +1,288 @@
+package netlistener
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+
+	"golang.org/x/time/rate"
+)
+
+const defaultMirrorQueueSize = 1 << 20
+
+// Mirror copies the data of a sample of the connections to a secondary sink, e.g. for analytics or an IDS.
+// The data is copied after it passed the limiters and is written to the sinks in the background, with its own limit,
+// so a slow or failing sink never delays the connections: mirrored data which does not fit is dropped instead
+type Mirror struct {
+	// Sink returns the writer the data of a mirrored connection is copied to, e.g. a connection to the IDS.
+	// It is called once per mirrored connection when it is accepted, writers which are io.Closer are closed after the connection.
+	// A nil writer skips the connection
+	Sink func(conn *ThrottledConn) io.Writer
+	// Percent is the part of the connections mirrored, zero mirrors all of them
+	Percent float64
+	// Limit bounds the mirrored bytes per second over all connections, nil means unlimited
+	Limit *int
+	// Reads and Writes select the mirrored directions, both are mirrored when neither is set
+	Reads  bool
+	Writes bool
+	// QueueSize is the number of mirrored bytes buffered for the sinks, 1 MiB by default
+	QueueSize int
+}
+
+func (m Mirror) validate() (Mirror, error) {
+	if m.Sink == nil {
+		return m, errors.New("mirror sink must be set")
+	}
+	if m.Percent < 0 || m.Percent > 100 {
+		return m, fmt.Errorf("mirror percent %v out of range", m.Percent)
+	}
+	if m.Limit != nil && *m.Limit <= 0 {
+		return m, errors.New("mirror limit must be positive")
+	}
+	if !m.Reads && !m.Writes {
+		m.Reads, m.Writes = true, true
+	}
+	if m.QueueSize <= 0 {
+		m.QueueSize = defaultMirrorQueueSize
+	}
+
+	return m, nil
+}
+
+// MirrorStats counts the bytes of the mirrored connections, Dropped are the ones over the limit or the queue of the mirror
+// and the ones a sink failed to take
+type MirrorStats struct {
+	Conns   int64 `json:"conns"`
+	Bytes   int64 `json:"bytes"`
+	Dropped int64 `json:"dropped"`
+}
+
+// mirrorer writes the mirrored data of all connections to their sinks from a single goroutine
+type mirrorer struct {
+	config  Mirror
+	limiter *rate.Limiter
+	owner   *BandwidthConfig
+
+	queue  chan mirrorChunk
+	queued atomic.Int64
+	done   chan struct{}
+	exited chan struct{}
+
+	conns, bytes, dropped atomic.Int64
+
+	// streams are the open sinks, they are closed when the mirror is replaced
+	streams map[*mirrorStream]struct{}
+	mu      sync.Mutex
+}
+
+// mirrorChunk is data to write to a stream, nil data closes it
+type mirrorChunk struct {
+	stream *mirrorStream
+	data   []byte
+}
+
+// mirrorStream is the sink of one connection, it is written by the goroutine of the mirrorer only
+type mirrorStream struct {
+	mirrorer *mirrorer
+	writer   io.Writer
+	failed   bool
+}
+
+func newMirrorer(config Mirror, owner *BandwidthConfig) *mirrorer {
+	m := &mirrorer{
+		config:  config,
+		limiter: rate.NewLimiter(formatRateLimit(config.Limit), formatBurst(config.Limit)),
+		owner:   owner,
+		queue:   make(chan mirrorChunk, 1024),
+		done:    make(chan struct{}),
+		exited:  make(chan struct{}),
+		streams: make(map[*mirrorStream]struct{}),
+	}
+	go m.run()
+
+	return m
+}
+
+func (m *mirrorer) run() {
+	defer close(m.exited)
+	defer m.owner.recoverPanic("mirror")
+
+	for {
+		select {
+		case chunk := <-m.queue:
+			m.write(chunk)
+		case <-m.done:
+			return
+		}
+	}
+}
+
+func (m *mirrorer) write(chunk mirrorChunk) {
+	stream := chunk.stream
+	if chunk.data == nil {
+		m.close(stream)
+		return
+	}
+
+	m.queued.Add(-int64(len(chunk.data)))
+	if stream.failed {
+		m.dropped.Add(int64(len(chunk.data)))
+		return
+	}
+
+	n, err := stream.writer.Write(chunk.data)
+	m.bytes.Add(int64(n))
+	if err != nil {
+		// a failed sink is not written again, the connection itself goes on
+		stream.failed = true
+		m.dropped.Add(int64(len(chunk.data) - n))
+		m.owner.reportError(fmt.Errorf("mirroring connection: %w", err))
+	}
+}
+
+func (m *mirrorer) close(stream *mirrorStream) {
+	m.mu.Lock()
+	_, open := m.streams[stream]
+	delete(m.streams, stream)
+	m.mu.Unlock()
+
+	if closer, ok := stream.writer.(io.Closer); ok && open {
+		closer.Close()
+	}
+}
+
+// stop ends the goroutine and closes the sinks which are still open, data still queued is dropped
+func (m *mirrorer) stop() {
+	close(m.done)
+	<-m.exited
+
+	m.mu.Lock()
+	streams := m.streams
+	m.streams = make(map[*mirrorStream]struct{})
+	m.mu.Unlock()
+
+	for stream := range streams {
+		if closer, ok := stream.writer.(io.Closer); ok {
+			closer.Close()
+		}
+	}
+}
+
+// open returns the stream of a newly accepted connection, nil if it is not sampled
+func (m *mirrorer) open(conn *ThrottledConn) *mirrorStream {
+	if m.config.Percent > 0 && rand.Float64()*100 >= m.config.Percent {
+		return nil
+	}
+
+	writer := m.config.Sink(conn)
+	if writer == nil {
+		return nil
+	}
+
+	stream := &mirrorStream{mirrorer: m, writer: writer}
+	m.mu.Lock()
+	m.streams[stream] = struct{}{}
+	m.mu.Unlock()
+	m.conns.Add(1)
+
+	return stream
+}
+
+// mirror queues a copy of the data, it never blocks
+func (s *mirrorStream) mirror(read bool, data []byte) {
+	m := s.mirrorer
+	if len(data) == 0 || (read && !m.config.Reads) || (!read && !m.config.Writes) {
+		return
+	}
+
+	size := int64(len(data))
+	if m.queued.Add(size) > int64(m.config.QueueSize) || !m.limiter.AllowN(m.owner.now(), min(len(data), m.limiter.Burst())) {
+		m.queued.Add(-size)
+		m.dropped.Add(size)
+		return
+	}
+	// data over the burst of the mirror limit is dropped, it can never be allowed at once
+	if burst := m.limiter.Burst(); len(data) > burst && m.limiter.Limit() != rate.Inf {
+		m.queued.Add(-int64(len(data) - burst))
+		m.dropped.Add(int64(len(data) - burst))
+		data = data[:burst]
+	}
+
+	select {
+	case m.queue <- mirrorChunk{stream: s, data: append([]byte(nil), data...)}:
+	default:
+		m.queued.Add(-int64(len(data)))
+		m.dropped.Add(int64(len(data)))
+	}
+}
+
+// close closes the sink after the data queued before, without blocking the connection
+func (s *mirrorStream) close() {
+	m := s.mirrorer
+	select {
+	case m.queue <- mirrorChunk{stream: s}:
+	default:
+		go func() {
+			select {
+			case m.queue <- mirrorChunk{stream: s}:
+			case <-m.done:
+			}
+		}()
+	}
+}
+
+// SetMirror mirrors the data of a sample of the connections accepted afterwards to a secondary sink, see Mirror.
+// nil stops mirroring, the sinks of the mirrored connections are closed then
+func (c *BandwidthConfig) SetMirror(mirror *Mirror) error {
+	var next *mirrorer
+	if mirror != nil {
+		config, err := mirror.validate()
+		if err != nil {
+			return err
+		}
+		next = newMirrorer(config, c)
+	}
+
+	c.mu.Lock()
+	previous := c.mirror
+	c.mirror = next
+	c.mu.Unlock()
+
+	if previous != nil {
+		previous.stop()
+	}
+
+	return nil
+}
+
+// MirrorStats returns the counters of the current mirror, zero if there is none
+func (c *BandwidthConfig) MirrorStats() MirrorStats {
+	c.mu.RLock()
+	m := c.mirror
+	c.mu.RUnlock()
+
+	if m == nil {
+		return MirrorStats{}
+	}
+
+	return MirrorStats{Conns: m.conns.Load(), Bytes: m.bytes.Load(), Dropped: m.dropped.Load()}
+}
+
+func (c *BandwidthConfig) mirrorer() *mirrorer {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.mirror
+}
+
+// mirror copies data the connection transferred to its mirror, a wrapping connection mirrors the data of wrapped ones
+func (c *ThrottledConn) mirror(read bool, data []byte) {
+	if c.mirrored == nil || c.wrapped.Load() != nil {
+		return
+	}
+
+	c.mirrored.mirror(read, data)
+}
@@ -0,0 +1,140 @@
+package netlistener
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// mirrorSink collects the mirrored data and is signalled when it is closed
+type mirrorSink struct {
+	buf    bytes.Buffer
+	mu     sync.Mutex
+	closed chan struct{}
+}
+
+func (s *mirrorSink) Write(b []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.buf.Write(b)
+}
+
+func (s *mirrorSink) Close() error {
+	close(s.closed)
+	return nil
+}
+
+func (s *mirrorSink) String() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.buf.String()
+}
+
+func TestMirror(t *testing.T) {
+	tests := []struct {
+		name     string
+		mirror   Mirror
+		expected string
+		dropped  int64
+	}{
+		{name: "Both directions are mirrored", mirror: Mirror{}, expected: "requestresponse"},
+		{name: "Only reads are mirrored", mirror: Mirror{Reads: true}, expected: "request"},
+		{name: "Data over the limit is dropped", mirror: Mirror{Limit: ptr(4)}, expected: "requ", dropped: 11},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := NewBandwithConfig(nil, nil)
+			sink := &mirrorSink{closed: make(chan struct{})}
+			tt.mirror.Sink = func(conn *ThrottledConn) io.Writer { return sink }
+			if err := config.SetMirror(&tt.mirror); err != nil {
+				t.Fatal(err)
+			}
+			defer config.SetMirror(nil)
+
+			client, server := net.Pipe()
+			defer client.Close()
+			conn := NewThrottledConnection(server, NewConnectionBandwithConfig(config))
+
+			go func() {
+				client.Write([]byte("request"))
+				io.ReadAll(client)
+			}()
+
+			buf := make([]byte, 7)
+			if _, err := io.ReadFull(conn, buf); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := conn.Write([]byte("response")); err != nil {
+				t.Fatal(err)
+			}
+			conn.Close()
+
+			select {
+			case <-sink.closed:
+			case <-time.After(time.Second):
+				t.Fatal("sink was not closed after the connection")
+			}
+
+			if sink.String() != tt.expected {
+				t.Errorf("expected %q mirrored, got %q", tt.expected, sink.String())
+			}
+			if stats := config.MirrorStats(); stats.Conns != 1 || stats.Dropped != tt.dropped {
+				t.Errorf("expected 1 connection and %d bytes dropped, got %+v", tt.dropped, stats)
+			}
+		})
+	}
+}
+
+func TestMirror_Sampling(t *testing.T) {
+	config := NewBandwithConfig(nil, nil)
+	var sinks int
+	err := config.SetMirror(&Mirror{Percent: 50, Sink: func(conn *ThrottledConn) io.Writer {
+		sinks++
+		return io.Discard
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer config.SetMirror(nil)
+
+	for range 1000 {
+		client, server := net.Pipe()
+		NewThrottledConnection(server, NewConnectionBandwithConfig(config)).Close()
+		client.Close()
+	}
+
+	if sinks < 400 || sinks > 600 {
+		t.Errorf("expected about half of the connections mirrored, got %d", sinks)
+	}
+}
+
+func TestMirror_Validate(t *testing.T) {
+	sink := func(conn *ThrottledConn) io.Writer { return io.Discard }
+
+	tests := []struct {
+		name   string
+		mirror Mirror
+		valid  bool
+	}{
+		{name: "Valid mirror", mirror: Mirror{Sink: sink, Percent: 10, Limit: ptr(1000)}, valid: true},
+		{name: "Missing sink", mirror: Mirror{}},
+		{name: "Percent over 100", mirror: Mirror{Sink: sink, Percent: 150}},
+		{name: "Zero limit", mirror: Mirror{Sink: sink, Limit: ptr(0)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := NewBandwithConfig(nil, nil)
+			if err := config.SetMirror(&tt.mirror); (err == nil) != tt.valid {
+				t.Errorf("expected valid %v, got %v", tt.valid, err)
+			}
+			config.SetMirror(nil)
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package netlistener
+
+import (
+	"errors"
+	"net"
+	"sync"
+)
+
+// MultiListener accepts connections from several listeners as one, so a single throttled Listener
+// with one configuration can front a set of ports, e.g. 8080 and 8443, and classify them by local port
+type MultiListener struct {
+	listeners []net.Listener
+
+	accepted chan acceptResult
+	done     chan struct{}
+
+	closeOnce sync.Once
+	closeErr  error
+}
+
+type acceptResult struct {
+	conn net.Conn
+	err  error
+}
+
+var _ net.Listener = (*MultiListener)(nil)
+
+// NewMultiListener starts accepting from all listeners. A listener failing with an error other than a timeout
+// stops being accepted from, the error is returned by Accept once
+func NewMultiListener(listeners ...net.Listener) *MultiListener {
+	m := &MultiListener{
+		listeners: listeners,
+		accepted:  make(chan acceptResult),
+		done:      make(chan struct{}),
+	}
+
+	for _, listener := range listeners {
+		go m.acceptFrom(listener)
+	}
+
+	return m
+}
+
+func (m *MultiListener) acceptFrom(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+
+		select {
+		case m.accepted <- acceptResult{conn: conn, err: err}:
+		case <-m.done:
+			if conn != nil {
+				conn.Close()
+			}
+			return
+		}
+
+		if ne, ok := err.(net.Error); err != nil && (!ok || !ne.Timeout()) {
+			return
+		}
+	}
+}
+
+// Accept returns the next connection accepted by any of the listeners, net.ErrClosed after Close
+func (m *MultiListener) Accept() (net.Conn, error) {
+	select {
+	case result := <-m.accepted:
+		return result.conn, result.err
+	case <-m.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close closes all listeners, returning their errors joined
+func (m *MultiListener) Close() error {
+	m.closeOnce.Do(func() {
+		close(m.done)
+
+		errs := make([]error, 0, len(m.listeners))
+		for _, listener := range m.listeners {
+			errs = append(errs, listener.Close())
+		}
+		m.closeErr = errors.Join(errs...)
+	})
+
+	return m.closeErr
+}
+
+// Addr returns the address of the first listener, see Addrs for all of them
+func (m *MultiListener) Addr() net.Addr {
+	if len(m.listeners) == 0 {
+		return nil
+	}
+
+	return m.listeners[0].Addr()
+}
+
+// Addrs returns the addresses of all listeners
+func (m *MultiListener) Addrs() []net.Addr {
+	addrs := make([]net.Addr, 0, len(m.listeners))
+	for _, listener := range m.listeners {
+		addrs = append(addrs, listener.Addr())
+	}
+
+	return addrs
+}
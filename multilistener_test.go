@@ -0,0 +1,66 @@
+package netlistener
+
+import (
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestMultiListener_ClassifiesByLocalPort(t *testing.T) {
+	var listeners []net.Listener
+	for range 2 {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal("Failed to create listener", err)
+		}
+		listeners = append(listeners, listener)
+	}
+
+	multi := NewMultiListener(listeners...)
+	defer multi.Close()
+
+	policy := &Policy{Rules: []Rule{
+		{Name: "second", Match: RuleMatch{Ports: []int{addrPort(listeners[1].Addr())}}, Action: RuleAction{Class: "second"}},
+		{Name: "first", Match: RuleMatch{PortRanges: []string{"0-65535"}}, Action: RuleAction{Class: "first"}},
+	}}
+	if err := policy.Compile(); err != nil {
+		t.Fatal(err)
+	}
+
+	throttledListener, _ := NewListener(multi, nil, nil)
+	throttledListener.SetClassifier(policy)
+
+	expected := map[string]string{}
+	for i, class := range []string{"first", "second"} {
+		conn, err := net.Dial("tcp", listeners[i].Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		expected[conn.LocalAddr().String()] = class
+	}
+
+	for range 2 {
+		conn, err := throttledListener.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+
+		class := conn.(*ThrottledConn).config.Classification().Class
+		if want := expected[conn.RemoteAddr().String()]; class != want {
+			t.Errorf("expected class %q for the connection to %v, got %q", want, conn.LocalAddr(), class)
+		}
+	}
+
+	if len(multi.Addrs()) != 2 || multi.Addr() != listeners[0].Addr() {
+		t.Errorf("unexpected addresses %v", multi.Addrs())
+	}
+
+	if err := multi.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := multi.Accept(); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("expected net.ErrClosed after close, got %v", err)
+	}
+}
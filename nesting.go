@@ -0,0 +1,85 @@
+package netlistener
+
+import (
+	"net"
+	"slices"
+
+	"golang.org/x/time/rate"
+)
+
+// maxNestingDepth bounds the walk through wrappers, in case one of them returns itself
+const maxNestingDepth = 16
+
+// nestedThrottled returns the throttled connection conn wraps, directly or through wrappers exposing the connection
+// they wrap with NetConn or Unwrap, e.g. *tls.Conn. It returns nil if there is none
+func nestedThrottled(conn net.Conn) *ThrottledConn {
+	for range maxNestingDepth {
+		switch c := conn.(type) {
+		case *ThrottledConn:
+			return c
+		case interface{ NetConn() net.Conn }:
+			conn = c.NetConn()
+		case interface{ Unwrap() net.Conn }:
+			conn = c.Unwrap()
+		default:
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// nest makes the connection charge the limiters of the throttled connection it wraps, which passes its operations through.
+// Stacked wrappers would wait one after another, and the inner one would not see the context and deadlines of the outer one,
+// so a single chain of limiters is used instead: the limiters of the outer connection first, followed by the ones of the inner
+// connection which are not in the chain already. Both limits apply, so the tighter one wins, and the exemptions, classes
+// and profiles of each wrapper only apply to its own limiters. The inner connection keeps counting its bytes in its stats,
+// unless both wrappers share the stats of a config
+func (c *ThrottledConn) nest(inner *ThrottledConn) {
+	c.nested = inner
+	inner.wrapped.Store(c)
+}
+
+// withNested appends the limiters of the nested connection which are not in the chain already
+func (c *ThrottledConn) withNested(limiters []*rate.Limiter, read bool) []*rate.Limiter {
+	if c.nested == nil {
+		return limiters
+	}
+
+	for _, limiter := range c.nested.activeLimiters(read) {
+		if !slices.Contains(limiters, limiter) {
+			limiters = append(limiters, limiter)
+		}
+	}
+
+	return limiters
+}
+
+// sharesStats reports whether the wrapping connection counts its bytes in the same stats, so they are not counted twice
+func (c *ThrottledConn) sharesStats() bool {
+	return c.wrapped.Load().config.globalConfig == c.config.globalConfig
+}
+
+// passThroughRead reads without waiting for the limiters, the wrapping connection has waited for them already
+func (c *ThrottledConn) passThroughRead(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if c.sharesStats() {
+		c.bytesRead.Add(int64(n))
+	} else {
+		c.accountRead(n)
+	}
+
+	return n, err
+}
+
+// passThroughWrite writes without waiting for the limiters, the wrapping connection has waited for them already
+func (c *ThrottledConn) passThroughWrite(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	if c.sharesStats() {
+		c.bytesWritten.Add(int64(n))
+	} else {
+		c.accountWrite(n)
+	}
+
+	return n, err
+}
@@ -0,0 +1,124 @@
+package netlistener
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// unwrapConn exposes the connection it wraps like the wrappers of other libraries
+type unwrapConn struct {
+	net.Conn
+}
+
+func (c unwrapConn) Unwrap() net.Conn {
+	return c.Conn
+}
+
+func TestNestedThrottled(t *testing.T) {
+	connRead, connWrite := net.Pipe()
+	defer connRead.Close()
+	inner := NewThrottledConnection(connWrite, nil)
+
+	tests := []struct {
+		name     string
+		conn     net.Conn
+		expected *ThrottledConn
+	}{
+		{name: "Plain connection", conn: connWrite, expected: nil},
+		{name: "Throttled connection", conn: inner, expected: inner},
+		{name: "Through Unwrap", conn: unwrapConn{inner}, expected: inner},
+		{name: "Through nested wrappers", conn: unwrapConn{unwrapConn{inner}}, expected: inner},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := nestedThrottled(tt.conn); got != tt.expected {
+				t.Errorf("expected %p, got %p", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestRateLimitedConnection_Nested(t *testing.T) {
+	tests := []struct {
+		name       string
+		innerLimit *int
+		outerLimit *int
+		// globalLimit is the global limit of the inner config
+		globalLimit *int
+		// sameConfig wraps twice with the same listener config, so the limiters are shared
+		sameConfig bool
+		// expected is how long writing 40 bytes after the burst takes
+		expected time.Duration
+	}{
+		{name: "Tighter inner limit", innerLimit: Limit(20), outerLimit: Limit(1000), expected: 2 * time.Second},
+		{name: "Tighter outer limit", innerLimit: Limit(1000), outerLimit: Limit(20), expected: 2 * time.Second},
+		// charged twice, the global limiter would take 80 tokens for the 40 bytes
+		{name: "Same config is charged once", globalLimit: Limit(40), sameConfig: true, expected: 500 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			innerConfig := NewBandwithConfig(tt.globalLimit, tt.innerLimit)
+			outerConfig := NewBandwithConfig(nil, tt.outerLimit)
+			if tt.sameConfig {
+				outerConfig = innerConfig
+			}
+
+			connRead, connWrite := net.Pipe()
+			inner := NewThrottledConnection(connWrite, NewConnectionBandwithConfig(innerConfig))
+			outer := NewThrottledConnection(unwrapConn{inner}, NewConnectionBandwithConfig(outerConfig))
+			go readDataFromConn(connRead)
+
+			// the first write takes the burst of the limiters
+			if _, err := outer.Write(make([]byte, 20)); err != nil {
+				t.Fatal(err)
+			}
+
+			start := time.Now()
+			if _, err := outer.Write(make([]byte, 40)); err != nil {
+				t.Fatal(err)
+			}
+			elapsed := time.Since(start)
+			if elapsed < tt.expected-300*time.Millisecond || elapsed > tt.expected+500*time.Millisecond {
+				t.Errorf("expected the write to take about %v, took %v", tt.expected, elapsed)
+			}
+
+			outer.Close()
+			if stats := innerConfig.Stats(); stats.BytesWritten != 60 {
+				t.Errorf("expected the inner config to count 60 bytes once, got %d", stats.BytesWritten)
+			}
+			if stats := outerConfig.Stats(); !tt.sameConfig && stats.BytesWritten != 60 {
+				t.Errorf("expected the outer config to count 60 bytes, got %d", stats.BytesWritten)
+			}
+		})
+	}
+}
+
+func TestRateLimitedConnection_NestedContext(t *testing.T) {
+	connRead, connWrite := net.Pipe()
+	inner := NewThrottledConnection(connWrite, NewConnectionBandwithConfig(NewBandwithConfig(nil, Limit(20))))
+	outer := NewThrottledConnection(unwrapConn{inner}, NewConnectionBandwithConfig(NewBandwithConfig(nil, nil)))
+	go readDataFromConn(connRead)
+	defer outer.Close()
+
+	if _, err := outer.Write(make([]byte, 20)); err != nil {
+		t.Fatal(err)
+	}
+
+	// the wait for the inner limit is interrupted by the context of the outer connection
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := outer.WriteContext(ctx, make([]byte, 20))
+	if !errors.Is(err, ErrThrottleCancelled) {
+		t.Errorf("expected ErrThrottleCancelled, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("expected the wait to be cancelled with the context, took %v", elapsed)
+	}
+}
@@ -0,0 +1,103 @@
+package netlistener
+
+import (
+	"time"
+)
+
+// offPeakWindow lifts all limits while the time of day in its location is within the window
+type offPeakWindow struct {
+	window   TimeWindow
+	location *time.Location
+	// timer wakes the operations waiting for the limiters when the window starts
+	timer *time.Timer
+}
+
+func (w *offPeakWindow) active(now time.Time) bool {
+	return w.window.contains(now.In(w.location))
+}
+
+// nextStart returns when the window starts after now
+func (w *offPeakWindow) nextStart(now time.Time) time.Time {
+	local := now.In(w.location)
+	hour, minute := int(w.window.from/time.Hour), int(w.window.from%time.Hour/time.Minute)
+
+	for day := 0; day <= 7; day++ {
+		start := time.Date(local.Year(), local.Month(), local.Day()+day, hour, minute, 0, 0, w.location)
+		if start.After(now) && w.window.contains(start) {
+			return start
+		}
+	}
+
+	return now.Add(24 * time.Hour)
+}
+
+func (w *offPeakWindow) String() string {
+	return w.window.From + "-" + w.window.To + " " + w.location.String()
+}
+
+// SetOffPeakUnlimited lifts all limits of the connections every day between start and end in "15:04" format, e.g. "22:00"
+// and "06:00" for the night, in the location, nil meaning the local time. Connections which are open when the window starts
+// are unlimited for its duration and throttled again when it ends. Empty start and end disable the window
+func (c *BandwidthConfig) SetOffPeakUnlimited(start, end string, location *time.Location) error {
+	var next *offPeakWindow
+	if start != "" || end != "" {
+		window := TimeWindow{From: start, To: end}
+		if err := window.compile(); err != nil {
+			return err
+		}
+		if location == nil {
+			location = time.Local
+		}
+
+		next = &offPeakWindow{window: window, location: location}
+	}
+
+	c.mu.Lock()
+	if c.offPeak != nil {
+		c.offPeak.timer.Stop()
+	}
+	c.offPeak = next
+	if next != nil {
+		c.scheduleOffPeakLocked(next)
+	}
+	c.mu.Unlock()
+
+	// operations waiting now may be within the new window
+	c.limitUpdates.Notify()
+
+	return nil
+}
+
+// scheduleOffPeakLocked wakes the waiting operations at the next start of the window, the lock of the config has to be held
+func (c *BandwidthConfig) scheduleOffPeakLocked(window *offPeakWindow) {
+	window.timer = time.AfterFunc(time.Until(window.nextStart(time.Now())), func() {
+		c.limitUpdates.Notify()
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		if c.offPeak == window {
+			c.scheduleOffPeakLocked(window)
+		}
+	})
+}
+
+// offPeakUnlimited reports whether the limits are lifted by the off-peak window at the time
+func (c *BandwidthConfig) offPeakUnlimited(now time.Time) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.offPeak != nil && c.offPeak.active(now)
+}
+
+// OffPeakUnlimited returns the off-peak window as "22:00-06:00 Europe/Berlin", empty if there is none
+func (c *BandwidthConfig) OffPeakUnlimited() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.offPeak == nil {
+		return ""
+	}
+
+	return c.offPeak.String()
+}
@@ -0,0 +1,80 @@
+package netlistener
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestOffPeakWindow(t *testing.T) {
+	window := &offPeakWindow{window: TimeWindow{From: "22:00", To: "06:00"}, location: time.UTC}
+	if err := window.window.compile(); err != nil {
+		t.Fatal(err)
+	}
+
+	day := func(hour int) time.Time {
+		return time.Date(2024, 3, 1, hour, 0, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name      string
+		now       time.Time
+		active    bool
+		nextStart time.Time
+	}{
+		{name: "Peak hours", now: day(12), nextStart: day(22)},
+		{name: "Before midnight", now: day(23), active: true, nextStart: day(22).AddDate(0, 0, 1)},
+		{name: "After midnight", now: day(3), active: true, nextStart: day(22)},
+		{name: "Other time zone", now: time.Date(2024, 3, 1, 23, 0, 0, 0, time.FixedZone("UTC+2", 2*3600)), nextStart: day(22)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if active := window.active(tt.now); active != tt.active {
+				t.Errorf("expected active %v, got %v", tt.active, active)
+			}
+			if next := window.nextStart(tt.now); !next.Equal(tt.nextStart) {
+				t.Errorf("expected the next start at %v, got %v", tt.nextStart, next)
+			}
+		})
+	}
+}
+
+func TestRateLimitedConnection_OffPeakUnlimited(t *testing.T) {
+	now := time.Now().UTC()
+	tests := []struct {
+		name       string
+		start, end time.Time
+		unlimited  bool
+	}{
+		{name: "Within the window", start: now.Add(-time.Hour), end: now.Add(time.Hour), unlimited: true},
+		{name: "Outside of the window", start: now.Add(time.Hour), end: now.Add(2 * time.Hour)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := NewBandwithConfig(nil, ptr(10))
+			if err := config.SetOffPeakUnlimited(tt.start.Format("15:04"), tt.end.Format("15:04"), time.UTC); err != nil {
+				t.Fatal(err)
+			}
+			defer config.SetOffPeakUnlimited("", "", nil)
+
+			_, server := net.Pipe()
+			conn := NewThrottledConnection(server, NewConnectionBandwithConfig(config))
+			defer conn.Close()
+
+			if unlimited := len(conn.activeLimiters(false)) == 0; unlimited != tt.unlimited {
+				t.Errorf("expected unlimited %v, got %v", tt.unlimited, unlimited)
+			}
+		})
+	}
+
+	config := NewBandwithConfig(nil, nil)
+	if err := config.SetOffPeakUnlimited("22:00", "6am", nil); err == nil {
+		t.Error("expected an error for an invalid time of day")
+	}
+	if err := config.SetOffPeakUnlimited("22:00", "06:00", time.UTC); err != nil || config.Snapshot().OffPeakUnlimited != "22:00-06:00 UTC" {
+		t.Errorf("expected the window in the snapshot, got %q: %v", config.Snapshot().OffPeakUnlimited, err)
+	}
+	config.SetOffPeakUnlimited("", "", nil)
+}
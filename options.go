@@ -0,0 +1,156 @@
+package netlistener
+
+import (
+	"net"
+	"time"
+)
+
+// Option configures a Listener when it is created, it is a shorthand for calling the setter of the Listener afterwards.
+// An error of an option fails NewListener
+type Option func(l *Listener) error
+
+// WithTCPKeepAlive enables TCP keep-alive probes with the config on every accepted TCP connection,
+// so connections to dead peers fail even while they are throttled and do not send anything
+func WithTCPKeepAlive(config net.KeepAliveConfig) Option {
+	return func(l *Listener) error {
+		l.SetTCPKeepAlive(&config)
+		return nil
+	}
+}
+
+// WithLivenessProbe probes the peers of connections which are not covered by TCP keep-alive,
+// see SetLivenessProbe
+func WithLivenessProbe(probe LivenessProbe) Option {
+	return func(l *Listener) error {
+		l.SetLivenessProbe(&probe)
+		return nil
+	}
+}
+
+// WithBucketPersistence restores the global token buckets from the file when the listener is created and saves them
+// when it is closed, see SetBucketPersistence
+func WithBucketPersistence(path string, maxAge time.Duration) Option {
+	return func(l *Listener) error {
+		return l.SetBucketPersistence(path, maxAge)
+	}
+}
+
+// WithAcceptPool processes accepted connections with a bounded pool of workers, see SetAcceptPool
+func WithAcceptPool(pool AcceptPool) Option {
+	return func(l *Listener) error {
+		return l.SetAcceptPool(&pool)
+	}
+}
+
+// WithServeConcurrency limits the number of handlers Serve runs at once, see SetServeConcurrency
+func WithServeConcurrency(handlers int) Option {
+	return func(l *Listener) error {
+		l.SetServeConcurrency(handlers)
+		return nil
+	}
+}
+
+// WithErrorHandler sets the handler receiving the errors and recovered panics of background work, see SetErrorHandler
+func WithErrorHandler(handler ErrorHandler) Option {
+	return func(l *Listener) error {
+		l.SetErrorHandler(handler)
+		return nil
+	}
+}
+
+// WithConnTracer traces every connection with the tracer, see SetConnTracer
+func WithConnTracer(tracer ConnTracer) Option {
+	return func(l *Listener) error {
+		l.SetConnTracer(tracer)
+		return nil
+	}
+}
+
+// WithClock sets the time source of the limiters, see SetClock
+func WithClock(clock Clock) Option {
+	return func(l *Listener) error {
+		l.SetClock(clock)
+		return nil
+	}
+}
+
+// WithAccountingMode chooses between per operation and sampled accounting, see SetAccountingMode
+func WithAccountingMode(mode AccountingMode, interval time.Duration) Option {
+	return func(l *Listener) error {
+		return l.SetAccountingMode(mode, interval)
+	}
+}
+
+// WithAbuseDetection emits EventAbuseDetected when the connections of many distinct sources were rejected, see SetAbuseDetection
+func WithAbuseDetection(detection AbuseDetection) Option {
+	return func(l *Listener) error {
+		return l.SetAbuseDetection(&detection)
+	}
+}
+
+// WithPeerPrefix aggregates remote IPs into prefixes for the per IP state and caps, see SetPeerPrefix
+func WithPeerPrefix(prefix PeerPrefix) Option {
+	return func(l *Listener) error {
+		return l.SetPeerPrefix(&prefix)
+	}
+}
+
+// WithPeerEviction bounds the per IP state, see SetPeerEviction
+func WithPeerEviction(eviction PeerEviction) Option {
+	return func(l *Listener) error {
+		return l.SetPeerEviction(&eviction)
+	}
+}
+
+// WithWarmPeers prepares the state of the expected remote IPs, see WarmPeers
+func WithWarmPeers(ips []string) Option {
+	return func(l *Listener) error {
+		_, err := l.WarmPeers(ips)
+		return err
+	}
+}
+
+// WithStatsBatching makes the connections add their bytes to the stats in batches, see SetStatsBatching
+func WithStatsBatching(batching StatsBatching) Option {
+	return func(l *Listener) error {
+		return l.SetStatsBatching(&batching)
+	}
+}
+
+// WithTimerWheel wakes the waits for the limiters from a timer wheel, see SetTimerWheel
+func WithTimerWheel(wheel TimerWheel) Option {
+	return func(l *Listener) error {
+		return l.SetTimerWheel(&wheel)
+	}
+}
+
+// WithOffPeakUnlimited lifts all limits every day between start and end in "15:04" format in the location,
+// e.g. WithOffPeakUnlimited("22:00", "06:00", nil) for unlimited nights in the local time, see SetOffPeakUnlimited
+func WithOffPeakUnlimited(start, end string, location *time.Location) Option {
+	return func(l *Listener) error {
+		return l.SetOffPeakUnlimited(start, end, location)
+	}
+}
+
+// WithBoost lets connections transfer at full speed at their start, see SetBoost
+func WithBoost(boost Boost) Option {
+	return func(l *Listener) error {
+		return l.SetBoost(&boost)
+	}
+}
+
+// WithInterfaceLimits attaches per interface limits shared with other listeners, see SetInterfaceLimits
+func WithInterfaceLimits(limits *InterfaceLimits) Option {
+	return func(l *Listener) error {
+		l.SetInterfaceLimits(limits)
+		return nil
+	}
+}
+
+// WithLocalExemption exempts peers in local address ranges from all limiters, see SetLocalExemption
+func WithLocalExemption(local LocalExemption) Option {
+	return func(l *Listener) error {
+		l.SetLocalExemption(local)
+		return nil
+	}
+}
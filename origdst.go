@@ -0,0 +1,48 @@
+package netlistener
+
+import (
+	"errors"
+	"net"
+)
+
+// ErrOriginalDstUnsupported is returned by OriginalDst on platforms other than linux, for connections which are not TCP sockets
+// and when connection tracking is not available in the kernel
+var ErrOriginalDstUnsupported = errors.New("original destination is not supported for this connection")
+
+// OriginalDst returns the destination the client connected to before the connection was redirected to the listener,
+// e.g. by an iptables REDIRECT or DNAT rule, read with SO_ORIGINAL_DST. Connections which were not redirected, including
+// the ones intercepted with TPROXY, already have it as their local address, which is returned then. Linux only
+func OriginalDst(conn net.Conn) (net.Addr, error) {
+	rawConn, err := syscallConn(conn)
+	if err != nil {
+		return nil, ErrOriginalDstUnsupported
+	}
+
+	return originalDst(rawConn, conn.LocalAddr())
+}
+
+// SetOriginalDst looks the original destination of redirected connections up at accept time and passes it to the classifier
+// as ConnMetadata.OriginalDst, so policies match the true destination instead of the address of the listener.
+// It costs a system call per accepted connection, so it is disabled unless it is set
+func (c *BandwidthConfig) SetOriginalDst(enabled bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.originalDst = enabled
+}
+
+func (c *BandwidthConfig) OriginalDst() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.originalDst
+}
+
+// destination returns the address the client connected to, the original destination when it is known
+func (m ConnMetadata) destination() net.Addr {
+	if m.OriginalDst != nil {
+		return m.OriginalDst
+	}
+
+	return m.LocalAddr
+}
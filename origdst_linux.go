@@ -0,0 +1,85 @@
+//go:build linux
+
+package netlistener
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+	"unsafe"
+)
+
+// soOriginalDst is SO_ORIGINAL_DST of linux/netfilter_ipv4.h, IP6T_SO_ORIGINAL_DST of linux/netfilter_ipv6/ip6_tables.h has the same value
+const soOriginalDst = 80
+
+// originalDst reads the destination recorded by connection tracking. IPv4 connections of dual-stack sockets
+// are IPv6 sockets, the IPv4 destination is tried for them first
+func originalDst(rawConn syscall.RawConn, local net.Addr) (net.Addr, error) {
+	var addr net.Addr
+	var sockErr error
+
+	err := rawConn.Control(func(fd uintptr) {
+		domain, err := syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_DOMAIN)
+		if err != nil {
+			sockErr = err
+			return
+		}
+
+		if ip := addrIP(local); domain == syscall.AF_INET || ip.To4() != nil {
+			var sa syscall.RawSockaddrInet4
+			sockErr = getsockopt(fd, syscall.SOL_IP, unsafe.Pointer(&sa), unsafe.Sizeof(sa))
+			if sockErr == nil {
+				addr = &net.TCPAddr{IP: net.IP(sa.Addr[:]).To16(), Port: networkPort(sa.Port)}
+			}
+			if sockErr == nil || domain == syscall.AF_INET {
+				return
+			}
+		}
+
+		var sa syscall.RawSockaddrInet6
+		if sockErr = getsockopt(fd, syscall.SOL_IPV6, unsafe.Pointer(&sa), unsafe.Sizeof(sa)); sockErr == nil {
+			addr = &net.TCPAddr{IP: net.IP(sa.Addr[:]), Port: networkPort(sa.Port)}
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("accessing socket: %w", err)
+	}
+
+	switch {
+	case errors.Is(sockErr, syscall.ENOENT):
+		// connection tracking knows the connection, but it was not redirected
+		return local, nil
+	case errors.Is(sockErr, syscall.ENOPROTOOPT), errors.Is(sockErr, syscall.EOPNOTSUPP):
+		return nil, ErrOriginalDstUnsupported
+	case sockErr != nil:
+		return nil, fmt.Errorf("getsockopt SO_ORIGINAL_DST: %w", sockErr)
+	}
+
+	return addr, nil
+}
+
+func getsockopt(fd uintptr, level int, value unsafe.Pointer, size uintptr) error {
+	length := uint32(size)
+	_, _, errno := syscall.Syscall6(
+		syscall.SYS_GETSOCKOPT,
+		fd,
+		uintptr(level),
+		soOriginalDst,
+		uintptr(value),
+		uintptr(unsafe.Pointer(&length)),
+		0,
+	)
+	if errno != 0 {
+		return errno
+	}
+
+	return nil
+}
+
+// networkPort converts the port of a raw socket address from network byte order
+func networkPort(port uint16) int {
+	b := (*[2]byte)(unsafe.Pointer(&port))
+
+	return int(b[0])<<8 | int(b[1])
+}
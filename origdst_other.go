@@ -0,0 +1,12 @@
+//go:build !linux
+
+package netlistener
+
+import (
+	"net"
+	"syscall"
+)
+
+func originalDst(rawConn syscall.RawConn, local net.Addr) (net.Addr, error) {
+	return nil, ErrOriginalDstUnsupported
+}
@@ -0,0 +1,48 @@
+package netlistener
+
+import (
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestOriginalDst(t *testing.T) {
+	connRead, connWrite := net.Pipe()
+	defer connRead.Close()
+	defer connWrite.Close()
+
+	if _, err := OriginalDst(connWrite); !errors.Is(err, ErrOriginalDstUnsupported) {
+		t.Errorf("expected ErrOriginalDstUnsupported for a pipe, got %v", err)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	client, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	server, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	dst, err := OriginalDst(server)
+	if errors.Is(err, ErrOriginalDstUnsupported) {
+		t.Skip("connection tracking is not available")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a connection which was not redirected has its local address as the original destination
+	if dst.String() != server.LocalAddr().String() {
+		t.Errorf("expected %v, got %v", server.LocalAddr(), dst)
+	}
+}
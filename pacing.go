@@ -0,0 +1,113 @@
+package netlistener
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"runtime"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// pace waits until all limiters allow n bytes. The tokens are reserved on all of them at once,
+// so the wait is as long as the longest of their delays.
+// With a spin, it only sleeps until spin before the tokens are available and busy waits for the rest,
+// since platforms with coarse timers coalesce short sleeps, which makes low limits bursty.
+// It fails with os.ErrDeadlineExceeded right away if the bytes would not be allowed before a non zero deadline,
+// with ErrThrottleCancelled if the context is done and with net.ErrClosed if closed is closed during the wait.
+// When changed is closed during the wait, it fails with errLimitsChanged, so the caller can wait again with the new limits
+// instead of sleeping out the delay computed with the old ones. In all cases the reserved tokens are refunded.
+// The limiters only see the time of the clock, the deadline is compared to the delay, so it may come from the system clock
+// The sleep is woken up by the timer wheel if there is one
+func pace(ctx context.Context, clock Clock, wheel *timerWheel, closed, changed <-chan struct{}, limiters []*rate.Limiter, n int, spin time.Duration, deadline time.Time) error {
+	tokens := make([]int, len(limiters))
+	for i := range tokens {
+		tokens[i] = n
+	}
+
+	return paceTokens(ctx, clock, wheel, closed, changed, limiters, tokens, spin, deadline)
+}
+
+// paceTokens is pace taking a different number of tokens from each limiter, e.g. when bytes cost more on some of them
+func paceTokens(ctx context.Context, clock Clock, wheel *timerWheel, closed, changed <-chan struct{}, limiters []*rate.Limiter, tokens []int, spin time.Duration, deadline time.Time) error {
+	now := clock.Now()
+	ready := now
+
+	reservations := make([]*rate.Reservation, 0, len(limiters))
+	refund := func() {
+		now := clock.Now()
+		for i, reservation := range reservations {
+			refundReservation(limiters[i], reservation, tokens[i], now)
+		}
+	}
+
+	for i, limiter := range limiters {
+		reservation := limiter.ReserveN(now, tokens[i])
+		if !reservation.OK() {
+			refund()
+			return fmt.Errorf("%w: %d bytes, burst %d", ErrLimitExceededBurst, tokens[i], limiter.Burst())
+		}
+
+		reservations = append(reservations, reservation)
+		if delay := reservation.DelayFrom(now); now.Add(delay).After(ready) {
+			ready = now.Add(delay)
+		}
+	}
+
+	if !deadline.IsZero() && ready.Sub(now) > time.Until(deadline) {
+		refund()
+		return os.ErrDeadlineExceeded
+	}
+
+	if sleep := ready.Sub(clock.Now()) - spin; sleep > 0 {
+		expired, stop := sleepTimer(wheel, sleep)
+		defer stop()
+
+		select {
+		case <-expired:
+		case <-ctx.Done():
+			refund()
+			return fmt.Errorf("%w: %w", ErrThrottleCancelled, ctx.Err())
+		case <-closed:
+			refund()
+			return net.ErrClosed
+		case <-changed:
+			refund()
+			return errLimitsChanged
+		}
+	}
+
+	for clock.Now().Before(ready) {
+		if err := ctx.Err(); err != nil {
+			refund()
+			return fmt.Errorf("%w: %w", ErrThrottleCancelled, err)
+		}
+		select {
+		case <-closed:
+			refund()
+			return net.ErrClosed
+		case <-changed:
+			refund()
+			return errLimitsChanged
+		default:
+		}
+
+		runtime.Gosched()
+	}
+
+	return nil
+}
+
+// refundReservation gives the tokens of a reservation of n tokens back to the limiter.
+// Reservation.Cancel only refunds reservations whose time to act has not come yet,
+// the tokens of the others are put back by reserving a negative amount, which the limiter caps at the burst
+func refundReservation(limiter *rate.Limiter, reservation *rate.Reservation, n int, now time.Time) {
+	if reservation.DelayFrom(now) > 0 {
+		reservation.CancelAt(now)
+		return
+	}
+
+	limiter.ReserveN(now, -n)
+}
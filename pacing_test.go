@@ -0,0 +1,93 @@
+package netlistener
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+func TestPace(t *testing.T) {
+	tests := []struct {
+		name          string
+		n             int
+		assertionFunc func(t *testing.T, elapsed time.Duration, err error)
+	}{
+		{
+			name: "Waits until the tokens are available",
+			n:    10,
+			assertionFunc: func(t *testing.T, elapsed time.Duration, err error) {
+				if err != nil {
+					t.Fatal(err)
+				}
+				// 10 bytes at 1000 B/s take 10ms
+				if elapsed < 9*time.Millisecond || elapsed > 15*time.Millisecond {
+					t.Errorf("expected to wait 10ms, waited %s", elapsed)
+				}
+			},
+		},
+		{
+			name: "Fails for more than the burst",
+			n:    2000,
+			assertionFunc: func(t *testing.T, elapsed time.Duration, err error) {
+				if err == nil {
+					t.Error("expected an error for more than the burst")
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limiter := rate.NewLimiter(1000, 1000)
+			limiter.AllowN(time.Now(), 1000)
+
+			start := time.Now()
+			err := pace(context.Background(), SystemClock, nil, nil, nil, []*rate.Limiter{limiter}, tt.n, 2*time.Millisecond, time.Time{})
+
+			tt.assertionFunc(t, time.Since(start), err)
+		})
+	}
+}
+
+func TestPace_Refund(t *testing.T) {
+	tests := []struct {
+		name     string
+		ctx      func() context.Context
+		deadline time.Time
+	}{
+		{
+			name: "Cancelled context",
+			ctx: func() context.Context {
+				ctx, cancel := context.WithCancel(context.Background())
+				time.AfterFunc(20*time.Millisecond, cancel)
+				return ctx
+			},
+		},
+		{
+			name:     "Deadline before the tokens are available",
+			ctx:      context.Background,
+			deadline: time.Now().Add(20 * time.Millisecond),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// the second limiter is empty, so the bytes are allowed only after a second
+			limiters := []*rate.Limiter{rate.NewLimiter(100, 100), rate.NewLimiter(100, 100)}
+			limiters[1].AllowN(time.Now(), 100)
+
+			if err := pace(tt.ctx(), SystemClock, nil, nil, nil, limiters, 100, 0, tt.deadline); err == nil {
+				t.Fatal("expected the wait to fail")
+			}
+
+			if tokens := limiters[0].Tokens(); tokens < 99 {
+				t.Errorf("expected the tokens of the first limiter to be refunded, %f left", tokens)
+			}
+			if tokens := limiters[1].Tokens(); tokens < -1 || tokens > 10 {
+				t.Errorf("expected the reservation on the second limiter to be refunded, %f left", tokens)
+			}
+		})
+	}
+}
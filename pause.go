@@ -0,0 +1,60 @@
+package netlistener
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"time"
+)
+
+// Pause holds back the reads and writes of the connection until Resume without closing it, e.g. while the tenant
+// of the connection is suspended. Operations already past the check finish, the following ones wait.
+// A wait ending at the deadline or with the context while the connection is still paused fails with ErrConnPaused
+func (c *ThrottledConn) Pause() {
+	resumed := make(chan struct{})
+	c.paused.CompareAndSwap(nil, &resumed)
+}
+
+// Resume lets the reads and writes held back by Pause continue
+func (c *ThrottledConn) Resume() {
+	if resumed := c.paused.Swap(nil); resumed != nil {
+		close(*resumed)
+	}
+}
+
+// Paused reports whether the connection is paused
+func (c *ThrottledConn) Paused() bool {
+	return c.paused.Load() != nil
+}
+
+// waitResumed holds an operation back while the connection is paused, up to the deadline
+func (c *ThrottledConn) waitResumed(ctx context.Context, deadline time.Time) error {
+	resumed := c.paused.Load()
+	if resumed == nil {
+		return nil
+	}
+
+	var expired <-chan time.Time
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	// the connection may be paused again right after it was resumed
+	for resumed != nil {
+		select {
+		case <-*resumed:
+			resumed = c.paused.Load()
+		case <-ctx.Done():
+			return fmt.Errorf("%w: %w: %w", ErrConnPaused, ErrThrottleCancelled, ctx.Err())
+		case <-expired:
+			return fmt.Errorf("%w: %w", ErrConnPaused, os.ErrDeadlineExceeded)
+		case <-c.closed:
+			return net.ErrClosed
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,96 @@
+package netlistener
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestThrottledConn_Pause(t *testing.T) {
+	tests := []struct {
+		name     string
+		deadline time.Duration
+		cancel   bool
+		close    bool
+		resume   bool
+		// errs are the errors the write fails with, none if it succeeds
+		errs []error
+	}{
+		{name: "Resumed", resume: true},
+		{name: "Deadline while paused", deadline: 50 * time.Millisecond, errs: []error{ErrConnPaused, os.ErrDeadlineExceeded}},
+		{name: "Context done while paused", cancel: true, errs: []error{ErrConnPaused, ErrThrottleCancelled, context.Canceled}},
+		{name: "Closed while paused", close: true, errs: []error{net.ErrClosed}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, server := net.Pipe()
+			defer client.Close()
+			go readDataFromConn(client)
+
+			conn := NewThrottledConnection(server, NewConnConfig(NewBandwidthConfig(nil, nil)))
+			defer conn.Close()
+
+			conn.Pause()
+			if !conn.Paused() {
+				t.Fatal("expected the connection to be paused")
+			}
+			if tt.deadline > 0 {
+				conn.SetWriteDeadline(time.Now().Add(tt.deadline))
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			done := make(chan error, 1)
+			go func() {
+				_, err := conn.WriteContext(ctx, []byte("hello"))
+				done <- err
+			}()
+
+			select {
+			case err := <-done:
+				if tt.deadline == 0 {
+					t.Fatalf("expected the write to be held back, it returned %v", err)
+				}
+				done <- err
+			case <-time.After(100 * time.Millisecond):
+			}
+
+			switch {
+			case tt.resume:
+				conn.Resume()
+			case tt.cancel:
+				cancel()
+			case tt.close:
+				conn.Close()
+			}
+
+			var err error
+			select {
+			case err = <-done:
+			case <-time.After(time.Second):
+				t.Fatal("expected the write to return")
+			}
+
+			if (err != nil) != (len(tt.errs) > 0) {
+				t.Fatalf("expected errors %v, got %v", tt.errs, err)
+			}
+			for _, expected := range tt.errs {
+				if !errors.Is(err, expected) {
+					t.Errorf("expected error wrapping %v, got %v", expected, err)
+				}
+			}
+			var throttleErr *ThrottleError
+			if err != nil && !errors.As(err, &throttleErr) {
+				t.Errorf("expected a ThrottleError, got %T", err)
+			}
+			if throttleErr != nil && throttleErr.Timeout() != (tt.deadline > 0) {
+				t.Errorf("expected Timeout() to be %t", tt.deadline > 0)
+			}
+		})
+	}
+}
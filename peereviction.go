@@ -0,0 +1,146 @@
+package netlistener
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// PeerEviction bounds the per IP state, so memory stays bounded when spoofed addresses or large NATs bring
+// an endless stream of new remote IPs. Peers with open connections are never evicted
+type PeerEviction struct {
+	// MaxEntries is the number of peers kept, the least recently seen ones are evicted when it is exceeded. Zero keeps any number
+	MaxEntries int `json:"max_entries,omitempty"`
+	// TTL is how long a peer is kept after it was last seen, zero keeps peers until they are the least recently seen ones
+	TTL time.Duration `json:"ttl,omitempty"`
+	// OnEvict is called with the IP and the final state of every evicted peer, e.g. to save it
+	OnEvict func(ip string, state PeerState) `json:"-"`
+}
+
+func (e PeerEviction) validate() error {
+	if e.MaxEntries < 0 || e.TTL < 0 || e.MaxEntries == 0 && e.TTL == 0 {
+		return fmt.Errorf("peer eviction needs a maximum number of entries or a TTL which are not negative, got %d entries and %s", e.MaxEntries, e.TTL)
+	}
+
+	return nil
+}
+
+// evictionBatch is the share of the maximum number of entries evicted at once when it is exceeded,
+// so the least recently seen peers are not searched for on every new peer
+const evictionBatch = 16
+
+// SetEviction bounds the registry, entries which are over the bounds already are evicted right away. Nil keeps all entries
+func (r *peerRegistry) SetEviction(eviction *PeerEviction) {
+	r.mu.Lock()
+	r.eviction = eviction
+	evicted := r.evictLocked(time.Now(), true)
+	r.mu.Unlock()
+
+	r.evicted(eviction, evicted)
+}
+
+func (r *peerRegistry) Eviction() *PeerEviction {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.eviction
+}
+
+// Evict removes the entries which are idle for longer than the TTL or over the maximum number of entries,
+// it returns the number of evicted entries
+func (r *peerRegistry) Evict() int {
+	r.mu.Lock()
+	eviction := r.eviction
+	evicted := r.evictLocked(time.Now(), true)
+	r.mu.Unlock()
+
+	r.evicted(eviction, evicted)
+
+	return len(evicted)
+}
+
+// evictLocked removes the expired entries and, once the maximum number of entries is exceeded, the least recently seen ones
+// down to a batch below it. Expired entries are searched for at most every quarter of the TTL, unless forced
+func (r *peerRegistry) evictLocked(now time.Time, force bool) []*peerEntry {
+	eviction := r.eviction
+	if eviction == nil {
+		return nil
+	}
+
+	var evicted []*peerEntry
+	if eviction.TTL > 0 && (force || now.Sub(r.lastSweep) >= eviction.TTL/4) {
+		r.lastSweep = now
+		expiry := now.Add(-eviction.TTL).UnixNano()
+		for key, entry := range r.peers {
+			if entry.open.Load() == 0 && entry.lastSeen.Load() < expiry {
+				delete(r.peers, key)
+				evicted = append(evicted, entry)
+			}
+		}
+	}
+
+	if eviction.MaxEntries <= 0 || len(r.peers) <= eviction.MaxEntries {
+		return evicted
+	}
+
+	idle := make([]*peerEntry, 0, len(r.peers))
+	for _, entry := range r.peers {
+		if entry.open.Load() == 0 {
+			idle = append(idle, entry)
+		}
+	}
+	slices.SortFunc(idle, func(a, b *peerEntry) int {
+		return cmp.Compare(a.lastSeen.Load(), b.lastSeen.Load())
+	})
+
+	excess := len(r.peers) - eviction.MaxEntries + max(eviction.MaxEntries/evictionBatch, 1) - 1
+	for _, entry := range idle[:min(excess, len(idle))] {
+		delete(r.peers, entry.key)
+		evicted = append(evicted, entry)
+	}
+
+	return evicted
+}
+
+// evicted passes the evicted entries to the callback of the eviction, it must not be called with the lock held
+func (r *peerRegistry) evicted(eviction *PeerEviction, entries []*peerEntry) {
+	if eviction == nil || eviction.OnEvict == nil {
+		return
+	}
+
+	for _, entry := range entries {
+		eviction.OnEvict(entry.key, entry.state())
+	}
+}
+
+// SetPeerEviction bounds the per IP state by its number of entries and their idle time, see PeerEviction. Nil keeps all peers
+func (c *BandwidthConfig) SetPeerEviction(eviction *PeerEviction) error {
+	if eviction != nil {
+		if err := eviction.validate(); err != nil {
+			return err
+		}
+		copied := *eviction
+		eviction = &copied
+	}
+
+	c.peers.SetEviction(eviction)
+
+	return nil
+}
+
+// PeerEviction returns how the per IP state is bounded, nil if all peers are kept
+func (c *BandwidthConfig) PeerEviction() *PeerEviction {
+	if eviction := c.peers.Eviction(); eviction != nil {
+		copied := *eviction
+		return &copied
+	}
+
+	return nil
+}
+
+// EvictPeers evicts the peers which are over the bounds right away instead of when the next new peer connects.
+// It returns the number of evicted peers
+func (c *BandwidthConfig) EvictPeers() int {
+	return c.peers.Evict()
+}
@@ -0,0 +1,101 @@
+package netlistener
+
+import (
+	"fmt"
+	"net"
+)
+
+// PeerPrefix aggregates remote IPs into prefixes when keying the per IP state, the penalty box and the per IP connection cap.
+// Single IPv6 addresses are free for an attacker, who usually gets a /64 or a /56, so limits per address are trivially bypassed
+type PeerPrefix struct {
+	// IPv4 is the length of the prefixes IPv4 addresses are aggregated to, e.g. 24. Zero keeps single addresses
+	IPv4 int `json:"ipv4,omitempty"`
+	// IPv6 is the length of the prefixes IPv6 addresses are aggregated to, e.g. 64 or 56. Zero keeps single addresses
+	IPv6 int `json:"ipv6,omitempty"`
+}
+
+func (p PeerPrefix) validate() error {
+	if p.IPv4 < 0 || p.IPv4 > 8*net.IPv4len || p.IPv6 < 0 || p.IPv6 > 8*net.IPv6len {
+		return fmt.Errorf("peer prefix lengths have to be within 0-32 for IPv4 and 0-128 for IPv6, got /%d and /%d", p.IPv4, p.IPv6)
+	}
+
+	return nil
+}
+
+// key returns the key the IP is counted under, the prefix it belongs to, e.g. "2001:db8:0:1::/64", or the IP itself
+// if it is not aggregated. A nil prefix keeps single addresses
+func (p *PeerPrefix) key(ip net.IP) string {
+	if p == nil {
+		return ip.String()
+	}
+
+	bits, length := p.IPv6, 8*net.IPv6len
+	if ip4 := ip.To4(); ip4 != nil {
+		ip, bits, length = ip4, p.IPv4, 8*net.IPv4len
+	}
+	if bits == 0 || bits == length {
+		return ip.String()
+	}
+
+	mask := net.CIDRMask(bits, length)
+
+	return (&net.IPNet{IP: ip.Mask(mask), Mask: mask}).String()
+}
+
+// connKey returns the key of the remote IP of the connection, empty if it is not IP based
+func (p *PeerPrefix) connKey(conn net.Conn) string {
+	if ip := remoteIP(conn); ip != nil {
+		return p.key(ip)
+	}
+
+	return ""
+}
+
+// SetPeerPrefix aggregates remote IPs into prefixes for the per IP state, the penalty box and the per IP connection cap,
+// see PeerPrefix. Nil keeps single addresses. It applies to connections accepted afterwards, so it should be set
+// before connections are accepted
+func (c *BandwidthConfig) SetPeerPrefix(prefix *PeerPrefix) error {
+	if prefix != nil {
+		if err := prefix.validate(); err != nil {
+			return err
+		}
+		copied := *prefix
+		prefix = &copied
+	}
+
+	c.peers.setPrefix(prefix)
+	c.caps.setPrefix(prefix)
+
+	return nil
+}
+
+// PeerPrefix returns how remote IPs are aggregated, nil if single addresses are kept
+func (c *BandwidthConfig) PeerPrefix() *PeerPrefix {
+	if prefix := c.peers.Prefix(); prefix != nil {
+		copied := *prefix
+		return &copied
+	}
+
+	return nil
+}
+
+func (r *peerRegistry) setPrefix(prefix *PeerPrefix) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.prefix = prefix
+}
+
+func (r *peerRegistry) Prefix() *PeerPrefix {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.prefix
+}
+
+func (c *connCaps) setPrefix(prefix *PeerPrefix) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.prefix = prefix
+}
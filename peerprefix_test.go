@@ -0,0 +1,85 @@
+package netlistener
+
+import (
+	"net"
+	"testing"
+)
+
+func TestPeerPrefix_Key(t *testing.T) {
+	tests := []struct {
+		name     string
+		prefix   *PeerPrefix
+		ip       string
+		expected string
+	}{
+		{name: "No prefix", ip: "2001:db8:0:1:2:3:4:5", expected: "2001:db8:0:1:2:3:4:5"},
+		{name: "IPv6 /64", prefix: &PeerPrefix{IPv6: 64}, ip: "2001:db8:0:1:2:3:4:5", expected: "2001:db8:0:1::/64"},
+		{name: "IPv6 /56", prefix: &PeerPrefix{IPv6: 56}, ip: "2001:db8:0:1ff:2:3:4:5", expected: "2001:db8:0:100::/56"},
+		{name: "IPv6 full length", prefix: &PeerPrefix{IPv6: 128}, ip: "2001:db8::1", expected: "2001:db8::1"},
+		{name: "IPv4 /24", prefix: &PeerPrefix{IPv4: 24}, ip: "192.0.2.77", expected: "192.0.2.0/24"},
+		{name: "IPv4 kept", prefix: &PeerPrefix{IPv6: 64}, ip: "192.0.2.77", expected: "192.0.2.77"},
+		{name: "IPv4-mapped IPv6 address", prefix: &PeerPrefix{IPv4: 24, IPv6: 64}, ip: "::ffff:192.0.2.77", expected: "192.0.2.0/24"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.prefix.key(net.ParseIP(tt.ip)); got != tt.expected {
+				t.Errorf("key() = %s, expected %s", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestBandwidthConfig_SetPeerPrefix(t *testing.T) {
+	tests := []struct {
+		name   string
+		prefix *PeerPrefix
+		// remotes are the addresses of two connections, shared tells whether they are counted as one peer
+		remotes [2]string
+		shared  bool
+		wantErr bool
+	}{
+		{name: "Single addresses", remotes: [2]string{"2001:db8::1", "2001:db8::2"}, shared: false},
+		{name: "Same /64", prefix: &PeerPrefix{IPv6: 64}, remotes: [2]string{"2001:db8::1", "2001:db8::2"}, shared: true},
+		{name: "Different /64", prefix: &PeerPrefix{IPv6: 64}, remotes: [2]string{"2001:db8::1", "2001:db8:0:1::1"}, shared: false},
+		{name: "Same /24", prefix: &PeerPrefix{IPv4: 24}, remotes: [2]string{"192.0.2.1", "192.0.2.200"}, shared: true},
+		{name: "Invalid IPv4 length", prefix: &PeerPrefix{IPv4: 33}, wantErr: true},
+		{name: "Invalid IPv6 length", prefix: &PeerPrefix{IPv6: -1}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := NewBandwidthConfig(nil, nil)
+			err := config.SetPeerPrefix(tt.prefix)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SetPeerPrefix() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				if config.PeerPrefix() != nil {
+					t.Error("expected the invalid prefix not to be set")
+				}
+				return
+			}
+			config.SetPeerTracking(true)
+			config.SetMaxConns(0, 1)
+
+			conns := make([]*addrConn, len(tt.remotes))
+			for i, remote := range tt.remotes {
+				connRead, connWrite := net.Pipe()
+				defer connRead.Close()
+				conns[i] = &addrConn{Conn: connWrite, remoteAddr: &net.TCPAddr{IP: net.ParseIP(remote), Port: 1234}}
+			}
+
+			first := NewThrottledConnection(conns[0], NewConnConfig(config))
+			defer first.Close()
+
+			_, _, _, admitted := config.caps.admit(conns[1], 1)
+			if admitted == tt.shared {
+				t.Errorf("expected the second connection to be admitted %v by the per IP cap, got %v", !tt.shared, admitted)
+			}
+			if shared := config.peers.Get(conns[1]) == first.peer; shared != tt.shared {
+				t.Errorf("expected the connections to share the peer %v, got %v", tt.shared, shared)
+			}
+		})
+	}
+}
@@ -0,0 +1,184 @@
+package netlistener
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// PeerState is the accounting kept for a single remote IP across all of its connections
+type PeerState struct {
+	// BytesRead and BytesWritten count towards the PeerQuota, restoring them restores the usage of the quota
+	BytesRead    int64     `json:"bytes_read"`
+	BytesWritten int64     `json:"bytes_written"`
+	Connections  int64     `json:"connections"`
+	LastSeen     time.Time `json:"last_seen"`
+	// PenaltyUntil is set while the peer is in the penalty box
+	PenaltyUntil time.Time `json:"penalty_until"`
+}
+
+// peerEntry is shared by all connections from the same IP, counters are updated on every operation
+type peerEntry struct {
+	key string
+
+	bytesRead    atomic.Int64
+	bytesWritten atomic.Int64
+	connections  atomic.Int64
+	lastSeen     atomic.Int64
+	// open is the number of open connections, peers with open connections are not evicted
+	open atomic.Int64
+
+	penalty peerPenalty
+}
+
+func (p *peerEntry) touch() {
+	p.lastSeen.Store(time.Now().UnixNano())
+}
+
+func (p *peerEntry) state() PeerState {
+	p.penalty.mu.Lock()
+	penaltyUntil := p.penalty.until
+	p.penalty.mu.Unlock()
+
+	return PeerState{
+		BytesRead:    p.bytesRead.Load(),
+		BytesWritten: p.bytesWritten.Load(),
+		Connections:  p.connections.Load(),
+		LastSeen:     time.Unix(0, p.lastSeen.Load()),
+		PenaltyUntil: penaltyUntil,
+	}
+}
+
+// peerRegistry keeps the state of every remote IP seen by the listener.
+// It is disabled by default, since the map grows with every new address
+type peerRegistry struct {
+	enabled bool
+	peers   map[string]*peerEntry
+	// prefix aggregates the remote IPs into the keys of the entries, nil keys them by single addresses
+	prefix *PeerPrefix
+	// eviction bounds the number of entries, nil keeps all of them. lastSweep is when expired entries were last removed
+	eviction  *PeerEviction
+	lastSweep time.Time
+
+	mu sync.RWMutex
+}
+
+func (r *peerRegistry) SetEnabled(enabled bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.enabled = enabled
+	if enabled && r.peers == nil {
+		r.peers = make(map[string]*peerEntry)
+	}
+}
+
+func (r *peerRegistry) Enabled() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.enabled
+}
+
+// Get returns the entry for the remote IP of the connection, or of the prefix it belongs to, creating it if necessary.
+// It returns nil if the registry is disabled or the connection is not IP based
+func (r *peerRegistry) Get(conn net.Conn) *peerEntry {
+	ip := remoteIP(conn)
+	if ip == nil {
+		return nil
+	}
+
+	return r.getByKey(r.Prefix().key(ip))
+}
+
+func (r *peerRegistry) getByKey(key string) *peerEntry {
+	r.mu.RLock()
+	if !r.enabled {
+		r.mu.RUnlock()
+		return nil
+	}
+	entry, ok := r.peers[key]
+	r.mu.RUnlock()
+
+	if ok {
+		return entry
+	}
+
+	r.mu.Lock()
+	var evicted []*peerEntry
+	if entry, ok = r.peers[key]; !ok {
+		entry = &peerEntry{key: key}
+		// new peers count as seen, so they are not the first ones evicted
+		entry.touch()
+		r.peers[key] = entry
+		evicted = r.evictLocked(time.Now(), false)
+	}
+	eviction := r.eviction
+	r.mu.Unlock()
+
+	r.evicted(eviction, evicted)
+
+	return entry
+}
+
+// Warm creates the entries of the keys which are not known yet, growing the map for all of them at once.
+// Warmed entries count as seen when they were created, so eviction keeps them for a TTL. It returns the number of entries created, none if the registry is disabled
+func (r *peerRegistry) Warm(keys []string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.enabled {
+		return 0
+	}
+
+	peers := make(map[string]*peerEntry, len(r.peers)+len(keys))
+	for key, entry := range r.peers {
+		peers[key] = entry
+	}
+
+	created := 0
+	for _, key := range keys {
+		if _, ok := peers[key]; !ok {
+			entry := &peerEntry{key: key}
+			entry.touch()
+			peers[key] = entry
+			created++
+		}
+	}
+	r.peers = peers
+
+	return created
+}
+
+// Snapshot returns a copy of the state of all known peers keyed by IP
+func (r *peerRegistry) Snapshot() map[string]PeerState {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	snapshot := make(map[string]PeerState, len(r.peers))
+	for key, entry := range r.peers {
+		snapshot[key] = entry.state()
+	}
+
+	return snapshot
+}
+
+// Restore merges previously saved state into the registry, counters of already known peers are overwritten
+func (r *peerRegistry) Restore(state map[string]PeerState) {
+	for key, peerState := range state {
+		entry := r.getByKey(key)
+		if entry == nil {
+			return
+		}
+
+		entry.bytesRead.Store(peerState.BytesRead)
+		entry.bytesWritten.Store(peerState.BytesWritten)
+		entry.connections.Store(peerState.Connections)
+		entry.lastSeen.Store(peerState.LastSeen.UnixNano())
+
+		entry.penalty.mu.Lock()
+		entry.penalty.until = peerState.PenaltyUntil
+		entry.penalty.mu.Unlock()
+	}
+}
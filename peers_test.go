@@ -0,0 +1,186 @@
+package netlistener
+
+import (
+	"maps"
+	"net"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestBandwidthConfig_WarmPeers(t *testing.T) {
+	tests := []struct {
+		name string
+		// known are the peers connected before warming
+		known []string
+		ips   []string
+		// expected are the number of peers created and known afterwards
+		expectedCreated int
+		expectedPeers   int
+		wantErr         bool
+	}{
+		{name: "New peers", ips: []string{"192.0.2.1", "192.0.2.2", "2001:db8::1"}, expectedCreated: 3, expectedPeers: 3},
+		{name: "Known peers are kept", known: []string{"192.0.2.1"}, ips: []string{"192.0.2.1", " 192.0.2.2 "}, expectedCreated: 1, expectedPeers: 2},
+		{name: "Duplicates", ips: []string{"192.0.2.1", "192.0.2.1"}, expectedCreated: 1, expectedPeers: 1},
+		{name: "Invalid IP", known: []string{"192.0.2.1"}, ips: []string{"192.0.2.2", "example.com"}, expectedPeers: 1, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := NewBandwidthConfig(nil, nil)
+			config.SetPeerTracking(true)
+			for _, ip := range tt.known {
+				connRead, connWrite := net.Pipe()
+				conn := NewThrottledConnection(&addrConn{Conn: connWrite, remoteAddr: &net.TCPAddr{IP: net.ParseIP(ip)}}, NewConnConfig(config))
+				go readDataFromConn(connRead)
+				conn.Write(make([]byte, 42))
+				conn.Close()
+			}
+
+			created, err := config.WarmPeers(tt.ips)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("WarmPeers() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if created != tt.expectedCreated {
+				t.Errorf("WarmPeers() = %d, expected %d", created, tt.expectedCreated)
+			}
+
+			states := config.PeerStates()
+			if len(states) != tt.expectedPeers {
+				t.Errorf("expected %d peers, got %v", tt.expectedPeers, states)
+			}
+			for _, ip := range tt.known {
+				if state := states[ip]; state.BytesWritten != 42 {
+					t.Errorf("expected the state of %s to be kept, got %+v", ip, state)
+				}
+			}
+		})
+	}
+}
+
+func TestBandwidthConfig_WarmPeersSharedEntry(t *testing.T) {
+	config := NewBandwidthConfig(nil, nil)
+	if _, err := config.WarmPeers([]string{"192.0.2.1"}); err != nil {
+		t.Fatal(err)
+	}
+	warmed := config.peers.getByKey("192.0.2.1")
+
+	connRead, connWrite := net.Pipe()
+	conn := NewThrottledConnection(&addrConn{Conn: connWrite, remoteAddr: &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 1234}}, NewConnConfig(config))
+	defer conn.Close()
+	go readDataFromConn(connRead)
+
+	if conn.peer != warmed {
+		t.Error("expected the connection to use the warmed peer entry")
+	}
+}
+
+func TestPeerRegistry_Eviction(t *testing.T) {
+	tests := []struct {
+		name     string
+		eviction PeerEviction
+		// keys are added in order, each seen a minute after the previous one and the last one now.
+		// The open ones have an open connection
+		keys     []string
+		open     []string
+		expected []string
+		evicted  []string
+	}{
+		{
+			name:     "Least recently seen evicted",
+			eviction: PeerEviction{MaxEntries: 2},
+			keys:     []string{"192.0.2.1", "192.0.2.2", "192.0.2.3", "192.0.2.4"},
+			expected: []string{"192.0.2.3", "192.0.2.4"},
+			evicted:  []string{"192.0.2.1", "192.0.2.2"},
+		},
+		{
+			name:     "Peers with open connections kept",
+			eviction: PeerEviction{MaxEntries: 2},
+			keys:     []string{"192.0.2.1", "192.0.2.2", "192.0.2.3", "192.0.2.4"},
+			open:     []string{"192.0.2.1"},
+			expected: []string{"192.0.2.1", "192.0.2.4"},
+			evicted:  []string{"192.0.2.2", "192.0.2.3"},
+		},
+		{
+			name:     "Expired",
+			eviction: PeerEviction{TTL: 30 * time.Second},
+			keys:     []string{"192.0.2.1", "192.0.2.2", "192.0.2.3"},
+			expected: []string{"192.0.2.3"},
+			evicted:  []string{"192.0.2.1", "192.0.2.2"},
+		},
+		{
+			name:     "Expired peers with open connections kept",
+			eviction: PeerEviction{TTL: 30 * time.Second},
+			keys:     []string{"192.0.2.1", "192.0.2.2", "192.0.2.3"},
+			open:     []string{"192.0.2.1"},
+			expected: []string{"192.0.2.1", "192.0.2.3"},
+			evicted:  []string{"192.0.2.2"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var evicted []string
+			eviction := tt.eviction
+			eviction.OnEvict = func(ip string, state PeerState) {
+				evicted = append(evicted, ip)
+			}
+
+			registry := &peerRegistry{}
+			registry.SetEnabled(true)
+			registry.SetEviction(&eviction)
+
+			start := time.Now().Add(-time.Duration(len(tt.keys)-1) * time.Minute)
+			for i, key := range tt.keys {
+				entry := registry.getByKey(key)
+				entry.lastSeen.Store(start.Add(time.Duration(i) * time.Minute).UnixNano())
+				if slices.Contains(tt.open, key) {
+					entry.open.Add(1)
+				}
+			}
+			registry.Evict()
+
+			keys := slices.Sorted(maps.Keys(registry.Snapshot()))
+			if !slices.Equal(keys, tt.expected) {
+				t.Errorf("expected the peers %v, got %v", tt.expected, keys)
+			}
+			slices.Sort(evicted)
+			if !slices.Equal(evicted, tt.evicted) {
+				t.Errorf("expected the peers %v to be evicted, got %v", tt.evicted, evicted)
+			}
+		})
+	}
+}
+
+func TestBandwidthConfig_SetPeerEviction(t *testing.T) {
+	tests := []struct {
+		name     string
+		eviction *PeerEviction
+		wantErr  bool
+	}{
+		{name: "Maximum number of entries", eviction: &PeerEviction{MaxEntries: 100000}},
+		{name: "TTL", eviction: &PeerEviction{TTL: time.Hour}},
+		{name: "Disabled"},
+		{name: "No bounds", eviction: &PeerEviction{}, wantErr: true},
+		{name: "Negative maximum", eviction: &PeerEviction{MaxEntries: -1, TTL: time.Hour}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := NewBandwidthConfig(nil, nil)
+			err := config.SetPeerEviction(tt.eviction)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SetPeerEviction() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			got := config.PeerEviction()
+			if expected := tt.eviction; tt.wantErr || expected == nil {
+				if got != nil {
+					t.Errorf("PeerEviction() = %+v, expected nil", got)
+				}
+			} else if got == nil || got.MaxEntries != expected.MaxEntries || got.TTL != expected.TTL {
+				t.Errorf("PeerEviction() = %+v, expected %+v", got, expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package netlistener
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// PenaltyPolicy describes when a peer is considered abusive and how it is punished.
+// A peer which got throttled or had connections rejected by the connection caps Threshold times within Window
+// is limited to Limit bytes per second per connection for Cooldown
+type PenaltyPolicy struct {
+	Threshold int           `json:"threshold"`
+	Window    time.Duration `json:"window"`
+	Limit     int           `json:"limit"`
+	Cooldown  time.Duration `json:"cooldown"`
+}
+
+// peerPenalty is the penalty box state of a single peer
+type peerPenalty struct {
+	hits        int
+	windowStart time.Time
+	until       time.Time
+
+	mu sync.Mutex
+}
+
+// penaltyBox applies the penalty policy to peers
+type penaltyBox struct {
+	policy *PenaltyPolicy
+
+	mu sync.RWMutex
+}
+
+func (p *penaltyBox) SetPolicy(policy *PenaltyPolicy) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.policy = policy
+	invalidateFastPath()
+}
+
+func (p *penaltyBox) Policy() *PenaltyPolicy {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.policy
+}
+
+// RecordHit counts a throttled operation or a connection of the peer rejected by the connection caps,
+// returning an event if the peer has just been penalized
+func (p *penaltyBox) RecordHit(peer *peerEntry, now time.Time) (Event, bool) {
+	policy := p.Policy()
+	if policy == nil || peer == nil {
+		return Event{}, false
+	}
+
+	penalty := &peer.penalty
+	penalty.mu.Lock()
+	defer penalty.mu.Unlock()
+
+	if now.Before(penalty.until) {
+		return Event{}, false
+	}
+
+	if now.Sub(penalty.windowStart) > policy.Window {
+		penalty.windowStart = now
+		penalty.hits = 0
+	}
+
+	penalty.hits++
+	if penalty.hits < policy.Threshold {
+		return Event{}, false
+	}
+
+	penalty.hits = 0
+	penalty.until = now.Add(policy.Cooldown)
+	invalidateFastPath()
+
+	return Event{
+		Type:    EventPenaltyStarted,
+		Time:    now,
+		Details: fmt.Sprintf("hit the limits or connection caps %d times within %s, limited to %d B/s until %s", policy.Threshold, policy.Window, policy.Limit, penalty.until.Format(time.RFC3339)),
+	}, true
+}
+
+// recordCapHit counts a connection rejected by the connection caps towards the penalty box of its peer
+func (c *BandwidthConfig) recordCapHit(conn net.Conn, now time.Time) {
+	if c.penalties.Policy() == nil {
+		return
+	}
+
+	peer := c.peers.Get(conn)
+	if event, penalized := c.penalties.RecordHit(peer, now); penalized {
+		event.Peer = peer.key
+		c.emit(event)
+	}
+}
+
+// Limit returns the reduced limit if the peer is in the penalty box.
+// When the cooldown is over, the penalty is cleared and an event is returned
+func (p *penaltyBox) Limit(peer *peerEntry, now time.Time) (limit rate.Limit, penalized bool, ended *Event) {
+	if peer == nil {
+		return rate.Inf, false, nil
+	}
+
+	penalty := &peer.penalty
+	penalty.mu.Lock()
+	defer penalty.mu.Unlock()
+
+	if penalty.until.IsZero() {
+		return rate.Inf, false, nil
+	}
+
+	if !now.Before(penalty.until) {
+		penalty.until = time.Time{}
+		return rate.Inf, false, &Event{Type: EventPenaltyEnded, Time: now}
+	}
+
+	policy := p.Policy()
+	if policy == nil {
+		return rate.Inf, false, nil
+	}
+
+	return rate.Limit(policy.Limit), true, nil
+}
@@ -0,0 +1,140 @@
+package netlistener
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+func TestPenaltyBox_RecordHit(t *testing.T) {
+	box := &penaltyBox{}
+	box.SetPolicy(&PenaltyPolicy{Threshold: 3, Window: time.Second, Limit: 5, Cooldown: time.Minute})
+
+	peer := &peerEntry{key: "192.0.2.1"}
+	now := time.Now()
+
+	for i := 0; i < 2; i++ {
+		if _, penalized := box.RecordHit(peer, now); penalized {
+			t.Fatalf("peer penalized after %d hits", i+1)
+		}
+	}
+
+	// hits outside of the window are not counted together
+	if _, penalized := box.RecordHit(peer, now.Add(2*time.Second)); penalized {
+		t.Fatal("peer penalized with hits outside of the window")
+	}
+
+	now = now.Add(2 * time.Second)
+	box.RecordHit(peer, now)
+	event, penalized := box.RecordHit(peer, now)
+	if !penalized {
+		t.Fatal("expected peer to be penalized")
+	}
+	if event.Type != EventPenaltyStarted {
+		t.Errorf("expected %s event, got %s", EventPenaltyStarted, event.Type)
+	}
+
+	limit, penalized, ended := box.Limit(peer, now.Add(time.Second))
+	if !penalized || limit != 5 || ended != nil {
+		t.Errorf("expected penalty limit of 5, got %v, penalized %v", limit, penalized)
+	}
+
+	limit, penalized, ended = box.Limit(peer, now.Add(2*time.Minute))
+	if penalized || limit != rate.Inf {
+		t.Errorf("expected penalty to be over, got limit %v", limit)
+	}
+	if ended == nil || ended.Type != EventPenaltyEnded {
+		t.Error("expected penalty ended event")
+	}
+}
+
+func TestRateLimitedConnection_Penalized(t *testing.T) {
+	config := NewBandwithConfig(nil, ptr(1000))
+	config.SetPenaltyPolicy(&PenaltyPolicy{Threshold: 1, Window: time.Minute, Limit: 10, Cooldown: time.Minute})
+
+	events := make(chan Event, 10)
+	config.SetEventHandler(func(event Event) {
+		events <- event
+	})
+
+	connRead, connWrite := net.Pipe()
+	conn := NewThrottledConnection(&addrConn{Conn: connWrite, remoteAddr: &net.TCPAddr{IP: net.ParseIP("192.0.2.1")}}, NewConnectionBandwithConfig(config))
+	go readDataFromConn(connRead)
+	defer conn.Close()
+
+	conn.Write(make([]byte, 1000))
+	conn.Write(make([]byte, 500))
+
+	select {
+	case event := <-events:
+		if event.Type != EventPenaltyStarted || event.Peer != "192.0.2.1" {
+			t.Errorf("unexpected event: %+v", event)
+		}
+	default:
+		t.Fatal("expected penalty started event")
+	}
+
+	if got := conn.perConnLimit(config.PerConnWriteLimit(), false); got != 10 {
+		t.Errorf("expected penalty limit of 10, got %v", got)
+	}
+	if config.PeerStates()["192.0.2.1"].PenaltyUntil.IsZero() {
+		t.Error("expected penalty to be part of the peer state")
+	}
+}
+
+func TestListener_PerIPCapPenalized(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Failed to create listener", err)
+	}
+	defer listener.Close()
+
+	throttledListener, _ := NewListener(listener, nil, ptr(1000))
+	throttledListener.SetMaxConns(0, 1)
+	throttledListener.SetPenaltyPolicy(&PenaltyPolicy{Threshold: 3, Window: time.Minute, Limit: 10, Cooldown: time.Minute})
+
+	events := make(chan Event, 10)
+	throttledListener.SetEventHandler(func(event Event) {
+		if event.Type == EventPenaltyStarted {
+			events <- event
+		}
+	})
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		for {
+			conn, err := throttledListener.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- conn
+		}
+	}()
+
+	// the first connection is admitted, the following ones hit the per IP cap
+	for i := 0; i < 4; i++ {
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+	}
+
+	conn := (<-accepted).(*ThrottledConn)
+	defer conn.Close()
+
+	select {
+	case event := <-events:
+		if event.Peer != "127.0.0.1" {
+			t.Errorf("expected the peer to be penalized, got %+v", event)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the peer hitting the per IP cap to be penalized")
+	}
+
+	if got := conn.perConnLimit(throttledListener.config.PerConnWriteLimit(), false); got != 10 {
+		t.Errorf("expected penalty limit of 10, got %v", got)
+	}
+}
@@ -0,0 +1,52 @@
+//go:build (linux || darwin || freebsd) && cgo
+
+package netlistener
+
+import (
+	"fmt"
+	"plugin"
+)
+
+// ClassifierPluginSymbol is the name of the function a classifier plugin has to export.
+// Its signature must be func(netlistener.ConnMetadata) netlistener.Classification
+const ClassifierPluginSymbol = "Classify"
+
+// LoadClassifierPlugin loads policy logic from a Go plugin built with -buildmode=plugin against the same version of this package,
+// so operators can change policies without recompiling the server. The classifier is returned, it is not set.
+// A plugin is not isolated in any way: it runs in the process with full access to its memory, files and network,
+// like any code linked into the server, so only plugins trusted as much as the server may be loaded. It is handed a copy
+// of the connection metadata only, so it does not change the metadata of the listener by mistake.
+// A panic of the plugin is passed to the error handler as a PanicError and the connection gets an empty classification,
+// without an error handler it crashes the program like other panics of background work
+func (c *BandwidthConfig) LoadClassifierPlugin(path string) (Classifier, error) {
+	p, err := plugin.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening classifier plugin: %w", err)
+	}
+
+	symbol, err := p.Lookup(ClassifierPluginSymbol)
+	if err != nil {
+		return nil, fmt.Errorf("looking up %s in classifier plugin: %w", ClassifierPluginSymbol, err)
+	}
+
+	classify, ok := symbol.(func(ConnMetadata) Classification)
+	if !ok {
+		return nil, fmt.Errorf("classifier plugin symbol %s has unexpected type %T", ClassifierPluginSymbol, symbol)
+	}
+
+	return &pluginClassifier{config: c, classify: classify}, nil
+}
+
+type pluginClassifier struct {
+	// config receives the panics of the plugin
+	config   *BandwidthConfig
+	classify func(ConnMetadata) Classification
+}
+
+func (c *pluginClassifier) Classify(meta ConnMetadata) Classification {
+	defer c.config.recoverPanic("classifier plugin")
+
+	meta.Tags = append([]string(nil), meta.Tags...)
+
+	return c.classify(meta)
+}
@@ -0,0 +1,47 @@
+//go:build (linux || darwin || freebsd) && cgo
+
+package netlistener
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadClassifierPlugin_MissingFile(t *testing.T) {
+	if _, err := NewBandwidthConfig(nil, nil).LoadClassifierPlugin(filepath.Join(t.TempDir(), "missing.so")); err == nil {
+		t.Error("expected error for missing plugin")
+	}
+}
+
+func TestPluginClassifier_ReportsPanic(t *testing.T) {
+	config := NewBandwidthConfig(nil, nil)
+	var reported error
+	config.SetErrorHandler(func(err error) { reported = err })
+
+	classifier := &pluginClassifier{config: config, classify: func(meta ConnMetadata) Classification {
+		panic("broken plugin")
+	}}
+
+	if got := classifier.Classify(ConnMetadata{}); got.Deny || got.Class != "" {
+		t.Errorf("expected empty classification, got %+v", got)
+	}
+
+	var panicErr *PanicError
+	if !errors.As(reported, &panicErr) || panicErr.Op != "classifier plugin" || panicErr.Value != "broken plugin" {
+		t.Errorf("expected the panic to be reported, got %v", reported)
+	}
+}
+
+func TestPluginClassifier_PanicsWithoutErrorHandler(t *testing.T) {
+	classifier := &pluginClassifier{config: NewBandwidthConfig(nil, nil), classify: func(meta ConnMetadata) Classification {
+		panic("broken plugin")
+	}}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected the panic to crash without an error handler")
+		}
+	}()
+	classifier.Classify(ConnMetadata{})
+}
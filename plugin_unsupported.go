@@ -0,0 +1,12 @@
+//go:build !((linux || darwin || freebsd) && cgo)
+
+package netlistener
+
+import "errors"
+
+const ClassifierPluginSymbol = "Classify"
+
+// LoadClassifierPlugin is not supported on this platform, Go plugins require cgo on linux, darwin or freebsd
+func (c *BandwidthConfig) LoadClassifierPlugin(path string) (Classifier, error) {
+	return nil, errors.New("classifier plugins are not supported on this platform")
+}
@@ -0,0 +1,378 @@
+package netlistener
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Policy is an ordered list of rules, evaluated at accept time. The first matching rule decides,
+// unless its action asks to continue, in which case its tags are visible to the following rules
+type Policy struct {
+	Rules []Rule `json:"rules"`
+}
+
+type Rule struct {
+	Name   string     `json:"name"`
+	Match  RuleMatch  `json:"match"`
+	Action RuleAction `json:"action"`
+}
+
+// RuleMatch holds the conditions of a rule, all non empty conditions have to match
+type RuleMatch struct {
+	CIDRs []string `json:"cidrs,omitempty"`
+	// LocalCIDRs are matched against the local address the client connected to, e.g. one of many virtual IPs
+	// served by one listener through transparent proxying. The original destination takes precedence when it is known
+	LocalCIDRs []string `json:"local_cidrs,omitempty"`
+	// SNI holds patterns in path.Match syntax matched against the server name of the TLS hello, e.g. "*.example.com".
+	// It only matches connections accepted through NewTLSListener, which are classified again once the hello was read
+	SNI []string `json:"sni,omitempty"`
+	// Hostnames holds patterns in path.Match syntax matched against the reverse DNS name of the remote IP,
+	// e.g. "*.crawler.searchengine.com". It only matches once reverse DNS is enabled and the name was resolved, see SetReverseDNS
+	Hostnames []string `json:"hostnames,omitempty"`
+	Tags      []string `json:"tags,omitempty"`
+	// Ports are the local ports the client connected to, the port of the original destination when it is known
+	Ports []int `json:"ports,omitempty"`
+	// PortRanges are inclusive ranges of local ports in "8000-8099" format, matched in addition to Ports,
+	// e.g. for a listener fronting a port range through a MultiListener
+	PortRanges []string    `json:"port_ranges,omitempty"`
+	Time       *TimeWindow `json:"time,omitempty"`
+
+	nets      []*net.IPNet
+	localNets []*net.IPNet
+	ranges    []portRange
+	// sni and hostnames are the lowercased patterns, names are matched case insensitive
+	sni       []string
+	hostnames []string
+}
+
+type portRange struct {
+	from, to int
+}
+
+type RuleAction struct {
+	Deny         bool     `json:"deny,omitempty"`
+	Class        string   `json:"class,omitempty"`
+	PerConnLimit *int     `json:"per_conn_limit,omitempty"`
+	Profile      string   `json:"profile,omitempty"`
+	Priority     int      `json:"priority,omitempty"`
+	Tags         []string `json:"tags,omitempty"`
+	// Throttle subjects matching connections to the limits although their peers are in a local range which is exempt
+	Throttle bool `json:"throttle,omitempty"`
+	Continue bool `json:"continue,omitempty"`
+}
+
+// TimeWindow matches the local time of day between From and To in "15:04" format, it may wrap around midnight.
+// Days optionally restricts the window to given weekdays, e.g. "Mon", "Sat"
+type TimeWindow struct {
+	From string   `json:"from"`
+	To   string   `json:"to"`
+	Days []string `json:"days,omitempty"`
+
+	from, to time.Duration
+}
+
+// LoadPolicyFile reads a JSON encoded policy from the file
+func LoadPolicyFile(filename string) (*Policy, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("reading policy file: %w", err)
+	}
+
+	return ParsePolicy(data)
+}
+
+// ParsePolicy decodes a JSON encoded policy and validates all of its rules
+func ParsePolicy(data []byte) (*Policy, error) {
+	policy := &Policy{}
+	if err := json.Unmarshal(data, policy); err != nil {
+		return nil, fmt.Errorf("decoding policy: %w", err)
+	}
+
+	if err := policy.Compile(); err != nil {
+		return nil, err
+	}
+
+	return policy, nil
+}
+
+// Compile validates the rules and prepares them for evaluation, it has to be called for policies built in code
+func (p *Policy) Compile() error {
+	for i := range p.Rules {
+		if err := p.Rules[i].Match.compile(); err != nil {
+			return fmt.Errorf("rule %d %q: %w", i, p.Rules[i].Name, err)
+		}
+	}
+
+	return nil
+}
+
+// Classify evaluates the rules against the connection, connections not matching any rule get an empty classification
+func (p *Policy) Classify(meta ConnMetadata) Classification {
+	classification := Classification{}
+	tags := append([]string(nil), meta.Tags...)
+
+	for _, rule := range p.Rules {
+		meta.Tags = tags
+		if !rule.Match.matches(meta) {
+			continue
+		}
+
+		action := rule.Action
+		tags = append(tags, action.Tags...)
+		classification.Tags = append(classification.Tags, action.Tags...)
+
+		if action.Class != "" {
+			classification.Class = action.Class
+		}
+		if action.PerConnLimit != nil {
+			classification.PerConnLimit = action.PerConnLimit
+		}
+		if action.Profile != "" {
+			classification.Profile = action.Profile
+		}
+		if action.Throttle {
+			classification.Throttle = true
+		}
+		if action.Priority != 0 {
+			classification.Priority = action.Priority
+		}
+
+		if action.Deny {
+			classification.Deny = true
+			return classification
+		}
+
+		if !action.Continue {
+			return classification
+		}
+	}
+
+	return classification
+}
+
+func (m *RuleMatch) compile() error {
+	var err error
+	if m.nets, err = parseRuleCIDRs(m.nets[:0], m.CIDRs); err != nil {
+		return err
+	}
+	if m.localNets, err = parseRuleCIDRs(m.localNets[:0], m.LocalCIDRs); err != nil {
+		return err
+	}
+
+	m.ranges = m.ranges[:0]
+	for _, s := range m.PortRanges {
+		r, err := parsePortRange(s)
+		if err != nil {
+			return err
+		}
+
+		m.ranges = append(m.ranges, r)
+	}
+
+	if m.sni, err = parseNamePatterns(m.sni[:0], "SNI", m.SNI); err != nil {
+		return err
+	}
+	if m.hostnames, err = parseNamePatterns(m.hostnames[:0], "hostname", m.Hostnames); err != nil {
+		return err
+	}
+
+	if m.Time != nil {
+		if err := m.Time.compile(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func parseRuleCIDRs(nets []*net.IPNet, cidrs []string) ([]*net.IPNet, error) {
+	for _, cidr := range cidrs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q: %w", cidr, err)
+		}
+
+		nets = append(nets, ipNet)
+	}
+
+	return nets, nil
+}
+
+// parseNamePatterns validates the name patterns and returns them lowercased
+func parseNamePatterns(compiled []string, kind string, patterns []string) ([]string, error) {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid %s pattern %q: %w", kind, pattern, err)
+		}
+
+		compiled = append(compiled, strings.ToLower(pattern))
+	}
+
+	return compiled, nil
+}
+
+func (m *RuleMatch) matches(meta ConnMetadata) bool {
+	if len(m.nets) > 0 && !matchesNets(m.nets, addrIP(meta.RemoteAddr)) {
+		return false
+	}
+
+	if len(m.localNets) > 0 && !matchesNets(m.localNets, addrIP(meta.destination())) {
+		return false
+	}
+
+	if len(m.sni) > 0 && !matchesName(m.sni, meta.SNI) {
+		return false
+	}
+
+	if len(m.hostnames) > 0 && !matchesName(m.hostnames, meta.Hostname) {
+		return false
+	}
+
+	for _, tag := range m.Tags {
+		if !hasTag(meta.Tags, tag) {
+			return false
+		}
+	}
+
+	if (len(m.Ports) > 0 || len(m.ranges) > 0) && !matchesPort(m.Ports, m.ranges, addrPort(meta.destination())) {
+		return false
+	}
+
+	if m.Time != nil && !m.Time.contains(meta.AcceptedAt) {
+		return false
+	}
+
+	return true
+}
+
+func (w *TimeWindow) compile() error {
+	from, err := parseTimeOfDay(w.From)
+	if err != nil {
+		return err
+	}
+
+	to, err := parseTimeOfDay(w.To)
+	if err != nil {
+		return err
+	}
+
+	for _, day := range w.Days {
+		if _, err := time.Parse("Mon", day); err != nil {
+			return fmt.Errorf("invalid weekday %q", day)
+		}
+	}
+
+	w.from, w.to = from, to
+
+	return nil
+}
+
+func (w *TimeWindow) contains(t time.Time) bool {
+	if len(w.Days) > 0 {
+		weekday := t.Weekday().String()[:3]
+		found := false
+		for _, day := range w.Days {
+			if strings.EqualFold(day, weekday) {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	sinceMidnight := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+
+	if w.from <= w.to {
+		return sinceMidnight >= w.from && sinceMidnight < w.to
+	}
+
+	// window wraps around midnight, e.g. 22:00-06:00
+	return sinceMidnight >= w.from || sinceMidnight < w.to
+}
+
+func parseTimeOfDay(value string) (time.Duration, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q, expected HH:MM", value)
+	}
+
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+func matchesNets(nets []*net.IPNet, ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+
+	for _, ipNet := range nets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchesName matches a server name or hostname against lowercased patterns, case insensitive
+func matchesName(patterns []string, name string) bool {
+	if name == "" {
+		return false
+	}
+
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, strings.ToLower(name)); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+func matchesPort(ports []int, ranges []portRange, port int) bool {
+	for _, p := range ports {
+		if p == port {
+			return true
+		}
+	}
+
+	for _, r := range ranges {
+		if port >= r.from && port <= r.to {
+			return true
+		}
+	}
+
+	return false
+}
+
+// parsePortRange parses an inclusive range of ports in "8000-8099" format, a single port is a range as well
+func parsePortRange(s string) (portRange, error) {
+	fromStr, toStr, found := strings.Cut(s, "-")
+	if !found {
+		toStr = fromStr
+	}
+
+	from, err := strconv.Atoi(strings.TrimSpace(fromStr))
+	if err != nil {
+		return portRange{}, fmt.Errorf("invalid port range %q: %w", s, err)
+	}
+
+	to, err := strconv.Atoi(strings.TrimSpace(toStr))
+	if err != nil {
+		return portRange{}, fmt.Errorf("invalid port range %q: %w", s, err)
+	}
+
+	if from < 0 || to > 65535 || from > to {
+		return portRange{}, fmt.Errorf("invalid port range %q", s)
+	}
+
+	return portRange{from: from, to: to}, nil
+}
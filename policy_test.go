@@ -0,0 +1,201 @@
+package netlistener
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+const testPolicy = `{
+	"rules": [
+		{"name": "blocked", "match": {"cidrs": ["203.0.113.0/24"]}, "action": {"deny": true}},
+		{"name": "internal", "match": {"cidrs": ["10.0.0.0/8"]}, "action": {"tags": ["internal"], "continue": true}},
+		{"name": "internal-api", "match": {"tags": ["internal"], "ports": [8443]}, "action": {"class": "internal-api", "per_conn_limit": 1000000}},
+		{"name": "crawlers", "match": {"sni": ["*.crawler.example.com"]}, "action": {"class": "crawler", "per_conn_limit": 1000, "priority": -1}},
+		{"name": "vip", "match": {"local_cidrs": ["192.0.2.10/32"], "ports": [443]}, "action": {"class": "vip"}},
+		{"name": "tls-ports", "match": {"port_ranges": ["9440-9449"]}, "action": {"class": "tls"}},
+		{"name": "night", "match": {"time": {"from": "22:00", "to": "06:00"}}, "action": {"class": "night"}}
+	]
+}`
+
+func TestPolicy_Classify(t *testing.T) {
+	policy, err := ParsePolicy([]byte(testPolicy))
+	if err != nil {
+		t.Fatal("failed to parse policy", err)
+	}
+
+	noon := time.Date(2024, 1, 1, 12, 0, 0, 0, time.Local)
+	midnight := time.Date(2024, 1, 1, 0, 30, 0, 0, time.Local)
+
+	tests := []struct {
+		name     string
+		meta     ConnMetadata
+		expected Classification
+	}{
+		{
+			name:     "Denied network",
+			meta:     ConnMetadata{RemoteAddr: &net.TCPAddr{IP: net.ParseIP("203.0.113.7")}, AcceptedAt: noon},
+			expected: Classification{Deny: true},
+		},
+		{
+			name: "Tag from previous rule is matched",
+			meta: ConnMetadata{
+				RemoteAddr: &net.TCPAddr{IP: net.ParseIP("10.1.1.1")},
+				LocalAddr:  &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 8443},
+				AcceptedAt: noon,
+			},
+			expected: Classification{Class: "internal-api", PerConnLimit: ptr(1000000), Tags: []string{"internal"}},
+		},
+		{
+			name:     "SNI pattern",
+			meta:     ConnMetadata{RemoteAddr: &net.TCPAddr{IP: net.ParseIP("198.51.100.1")}, SNI: "bot1.crawler.example.com", AcceptedAt: noon},
+			expected: Classification{Class: "crawler", PerConnLimit: ptr(1000), Priority: -1},
+		},
+		{
+			name: "Local port within range",
+			meta: ConnMetadata{
+				RemoteAddr: &net.TCPAddr{IP: net.ParseIP("198.51.100.1")},
+				LocalAddr:  &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 9443},
+				AcceptedAt: noon,
+			},
+			expected: Classification{Class: "tls"},
+		},
+		{
+			name: "Local address and port",
+			meta: ConnMetadata{
+				RemoteAddr: &net.TCPAddr{IP: net.ParseIP("198.51.100.1")},
+				LocalAddr:  &net.TCPAddr{IP: net.ParseIP("192.0.2.10"), Port: 443},
+				AcceptedAt: noon,
+			},
+			expected: Classification{Class: "vip"},
+		},
+		{
+			name: "Original destination takes precedence over the local address",
+			meta: ConnMetadata{
+				RemoteAddr:  &net.TCPAddr{IP: net.ParseIP("198.51.100.1")},
+				LocalAddr:   &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 9000},
+				OriginalDst: &net.TCPAddr{IP: net.ParseIP("192.0.2.10"), Port: 443},
+				AcceptedAt:  noon,
+			},
+			expected: Classification{Class: "vip"},
+		},
+		{
+			name: "Port on another local address",
+			meta: ConnMetadata{
+				RemoteAddr: &net.TCPAddr{IP: net.ParseIP("198.51.100.1")},
+				LocalAddr:  &net.TCPAddr{IP: net.ParseIP("192.0.2.11"), Port: 443},
+				AcceptedAt: noon,
+			},
+			expected: Classification{},
+		},
+		{
+			name:     "Time window wrapping around midnight",
+			meta:     ConnMetadata{RemoteAddr: &net.TCPAddr{IP: net.ParseIP("198.51.100.1")}, AcceptedAt: midnight},
+			expected: Classification{Class: "night"},
+		},
+		{
+			name:     "No rule matches",
+			meta:     ConnMetadata{RemoteAddr: &net.TCPAddr{IP: net.ParseIP("198.51.100.1")}, AcceptedAt: noon},
+			expected: Classification{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := policy.Classify(tt.meta)
+
+			if got.Deny != tt.expected.Deny || got.Class != tt.expected.Class || got.Priority != tt.expected.Priority {
+				t.Errorf("expected %+v, got %+v", tt.expected, got)
+			}
+			if (got.PerConnLimit == nil) != (tt.expected.PerConnLimit == nil) ||
+				(got.PerConnLimit != nil && *got.PerConnLimit != *tt.expected.PerConnLimit) {
+				t.Errorf("expected per conn limit %v, got %v", tt.expected.PerConnLimit, got.PerConnLimit)
+			}
+			if len(got.Tags) != len(tt.expected.Tags) {
+				t.Errorf("expected tags %v, got %v", tt.expected.Tags, got.Tags)
+			}
+		})
+	}
+}
+
+func TestParsePolicy_Invalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy string
+	}{
+		{name: "Invalid JSON", policy: `{"rules": [`},
+		{name: "Invalid CIDR", policy: `{"rules": [{"match": {"cidrs": ["10.0.0.0/40"]}}]}`},
+		{name: "Invalid local CIDR", policy: `{"rules": [{"match": {"local_cidrs": ["192.0.2.300/32"]}}]}`},
+		{name: "Invalid time", policy: `{"rules": [{"match": {"time": {"from": "25:00", "to": "06:00"}}}]}`},
+		{name: "Invalid weekday", policy: `{"rules": [{"match": {"time": {"from": "20:00", "to": "06:00", "days": ["Funday"]}}}]}`},
+		{name: "Invalid SNI pattern", policy: `{"rules": [{"match": {"sni": ["[a-"]}}]}`},
+		{name: "Invalid port range", policy: `{"rules": [{"match": {"port_ranges": ["9000-8000"]}}]}`},
+		{name: "Port range out of bounds", policy: `{"rules": [{"match": {"port_ranges": ["65000-70000"]}}]}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParsePolicy([]byte(tt.policy)); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
+
+func TestListener_ClassifierDeniesConnection(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Failed to create listener", err)
+	}
+	defer listener.Close()
+
+	throttledListener, _ := NewListener(listener, nil, nil)
+
+	denied := true
+	throttledListener.SetClassifier(ClassifierFunc(func(meta ConnMetadata) Classification {
+		deny := denied
+		denied = false
+		return Classification{Deny: deny, Class: "second"}
+	}))
+
+	for i := 0; i < 2; i++ {
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+	}
+
+	conn, err := throttledListener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if class := conn.(*ThrottledConn).config.Classification().Class; class != "second" {
+		t.Errorf("expected second connection to be accepted, got class %q", class)
+	}
+	if denied := throttledListener.Stats().DeniedConns; denied != 1 {
+		t.Errorf("expected 1 denied connection, got %d", denied)
+	}
+}
+
+func TestPolicy_NamePatternsAreCaseInsensitive(t *testing.T) {
+	policy := &Policy{Rules: []Rule{
+		{Name: "sni", Match: RuleMatch{SNI: []string{"*.Example.com"}}, Action: RuleAction{Class: "sni"}},
+		{Name: "hostname", Match: RuleMatch{Hostnames: []string{"*.Crawler.example.net"}}, Action: RuleAction{Class: "hostname"}},
+	}}
+	if err := policy.Compile(); err != nil {
+		t.Fatal(err)
+	}
+
+	if class := policy.Classify(ConnMetadata{SNI: "WWW.example.COM"}).Class; class != "sni" {
+		t.Errorf("expected the SNI pattern to match, got class %q", class)
+	}
+	if class := policy.Classify(ConnMetadata{Hostname: "bot.crawler.example.net"}).Class; class != "hostname" {
+		t.Errorf("expected the hostname pattern to match, got class %q", class)
+	}
+	if policy.Rules[0].Match.SNI[0] != "*.Example.com" {
+		t.Errorf("expected the configured pattern to be kept, got %q", policy.Rules[0].Match.SNI[0])
+	}
+}
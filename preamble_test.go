@@ -0,0 +1,94 @@
+package netlistener
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestRateLimitedConnection_PreambleExemption(t *testing.T) {
+	tests := []struct {
+		name          string
+		preamble      int64
+		assertionFunc func(t *testing.T, elapsed time.Duration)
+	}{
+		{
+			name:     "Preamble is not throttled",
+			preamble: 200,
+			assertionFunc: func(t *testing.T, elapsed time.Duration) {
+				if elapsed > 500*time.Millisecond {
+					t.Errorf("expected preamble not to be throttled, took %s", elapsed)
+				}
+			},
+		},
+		{
+			name:     "Data after the preamble is throttled",
+			preamble: 100,
+			assertionFunc: func(t *testing.T, elapsed time.Duration) {
+				if elapsed < 900*time.Millisecond || elapsed > 2*time.Second {
+					t.Errorf("expected data after the preamble to be throttled, took %s", elapsed)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			config := NewBandwithConfig(nil, ptr(50))
+			config.SetPreambleExemption(tt.preamble)
+
+			connRead, connWrite := net.Pipe()
+			conn := NewThrottledConnection(connWrite, NewConnectionBandwithConfig(config))
+			defer conn.Close()
+
+			received := make(chan int)
+			go func() {
+				n, _ := io.ReadFull(connRead, make([]byte, 200))
+				received <- n
+			}()
+
+			// 200 bytes at 50 B/s with a burst of 50, so 50 bytes over the preamble and burst take a second
+			start := time.Now()
+			written := 0
+			for i := 0; i < 4; i++ {
+				n, err := conn.Write(make([]byte, 50))
+				if err != nil {
+					t.Fatal(err)
+				}
+				written += n
+			}
+			elapsed := time.Since(start)
+
+			if written != 200 || <-received != 200 {
+				t.Errorf("expected 200 bytes to be written, got %d", written)
+			}
+
+			tt.assertionFunc(t, elapsed)
+		})
+	}
+}
+
+func TestRateLimitedConnection_PreambleExemption_SplitWrite(t *testing.T) {
+	config := NewBandwithConfig(nil, ptr(50))
+	config.SetPreambleExemption(30)
+
+	connRead, connWrite := net.Pipe()
+	conn := NewThrottledConnection(connWrite, NewConnectionBandwithConfig(config))
+	defer conn.Close()
+	go readDataFromConn(connRead)
+
+	// 30 bytes are written as preamble and the remaining 40 through the limiters
+	n, err := conn.Write(make([]byte, 70))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 70 {
+		t.Errorf("expected 70 bytes written, got %d", n)
+	}
+	if tokens := conn.config.PerConnWriteLimiter().Tokens(); tokens > 11 {
+		t.Errorf("expected only bytes after the preamble to be charged, %f tokens left", tokens)
+	}
+}
@@ -0,0 +1,59 @@
+package netlistener
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestRateLimitedConnection_PrecisionMode(t *testing.T) {
+	tests := []struct {
+		name          string
+		precision     bool
+		assertionFunc func(t *testing.T, elapsed time.Duration)
+	}{
+		{
+			name:      "Burst lets the connection exceed a low limit",
+			precision: false,
+			assertionFunc: func(t *testing.T, elapsed time.Duration) {
+				if elapsed > 1200*time.Millisecond {
+					t.Errorf("expected the burst to be used, took %s", elapsed)
+				}
+			},
+		},
+		{
+			name:      "Precision mode keeps the throughput within 2% of the limit",
+			precision: true,
+			assertionFunc: func(t *testing.T, elapsed time.Duration) {
+				if elapsed < 1960*time.Millisecond || elapsed > 2040*time.Millisecond {
+					t.Errorf("expected 1000 bytes at 500 B/s to take 2s, took %s", elapsed)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			config := NewBandwithConfig(nil, ptr(500))
+			config.SetPrecisionMode(tt.precision)
+
+			connRead, connWrite := net.Pipe()
+			conn := NewThrottledConnection(connWrite, NewConnectionBandwithConfig(config))
+			defer conn.Close()
+			go readDataFromConn(connRead)
+
+			start := time.Now()
+			n, err := conn.Write(make([]byte, 1000))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != 1000 {
+				t.Errorf("expected 1000 bytes written, got %d", n)
+			}
+
+			tt.assertionFunc(t, time.Since(start))
+		})
+	}
+}
@@ -0,0 +1,214 @@
+package netlistener
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"golang.org/x/time/rate"
+)
+
+// Profile bundles the per connection shaping of a kind of link, e.g. to emulate mobile clients in tests or to sell tiers.
+// Limits are in bytes per second as seen by the server: reads are the uploads of the client, writes are its downloads
+type Profile struct {
+	Name string `json:"name"`
+	// ReadLimit and WriteLimit are the limits of each connection of the profile, nil means unlimited
+	ReadLimit  *int `json:"read_limit,omitempty"`
+	WriteLimit *int `json:"write_limit,omitempty"`
+	// Burst is the amount of bytes a connection may transfer at once after being idle, zero means one second worth of the limit
+	Burst int `json:"burst,omitempty"`
+	// Precise shapes with a burst of 10ms worth of the limit like precision mode, so slow links do not transfer a second at once.
+	// It is ignored when Burst is set
+	Precise bool `json:"precise,omitempty"`
+	// Shared makes the limits apply to all connections of the profile together instead of to each of them
+	Shared bool `json:"shared,omitempty"`
+}
+
+// builtinProfiles are the presets every listener knows, they can be overridden by registering a profile with the same name
+var builtinProfiles = []Profile{
+	// a 56k modem, downloading at about 53kbit/s and uploading at 33.6kbit/s
+	{Name: "dialup", ReadLimit: Limit(4200), WriteLimit: Limit(6600), Precise: true},
+	// a typical 3G connection, 1.6Mbit/s down and 768kbit/s up
+	{Name: "3g", ReadLimit: Limit(96_000), WriteLimit: Limit(200_000), Precise: true},
+	// a typical LTE connection, 12Mbit/s down and 5Mbit/s up
+	{Name: "lte", ReadLimit: Limit(625_000), WriteLimit: Limit(1_500_000)},
+	// a 100Mbit/s link shared by all connections of the profile
+	{Name: "100mbit-shared", ReadLimit: Limit(12_500_000), WriteLimit: Limit(12_500_000), Shared: true},
+}
+
+// profileEntry holds a profile and its limiters shared by the connections of the profile if it is shared
+type profileEntry struct {
+	profile      Profile
+	readLimiter  *rate.Limiter
+	writeLimiter *rate.Limiter
+}
+
+func newProfileEntry(profile Profile) *profileEntry {
+	entry := &profileEntry{
+		readLimiter:  rate.NewLimiter(rate.Inf, 0),
+		writeLimiter: rate.NewLimiter(rate.Inf, 0),
+	}
+	entry.update(profile)
+
+	return entry
+}
+
+// update changes the profile in place, so connections of the profile pick up the new limits
+func (e *profileEntry) update(profile Profile) {
+	e.profile = profile
+	e.readLimiter.SetLimit(formatRateLimit(profile.ReadLimit))
+	e.readLimiter.SetBurst(profile.burst(formatRateLimit(profile.ReadLimit)))
+	e.writeLimiter.SetLimit(formatRateLimit(profile.WriteLimit))
+	e.writeLimiter.SetBurst(profile.burst(formatRateLimit(profile.WriteLimit)))
+	invalidateFastPath()
+}
+
+// limit returns the per connection limit of the profile in the direction, nil if the limits of the profile are shared
+func (e *profileEntry) limit(read bool) (*int, bool) {
+	if e == nil || e.profile.Shared {
+		return nil, false
+	}
+
+	if read {
+		return e.profile.ReadLimit, true
+	}
+
+	return e.profile.WriteLimit, true
+}
+
+// burst returns the burst of a limiter of the profile with the limit
+func (p Profile) burst(limit rate.Limit) int {
+	switch {
+	case limit == rate.Inf:
+		return 0
+	case p.Burst > 0:
+		return p.Burst
+	case p.Precise:
+		return precisionBurst(limit)
+	}
+
+	return parseBurstFromRateLimit(limit)
+}
+
+// profileRegistry holds the profiles of a listener, the built-in ones are created on first use
+type profileRegistry struct {
+	profiles       map[string]*profileEntry
+	defaultProfile string
+
+	mu sync.RWMutex
+}
+
+func (r *profileRegistry) Register(profile Profile) error {
+	if profile.Name == "" {
+		return fmt.Errorf("profile without a name")
+	}
+	if profile.ReadLimit != nil && *profile.ReadLimit < 0 || profile.WriteLimit != nil && *profile.WriteLimit < 0 || profile.Burst < 0 {
+		return fmt.Errorf("profile %q has a negative limit", profile.Name)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if entry := r.lookupLocked(profile.Name); entry != nil {
+		entry.update(profile)
+		return nil
+	}
+
+	r.profiles[profile.Name] = newProfileEntry(profile)
+
+	return nil
+}
+
+// Exists reports whether the profile is registered or built in, profiles are never removed
+func (r *profileRegistry) Exists(name string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.lookupLocked(name) != nil
+}
+
+// SetDefault sets the profile of connections which were not assigned one by the classifier, empty removes it
+func (r *profileRegistry) SetDefault(name string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if name != "" && r.lookupLocked(name) == nil {
+		return fmt.Errorf("unknown profile %q", name)
+	}
+
+	r.defaultProfile = name
+
+	return nil
+}
+
+func (r *profileRegistry) Default() string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.defaultProfile
+}
+
+// Get returns the profile by name, falling back to the default profile. It returns nil if there is no such profile
+func (r *profileRegistry) Get(name string) *profileEntry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if name != "" {
+		if entry := r.lookupLocked(name); entry != nil {
+			return entry
+		}
+	}
+
+	if r.defaultProfile == "" {
+		return nil
+	}
+
+	return r.lookupLocked(r.defaultProfile)
+}
+
+// lookupLocked returns the registered or built-in profile, creating the entry of a built-in profile on first use
+func (r *profileRegistry) lookupLocked(name string) *profileEntry {
+	if r.profiles == nil {
+		r.profiles = make(map[string]*profileEntry)
+	}
+
+	if entry, ok := r.profiles[name]; ok {
+		return entry
+	}
+
+	for _, profile := range builtinProfiles {
+		if profile.Name == name {
+			entry := newProfileEntry(profile)
+			r.profiles[name] = entry
+
+			return entry
+		}
+	}
+
+	return nil
+}
+
+// Profiles returns the built-in and registered profiles by name
+func (r *profileRegistry) Profiles() []Profile {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	profiles := make([]Profile, 0, len(r.profiles)+len(builtinProfiles))
+	for _, entry := range r.profiles {
+		profiles = append(profiles, entry.profile)
+	}
+	for _, profile := range builtinProfiles {
+		if _, ok := r.profiles[profile.Name]; !ok {
+			profiles = append(profiles, profile)
+		}
+	}
+	for i := range profiles {
+		profiles[i].ReadLimit, profiles[i].WriteLimit = cloneLimit(profiles[i].ReadLimit), cloneLimit(profiles[i].WriteLimit)
+	}
+
+	sort.Slice(profiles, func(i, j int) bool {
+		return profiles[i].Name < profiles[j].Name
+	})
+
+	return profiles
+}
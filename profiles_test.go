@@ -0,0 +1,134 @@
+package netlistener
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestProfileRegistry_Register(t *testing.T) {
+	tests := []struct {
+		name    string
+		profile Profile
+		wantErr bool
+	}{
+		{name: "Custom profile", profile: Profile{Name: "satellite", ReadLimit: ptr(50_000), WriteLimit: ptr(250_000)}},
+		{name: "Overriding a preset", profile: Profile{Name: "lte", WriteLimit: ptr(3_000_000)}},
+		{name: "Without a name", profile: Profile{ReadLimit: ptr(1000)}, wantErr: true},
+		{name: "Negative limit", profile: Profile{Name: "broken", WriteLimit: ptr(-1)}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			registry := profileRegistry{}
+
+			err := registry.Register(tt.profile)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expected error %t, got %v", tt.wantErr, err)
+			}
+			if err != nil {
+				return
+			}
+
+			entry := registry.Get(tt.profile.Name)
+			if entry == nil || *entry.profile.WriteLimit != *tt.profile.WriteLimit {
+				t.Errorf("expected profile %+v, got %+v", tt.profile, entry)
+			}
+		})
+	}
+}
+
+func TestProfileRegistry_Presets(t *testing.T) {
+	registry := profileRegistry{}
+
+	for _, name := range []string{"dialup", "3g", "lte", "100mbit-shared"} {
+		if registry.Get(name) == nil {
+			t.Errorf("expected preset %q", name)
+		}
+	}
+
+	if err := registry.SetDefault("unknown"); err == nil {
+		t.Error("expected error for unknown default profile")
+	}
+	if registry.Get("unknown") != nil {
+		t.Error("expected no profile for unknown name without a default")
+	}
+
+	if err := registry.SetDefault("3g"); err != nil {
+		t.Fatal(err)
+	}
+	if entry := registry.Get("unknown"); entry == nil || entry.profile.Name != "3g" {
+		t.Errorf("expected fallback to the default profile, got %+v", entry)
+	}
+}
+
+func TestRateLimitedConnection_Profile(t *testing.T) {
+	tests := []struct {
+		name           string
+		classification Classification
+		defaultProfile string
+		// throttled is whether the second write of 20 bytes has to wait for the profile limit of 20 bytes per second
+		throttled bool
+	}{
+		{name: "Profile of the classification", classification: Classification{Profile: "slow"}, throttled: true},
+		{name: "Default profile", defaultProfile: "slow", throttled: true},
+		{name: "Per connection limit of the classification takes precedence", classification: Classification{Profile: "slow", PerConnLimit: ptr(1000)}},
+		{name: "Without a profile"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := NewBandwithConfig(nil, nil)
+			if err := config.RegisterProfile(Profile{Name: "slow", ReadLimit: ptr(20), WriteLimit: ptr(20)}); err != nil {
+				t.Fatal(err)
+			}
+			if err := config.SetDefaultProfile(tt.defaultProfile); err != nil {
+				t.Fatal(err)
+			}
+
+			connRead, connWrite := net.Pipe()
+			connConfig := NewConnectionBandwithConfig(config)
+			connConfig.SetClassification(tt.classification)
+			conn := NewThrottledConnection(connWrite, connConfig)
+			defer conn.Close()
+			go readDataFromConn(connRead)
+
+			start := time.Now()
+			for range 2 {
+				if _, err := conn.Write(make([]byte, 20)); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if throttled := time.Since(start) > 500*time.Millisecond; throttled != tt.throttled {
+				t.Errorf("expected throttled %t, took %v", tt.throttled, time.Since(start))
+			}
+		})
+	}
+}
+
+func TestRateLimitedConnection_SharedProfile(t *testing.T) {
+	config := NewBandwithConfig(nil, nil)
+	if err := config.RegisterProfile(Profile{Name: "shared", WriteLimit: ptr(20), Shared: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	for range 2 {
+		connRead, connWrite := net.Pipe()
+		connConfig := NewConnectionBandwithConfig(config)
+		connConfig.SetClassification(Classification{Profile: "shared"})
+		conn := NewThrottledConnection(connWrite, connConfig)
+		defer conn.Close()
+		go readDataFromConn(connRead)
+
+		if _, err := conn.Write(make([]byte, 20)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// each connection writes within a per connection limit, but together they exceed the shared one
+	if elapsed := time.Since(start); elapsed < 500*time.Millisecond {
+		t.Errorf("expected the second connection to wait for the shared limit, took %v", elapsed)
+	}
+}
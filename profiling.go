@@ -0,0 +1,51 @@
+package netlistener
+
+import (
+	"context"
+	"runtime/pprof"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// Labels attached to the goroutines performing throttled I/O when profiler labels are enabled
+const (
+	ProfilerLabelConn  = "netlistener_conn"
+	ProfilerLabelClass = "netlistener_class"
+	ProfilerLabelTags  = "netlistener_tags"
+)
+
+// connIDs numbers the throttled connections of the process, so their profiler labels and infos can be correlated
+var connIDs atomic.Uint64
+
+// SetProfilerLabels makes reads and writes of the connections run with pprof labels holding the id, class and tags
+// of the connection, so CPU profiles can be attributed per tenant, e.g. with go tool pprof -tagfocus netlistener_class=acme.
+// Setting the labels costs an allocation per operation, so it is disabled by default
+func (c *BandwidthConfig) SetProfilerLabels(enabled bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.profilerLabels = enabled
+}
+
+func (c *BandwidthConfig) ProfilerLabels() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.profilerLabels
+}
+
+// withProfilerLabels runs the read or write with the labels of the connection, the labels of the goroutine are restored afterwards
+func (c *ThrottledConn) withProfilerLabels(ctx context.Context, b []byte, op func(ctx context.Context, b []byte) (int, error)) (n int, err error) {
+	classification := c.config.Classification()
+	labels := pprof.Labels(
+		ProfilerLabelConn, strconv.FormatUint(c.id, 10),
+		ProfilerLabelClass, classification.Class,
+		ProfilerLabelTags, strings.Join(classification.Tags, ","),
+	)
+	pprof.Do(ctx, labels, func(ctx context.Context) {
+		n, err = op(ctx, b)
+	})
+
+	return n, err
+}
@@ -0,0 +1,67 @@
+package netlistener
+
+import (
+	"context"
+	"net"
+	"runtime/pprof"
+	"strconv"
+	"testing"
+)
+
+func TestRateLimitedConnection_ProfilerLabels(t *testing.T) {
+	tests := []struct {
+		name           string
+		classification Classification
+		expected       map[string]string
+	}{
+		{
+			name:     "Unclassified",
+			expected: map[string]string{ProfilerLabelClass: "", ProfilerLabelTags: ""},
+		},
+		{
+			name:           "Tenant with tags",
+			classification: Classification{Class: "acme", Tags: []string{"internal", "batch"}},
+			expected:       map[string]string{ProfilerLabelClass: "acme", ProfilerLabelTags: "internal,batch"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			connRead, connWrite := net.Pipe()
+			defer connRead.Close()
+			connConfig := NewConnectionBandwithConfig(NewBandwithConfig(nil, nil))
+			connConfig.SetClassification(tt.classification)
+			conn := NewThrottledConnection(connWrite, connConfig)
+			defer conn.Close()
+
+			tt.expected[ProfilerLabelConn] = strconv.FormatUint(conn.ConnInfo().ID, 10)
+			_, _ = conn.withProfilerLabels(context.Background(), nil, func(ctx context.Context, b []byte) (int, error) {
+				for key, expected := range tt.expected {
+					if value, _ := pprof.Label(ctx, key); value != expected {
+						t.Errorf("expected label %s to be %q, got %q", key, expected, value)
+					}
+				}
+
+				return 0, nil
+			})
+		})
+	}
+}
+
+func TestRateLimitedConnection_ProfilerLabelsIO(t *testing.T) {
+	config := NewBandwithConfig(nil, ptr(1<<20))
+	config.SetProfilerLabels(true)
+
+	connRead, connWrite := net.Pipe()
+	conn := NewThrottledConnection(connWrite, NewConnectionBandwithConfig(config))
+	go readDataFromConn(connRead)
+
+	if n, err := conn.Write(make([]byte, 100)); err != nil || n != 100 {
+		t.Fatalf("expected 100 bytes written, got %d: %v", n, err)
+	}
+	conn.Close()
+
+	if stats := config.Stats(); stats.BytesWritten != 100 {
+		t.Errorf("expected 100 bytes counted, got %d", stats.BytesWritten)
+	}
+}
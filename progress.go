@@ -0,0 +1,98 @@
+package netlistener
+
+import (
+	"fmt"
+	"time"
+)
+
+// TransferProgress emits EventTransferProgress for long running transfers, so operators can watch big transfers
+// crawl through a constrained link without polling stats
+type TransferProgress struct {
+	// Threshold is the number of bytes a connection has to transfer in both directions together before its progress is emitted
+	Threshold int64 `json:"threshold"`
+	// EveryBytes emits the progress each time the connection transferred that many more bytes, zero disables it
+	EveryBytes int64 `json:"every_bytes,omitempty"`
+	// Every emits the progress when that much time passed since the last event and the connection transferred anything since, zero disables it
+	Every time.Duration `json:"every,omitempty"`
+}
+
+func (p TransferProgress) validate() error {
+	if p.Threshold < 0 || p.EveryBytes < 0 || p.Every < 0 {
+		return fmt.Errorf("negative transfer progress %+v", p)
+	}
+	if p.EveryBytes == 0 && p.Every == 0 {
+		return fmt.Errorf("transfer progress needs an interval in bytes or time")
+	}
+
+	return nil
+}
+
+// SetTransferProgress enables progress events of connections which transferred more than the threshold, nil disables them.
+// The progress is checked when the connection transfers data, a stalled connection emits nothing until it moves again
+func (c *BandwidthConfig) SetTransferProgress(progress *TransferProgress) error {
+	if progress != nil {
+		if err := progress.validate(); err != nil {
+			return err
+		}
+		copied := *progress
+		progress = &copied
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.progress = progress
+
+	return nil
+}
+
+func (c *BandwidthConfig) TransferProgress() *TransferProgress {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.progress == nil {
+		return nil
+	}
+	progress := *c.progress
+
+	return &progress
+}
+
+// checkProgress emits EventTransferProgress if the connection is due, the last reported byte count is swapped,
+// so concurrent reads and writes emit a checkpoint once
+func (c *ThrottledConn) checkProgress(now time.Time) {
+	config := c.config.globalConfig
+	config.mu.RLock()
+	progress := config.progress
+	config.mu.RUnlock()
+	if progress == nil {
+		return
+	}
+
+	read, written := c.bytesRead.Load(), c.bytesWritten.Load()
+	total := read + written
+	if total <= progress.Threshold {
+		return
+	}
+
+	last := c.progressBytes.Load()
+	lastAt := c.progressAt.Load()
+	if lastAt == 0 {
+		lastAt = c.acceptedAt.UnixNano()
+	}
+
+	due := progress.EveryBytes > 0 && total-last >= progress.EveryBytes ||
+		progress.Every > 0 && total > last && now.UnixNano()-lastAt >= int64(progress.Every)
+	if !due || !c.progressBytes.CompareAndSwap(last, total) {
+		return
+	}
+	c.progressAt.Store(now.UnixNano())
+
+	config.emit(Event{
+		Type:      EventTransferProgress,
+		Time:      now,
+		Peer:      peerKey(c.Conn),
+		Details:   fmt.Sprintf("conn %d: %d bytes read, %d bytes written in %v", c.id, read, written, now.Sub(c.acceptedAt).Round(time.Millisecond)),
+		ConnState: c.lifecycle.Load().String(),
+	})
+}
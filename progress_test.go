@@ -0,0 +1,88 @@
+package netlistener
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRateLimitedConnection_TransferProgress(t *testing.T) {
+	tests := []struct {
+		name     string
+		progress *TransferProgress
+		// writes of 50 bytes are made with pause in between
+		writes   int
+		pause    time.Duration
+		expected int
+	}{
+		{name: "Disabled", writes: 10},
+		{name: "Below the threshold", progress: &TransferProgress{Threshold: 1000, EveryBytes: 100}, writes: 10},
+		// at 150, 250, 350 and 450 bytes
+		{name: "Every 100 bytes", progress: &TransferProgress{Threshold: 100, EveryBytes: 100}, writes: 10, expected: 4},
+		{name: "Every 50ms", progress: &TransferProgress{Every: 50 * time.Millisecond}, writes: 3, pause: 60 * time.Millisecond, expected: 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := NewBandwithConfig(nil, nil)
+			if err := config.SetTransferProgress(tt.progress); err != nil {
+				t.Fatal(err)
+			}
+
+			var mu sync.Mutex
+			var events []Event
+			config.SetEventHandler(func(event Event) {
+				if event.Type == EventTransferProgress {
+					mu.Lock()
+					events = append(events, event)
+					mu.Unlock()
+				}
+			})
+
+			connRead, connWrite := net.Pipe()
+			conn := NewThrottledConnection(connWrite, NewConnectionBandwithConfig(config))
+			defer conn.Close()
+			go readDataFromConn(connRead)
+
+			for range tt.writes {
+				time.Sleep(tt.pause)
+				if _, err := conn.Write(make([]byte, 50)); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if len(events) != tt.expected {
+				t.Fatalf("expected %d progress events, got %d", tt.expected, len(events))
+			}
+			if tt.expected > 0 && !strings.Contains(events[0].Details, "bytes written") {
+				t.Errorf("expected the details to hold the bytes written, got %q", events[0].Details)
+			}
+		})
+	}
+}
+
+func TestBandwidthConfig_SetTransferProgress(t *testing.T) {
+	tests := []struct {
+		name     string
+		progress TransferProgress
+		wantErr  bool
+	}{
+		{name: "Bytes", progress: TransferProgress{Threshold: 1 << 20, EveryBytes: 1 << 20}},
+		{name: "Time", progress: TransferProgress{Every: time.Second}},
+		{name: "No interval", progress: TransferProgress{Threshold: 1 << 20}, wantErr: true},
+		{name: "Negative", progress: TransferProgress{EveryBytes: -1}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := NewBandwithConfig(nil, nil).SetTransferProgress(&tt.progress)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("expected error %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
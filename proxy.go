@@ -0,0 +1,73 @@
+package netlistener
+
+import (
+	"net"
+
+	"golang.org/x/time/rate"
+)
+
+const defaultProxyChunkSize = 32 * 1024
+
+// ProxyConfig configures Proxy, nil config means default chunk size and no limits other than the ones of the connections
+type ProxyConfig struct {
+	// ChunkSize is the amount of data copied at once, the shaping budget is charged for every chunk.
+	// It is reduced to the smallest burst of the involved limiters. Defaults to 32KB
+	ChunkSize int
+	// Limit applies to each direction separately in bytes per second, nil means unlimited
+	Limit *int
+}
+
+// Proxy pipes data between two connections in both directions until both sides are done, it is a shorthand for a Relay.
+// Throttled connections are charged for every chunk after it was copied, as if the data went through their Read and Write,
+// while the copying itself happens between the underlying connections, so the kernel can use splice for TCP.
+// When one direction reaches EOF, the write side of the other connection is closed if it supports half-close.
+// Both connections are closed when Proxy returns
+func Proxy(a, b net.Conn, cfg *ProxyConfig) (aToB int64, bToA int64, err error) {
+	if cfg == nil {
+		cfg = &ProxyConfig{}
+	}
+
+	relay := NewRelay(a, b, &RelayConfig{
+		ChunkSize: cfg.ChunkSize,
+		AToBLimit: cfg.Limit,
+		BToALimit: cfg.Limit,
+		HalfClose: true,
+	})
+	err = relay.Run()
+	stats := relay.Stats()
+
+	return stats.AToB, stats.BToA, err
+}
+
+// maxChunk returns the chunk size which does not exceed the burst of any limiter, WaitN fails otherwise
+func maxChunk(limiters []*rate.Limiter, chunkSize int) int {
+	for _, limiter := range limiters {
+		if limiter.Limit() == rate.Inf {
+			continue
+		}
+
+		if burst := limiter.Burst(); burst > 0 && burst < chunkSize {
+			chunkSize = burst
+		}
+	}
+
+	return chunkSize
+}
+
+func unwrapThrottled(conn net.Conn) net.Conn {
+	if throttled, ok := conn.(*ThrottledConn); ok {
+		return throttled.Conn
+	}
+
+	return conn
+}
+
+// closeWrite half-closes the connection, reporting whether it is supported
+func closeWrite(conn net.Conn) bool {
+	cw, ok := unwrapThrottled(conn).(interface{ CloseWrite() error })
+	if !ok {
+		return false
+	}
+
+	return cw.CloseWrite() == nil
+}
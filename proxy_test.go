@@ -0,0 +1,127 @@
+package netlistener
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// tcpPair returns both ends of a loopback TCP connection
+func tcpPair(t *testing.T) (net.Conn, net.Conn) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Failed to create listener", err)
+	}
+	defer listener.Close()
+
+	accepted := make(chan net.Conn)
+	go func() {
+		conn, _ := listener.Accept()
+		accepted <- conn
+	}()
+
+	client, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return client, <-accepted
+}
+
+func TestProxy(t *testing.T) {
+	tests := []struct {
+		name          string
+		perConnLimit  *int
+		proxyConfig   *ProxyConfig
+		dataSize      int
+		assertionFunc func(t *testing.T, elapsed time.Duration)
+	}{
+		{
+			name:     "Unlimited proxy",
+			dataSize: 1024 * 1024,
+			assertionFunc: func(t *testing.T, elapsed time.Duration) {
+				if elapsed > time.Second {
+					t.Errorf("expected less than 1 second, took %s", elapsed)
+				}
+			},
+		},
+		{
+			name:         "Throttled connection is charged for proxied data",
+			perConnLimit: ptr(1000),
+			proxyConfig:  &ProxyConfig{ChunkSize: 500},
+			dataSize:     2000,
+			assertionFunc: func(t *testing.T, elapsed time.Duration) {
+				if elapsed < 900*time.Millisecond || elapsed > 2*time.Second {
+					t.Errorf("expected between 1 and 2 seconds, took %s", elapsed)
+				}
+			},
+		},
+		{
+			name:        "Proxy limit",
+			proxyConfig: &ProxyConfig{Limit: ptr(1000)},
+			dataSize:    2000,
+			assertionFunc: func(t *testing.T, elapsed time.Duration) {
+				if elapsed < 900*time.Millisecond || elapsed > 2*time.Second {
+					t.Errorf("expected between 1 and 2 seconds, took %s", elapsed)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			client, serverSide := tcpPair(t)
+			upstreamSide, upstream := tcpPair(t)
+			defer client.Close()
+			defer upstream.Close()
+
+			config := NewBandwithConfig(nil, tt.perConnLimit)
+			throttled := NewThrottledConnection(serverSide, NewConnectionBandwithConfig(config))
+
+			data := make([]byte, tt.dataSize)
+			rand.Read(data)
+
+			start := time.Now()
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				Proxy(throttled, upstreamSide, tt.proxyConfig)
+			}()
+
+			go func() {
+				client.Write(data)
+				client.(*net.TCPConn).CloseWrite()
+			}()
+
+			received, err := io.ReadAll(upstream)
+			if err != nil {
+				t.Fatal(err)
+			}
+			elapsed := time.Since(start)
+
+			if !bytes.Equal(received, data) {
+				t.Errorf("proxied data differs, got %d bytes, expected %d", len(received), len(data))
+			}
+
+			// closing the upstream ends the other direction
+			upstream.(*net.TCPConn).CloseWrite()
+			if _, err := io.ReadAll(client); err != nil {
+				t.Fatal(err)
+			}
+			<-done
+
+			if got := config.Stats().BytesRead; got != int64(tt.dataSize) {
+				t.Errorf("expected %d bytes read to be accounted, got %d", tt.dataSize, got)
+			}
+
+			tt.assertionFunc(t, elapsed)
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package netlistener
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+)
+
+const (
+	// queueGain is the multiple of the bandwidth-delay product allowed to be queued in the socket, like the cwnd gain of BBR
+	queueGain = 2
+	// maxQueueDelay bounds a single hold back, so wrong estimates do not stall the connection
+	maxQueueDelay = time.Second
+)
+
+// socketQueue is what the kernel reports about the sending side of a TCP socket
+type socketQueue struct {
+	// DeliveryRate is the recent rate at which data was acknowledged, in bytes per second
+	DeliveryRate uint64
+	MinRTT       time.Duration
+	// Queued is the data sent but not acknowledged plus the data not sent yet
+	Queued int64
+}
+
+// queueDelay returns how long the next write should be held back, so the data queued in the socket does not exceed
+// queueGain times the bandwidth-delay product and no standing queue builds up in the network
+func queueDelay(queue socketQueue) time.Duration {
+	if queue.DeliveryRate == 0 || queue.MinRTT <= 0 {
+		return 0
+	}
+
+	bdp := int64(float64(queue.DeliveryRate) * queue.MinRTT.Seconds())
+	excess := queue.Queued - queueGain*bdp
+	if excess <= 0 {
+		return 0
+	}
+
+	return min(time.Duration(float64(excess)/float64(queue.DeliveryRate)*float64(time.Second)), maxQueueDelay)
+}
+
+// paceQueue holds back a write while too much data is queued in the socket, when queue pacing is enabled.
+// The hold back ends early at the deadline, the write then fails on its own, and fails when the context is done
+// or the connection is closed
+func (c *ThrottledConn) paceQueue(ctx context.Context, deadline time.Time) error {
+	if !c.config.globalConfig.QueuePacing() {
+		return nil
+	}
+
+	queue, err := socketQueueOf(c.socket())
+	if err != nil {
+		return nil
+	}
+
+	return c.holdBack(ctx, queueDelay(queue), deadline)
+}
+
+// holdBack waits for the delay, up to the deadline, unless the context is done or the connection is closed before
+func (c *ThrottledConn) holdBack(ctx context.Context, delay time.Duration, deadline time.Time) error {
+	if !deadline.IsZero() {
+		delay = min(delay, time.Until(deadline))
+	}
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("%w: %w", ErrThrottleCancelled, ctx.Err())
+	case <-c.closed:
+		return net.ErrClosed
+	}
+}
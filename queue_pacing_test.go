@@ -0,0 +1,101 @@
+package netlistener
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestQueueDelay(t *testing.T) {
+	tests := []struct {
+		name     string
+		queue    socketQueue
+		expected time.Duration
+	}{
+		{
+			name:     "No estimate yet",
+			queue:    socketQueue{Queued: 1 << 20},
+			expected: 0,
+		},
+		{
+			// 1MB/s with 10ms RTT is a BDP of 10000 bytes, so 20000 bytes may be queued
+			name:     "Queue within the gain",
+			queue:    socketQueue{DeliveryRate: 1000000, MinRTT: 10 * time.Millisecond, Queued: 20000},
+			expected: 0,
+		},
+		{
+			name:     "Excess is drained at the delivery rate",
+			queue:    socketQueue{DeliveryRate: 1000000, MinRTT: 10 * time.Millisecond, Queued: 70000},
+			expected: 50 * time.Millisecond,
+		},
+		{
+			name:     "Delay is bounded",
+			queue:    socketQueue{DeliveryRate: 1000, MinRTT: 10 * time.Millisecond, Queued: 1 << 20},
+			expected: maxQueueDelay,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if delay := queueDelay(tt.queue); delay != tt.expected {
+				t.Errorf("expected delay %s, got %s", tt.expected, delay)
+			}
+		})
+	}
+}
+
+func TestThrottledConn_HoldBack(t *testing.T) {
+	tests := []struct {
+		name     string
+		deadline time.Duration
+		cancel   bool
+		close    bool
+		// errs are the errors the hold back wraps, none for success
+		errs     []error
+		expected time.Duration
+	}{
+		{name: "Whole delay", expected: 200 * time.Millisecond},
+		{name: "Ends at the deadline", deadline: 50 * time.Millisecond, expected: 50 * time.Millisecond},
+		{name: "Context done", cancel: true, errs: []error{ErrThrottleCancelled, context.Canceled}},
+		{name: "Connection closed", close: true, errs: []error{net.ErrClosed}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, server := net.Pipe()
+			defer client.Close()
+			conn := NewThrottledConnection(server, NewConnConfig(NewBandwidthConfig(nil, nil)))
+			defer conn.Close()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tt.cancel {
+				cancel()
+			}
+			if tt.close {
+				conn.Close()
+			}
+
+			var deadline time.Time
+			if tt.deadline > 0 {
+				deadline = time.Now().Add(tt.deadline)
+			}
+
+			start := time.Now()
+			err := conn.holdBack(ctx, 200*time.Millisecond, deadline)
+			if (err != nil) != (len(tt.errs) > 0) {
+				t.Errorf("expected errors %v, got %v", tt.errs, err)
+			}
+			for _, expected := range tt.errs {
+				if !errors.Is(err, expected) {
+					t.Errorf("expected error wrapping %v, got %v", expected, err)
+				}
+			}
+			if elapsed := time.Since(start); elapsed < tt.expected || elapsed > tt.expected+100*time.Millisecond {
+				t.Errorf("expected the hold back to take about %v, took %v", tt.expected, elapsed)
+			}
+		})
+	}
+}
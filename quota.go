@@ -0,0 +1,78 @@
+package netlistener
+
+import (
+	"fmt"
+	"net"
+)
+
+// PeerQuota caps the bytes a peer may transfer over all of its connections, read and written together.
+// The usage is the BytesRead and BytesWritten of the PeerState, so it survives restarts through SaveState and RestoreState
+// and a peer cannot reset it by reconnecting. It only starts over when the state of the peer is evicted, see SetPeerEviction.
+// Exempt connections are not subject to the quota, their bytes still count towards it
+type PeerQuota struct {
+	// Bytes is the number of bytes a peer may read and write
+	Bytes int64 `json:"bytes"`
+}
+
+func (q PeerQuota) validate() error {
+	if q.Bytes <= 0 {
+		return fmt.Errorf("peer quota needs a positive number of bytes, got %d", q.Bytes)
+	}
+
+	return nil
+}
+
+// exhausted reports whether the peer used up the quota, a nil quota is never exhausted
+func (q *PeerQuota) exhausted(peer *peerEntry) bool {
+	return q != nil && peer != nil && peer.bytesRead.Load()+peer.bytesWritten.Load() >= q.Bytes
+}
+
+// SetPeerQuota caps the bytes each peer may transfer, see PeerQuota. Once a peer used up its quota, reads and writes
+// of its connections fail with ErrQuotaExhausted, the operation crossing it still finishes, and its new connections
+// are rejected with RejectQuotaExhausted. Nil removes the quota.
+// Quotas are kept per remote IP, so peer tracking is enabled as well
+func (c *BandwidthConfig) SetPeerQuota(quota *PeerQuota) error {
+	if quota != nil {
+		if err := quota.validate(); err != nil {
+			return err
+		}
+		copied := *quota
+		quota = &copied
+
+		c.peers.SetEnabled(true)
+	}
+
+	c.peerQuota.Store(quota)
+
+	return nil
+}
+
+// PeerQuota returns the quota of the peers, nil if there is none
+func (c *BandwidthConfig) PeerQuota() *PeerQuota {
+	if quota := c.peerQuota.Load(); quota != nil {
+		copied := *quota
+		return &copied
+	}
+
+	return nil
+}
+
+// quotaExhausted reports whether the peer of a connection being accepted used up its quota,
+// exempt connections are accepted like their reads and writes are not limited by it
+func (c *BandwidthConfig) quotaExhausted(conn net.Conn, classification Classification) bool {
+	quota := c.peerQuota.Load()
+	if quota == nil || c.exemptions.IsExempt(conn) || !classification.Throttle && c.exemptions.IsLocal(conn) {
+		return false
+	}
+
+	return quota.exhausted(c.peers.Get(conn))
+}
+
+// checkQuota fails once the peer of the connection used up its quota
+func (c *ThrottledConn) checkQuota() error {
+	if c.config.globalConfig.peerQuota.Load().exhausted(c.peer) && !c.config.Exempt() {
+		return ErrQuotaExhausted
+	}
+
+	return nil
+}
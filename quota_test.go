@@ -0,0 +1,144 @@
+package netlistener
+
+import (
+	"errors"
+	"io"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBandwidthConfig_SetPeerQuota(t *testing.T) {
+	tests := []struct {
+		name    string
+		quota   *PeerQuota
+		wantErr bool
+	}{
+		{name: "Valid", quota: &PeerQuota{Bytes: 1000}},
+		{name: "Removed", quota: nil},
+		{name: "No bytes", quota: &PeerQuota{}, wantErr: true},
+		{name: "Negative bytes", quota: &PeerQuota{Bytes: -1}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := NewBandwidthConfig(nil, nil)
+			if err := config.SetPeerQuota(tt.quota); (err != nil) != tt.wantErr {
+				t.Fatalf("SetPeerQuota() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.quota != nil && !tt.wantErr && (config.PeerQuota() == nil || !config.peers.Enabled()) {
+				t.Errorf("expected the quota to be set with peer tracking, got %+v", config.PeerQuota())
+			}
+		})
+	}
+}
+
+func TestPeerQuota_RestoredPeerIsLimited(t *testing.T) {
+	store := NewFileStateStore(filepath.Join(t.TempDir(), "peers.json"))
+	remote := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 1234}
+
+	newConn := func(config *BandwidthConfig, remote net.Addr) *ThrottledConn {
+		connRead, connWrite := net.Pipe()
+		go readDataFromConn(connRead)
+		return NewThrottledConnection(&addrConn{Conn: connWrite, remoteAddr: remote}, NewConnConfig(config))
+	}
+
+	before := NewBandwidthConfig(nil, nil)
+	if err := before.SetPeerQuota(&PeerQuota{Bytes: 1000}); err != nil {
+		t.Fatal(err)
+	}
+
+	conn := newConn(before, remote)
+	// the write crossing the quota finishes, the next one fails
+	if n, err := conn.Write(make([]byte, 1200)); err != nil || n != 1200 {
+		t.Fatalf("expected the write crossing the quota to finish, wrote %d: %v", n, err)
+	}
+	if _, err := conn.Write(make([]byte, 1)); !errors.Is(err, ErrQuotaExhausted) {
+		t.Fatalf("expected ErrQuotaExhausted, got %v", err)
+	}
+	conn.Close()
+
+	if err := before.SaveState(store); err != nil {
+		t.Fatal(err)
+	}
+
+	// a restarted process restores the usage, reconnecting does not reset the quota
+	after := NewBandwidthConfig(nil, nil)
+	if err := after.SetPeerQuota(&PeerQuota{Bytes: 1000}); err != nil {
+		t.Fatal(err)
+	}
+	if err := after.RestoreState(store); err != nil {
+		t.Fatal(err)
+	}
+
+	conn = newConn(after, remote)
+	defer conn.Close()
+	_, err := conn.Write(make([]byte, 1))
+	var throttleErr *ThrottleError
+	if !errors.Is(err, ErrQuotaExhausted) || !errors.As(err, &throttleErr) || throttleErr.Timeout() {
+		t.Errorf("expected a ThrottleError wrapping ErrQuotaExhausted for the restored peer, got %v", err)
+	}
+
+	other := newConn(after, &net.TCPAddr{IP: net.ParseIP("192.0.2.2"), Port: 1234})
+	defer other.Close()
+	if _, err := other.Write(make([]byte, 100)); err != nil {
+		t.Errorf("expected other peers not to be limited, got %v", err)
+	}
+}
+
+func TestListener_QuotaExhaustedRejected(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Failed to create listener", err)
+	}
+	defer listener.Close()
+
+	throttledListener, _ := NewListener(listener, nil, nil)
+	if err := throttledListener.SetPeerQuota(&PeerQuota{Bytes: 10}); err != nil {
+		t.Fatal(err)
+	}
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		for {
+			conn, err := throttledListener.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- conn
+		}
+	}()
+
+	client, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	client.Write(make([]byte, 20))
+
+	conn := <-accepted
+	defer conn.Close()
+	if _, err := io.ReadAtLeast(conn, make([]byte, 20), 10); err != nil {
+		t.Fatal(err)
+	}
+
+	// the peer used up its quota, its next connection is rejected
+	again, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer again.Close()
+
+	deadline := time.Now().Add(time.Second)
+	for throttledListener.Stats().DeniedConns == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if rejected := throttledListener.Stats().Rejections[RejectQuotaExhausted.String()]; rejected != 1 {
+		t.Errorf("expected 1 connection rejected with %s, got %d", RejectQuotaExhausted, rejected)
+	}
+	if recent := throttledListener.RecentRejections(); len(recent) != 1 || recent[0].Reason != RejectQuotaExhausted {
+		t.Errorf("expected a rejection sample with %s, got %+v", RejectQuotaExhausted, recent)
+	}
+}
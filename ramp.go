@@ -0,0 +1,112 @@
+package netlistener
+
+import (
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// rampStep is the interval a ramping limit is updated in
+const rampStep = 100 * time.Millisecond
+
+// rampTarget is the limit a ramp changes
+type rampTarget int
+
+const (
+	rampGlobal rampTarget = iota
+	rampPerConn
+	rampTargets
+)
+
+// limitRamp moves a limit linearly from one value to another over the duration of the ramp
+type limitRamp struct {
+	from, to rate.Limit
+	target   *int
+	start    time.Time
+	duration time.Duration
+}
+
+// at returns the limit at the time, and whether the ramp is done
+func (r *limitRamp) at(now time.Time) (rate.Limit, bool) {
+	progress := float64(now.Sub(r.start)) / float64(r.duration)
+	if progress >= 1 {
+		return r.to, true
+	}
+
+	return r.from + rate.Limit(progress)*(r.to-r.from), false
+}
+
+// SetLimitRamp makes SetGlobalLimit and SetPerConnLimit move the limits to their new values gradually over the duration,
+// so a drastic clamp does not cause retransmit storms of the clamped TCP connections. Changes from or to unlimited
+// are applied at once. Zero applies all changes at once, ramps in progress are completed
+func (c *BandwidthConfig) SetLimitRamp(duration time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.rampDuration = duration
+}
+
+func (c *BandwidthConfig) LimitRamp() time.Duration {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.rampDuration
+}
+
+// rampLocked starts moving the limit of the target to the new value, it returns false if the change has to be applied at once.
+// A ramp in progress is replaced, the new one starts from the limit reached. The lock of the config has to be held
+func (c *BandwidthConfig) rampLocked(target rampTarget, limit *int) bool {
+	c.ramps[target] = nil
+
+	from := c.perConnWriteLimit
+	if target == rampGlobal {
+		from = c.globalWriteLimiter.Limit()
+	}
+
+	to := formatRateLimit(limit)
+	if c.rampDuration <= 0 || from == rate.Inf || to == rate.Inf || from == to {
+		return false
+	}
+
+	ramp := &limitRamp{from: from, to: to, target: cloneLimit(limit), start: c.lockedClock().Now(), duration: c.rampDuration}
+	c.ramps[target] = ramp
+	go c.runRamp(target, ramp)
+
+	return true
+}
+
+// runRamp updates the limit of the target every step until the ramp is done or replaced
+func (c *BandwidthConfig) runRamp(target rampTarget, ramp *limitRamp) {
+	ticker := time.NewTicker(rampStep)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if done := c.stepRamp(target, ramp, c.now()); done {
+			return
+		}
+	}
+}
+
+func (c *BandwidthConfig) stepRamp(target rampTarget, ramp *limitRamp, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.ramps[target] != ramp {
+		return true
+	}
+
+	limit, done := ramp.at(now)
+	step := Limit(int(limit))
+	if done {
+		step = ramp.target
+		c.ramps[target] = nil
+	}
+
+	if target == rampGlobal {
+		c.setGlobalLimitLocked(step)
+	} else {
+		c.setPerConnLimitLocked(step)
+	}
+
+	return done
+}
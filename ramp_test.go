@@ -0,0 +1,70 @@
+package netlistener
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+func TestLimitRamp_At(t *testing.T) {
+	start := time.Unix(0, 0)
+	ramp := &limitRamp{from: 1000, to: 200, start: start, duration: time.Second}
+
+	tests := []struct {
+		name     string
+		elapsed  time.Duration
+		expected rate.Limit
+		done     bool
+	}{
+		{name: "Start", elapsed: 0, expected: 1000},
+		{name: "Halfway", elapsed: 500 * time.Millisecond, expected: 600},
+		{name: "End", elapsed: time.Second, expected: 200, done: true},
+		{name: "After the end", elapsed: 2 * time.Second, expected: 200, done: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if limit, done := ramp.at(start.Add(tt.elapsed)); limit != tt.expected || done != tt.done {
+				t.Errorf("expected %v (done %v), got %v (done %v)", tt.expected, tt.done, limit, done)
+			}
+		})
+	}
+}
+
+func TestBandwidthConfig_LimitRamp(t *testing.T) {
+	config := NewBandwithConfig(ptr(10000), ptr(10000))
+	config.SetLimitRamp(500 * time.Millisecond)
+
+	config.SetGlobalLimit(ptr(1000))
+	config.SetPerConnLimit(ptr(1000))
+
+	if limit := config.GlobalWriteLimiter().Limit(); limit != 10000 {
+		t.Errorf("expected the global limit to ramp from 10000, got %v", limit)
+	}
+	if current := config.Config(); *current.GlobalLimit != 1000 || *current.PerConnLimit != 1000 {
+		t.Errorf("expected the configured limits to be the targets, got %v and %v", *current.GlobalLimit, *current.PerConnLimit)
+	}
+
+	time.Sleep(250 * time.Millisecond)
+	if limit := config.GlobalWriteLimiter().Limit(); limit <= 1000 || limit >= 10000 {
+		t.Errorf("expected the global limit between the old and the new one, got %v", limit)
+	}
+	if limit := config.PerConnWriteLimit(); limit <= 1000 || limit >= 10000 {
+		t.Errorf("expected the per connection limit between the old and the new one, got %v", limit)
+	}
+
+	time.Sleep(500 * time.Millisecond)
+	if limit := config.GlobalWriteLimiter().Limit(); limit != 1000 {
+		t.Errorf("expected the global limit at its target, got %v", limit)
+	}
+	if limit := config.PerConnWriteLimit(); limit != 1000 {
+		t.Errorf("expected the per connection limit at its target, got %v", limit)
+	}
+
+	// removing the limit is applied at once
+	config.SetGlobalLimit(nil)
+	if limit := config.GlobalWriteLimiter().Limit(); limit != rate.Inf {
+		t.Errorf("expected the global limit removed at once, got %v", limit)
+	}
+}
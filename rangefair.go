@@ -0,0 +1,144 @@
+package netlistener
+
+import (
+	"context"
+	"hash/fnv"
+	"net"
+	"net/http"
+	"sync"
+)
+
+// RangeFairness makes the connections of one client share a client limit, e.g. for file servers where a client downloads
+// a file in parallel ranged requests over several connections, each of which would get the full per connection limit.
+// Clients are identified by a hash of the remote IP and the User-Agent, so clients behind one NAT using different
+// software are told apart. The connections are bound to a Session of the client, their own limits apply as well
+type RangeFairness struct {
+	limit    *int
+	sessions map[uint64]*Session
+
+	mu sync.Mutex
+}
+
+func NewRangeFairness(limit *int) *RangeFairness {
+	return &RangeFairness{
+		limit:    cloneLimit(limit),
+		sessions: make(map[uint64]*Session),
+	}
+}
+
+// SetLimit changes the limit of every client, the ones with open connections pick it up with their next operation
+func (f *RangeFairness) SetLimit(limit *int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.limit = cloneLimit(limit)
+	for _, session := range f.sessions {
+		session.SetLimit(limit)
+	}
+}
+
+// Clients returns the number of clients with connections bound
+func (f *RangeFairness) Clients() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return len(f.sessions)
+}
+
+// Bind binds a throttled connection to the session of the client with the user agent, the session is dropped
+// once its last connection is released
+func (f *RangeFairness) Bind(conn net.Conn, userAgent string) error {
+	throttled, ok := asThrottled(conn)
+	if !ok {
+		return ErrNotThrottled
+	}
+
+	key := clientKey(remoteIP(throttled), userAgent)
+
+	// the session is bound without holding the lock, since binding may release a previous session of the client and
+	// forget it. A session forgotten in between, when its last connection was released, is put back or replaced
+	for {
+		session := f.session(key)
+		if err := session.Bind(throttled); err != nil {
+			return err
+		}
+
+		f.mu.Lock()
+		current, ok := f.sessions[key]
+		if !ok && session.Stats().ActiveConns > 0 {
+			f.sessions[key], current = session, session
+		}
+		f.mu.Unlock()
+
+		if !ok || current == session {
+			return nil
+		}
+	}
+}
+
+// session returns the session of the client, creating it if the client has none
+func (f *RangeFairness) session(key uint64) *Session {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	session, ok := f.sessions[key]
+	if !ok {
+		session = NewSession(&SessionConfig{
+			Limit:   f.limit,
+			OnEmpty: func(session *Session) { f.forget(key, session) },
+		})
+		f.sessions[key] = session
+	}
+
+	return session
+}
+
+// asThrottled returns the throttled connection of a connection returned by the listener, which may be paced by ContentLengthConn
+func asThrottled(conn net.Conn) (*ThrottledConn, bool) {
+	if paced, ok := conn.(*ContentLengthConn); ok {
+		return paced.ThrottledConn, true
+	}
+
+	throttled, ok := conn.(*ThrottledConn)
+
+	return throttled, ok
+}
+
+func (f *RangeFairness) forget(key uint64, session *Session) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.sessions[key] == session && session.Stats().ActiveConns == 0 {
+		delete(f.sessions, key)
+	}
+}
+
+// clientKey hashes the remote IP and the user agent of a client
+func clientKey(ip net.IP, userAgent string) uint64 {
+	hash := fnv.New64a()
+	hash.Write(ip.To16())
+	hash.Write([]byte{0})
+	hash.Write([]byte(userAgent))
+
+	return hash.Sum64()
+}
+
+// ConnContext is meant for http.Server.ConnContext, it makes the connection of a request known to Handler.
+// It is the same as the package level ConnContext
+func (f *RangeFairness) ConnContext(ctx context.Context, conn net.Conn) context.Context {
+	return ConnContext(ctx, conn)
+}
+
+// Handler binds the connections of ranged requests to the sessions of their clients before passing the requests on.
+// The server has to use ConnContext, requests whose connection is unknown or not throttled are passed on as they are
+func (f *RangeFairness) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Range") != "" {
+			if conn, ok := ConnFromContext(r.Context()); ok {
+				f.Bind(conn, r.UserAgent())
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,131 @@
+package netlistener
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestRangeFairness(t *testing.T) {
+	config := NewBandwithConfig(nil, ptr(1000))
+	fairness := NewRangeFairness(ptr(1000))
+
+	newConn := func(ip string) *ThrottledConn {
+		_, server := net.Pipe()
+		return NewThrottledConnection(&addrConn{Conn: server, remoteAddr: &net.TCPAddr{IP: net.ParseIP(ip)}}, NewConnectionBandwithConfig(config))
+	}
+
+	first, second := newConn("192.0.2.1"), newConn("192.0.2.1")
+	other, behindNAT := newConn("198.51.100.1"), newConn("192.0.2.1")
+
+	tests := []struct {
+		name      string
+		conn      *ThrottledConn
+		userAgent string
+		clients   int
+	}{
+		{name: "First connection of a client", conn: first, userAgent: "curl/8.0", clients: 1},
+		{name: "Parallel connection of the client shares its session", conn: second, userAgent: "curl/8.0", clients: 1},
+		{name: "Client from another IP", conn: other, userAgent: "curl/8.0", clients: 2},
+		{name: "Other software behind the same IP", conn: behindNAT, userAgent: "Wget/1.21", clients: 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := fairness.Bind(tt.conn, tt.userAgent); err != nil {
+				t.Fatal(err)
+			}
+			if clients := fairness.Clients(); clients != tt.clients {
+				t.Errorf("expected %d clients, got %d", tt.clients, clients)
+			}
+		})
+	}
+
+	if first.config.Session() != second.config.Session() || first.config.Session() == behindNAT.config.Session() {
+		t.Error("expected the connections of a client, and only them, to share a session")
+	}
+
+	for _, conn := range []*ThrottledConn{first, second, other, behindNAT} {
+		conn.Close()
+	}
+	if clients := fairness.Clients(); clients != 0 {
+		t.Errorf("expected the clients to be forgotten after their connections closed, got %d", clients)
+	}
+}
+
+func TestRangeFairness_Handler(t *testing.T) {
+	config := NewBandwithConfig(nil, nil)
+	fairness := NewRangeFairness(ptr(1000))
+	handler := fairness.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tests := []struct {
+		name  string
+		rng   string
+		bound bool
+	}{
+		{name: "Ranged request is bound", rng: "bytes=0-1023", bound: true},
+		{name: "Request without a range is not", rng: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, server := net.Pipe()
+			conn := NewThrottledConnection(server, NewConnectionBandwithConfig(config))
+			defer conn.Close()
+
+			req := httptest.NewRequest(http.MethodGet, "/file", nil)
+			req = req.WithContext(fairness.ConnContext(context.Background(), conn))
+			if tt.rng != "" {
+				req.Header.Set("Range", tt.rng)
+			}
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			if bound := conn.config.Session() != nil; bound != tt.bound {
+				t.Errorf("expected bound %v, got %v", tt.bound, bound)
+			}
+		})
+	}
+}
+
+func TestRangeFairness_ConcurrentBindAndClose(t *testing.T) {
+	config := NewBandwithConfig(nil, nil)
+	fairness := NewRangeFairness(ptr(1000))
+
+	newConn := func() *ThrottledConn {
+		_, server := net.Pipe()
+		return NewThrottledConnection(&addrConn{Conn: server, remoteAddr: &net.TCPAddr{IP: net.ParseIP("192.0.2.1")}}, NewConnectionBandwithConfig(config))
+	}
+
+	// connections of the client come and go while others are bound, the ones open at the same time share one session
+	for round := 0; round < 100; round++ {
+		conns := make([]*ThrottledConn, 4)
+		var wg sync.WaitGroup
+		for i := range conns {
+			conns[i] = newConn()
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := fairness.Bind(conns[i], "curl/8.0"); err != nil {
+					t.Error(err)
+				}
+			}()
+		}
+		wg.Wait()
+
+		for _, conn := range conns[1:] {
+			if conn.config.Session() != conns[0].config.Session() {
+				t.Fatalf("expected the open connections of the client to share a session in round %d", round)
+			}
+		}
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}
+
+	if clients := fairness.Clients(); clients != 0 {
+		t.Errorf("expected the client to be forgotten, got %d", clients)
+	}
+}
@@ -0,0 +1,226 @@
+package netlistener
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultReverseDNSTimeout = 2 * time.Second
+	defaultReverseDNSTTL     = 10 * time.Minute
+	// maxReverseDNSEntries bounds the cache, expired entries are dropped first once it is full
+	maxReverseDNSEntries = 10000
+)
+
+// ReverseDNS configures the reverse DNS lookups feeding the hostname of ConnMetadata.
+// Lookups never block Accept: a connection whose remote IP is not cached is classified without a hostname,
+// and classified again once the lookup finished
+type ReverseDNS struct {
+	// Timeout bounds a lookup, defaults to 2s
+	Timeout time.Duration `json:"timeout"`
+	// TTL is how long names, and failed lookups, are cached, defaults to 10m
+	TTL time.Duration `json:"ttl"`
+	// Verify accepts a name only if it resolves back to the remote IP (forward-confirmed reverse DNS),
+	// otherwise anyone controlling the reverse zone of their addresses can pick a name matching a rule
+	Verify bool `json:"verify"`
+	// Resolver defaults to net.DefaultResolver
+	Resolver *net.Resolver `json:"-"`
+}
+
+// reverseDNS resolves and caches the names of remote IPs
+type reverseDNS struct {
+	config ReverseDNS
+	// lookupAddr and lookupHost are the methods of the resolver, replaced in tests
+	lookupAddr func(ctx context.Context, addr string) ([]string, error)
+	lookupHost func(ctx context.Context, host string) ([]string, error)
+
+	entries map[string]rdnsEntry
+	// pending holds the callbacks of lookups in flight by IP, so concurrent connections from an IP share a lookup
+	pending map[string][]func(hostname string)
+	// onError receives the failed lookups other than names which do not exist, nil drops them
+	onError func(err error)
+	mu      sync.Mutex
+}
+
+type rdnsEntry struct {
+	hostname string
+	expires  time.Time
+}
+
+func newReverseDNS(config ReverseDNS) *reverseDNS {
+	if config.Timeout <= 0 {
+		config.Timeout = defaultReverseDNSTimeout
+	}
+	if config.TTL <= 0 {
+		config.TTL = defaultReverseDNSTTL
+	}
+	if config.Resolver == nil {
+		config.Resolver = net.DefaultResolver
+	}
+
+	return &reverseDNS{
+		config:     config,
+		lookupAddr: config.Resolver.LookupAddr,
+		lookupHost: config.Resolver.LookupHost,
+		entries:    make(map[string]rdnsEntry),
+		pending:    make(map[string][]func(string)),
+	}
+}
+
+// Cached returns the name of the IP if it is cached, an empty name is a failed lookup
+func (r *reverseDNS) Cached(ip string) (string, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	entry, ok := r.entries[ip]
+	if !ok || time.Now().After(entry.expires) {
+		return "", false
+	}
+
+	return entry.hostname, true
+}
+
+// Lookup resolves the name of the IP in the background and calls done with it, an empty name if it has none
+func (r *reverseDNS) Lookup(ip string, done func(hostname string)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	callbacks, inFlight := r.pending[ip]
+	r.pending[ip] = append(callbacks, done)
+	if inFlight {
+		return
+	}
+
+	go func() {
+		hostname := r.resolve(ip)
+
+		r.mu.Lock()
+		r.store(ip, hostname)
+		callbacks := r.pending[ip]
+		delete(r.pending, ip)
+		r.mu.Unlock()
+
+		for _, callback := range callbacks {
+			callback(hostname)
+		}
+	}()
+}
+
+func (r *reverseDNS) resolve(ip string) string {
+	ctx, cancel := context.WithTimeout(context.Background(), r.config.Timeout)
+	defer cancel()
+
+	names, err := r.lookupAddr(ctx, ip)
+	if err != nil {
+		var dnsErr *net.DNSError
+		if r.onError != nil && (!errors.As(err, &dnsErr) || !dnsErr.IsNotFound) {
+			r.onError(fmt.Errorf("reverse DNS lookup of %s: %w", ip, err))
+		}
+
+		return ""
+	}
+
+	for _, name := range names {
+		name = strings.ToLower(strings.TrimSuffix(name, "."))
+		if !r.config.Verify {
+			return name
+		}
+
+		addrs, err := r.lookupHost(ctx, name)
+		if err == nil && slices.Contains(addrs, ip) {
+			return name
+		}
+	}
+
+	return ""
+}
+
+// store caches the name, the lock has to be held
+func (r *reverseDNS) store(ip, hostname string) {
+	now := time.Now()
+
+	if len(r.entries) >= maxReverseDNSEntries {
+		for key, entry := range r.entries {
+			if now.After(entry.expires) {
+				delete(r.entries, key)
+			}
+		}
+	}
+	if len(r.entries) >= maxReverseDNSEntries {
+		for key := range r.entries {
+			delete(r.entries, key)
+			break
+		}
+	}
+
+	r.entries[ip] = rdnsEntry{hostname: hostname, expires: now.Add(r.config.TTL)}
+}
+
+// SetReverseDNS enables reverse DNS lookups of remote IPs for the classifier, nil disables them.
+// The cache starts empty whenever the config is set
+func (c *BandwidthConfig) SetReverseDNS(config *ReverseDNS) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if config == nil {
+		c.rdns = nil
+		return
+	}
+
+	c.rdns = newReverseDNS(*config)
+	c.rdns.onError = func(err error) {
+		c.reportError(err)
+	}
+}
+
+func (c *BandwidthConfig) ReverseDNS() *ReverseDNS {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.rdns == nil {
+		return nil
+	}
+
+	config := c.rdns.config
+
+	return &config
+}
+
+func (c *BandwidthConfig) reverseDNS() *reverseDNS {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.rdns
+}
+
+// classifyLater classifies the connection again once the reverse DNS name of its remote IP is resolved.
+// A connection the new classification denies is closed
+func (c *BandwidthConfig) classifyLater(rdns *reverseDNS, throttled *ThrottledConn, classifier Classifier) {
+	ip := addrIP(throttled.RemoteAddr())
+	if ip == nil {
+		return
+	}
+
+	rdns.Lookup(ip.String(), func(hostname string) {
+		defer c.recoverPanic("reverse DNS classification")
+
+		if hostname == "" || throttled.isClosed() {
+			return
+		}
+
+		meta := throttled.updateMeta(func(meta *ConnMetadata) { meta.Hostname = hostname })
+		classification := classifier.Classify(meta)
+		if classification.Deny {
+			c.reject(throttled, RejectDenied, "denied by classifier after reverse DNS lookup")
+			return
+		}
+
+		throttled.reclassify(classification)
+	})
+}
@@ -0,0 +1,109 @@
+package netlistener
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestReverseDNS_Resolve(t *testing.T) {
+	tests := []struct {
+		name     string
+		verify   bool
+		names    []string
+		forward  []string
+		expected string
+	}{
+		{name: "Name is normalized", names: []string{"Bot1.Crawler.Example.com."}, expected: "bot1.crawler.example.com"},
+		{name: "Verified name", verify: true, names: []string{"bot1.crawler.example.com."}, forward: []string{"192.0.2.1"}, expected: "bot1.crawler.example.com"},
+		{name: "Name not resolving back is rejected", verify: true, names: []string{"bot1.crawler.example.com."}, forward: []string{"198.51.100.1"}, expected: ""},
+		{name: "No name", expected: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rdns := newReverseDNS(ReverseDNS{Verify: tt.verify})
+			rdns.lookupAddr = func(ctx context.Context, addr string) ([]string, error) {
+				if len(tt.names) == 0 {
+					return nil, errors.New("no such host")
+				}
+				return tt.names, nil
+			}
+			rdns.lookupHost = func(ctx context.Context, host string) ([]string, error) {
+				return tt.forward, nil
+			}
+
+			if hostname := rdns.resolve("192.0.2.1"); hostname != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, hostname)
+			}
+		})
+	}
+}
+
+func TestListener_ReverseDNSClassification(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Failed to create listener", err)
+	}
+	defer listener.Close()
+
+	policy := &Policy{Rules: []Rule{
+		{Name: "crawlers", Match: RuleMatch{Hostnames: []string{"*.crawler.example.com"}}, Action: RuleAction{Class: "crawler"}},
+	}}
+	if err := policy.Compile(); err != nil {
+		t.Fatal(err)
+	}
+
+	throttledListener, _ := NewListener(listener, nil, nil)
+	throttledListener.SetClassifier(policy)
+	throttledListener.SetReverseDNS(&ReverseDNS{})
+
+	lookups := 0
+	release := make(chan struct{})
+	throttledListener.config.rdns.lookupAddr = func(ctx context.Context, addr string) ([]string, error) {
+		lookups++
+		<-release
+		return []string{"bot1.crawler.example.com."}, nil
+	}
+
+	accept := func() *ThrottledConn {
+		client, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { client.Close() })
+
+		conn, err := throttledListener.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+
+		return conn.(*ThrottledConn)
+	}
+
+	// the lookup is still running, so the first connection is accepted without the hostname
+	first := accept()
+	if class := first.config.Classification().Class; class != "" {
+		t.Fatalf("expected no class before the lookup finished, got %q", class)
+	}
+
+	close(release)
+	deadline := time.Now().Add(time.Second)
+	for first.config.Classification().Class != "crawler" {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the connection to be classified again once the hostname was resolved")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// the name is cached, so the second connection is classified by hostname right away
+	if class := accept().config.Classification().Class; class != "crawler" {
+		t.Errorf("expected the cached hostname to be used at accept, got class %q", class)
+	}
+	if lookups != 1 {
+		t.Errorf("expected a single lookup, got %d", lookups)
+	}
+}
@@ -0,0 +1,88 @@
+package netlistener
+
+import "time"
+
+// ReadOnlyConfig exposes the limits and stats of a config without any way to change them,
+// so plugins, dashboards and other untrusted modules can be handed one without risking them mutating the limits.
+// Everything it returns is a copy, and it cannot be converted back to the config it reads from
+type ReadOnlyConfig interface {
+	// Config returns the declarative configuration, see BandwidthConfig.Config
+	Config() ListenerConfig
+	// Snapshot returns the fully resolved configuration, see BandwidthConfig.Snapshot
+	Snapshot() ConfigSnapshot
+	Stats() Stats
+	ClassStats() ClassStats
+	Profiles() []Profile
+	PeerStates() map[string]PeerState
+	RecentRejections() []Rejection
+	TopTalkers(n int, window time.Duration, by TalkerGrouping) []Talker
+	ThroughputHistory() []ThroughputSample
+	ConnLifecycles() []ConnLifecycle
+	// LimitsChanged returns a channel which is closed on the next change of the limits
+	LimitsChanged() <-chan struct{}
+}
+
+// readOnlyConfig hides the config behind ReadOnlyConfig, a type assertion on it does not reveal the config
+type readOnlyConfig struct {
+	config *BandwidthConfig
+}
+
+// ReadOnly returns a view of the config which can read its limits and stats but not change them
+func (c *BandwidthConfig) ReadOnly() ReadOnlyConfig {
+	return readOnlyConfig{config: c}
+}
+
+// ReadOnly returns a view of the listener which can read its limits and stats but not change them
+func (l *Listener) ReadOnly() ReadOnlyConfig {
+	return l.config.ReadOnly()
+}
+
+func (r readOnlyConfig) Config() ListenerConfig {
+	return r.config.Config()
+}
+
+func (r readOnlyConfig) Snapshot() ConfigSnapshot {
+	snapshot := r.config.Snapshot()
+	// the resolver is shared with the config, it is not part of the limits
+	if snapshot.ReverseDNS != nil {
+		snapshot.ReverseDNS.Resolver = nil
+	}
+
+	return snapshot
+}
+
+func (r readOnlyConfig) Stats() Stats {
+	return r.config.Stats()
+}
+
+func (r readOnlyConfig) ClassStats() ClassStats {
+	return r.config.ClassStats()
+}
+
+func (r readOnlyConfig) Profiles() []Profile {
+	return r.config.Profiles()
+}
+
+func (r readOnlyConfig) PeerStates() map[string]PeerState {
+	return r.config.PeerStates()
+}
+
+func (r readOnlyConfig) RecentRejections() []Rejection {
+	return r.config.RecentRejections()
+}
+
+func (r readOnlyConfig) TopTalkers(n int, window time.Duration, by TalkerGrouping) []Talker {
+	return r.config.TopTalkers(n, window, by)
+}
+
+func (r readOnlyConfig) ThroughputHistory() []ThroughputSample {
+	return r.config.ThroughputHistory()
+}
+
+func (r readOnlyConfig) ConnLifecycles() []ConnLifecycle {
+	return r.config.ConnLifecycles()
+}
+
+func (r readOnlyConfig) LimitsChanged() <-chan struct{} {
+	return r.config.LimitUpdates().Changed()
+}
@@ -0,0 +1,60 @@
+package netlistener
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBandwidthConfig_ReadOnly(t *testing.T) {
+	config := NewBandwithConfig(ptr(1000), ptr(100))
+	if err := config.SetClasses([]ClassConfig{{Name: "video", Rate: 800, PerConnLimit: ptr(200)}}, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := config.RegisterProfile(Profile{Name: "mobile", ReadLimit: ptr(50), WriteLimit: ptr(50)}); err != nil {
+		t.Fatal(err)
+	}
+	config.SetPenaltyPolicy(&PenaltyPolicy{Threshold: 3, Window: time.Second, Limit: 10, Cooldown: time.Minute})
+
+	view := config.ReadOnly()
+
+	if _, ok := view.(interface{ SetGlobalLimit(*int) }); ok {
+		t.Fatal("expected the view not to expose setters")
+	}
+	if limit := view.Config().GlobalLimit; limit == nil || *limit != 1000 {
+		t.Errorf("expected the global limit 1000, got %v", limit)
+	}
+
+	// changing what the view returns does not change the limits
+	snapshot := view.Snapshot()
+	*snapshot.Classes[0].PerConnLimit = 1
+	snapshot.PenaltyPolicy.Limit = 1
+	for _, profile := range view.Profiles() {
+		if profile.Name == "mobile" {
+			*profile.ReadLimit = 1
+		}
+	}
+
+	snapshot = config.Snapshot()
+	if limit := snapshot.Classes[0].PerConnLimit; *limit != 200 {
+		t.Errorf("expected the class limit to stay 200, got %d", *limit)
+	}
+	if limit := snapshot.PenaltyPolicy.Limit; limit != 10 {
+		t.Errorf("expected the penalty limit to stay 10, got %d", limit)
+	}
+	for _, profile := range config.Profiles() {
+		if profile.Name == "mobile" && *profile.ReadLimit != 50 {
+			t.Errorf("expected the profile limit to stay 50, got %d", *profile.ReadLimit)
+		}
+	}
+
+	changed := view.LimitsChanged()
+	config.SetGlobalLimit(ptr(2000))
+	select {
+	case <-changed:
+	case <-time.After(time.Second):
+		t.Fatal("expected the view to be notified of the changed limits")
+	}
+	if limit := view.Snapshot().GlobalWriteLimit; limit == nil || *limit != 2000 {
+		t.Errorf("expected the view to read the changed global limit, got %v", limit)
+	}
+}
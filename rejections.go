@@ -0,0 +1,154 @@
+package netlistener
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// recentRejectionsSize is the number of rejection samples kept for inspection
+const recentRejectionsSize = 100
+
+// RejectReason tells why a connection was rejected
+type RejectReason int
+
+const (
+	// RejectDenied is a connection denied by the classifier, e.g. by a deny rule of the policy
+	RejectDenied RejectReason = iota
+	// RejectMaxConns is a connection over the limit of open connections
+	RejectMaxConns
+	// RejectPerIPCap is a connection over the limit of open connections of its remote IP
+	RejectPerIPCap
+	// RejectHandshakeTimeout is a connection which did not finish a proxy handshake in time
+	RejectHandshakeTimeout
+	// RejectAcceptQueueFull is a connection accepted while the queue of the accept pool was full, see AcceptOverflowReject
+	RejectAcceptQueueFull
+	// RejectQuotaExhausted is a connection of a peer which used up its quota, see SetPeerQuota
+	RejectQuotaExhausted
+
+	rejectReasons
+)
+
+func (r RejectReason) String() string {
+	switch r {
+	case RejectDenied:
+		return "denied"
+	case RejectMaxConns:
+		return "max_conns"
+	case RejectPerIPCap:
+		return "per_ip_cap"
+	case RejectHandshakeTimeout:
+		return "handshake_timeout"
+	case RejectAcceptQueueFull:
+		return "accept_queue_full"
+	case RejectQuotaExhausted:
+		return "quota_exhausted"
+	}
+
+	return "unknown"
+}
+
+func (r RejectReason) MarshalText() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+func (r *RejectReason) UnmarshalText(text []byte) error {
+	for reason := range rejectReasons {
+		if reason.String() == string(text) {
+			*r = reason
+			return nil
+		}
+	}
+
+	return fmt.Errorf("unknown reject reason %q", text)
+}
+
+// Rejection is a sample of a rejected connection
+type Rejection struct {
+	Time    time.Time    `json:"time"`
+	Peer    string       `json:"peer"`
+	Reason  RejectReason `json:"reason"`
+	Details string       `json:"details,omitempty"`
+}
+
+// rejectionRing keeps the most recent rejections
+type rejectionRing struct {
+	samples [recentRejectionsSize]Rejection
+	next    int
+	full    bool
+
+	mu sync.Mutex
+}
+
+func (r *rejectionRing) add(rejection Rejection) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.samples[r.next] = rejection
+	r.next = (r.next + 1) % recentRejectionsSize
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// recent returns the kept rejections, oldest first
+func (r *rejectionRing) recent() []Rejection {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.full {
+		return append([]Rejection(nil), r.samples[:r.next]...)
+	}
+
+	return append(append([]Rejection(nil), r.samples[r.next:]...), r.samples[:r.next]...)
+}
+
+// reject closes a connection which is not going to be served and records why
+func (c *BandwidthConfig) reject(conn net.Conn, reason RejectReason, details string) {
+	conn.Close()
+	c.recordRejection(conn, reason, details)
+}
+
+// recordRejection counts a rejected connection by reason, keeps a sample of it and emits EventConnectionDenied
+func (c *BandwidthConfig) recordRejection(conn net.Conn, reason RejectReason, details string) {
+	rejection := Rejection{
+		Time:    time.Now(),
+		Peer:    peerKey(conn),
+		Reason:  reason,
+		Details: details,
+	}
+
+	c.stats.deniedConns.Add(1)
+	c.stats.rejections[reason].Add(1)
+	c.recentRejections.add(rejection)
+
+	c.emit(Event{
+		Type:    EventConnectionDenied,
+		Time:    rejection.Time,
+		Peer:    rejection.Peer,
+		Details: fmt.Sprintf("%s: %s", reason, details),
+	})
+	c.detectAbuse(rejection.Time, c.peers.Prefix().connKey(conn), reason)
+	if reason == RejectMaxConns || reason == RejectPerIPCap {
+		c.recordCapHit(conn, c.now())
+	}
+}
+
+// RecentRejections returns samples of the last rejected connections, oldest first
+func (c *BandwidthConfig) RecentRejections() []Rejection {
+	return c.recentRejections.recent()
+}
+
+// recordHandshakeTimeout records a throttled connection whose proxy handshake failed with a timeout
+func recordHandshakeTimeout(conn net.Conn, err error) {
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		return
+	}
+
+	if throttled, ok := conn.(*ThrottledConn); ok {
+		throttled.config.globalConfig.recordRejection(conn, RejectHandshakeTimeout, err.Error())
+	}
+}
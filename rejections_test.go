@@ -0,0 +1,155 @@
+package netlistener
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestListener_MaxConns(t *testing.T) {
+	tests := []struct {
+		name     string
+		maxConns int64
+		maxPerIP int
+		reason   RejectReason
+	}{
+		{name: "Total connections over the limit are rejected", maxConns: 1, reason: RejectMaxConns},
+		{name: "Connections of a peer over the limit are rejected", maxPerIP: 1, reason: RejectPerIPCap},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal("Failed to create listener", err)
+			}
+			defer listener.Close()
+
+			throttledListener, _ := NewListener(listener, nil, nil)
+			throttledListener.SetMaxConns(tt.maxConns, tt.maxPerIP)
+
+			go func() {
+				for {
+					if _, err := throttledListener.Accept(); err != nil {
+						return
+					}
+				}
+			}()
+
+			for i := 0; i < 2; i++ {
+				conn, err := net.Dial("tcp", listener.Addr().String())
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer conn.Close()
+			}
+
+			deadline := time.Now().Add(time.Second)
+			for throttledListener.Stats().DeniedConns == 0 && time.Now().Before(deadline) {
+				time.Sleep(10 * time.Millisecond)
+			}
+
+			if rejected := throttledListener.Stats().Rejections[tt.reason.String()]; rejected != 1 {
+				t.Errorf("expected 1 connection rejected with %s, got %d", tt.reason, rejected)
+			}
+			if recent := throttledListener.RecentRejections(); len(recent) != 1 || recent[0].Reason != tt.reason {
+				t.Errorf("expected a rejection sample with %s, got %+v", tt.reason, recent)
+			}
+		})
+	}
+}
+
+func TestConnCaps_Admit(t *testing.T) {
+	tests := []struct {
+		name     string
+		maxConns int64
+		maxPerIP int
+		// cancel gives back the slot of the first connection before the second one is admitted
+		cancel   bool
+		admitted bool
+	}{
+		{name: "Total slot reserved before the connection is throttled", maxConns: 1},
+		{name: "Per IP slot reserved before the connection is throttled", maxPerIP: 1},
+		{name: "Cancelled slot is given back", maxConns: 1, maxPerIP: 1, cancel: true, admitted: true},
+		{name: "Room for both", maxConns: 2, maxPerIP: 2, admitted: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := NewBandwidthConfig(nil, nil)
+			config.SetMaxConns(tt.maxConns, tt.maxPerIP)
+
+			conn := &addrConn{remoteAddr: &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 1234}}
+			slot, _, _, ok := config.caps.admit(conn, 0)
+			if !ok {
+				t.Fatal("expected the first connection to be admitted")
+			}
+			if tt.cancel {
+				config.caps.cancel(slot)
+				config.caps.cancel(slot)
+			}
+
+			// the first connection is not active yet, as when accept workers admit concurrently
+			if _, _, _, ok := config.caps.admit(conn, 0); ok != tt.admitted {
+				t.Errorf("expected the second connection to be admitted %v, got %v", tt.admitted, ok)
+			}
+		})
+	}
+}
+
+func TestConnCaps_TrackClaimsSlot(t *testing.T) {
+	config := NewBandwidthConfig(nil, nil)
+	config.SetMaxConns(0, 1)
+
+	server, client := net.Pipe()
+	defer client.Close()
+	conn := &addrConn{Conn: server, remoteAddr: &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 1234}}
+
+	slot, _, _, _ := config.caps.admit(conn, 0)
+	connConfig := NewConnConfig(config)
+	connConfig.capSlot = slot
+	throttled := NewThrottledConnection(conn, connConfig)
+	// cancelling a claimed slot does not give it back
+	config.caps.cancel(slot)
+
+	if _, _, _, ok := config.caps.admit(conn, 0); ok {
+		t.Error("expected the slot to be held by the throttled connection")
+	}
+
+	throttled.Close()
+	if _, _, _, ok := config.caps.admit(conn, 0); !ok {
+		t.Error("expected the slot to be given back when the connection was closed")
+	}
+}
+
+func TestRejectionRing(t *testing.T) {
+	var ring rejectionRing
+	for i := 0; i < recentRejectionsSize+10; i++ {
+		ring.add(Rejection{Details: string(rune('a' + i%26))})
+	}
+
+	recent := ring.recent()
+	if len(recent) != recentRejectionsSize {
+		t.Fatalf("expected %d samples, got %d", recentRejectionsSize, len(recent))
+	}
+	// the first 10 samples were overwritten, so the oldest one is the 11th
+	if recent[0].Details != string(rune('a'+10)) {
+		t.Errorf("expected the oldest kept sample first, got %q", recent[0].Details)
+	}
+}
+
+func TestRejection_JSON(t *testing.T) {
+	data, err := json.Marshal(Rejection{Reason: RejectPerIPCap})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var rejection Rejection
+	if err := json.Unmarshal(data, &rejection); err != nil {
+		t.Fatal(err)
+	}
+	if rejection.Reason != RejectPerIPCap {
+		t.Errorf("expected the reason to survive a round trip, got %s", rejection.Reason)
+	}
+}
@@ -0,0 +1,240 @@
+package netlistener
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// RelayConfig configures a Relay, nil config means default chunk size, no limits and no idle timeout
+type RelayConfig struct {
+	// ChunkSize is the amount of data copied at once, defaults to 32KB
+	ChunkSize int
+	// AToBLimit and BToALimit are the limits of each direction in bytes per second, nil means unlimited
+	AToBLimit *int
+	BToALimit *int
+	// IdleTimeout closes the relay when no data flowed in either direction for this long, zero disables it
+	IdleTimeout time.Duration
+	// HalfClose propagates EOF by closing only the write side of the other connection, when it supports it.
+	// Otherwise EOF in one direction closes the whole relay
+	HalfClose bool
+}
+
+// RelayStats are the bytes copied by a relay in each direction
+type RelayStats struct {
+	AToB int64
+	BToA int64
+}
+
+// ErrRelayIdle is returned by Run when the relay was closed because of the idle timeout
+var ErrRelayIdle = errors.New("relay closed after idle timeout")
+
+// Relay pipes data between two connections in both directions with independent limits and counters.
+// Throttled connections are charged for every chunk, as if the data went through their Read and Write
+type Relay struct {
+	a, b net.Conn
+	cfg  RelayConfig
+
+	aToBLimiter *rate.Limiter
+	bToALimiter *rate.Limiter
+
+	aToB         atomic.Int64
+	bToA         atomic.Int64
+	lastActivity atomic.Int64
+	idle         atomic.Bool
+
+	closeOnce sync.Once
+	done      chan struct{}
+	// ctx is cancelled by Close, so waits for the limiters of the directions end with the relay
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func NewRelay(a, b net.Conn, cfg *RelayConfig) *Relay {
+	if cfg == nil {
+		cfg = &RelayConfig{}
+	}
+
+	r := &Relay{
+		a:    a,
+		b:    b,
+		cfg:  *cfg,
+		done: make(chan struct{}),
+	}
+	r.ctx, r.cancel = context.WithCancel(context.Background())
+
+	if r.cfg.ChunkSize <= 0 {
+		r.cfg.ChunkSize = defaultProxyChunkSize
+	}
+
+	aToBLimit, bToALimit := formatRateLimit(cfg.AToBLimit), formatRateLimit(cfg.BToALimit)
+	r.aToBLimiter = rate.NewLimiter(aToBLimit, parseBurstFromRateLimit(aToBLimit))
+	r.bToALimiter = rate.NewLimiter(bToALimit, parseBurstFromRateLimit(bToALimit))
+
+	return r
+}
+
+// SetLimits changes the limits of both directions while the relay is running, nil means unlimited
+func (r *Relay) SetLimits(aToBLimit *int, bToALimit *int) {
+	updateLimiter(r.aToBLimiter, formatRateLimit(aToBLimit))
+	updateLimiter(r.bToALimiter, formatRateLimit(bToALimit))
+}
+
+func (r *Relay) Stats() RelayStats {
+	return RelayStats{
+		AToB: r.aToB.Load(),
+		BToA: r.bToA.Load(),
+	}
+}
+
+// Run copies data until both directions are done, an error occurs or the relay is closed.
+// Both connections are closed when it returns
+func (r *Relay) Run() error {
+	r.lastActivity.Store(time.Now().UnixNano())
+
+	if r.cfg.IdleTimeout > 0 {
+		go r.watchIdle()
+	}
+
+	wg := sync.WaitGroup{}
+	wg.Add(2)
+
+	var aToBErr, bToAErr error
+	go func() {
+		defer wg.Done()
+		aToBErr = r.copy(r.b, r.a, r.aToBLimiter, &r.aToB)
+	}()
+
+	go func() {
+		defer wg.Done()
+		bToAErr = r.copy(r.a, r.b, r.bToALimiter, &r.bToA)
+	}()
+
+	wg.Wait()
+	r.Close()
+
+	if r.idle.Load() {
+		return ErrRelayIdle
+	}
+
+	// errors caused by closing the connections on purpose are not reported
+	if errors.Is(aToBErr, net.ErrClosed) || errors.Is(aToBErr, context.Canceled) {
+		aToBErr = nil
+	}
+	if errors.Is(bToAErr, net.ErrClosed) || errors.Is(bToAErr, context.Canceled) {
+		bToAErr = nil
+	}
+
+	return errors.Join(aToBErr, bToAErr)
+}
+
+// Close stops the relay by closing both connections
+func (r *Relay) Close() error {
+	var err error
+	r.closeOnce.Do(func() {
+		close(r.done)
+		r.cancel()
+		err = errors.Join(r.a.Close(), r.b.Close())
+	})
+
+	return err
+}
+
+func (r *Relay) watchIdle() {
+	ticker := time.NewTicker(r.cfg.IdleTimeout / 4)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.done:
+			return
+		case now := <-ticker.C:
+			if now.Sub(time.Unix(0, r.lastActivity.Load())) >= r.cfg.IdleTimeout {
+				r.idle.Store(true)
+				r.Close()
+				return
+			}
+		}
+	}
+}
+
+// copy moves data from src to dst chunk by chunk and propagates EOF to dst.
+// The underlying connections are copied between directly, so TCP to TCP is spliced by the kernel.
+// With an idle timeout the reads of a chunk end after a quarter of it, so a slow but live direction
+// is counted and marked active before the chunk is full
+func (r *Relay) copy(dst, src net.Conn, directionLimiter *rate.Limiter, counter *atomic.Int64) error {
+	throttledSrc, _ := src.(*ThrottledConn)
+	throttledDst, _ := dst.(*ThrottledConn)
+	rawSrc, rawDst := unwrapThrottled(src), unwrapThrottled(dst)
+
+	for {
+		var srcLimiters, dstLimiters []*rate.Limiter
+		if throttledSrc != nil {
+			srcLimiters = throttledSrc.activeLimiters(true)
+		}
+		if throttledDst != nil {
+			dstLimiters = throttledDst.activeLimiters(false)
+		}
+
+		chunk := r.cfg.ChunkSize
+		if throttledSrc != nil {
+			chunk = throttledSrc.maxChunk(srcLimiters, chunk)
+		}
+		if throttledDst != nil {
+			chunk = throttledDst.maxChunk(dstLimiters, chunk)
+		}
+		chunk = maxChunk([]*rate.Limiter{directionLimiter}, chunk)
+
+		if r.cfg.IdleTimeout > 0 {
+			rawSrc.SetReadDeadline(time.Now().Add(r.cfg.IdleTimeout / 4))
+		}
+
+		// the budget is charged after the chunk was copied, so waiting for data does not hold tokens
+		n, err := io.CopyN(rawDst, rawSrc, int64(chunk))
+		if n > 0 {
+			counter.Add(n)
+			r.lastActivity.Store(time.Now().UnixNano())
+
+			if throttledSrc != nil {
+				throttledSrc.accountRead(int(n))
+				if waitErr := throttledSrc.wait(srcLimiters, int(n)); waitErr != nil {
+					return waitErr
+				}
+			}
+			if throttledDst != nil {
+				throttledDst.accountWrite(int(n))
+				if waitErr := throttledDst.wait(dstLimiters, int(n)); waitErr != nil {
+					return waitErr
+				}
+			}
+			if waitErr := directionLimiter.WaitN(r.ctx, int(n)); waitErr != nil {
+				return waitErr
+			}
+		}
+
+		if err != nil {
+			// the deadline only ends the chunk early, the idle watchdog decides whether the relay is idle
+			if r.cfg.IdleTimeout > 0 && errors.Is(err, os.ErrDeadlineExceeded) {
+				continue
+			}
+			if !errors.Is(err, io.EOF) {
+				r.Close()
+				return err
+			}
+
+			if r.cfg.HalfClose && closeWrite(dst) {
+				return nil
+			}
+
+			r.Close()
+			return nil
+		}
+	}
+}
@@ -0,0 +1,217 @@
+package netlistener
+
+import (
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestRelay_PerDirectionLimitsAndStats(t *testing.T) {
+	client, serverSide := tcpPair(t)
+	upstreamSide, upstream := tcpPair(t)
+	defer client.Close()
+	defer upstream.Close()
+
+	relay := NewRelay(serverSide, upstreamSide, &RelayConfig{AToBLimit: ptr(1000), HalfClose: true})
+
+	done := make(chan error)
+	go func() {
+		done <- relay.Run()
+	}()
+
+	start := time.Now()
+	go func() {
+		client.Write(make([]byte, 2000))
+		client.(*net.TCPConn).CloseWrite()
+	}()
+	go func() {
+		upstream.Write(make([]byte, 5000))
+		upstream.(*net.TCPConn).CloseWrite()
+	}()
+
+	received, _ := io.ReadAll(upstream)
+	aToBElapsed := time.Since(start)
+
+	// the other direction is still open after the first one was half-closed
+	sent, _ := io.ReadAll(client)
+
+	if err := <-done; err != nil {
+		t.Fatal("unexpected relay error", err)
+	}
+
+	if len(received) != 2000 || len(sent) != 5000 {
+		t.Errorf("expected 2000 and 5000 bytes, got %d and %d", len(received), len(sent))
+	}
+	if stats := relay.Stats(); stats.AToB != 2000 || stats.BToA != 5000 {
+		t.Errorf("unexpected relay stats: %+v", stats)
+	}
+	if aToBElapsed < 900*time.Millisecond || aToBElapsed > 2*time.Second {
+		t.Errorf("expected limited direction to take about 1 second, took %s", aToBElapsed)
+	}
+}
+
+func TestRelay_IdleTimeout(t *testing.T) {
+	client, serverSide := tcpPair(t)
+	upstreamSide, upstream := tcpPair(t)
+	defer client.Close()
+	defer upstream.Close()
+
+	relay := NewRelay(serverSide, upstreamSide, &RelayConfig{IdleTimeout: 200 * time.Millisecond})
+
+	start := time.Now()
+	err := relay.Run()
+
+	if !errors.Is(err, ErrRelayIdle) {
+		t.Errorf("expected ErrRelayIdle, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected relay to be closed after idle timeout, took %s", elapsed)
+	}
+}
+
+func TestRelay_LowRateIsNotIdle(t *testing.T) {
+	client, serverSide := tcpPair(t)
+	upstreamSide, upstream := tcpPair(t)
+	defer client.Close()
+	defer upstream.Close()
+
+	relay := NewRelay(serverSide, upstreamSide, &RelayConfig{IdleTimeout: 400 * time.Millisecond})
+
+	done := make(chan error, 1)
+	go func() {
+		done <- relay.Run()
+	}()
+	go io.Copy(io.Discard, upstream)
+
+	// a few bytes every 100ms for more than twice the idle timeout
+	for i := 0; i < 10; i++ {
+		if _, err := client.Write([]byte("hello")); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	select {
+	case err := <-done:
+		t.Fatalf("expected the relay to stay open, it returned %v", err)
+	default:
+	}
+	// the chunk ends after a quarter of the idle timeout, so the bytes are counted before it is full
+	time.Sleep(100 * time.Millisecond)
+	if stats := relay.Stats(); stats.AToB != 50 {
+		t.Errorf("expected 50 bytes counted, got %d", stats.AToB)
+	}
+
+	relay.Close()
+	<-done
+}
+
+func TestRelay_CloseInterruptsWait(t *testing.T) {
+	client, serverSide := tcpPair(t)
+	upstreamSide, upstream := tcpPair(t)
+	defer client.Close()
+	defer upstream.Close()
+
+	relay := NewRelay(serverSide, upstreamSide, &RelayConfig{AToBLimit: ptr(1000)})
+
+	done := make(chan error, 1)
+	go func() {
+		done <- relay.Run()
+	}()
+	go io.Copy(io.Discard, upstream)
+
+	// the second chunk waits a second for the limiter of the direction
+	client.Write(make([]byte, 2000))
+	time.Sleep(200 * time.Millisecond)
+
+	start := time.Now()
+	relay.Close()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("expected no error after Close, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected Close to interrupt the wait for the limiter")
+	}
+	if elapsed := time.Since(start); elapsed > 400*time.Millisecond {
+		t.Errorf("expected the relay to return right after Close, took %s", elapsed)
+	}
+}
+
+// readFromConn records the readers its ReadFrom is called with
+type readFromConn struct {
+	*net.TCPConn
+	readers chan io.Reader
+}
+
+func (c *readFromConn) ReadFrom(r io.Reader) (int64, error) {
+	if limited, ok := r.(*io.LimitedReader); ok {
+		r = limited.R
+	}
+	select {
+	case c.readers <- r:
+	default:
+	}
+
+	return c.TCPConn.ReadFrom(r)
+}
+
+func TestRelay_CopiesUnderlyingConnections(t *testing.T) {
+	client, serverSide := tcpPair(t)
+	upstreamSide, upstream := tcpPair(t)
+	defer client.Close()
+	defer upstream.Close()
+
+	throttled := NewThrottledConnection(serverSide, NewConnConfig(NewBandwidthConfig(ptr(1000000), nil)))
+	dst := &readFromConn{TCPConn: upstreamSide.(*net.TCPConn), readers: make(chan io.Reader, 1)}
+
+	relay := NewRelay(throttled, dst, &RelayConfig{IdleTimeout: time.Second})
+	done := make(chan error, 1)
+	go func() {
+		done <- relay.Run()
+	}()
+	go io.Copy(io.Discard, upstream)
+
+	client.Write([]byte("hello"))
+
+	select {
+	case r := <-dst.readers:
+		// TCPConn.ReadFrom only splices when it reads from a TCP connection itself
+		if _, ok := r.(*net.TCPConn); !ok {
+			t.Errorf("expected ReadFrom to read from the underlying *net.TCPConn, got %T", r)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the relay to copy with ReadFrom")
+	}
+
+	relay.Close()
+	<-done
+}
+
+func TestRelay_EOFClosesWithoutHalfClose(t *testing.T) {
+	client, serverSide := tcpPair(t)
+	upstreamSide, upstream := tcpPair(t)
+	defer client.Close()
+	defer upstream.Close()
+
+	relay := NewRelay(serverSide, upstreamSide, nil)
+
+	done := make(chan error)
+	go func() {
+		done <- relay.Run()
+	}()
+
+	client.(*net.TCPConn).CloseWrite()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("unexpected relay error: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected relay to stop after EOF")
+	}
+}
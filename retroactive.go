@@ -0,0 +1,61 @@
+package netlistener
+
+import (
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// chargeDebt takes the usage over the window which exceeds what the new limit allows from the limiter,
+// so a tightened limit does not start with a burst on top of the traffic which just passed
+func chargeDebt(limiter *rate.Limiter, usage int64, limit rate.Limit, window time.Duration, now time.Time) {
+	if limit == rate.Inf {
+		return
+	}
+
+	allowed := int64(float64(limit) * window.Seconds())
+	reserve(limiter, usage-allowed, now)
+}
+
+// reserve takes n tokens from the limiter, in steps of the burst since ReserveN does not allow more at once.
+// It takes n/burst steps, so callers bound n, e.g. to a few bursts or to the usage of a window
+func reserve(limiter *rate.Limiter, n int64, now time.Time) {
+	burst := int64(limiter.Burst())
+	if limiter.Limit() == rate.Inf || limiter.Limit() <= 0 || burst <= 0 {
+		return
+	}
+
+	for ; n > 0; n -= burst {
+		limiter.ReserveN(now, int(min(n, burst)))
+	}
+}
+
+// chargeGlobalDebt charges the recent usage of all open connections to the global limiters which were tightened
+// The lock of the config has to be held
+func (c *BandwidthConfig) chargeGlobalDebt(read, write bool, window time.Duration) {
+	now := c.lockedClock().Now()
+
+	var readUsage, writeUsage int64
+	for _, conn := range c.conns.all() {
+		readUsage += conn.readUsage.sum(now, window)
+		writeUsage += conn.writeUsage.sum(now, window)
+	}
+
+	if read {
+		chargeDebt(c.globalReadLimiter, readUsage, c.globalReadLimiter.Limit(), window, now)
+	}
+	if write {
+		chargeDebt(c.globalWriteLimiter, writeUsage, c.globalWriteLimiter.Limit(), window, now)
+	}
+}
+
+// chargeConnDebt charges the recent usage of the connection to its per connection limiter after it was tightened
+func (c *ThrottledConn) chargeConnDebt(limiter *rate.Limiter, usage *usageWindow) {
+	window := c.config.globalConfig.RetroactiveCharging()
+	if window <= 0 {
+		return
+	}
+
+	now := c.config.globalConfig.now()
+	chargeDebt(limiter, usage.sum(now, window), limiter.Limit(), window, now)
+}
@@ -0,0 +1,90 @@
+package netlistener
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestRateLimitedConnection_RetroactiveCharging(t *testing.T) {
+	tests := []struct {
+		name      string
+		window    time.Duration
+		maxTokens float64
+	}{
+		{
+			name:      "Tightened limit starts without debt by default",
+			window:    0,
+			maxTokens: 50,
+		},
+		{
+			name:      "Tightened limit is charged the recent usage",
+			window:    time.Second,
+			maxTokens: -99,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := NewBandwithConfig(nil, nil)
+			config.SetRetroactiveCharging(tt.window)
+
+			connRead, connWrite := net.Pipe()
+			conn := NewThrottledConnection(connWrite, NewConnectionBandwithConfig(config))
+			defer conn.Close()
+			go readDataFromConn(connRead)
+
+			if _, err := conn.Write(make([]byte, 150)); err != nil {
+				t.Fatal(err)
+			}
+
+			// 150 bytes within the last second are 100 more than the new limit allows
+			config.SetPerConnLimit(ptr(50))
+			conn.activeLimiters(false)
+
+			if tokens := conn.config.PerConnWriteLimiter().Tokens(); tokens > tt.maxTokens {
+				t.Errorf("expected at most %f tokens, got %f", tt.maxTokens, tokens)
+			}
+		})
+	}
+}
+
+func TestBandwithConfig_RetroactiveCharging_Global(t *testing.T) {
+	config := NewBandwithConfig(ptr(1000), nil)
+	config.SetRetroactiveCharging(time.Second)
+
+	connRead, connWrite := net.Pipe()
+	conn := NewThrottledConnection(connWrite, NewConnectionBandwithConfig(config))
+	defer conn.Close()
+	go readDataFromConn(connRead)
+
+	if _, err := conn.Write(make([]byte, 500)); err != nil {
+		t.Fatal(err)
+	}
+
+	config.SetGlobalLimit(ptr(100))
+
+	// the bucket is capped at the new burst of 100 and charged the 400 bytes over the new limit
+	if tokens := config.GlobalWriteLimiter().Tokens(); tokens > -290 {
+		t.Errorf("expected the global limiter to be charged the recent usage, got %f tokens", tokens)
+	}
+}
+
+func TestUsageWindow(t *testing.T) {
+	var window usageWindow
+	now := time.Unix(1000, 0)
+
+	window.add(now.Add(-5*time.Second), 100)
+	window.add(now.Add(-time.Second), 10)
+	window.add(now, 1)
+
+	if sum := window.sum(now, 2*time.Second); sum != 11 {
+		t.Errorf("expected 11 bytes within 2 seconds, got %d", sum)
+	}
+	if sum := window.sum(now, 10*time.Second); sum != 111 {
+		t.Errorf("expected 111 bytes within 10 seconds, got %d", sum)
+	}
+	if sum := window.sum(now.Add(20*time.Second), 10*time.Second); sum != 0 {
+		t.Errorf("expected stale slots to be ignored, got %d", sum)
+	}
+}
@@ -0,0 +1,64 @@
+package netlistener
+
+import (
+	"fmt"
+	"math/rand/v2"
+)
+
+// InstrumentationSampling bounds the cost of the detailed per operation instrumentation, e.g. the wait time and connection
+// throughput histograms, on busy listeners. Shaping, counters and events are not sampled, so limits stay exact.
+// Sampled histograms keep their percentiles but count only the samples
+type InstrumentationSampling struct {
+	// Ops instruments one in every Ops operations of an instrumented connection, zero or one instruments all of them
+	Ops int `json:"ops,omitempty"`
+	// ConnPercent instruments the percentage of connections, chosen when they are wrapped. Zero instruments all of them
+	ConnPercent float64 `json:"conn_percent,omitempty"`
+}
+
+func (s InstrumentationSampling) validate() error {
+	if s.Ops < 0 {
+		return fmt.Errorf("negative operation sampling %d", s.Ops)
+	}
+	if s.ConnPercent < 0 || s.ConnPercent > 100 {
+		return fmt.Errorf("connection sampling %v%% out of range", s.ConnPercent)
+	}
+
+	return nil
+}
+
+// sampleConn picks whether a new connection is instrumented
+func (s InstrumentationSampling) sampleConn() bool {
+	return s.ConnPercent == 0 || rand.Float64()*100 < s.ConnPercent
+}
+
+// SetInstrumentationSampling makes the detailed instrumentation run for a sample of the operations or connections only,
+// connections wrapped before keep whether they are instrumented. The zero value instruments everything
+func (c *BandwidthConfig) SetInstrumentationSampling(sampling InstrumentationSampling) error {
+	if err := sampling.validate(); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.sampling = sampling
+
+	return nil
+}
+
+func (c *BandwidthConfig) InstrumentationSampling() InstrumentationSampling {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.sampling
+}
+
+// sampleOp reports whether the current operation of the connection is instrumented
+func (c *ThrottledConn) sampleOp() bool {
+	if !c.instrumented {
+		return false
+	}
+
+	every := c.config.globalConfig.InstrumentationSampling().Ops
+	return every <= 1 || c.ops.Add(1)%uint64(every) == 0
+}
@@ -0,0 +1,76 @@
+package netlistener
+
+import (
+	"net"
+	"testing"
+)
+
+func TestBandwidthConfig_SetInstrumentationSampling(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  InstrumentationSampling
+		wantErr bool
+	}{
+		{name: "Everything", config: InstrumentationSampling{}},
+		{name: "Ops and connections", config: InstrumentationSampling{Ops: 10, ConnPercent: 5}},
+		{name: "Negative ops", config: InstrumentationSampling{Ops: -1}, wantErr: true},
+		{name: "Percentage above 100", config: InstrumentationSampling{ConnPercent: 101}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := NewBandwithConfig(nil, nil)
+			err := config.SetInstrumentationSampling(tt.config)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+			}
+			if !tt.wantErr && config.InstrumentationSampling() != tt.config {
+				t.Errorf("expected %+v, got %+v", tt.config, config.InstrumentationSampling())
+			}
+		})
+	}
+}
+
+func TestRateLimitedConnection_InstrumentationSampling(t *testing.T) {
+	tests := []struct {
+		name     string
+		sampling InstrumentationSampling
+		// expected is the number of waits recorded out of 8 writes
+		expected int64
+	}{
+		{name: "Everything", expected: 8},
+		{name: "One in four operations", sampling: InstrumentationSampling{Ops: 4}, expected: 2},
+		{name: "All connections", sampling: InstrumentationSampling{ConnPercent: 100}, expected: 8},
+		// one in a million connections, the connection of the test is practically never picked
+		{name: "Almost no connections", sampling: InstrumentationSampling{ConnPercent: 0.0001}, expected: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := NewBandwithConfig(nil, ptr(1<<20))
+			if err := config.SetInstrumentationSampling(tt.sampling); err != nil {
+				t.Fatal(err)
+			}
+
+			connRead, connWrite := net.Pipe()
+			conn := NewThrottledConnection(connWrite, NewConnectionBandwithConfig(config))
+			go readDataFromConn(connRead)
+
+			for range 8 {
+				if _, err := conn.Write(make([]byte, 10)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			conn.Close()
+
+			stats := config.Stats()
+			if stats.WaitTimes.Count != tt.expected {
+				t.Errorf("expected %d recorded waits, got %d", tt.expected, stats.WaitTimes.Count)
+			}
+			// the bytes are counted regardless of the sampling
+			if stats.BytesWritten != 80 {
+				t.Errorf("expected 80 bytes written, got %d", stats.BytesWritten)
+			}
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package netlistener
+
+import (
+	"sync"
+	"time"
+)
+
+// ThroughputSample is the number of bytes transferred by all connections within one second
+type ThroughputSample struct {
+	Time         time.Time `json:"time"`
+	BytesRead    int64     `json:"bytes_read"`
+	BytesWritten int64     `json:"bytes_written"`
+}
+
+// throughputHistory keeps one sample per second for the retention, in a ring indexed by the unix second
+type throughputHistory struct {
+	samples []ThroughputSample
+
+	mu sync.Mutex
+}
+
+// SetRetention resizes the ring, dropping the samples recorded so far. Zero disables the history
+func (h *throughputHistory) SetRetention(retention time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.samples = nil
+	if seconds := int((retention + time.Second - 1) / time.Second); seconds > 0 {
+		h.samples = make([]ThroughputSample, seconds)
+	}
+}
+
+func (h *throughputHistory) Retention() time.Duration {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return time.Duration(len(h.samples)) * time.Second
+}
+
+func (h *throughputHistory) add(now time.Time, read, written int64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if len(h.samples) == 0 {
+		return
+	}
+
+	second := now.Truncate(time.Second)
+	sample := &h.samples[second.Unix()%int64(len(h.samples))]
+	if !sample.Time.Equal(second) {
+		*sample = ThroughputSample{Time: second}
+	}
+	sample.BytesRead += read
+	sample.BytesWritten += written
+}
+
+// Samples returns a sample for every second of the retention up to now, oldest first, seconds without traffic included
+func (h *throughputHistory) Samples(now time.Time) []ThroughputSample {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	size := len(h.samples)
+	samples := make([]ThroughputSample, 0, size)
+	current := now.Truncate(time.Second)
+	for i := size - 1; i >= 0; i-- {
+		second := current.Add(-time.Duration(i) * time.Second)
+		if sample := h.samples[second.Unix()%int64(size)]; sample.Time.Equal(second) {
+			samples = append(samples, sample)
+		} else {
+			samples = append(samples, ThroughputSample{Time: second})
+		}
+	}
+
+	return samples
+}
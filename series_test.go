@@ -0,0 +1,42 @@
+package netlistener
+
+import (
+	"testing"
+	"time"
+)
+
+func TestThroughputHistory(t *testing.T) {
+	var history throughputHistory
+	history.SetRetention(3 * time.Second)
+
+	now := time.Unix(1000, 0)
+	history.add(now.Add(-5*time.Second), 1000, 1000)
+	history.add(now.Add(-2*time.Second), 10, 0)
+	history.add(now, 1, 2)
+	history.add(now.Add(500*time.Millisecond), 1, 2)
+
+	samples := history.Samples(now.Add(500 * time.Millisecond))
+	expected := []ThroughputSample{
+		{Time: now.Add(-2 * time.Second), BytesRead: 10},
+		{Time: now.Add(-time.Second)},
+		{Time: now, BytesRead: 2, BytesWritten: 4},
+	}
+
+	if len(samples) != len(expected) {
+		t.Fatalf("expected %d samples, got %+v", len(expected), samples)
+	}
+	for i := range expected {
+		if !samples[i].Time.Equal(expected[i].Time) || samples[i].BytesRead != expected[i].BytesRead || samples[i].BytesWritten != expected[i].BytesWritten {
+			t.Errorf("expected sample %+v at %d, got %+v", expected[i], i, samples[i])
+		}
+	}
+}
+
+func TestThroughputHistory_Disabled(t *testing.T) {
+	var history throughputHistory
+	history.add(time.Now(), 1, 1)
+
+	if samples := history.Samples(time.Now()); len(samples) != 0 {
+		t.Errorf("expected no samples while disabled, got %+v", samples)
+	}
+}
@@ -0,0 +1,100 @@
+package netlistener
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"runtime/debug"
+	"time"
+)
+
+const (
+	// serveMinBackoff and serveMaxBackoff bound the delay before accepting again after a temporary error
+	serveMinBackoff = 5 * time.Millisecond
+	serveMaxBackoff = time.Second
+)
+
+// Handler serves a throttled connection, Serve closes the connection once the handler returned or panicked
+type Handler func(conn *ThrottledConn)
+
+// SetServeConcurrency limits the number of handlers Serve runs at once, Serve stops accepting while the limit is reached
+// and connections wait in the backlog of the kernel. Zero means no limit. It applies to Serve calls started afterwards
+func (l *Listener) SetServeConcurrency(handlers int) {
+	l.serveConcurrency.Store(int64(handlers))
+}
+
+// Serve accepts connections and runs the handler for each of them in its own goroutine, until the context is done
+// or accepting fails with an error other than a timeout. Temporary errors are retried with an exponential backoff,
+// a panicking handler is recovered, emitted as EventHandlerPanic and passed to the error handler. When the context is done the listener is closed
+// and the error of the context is returned, handlers which are still running are not waited for
+func (l *Listener) Serve(ctx context.Context, handler Handler) error {
+	stop := context.AfterFunc(ctx, func() {
+		l.Close()
+	})
+	defer stop()
+
+	var slots chan struct{}
+	if concurrency := l.serveConcurrency.Load(); concurrency > 0 {
+		slots = make(chan struct{}, concurrency)
+	}
+
+	backoff := time.Duration(0)
+	for {
+		if slots != nil {
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		conn, err := l.Accept()
+		if err != nil {
+			if slots != nil {
+				<-slots
+			}
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+				return err
+			}
+
+			backoff = min(max(2*backoff, serveMinBackoff), serveMaxBackoff)
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			continue
+		}
+		backoff = 0
+
+		go func() {
+			if slots != nil {
+				defer func() { <-slots }()
+			}
+			l.serveConn(conn.(*ThrottledConn), handler)
+		}()
+	}
+}
+
+// serveConn runs the handler, recovering from a panic in it, and closes the connection afterwards
+func (l *Listener) serveConn(conn *ThrottledConn, handler Handler) {
+	defer conn.Close()
+	defer func() {
+		if r := recover(); r != nil {
+			stack := debug.Stack()
+			l.config.emit(Event{
+				Type:      EventHandlerPanic,
+				Time:      time.Now(),
+				Peer:      peerKey(conn.Conn),
+				Details:   fmt.Sprintf("%v\n%s", r, stack),
+				ConnState: conn.lifecycle.Load().String(),
+			})
+			l.config.reportError(&PanicError{Op: "serve", Value: r, Stack: stack})
+		}
+	}()
+
+	handler(conn)
+}
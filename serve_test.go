@@ -0,0 +1,124 @@
+package netlistener
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestListener_Serve(t *testing.T) {
+	tests := []struct {
+		name        string
+		concurrency int
+		handler     func(conn *ThrottledConn)
+		// expectedPanics is the number of EventHandlerPanic events for the two connections
+		expectedPanics int
+		// expectedMaxRunning is the largest number of handlers running at once, zero is not checked
+		expectedMaxRunning int64
+	}{
+		{name: "Handlers run concurrently", handler: func(conn *ThrottledConn) {
+			time.Sleep(100 * time.Millisecond)
+		}, expectedMaxRunning: 2},
+		{name: "Concurrency limit", concurrency: 1, handler: func(conn *ThrottledConn) {
+			time.Sleep(100 * time.Millisecond)
+		}, expectedMaxRunning: 1},
+		{name: "Panicking handler is recovered", handler: func(conn *ThrottledConn) {
+			panic("handler failed")
+		}, expectedPanics: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			throttledListener, _ := NewListener(listener, nil, nil, WithServeConcurrency(tt.concurrency))
+
+			var mu sync.Mutex
+			panics := 0
+			throttledListener.SetEventHandler(func(event Event) {
+				if event.Type == EventHandlerPanic {
+					mu.Lock()
+					panics++
+					mu.Unlock()
+				}
+			})
+
+			var running, maxRunning atomic.Int64
+			ctx, cancel := context.WithCancel(context.Background())
+			served := make(chan error, 1)
+			go func() {
+				served <- throttledListener.Serve(ctx, func(conn *ThrottledConn) {
+					current := running.Add(1)
+					defer running.Add(-1)
+					for {
+						if previous := maxRunning.Load(); current <= previous || maxRunning.CompareAndSwap(previous, current) {
+							break
+						}
+					}
+
+					tt.handler(conn)
+				})
+			}()
+
+			for range 2 {
+				conn, err := net.Dial("tcp", listener.Addr().String())
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer conn.Close()
+
+				// the connection is closed by Serve once its handler is done
+				conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+				go io.Copy(io.Discard, conn)
+			}
+
+			time.Sleep(400 * time.Millisecond)
+			cancel()
+			if err := <-served; !errors.Is(err, context.Canceled) {
+				t.Errorf("expected context.Canceled, got %v", err)
+			}
+
+			if got := maxRunning.Load(); tt.expectedMaxRunning > 0 && got != tt.expectedMaxRunning {
+				t.Errorf("expected at most %d handlers at once, got %d", tt.expectedMaxRunning, got)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if panics != tt.expectedPanics {
+				t.Errorf("expected %d panics, got %d", tt.expectedPanics, panics)
+			}
+		})
+	}
+}
+
+func TestListener_ServeClosesConnections(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	throttledListener, _ := NewListener(listener, nil, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go throttledListener.Serve(ctx, func(conn *ThrottledConn) {
+		conn.Write([]byte("hello"))
+	})
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	data, err := io.ReadAll(conn)
+	if err != nil || string(data) != "hello" {
+		t.Errorf("expected the greeting followed by EOF, got %q: %v", data, err)
+	}
+}
@@ -0,0 +1,178 @@
+package netlistener
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// ErrNotThrottled is returned when a connection was expected to be created by this package
+var ErrNotThrottled = errors.New("connection is not a throttled connection")
+
+// SessionConfig configures a Session, all hooks are optional and called synchronously
+type SessionConfig struct {
+	// Limit is shared by all connections of the session in bytes per second, nil means unlimited
+	Limit *int
+	// OnBind is called after a connection was bound to the session
+	OnBind func(session *Session, conn net.Conn)
+	// OnRelease is called after a bound connection was closed or moved to another session
+	OnRelease func(session *Session, conn net.Conn)
+	// OnEmpty is called when the last bound connection was released
+	OnEmpty func(session *Session)
+}
+
+// SessionStats are the counters of all connections bound to a session during its lifetime
+type SessionStats struct {
+	ActiveConns  int64     `json:"active_conns"`
+	TotalConns   int64     `json:"total_conns"`
+	BytesRead    int64     `json:"bytes_read"`
+	BytesWritten int64     `json:"bytes_written"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// Session spans multiple connections of the same client, e.g. HTTP/1.1 keep-alive connections or parallel downloads,
+// which share the session limit in addition to their own limits
+type Session struct {
+	readLimiter  *rate.Limiter
+	writeLimiter *rate.Limiter
+
+	activeConns  atomic.Int64
+	totalConns   atomic.Int64
+	bytesRead    atomic.Int64
+	bytesWritten atomic.Int64
+	createdAt    time.Time
+	// configs are the configs of the connections bound so far, whose waits are woken up when the limit is raised
+	configs sync.Map
+
+	cfg SessionConfig
+}
+
+func NewSession(cfg *SessionConfig) *Session {
+	if cfg == nil {
+		cfg = &SessionConfig{}
+	}
+
+	limit := formatRateLimit(cfg.Limit)
+
+	return &Session{
+		readLimiter:  rate.NewLimiter(limit, parseBurstFromRateLimit(limit)),
+		writeLimiter: rate.NewLimiter(limit, parseBurstFromRateLimit(limit)),
+		createdAt:    time.Now(),
+		cfg:          *cfg,
+	}
+}
+
+// SetLimit changes the session limit, connections already bound pick it up with their next operation,
+// operations waiting for a limit which was raised are woken to wait again with the new one
+func (s *Session) SetLimit(limit *int) {
+	raised := formatRateLimit(limit) > s.writeLimiter.Limit() || formatRateLimit(limit) > s.readLimiter.Limit()
+
+	updateLimiter(s.readLimiter, formatRateLimit(limit))
+	updateLimiter(s.writeLimiter, formatRateLimit(limit))
+
+	if raised {
+		s.configs.Range(func(config, _ any) bool {
+			config.(*BandwidthConfig).limitUpdates.Notify()
+			return true
+		})
+	}
+}
+
+func (s *Session) Stats() SessionStats {
+	return SessionStats{
+		ActiveConns:  s.activeConns.Load(),
+		TotalConns:   s.totalConns.Load(),
+		BytesRead:    s.bytesRead.Load(),
+		BytesWritten: s.bytesWritten.Load(),
+		CreatedAt:    s.createdAt,
+	}
+}
+
+// Bind adds a throttled connection to the session, releasing it from its previous session if there was one.
+// Only the traffic after binding is counted in the session stats. Closed connections are not bound, Bind fails with net.ErrClosed
+func (s *Session) Bind(conn net.Conn) error {
+	throttled, ok := conn.(*ThrottledConn)
+	if !ok {
+		return ErrNotThrottled
+	}
+	if throttled.isClosed() {
+		return net.ErrClosed
+	}
+
+	// the connection is counted before it is bound, so a concurrent Close releasing it never takes the count below zero
+	s.activeConns.Add(1)
+	previous := throttled.config.SwapSession(s)
+	if previous == s {
+		s.activeConns.Add(-1)
+		return nil
+	}
+	if previous != nil {
+		previous.release(conn)
+	}
+	s.configs.Store(throttled.config.globalConfig, struct{}{})
+	s.totalConns.Add(1)
+
+	// a connection closed while it was bound may have released its previous session only, it is released here then
+	if throttled.isClosed() {
+		if session := throttled.config.SwapSession(nil); session != nil {
+			session.release(conn)
+		}
+		return net.ErrClosed
+	}
+
+	if s.cfg.OnBind != nil {
+		s.cfg.OnBind(s, conn)
+	}
+
+	return nil
+}
+
+// release is called when a bound connection is closed or moved to another session
+func (s *Session) release(conn net.Conn) {
+	active := s.activeConns.Add(-1)
+
+	if s.cfg.OnRelease != nil {
+		s.cfg.OnRelease(s, conn)
+	}
+
+	if active == 0 && s.cfg.OnEmpty != nil {
+		s.cfg.OnEmpty(s)
+	}
+}
+
+// unbind releases the connection from the session if it is still bound to it
+func (s *Session) unbind(throttled *ThrottledConn) {
+	if throttled.config.compareAndSwapSession(s, nil) {
+		s.release(throttled)
+	}
+}
+
+// LinkConnections puts throttled connections under one combined limit in bytes per second,
+// e.g. the control and data channels of an FTP session, so the session as a whole does not exceed it.
+// Each connection keeps its own limits as well. It is a shorthand for binding the connections to a new Session.
+// It fails with the first error of Bind, e.g. net.ErrClosed for a closed connection, and releases the connections
+// bound before then, which are no longer bound to the session they were bound to before
+func LinkConnections(limit *int, conns ...net.Conn) error {
+	for _, conn := range conns {
+		if _, ok := conn.(*ThrottledConn); !ok {
+			return ErrNotThrottled
+		}
+	}
+
+	session := NewSession(&SessionConfig{Limit: limit})
+	for i, conn := range conns {
+		if err := session.Bind(conn); err != nil {
+			for _, bound := range conns[:i] {
+				session.unbind(bound.(*ThrottledConn))
+			}
+
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,170 @@
+package netlistener
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLinkConnections(t *testing.T) {
+	config := NewBandwithConfig(nil, ptr(1000))
+
+	controlRead, controlWrite := net.Pipe()
+	dataRead, dataWrite := net.Pipe()
+	control := NewThrottledConnection(controlWrite, NewConnectionBandwithConfig(config))
+	data := NewThrottledConnection(dataWrite, NewConnectionBandwithConfig(config))
+	go readDataFromConn(controlRead)
+	go readDataFromConn(dataRead)
+
+	if err := LinkConnections(ptr(100), control, data); err != nil {
+		t.Fatal(err)
+	}
+
+	wg := sync.WaitGroup{}
+	wg.Add(2)
+	start := time.Now()
+
+	// each connection is within its own limit, but together they exceed the session limit
+	for _, conn := range []net.Conn{control, data} {
+		go func(conn net.Conn) {
+			defer wg.Done()
+			writeRandomDataToConn(conn, 100)
+		}(conn)
+	}
+
+	wg.Wait()
+
+	if elapsed := time.Since(start); elapsed < 900*time.Millisecond || elapsed > 2*time.Second {
+		t.Errorf("expected linked connections to share the limit, took %s", elapsed)
+	}
+}
+
+func TestLinkConnections_NotThrottled(t *testing.T) {
+	connRead, connWrite := net.Pipe()
+	defer connRead.Close()
+	defer connWrite.Close()
+
+	if err := LinkConnections(ptr(100), connWrite); err != ErrNotThrottled {
+		t.Errorf("expected ErrNotThrottled, got %v", err)
+	}
+}
+
+func TestLinkConnections_Closed(t *testing.T) {
+	config := NewBandwidthConfig(nil, nil)
+
+	_, firstWrite := net.Pipe()
+	_, closedWrite := net.Pipe()
+	first := NewThrottledConnection(firstWrite, NewConnConfig(config))
+	defer first.Close()
+	closed := NewThrottledConnection(closedWrite, NewConnConfig(config))
+	closed.Close()
+
+	if err := LinkConnections(ptr(100), first, closed); !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("expected net.ErrClosed, got %v", err)
+	}
+	if session := first.config.Session(); session != nil {
+		t.Errorf("expected the connection bound before the error to be released, got %+v", session.Stats())
+	}
+}
+
+func TestSession_StatsAndHooks(t *testing.T) {
+	config := NewBandwithConfig(nil, nil)
+
+	bound, released, emptied := 0, 0, 0
+	session := NewSession(&SessionConfig{
+		OnBind:    func(session *Session, conn net.Conn) { bound++ },
+		OnRelease: func(session *Session, conn net.Conn) { released++ },
+		OnEmpty:   func(session *Session) { emptied++ },
+	})
+
+	conns := make([]net.Conn, 0, 2)
+	for i := 0; i < 2; i++ {
+		connRead, connWrite := net.Pipe()
+		conn := NewThrottledConnection(connWrite, NewConnectionBandwithConfig(config))
+		go readDataFromConn(connRead)
+
+		if err := session.Bind(conn); err != nil {
+			t.Fatal(err)
+		}
+		// binding twice is a no-op
+		session.Bind(conn)
+
+		conn.Write(make([]byte, 10))
+		conns = append(conns, conn)
+	}
+
+	stats := session.Stats()
+	if stats.ActiveConns != 2 || stats.TotalConns != 2 || stats.BytesWritten != 20 {
+		t.Errorf("unexpected session stats: %+v", stats)
+	}
+
+	conns[0].Close()
+	if emptied != 0 {
+		t.Error("expected session not to be empty with one connection left")
+	}
+
+	// moving a connection to another session releases it from the first one
+	NewSession(nil).Bind(conns[1])
+
+	if bound != 2 || released != 2 || emptied != 1 {
+		t.Errorf("unexpected hook calls: bound %d, released %d, emptied %d", bound, released, emptied)
+	}
+	if active := session.Stats().ActiveConns; active != 0 {
+		t.Errorf("expected no active connections, got %d", active)
+	}
+
+	conns[1].Close()
+}
+
+func TestSession_BindClosed(t *testing.T) {
+	emptied := 0
+	session := NewSession(&SessionConfig{OnEmpty: func(session *Session) { emptied++ }})
+
+	_, server := net.Pipe()
+	conn := NewThrottledConnection(server, NewConnectionBandwithConfig(NewBandwithConfig(nil, nil)))
+	conn.Close()
+
+	if err := session.Bind(conn); err != net.ErrClosed {
+		t.Errorf("expected net.ErrClosed, got %v", err)
+	}
+	if stats := session.Stats(); stats.ActiveConns != 0 || stats.TotalConns != 0 {
+		t.Errorf("expected the closed connection not to be counted, got %+v", stats)
+	}
+	if conn.config.Session() != nil {
+		t.Error("expected the closed connection not to be bound")
+	}
+}
+
+func TestSession_SetLimitWakesWaits(t *testing.T) {
+	session := NewSession(&SessionConfig{Limit: ptr(10)})
+
+	connRead, connWrite := net.Pipe()
+	defer connRead.Close()
+	go readDataFromConn(connRead)
+
+	conn := NewThrottledConnection(connWrite, NewConnectionBandwithConfig(NewBandwithConfig(nil, nil)))
+	defer conn.Close()
+	if err := session.Bind(conn); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		// about 10 seconds at the initial limit
+		_, err := conn.Write(make([]byte, 100))
+		done <- err
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	session.SetLimit(ptr(1000000))
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected the raised session limit to wake the waiting write")
+	}
+}
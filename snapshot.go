@@ -0,0 +1,231 @@
+package netlistener
+
+import (
+	"fmt"
+	"maps"
+	"math"
+	"net"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// ConfigSnapshot is the fully resolved configuration at a point in time, meant for support and debugging.
+// Limits are in bytes per second, nil means unlimited
+type ConfigSnapshot struct {
+	GlobalReadLimit   *int `json:"global_read_limit"`
+	GlobalWriteLimit  *int `json:"global_write_limit"`
+	PerConnReadLimit  *int `json:"per_conn_read_limit"`
+	PerConnWriteLimit *int `json:"per_conn_write_limit"`
+	// FamilyLimits are the limits of the address families by "ipv4" and "ipv6"
+	FamilyLimits map[string]*int `json:"family_limits,omitempty"`
+	// InterfaceLimits are the limits of the network interfaces by name
+	InterfaceLimits map[string]*int `json:"interface_limits,omitempty"`
+
+	ExemptCIDRs []string `json:"exempt_cidrs"`
+	ExemptFunc  bool     `json:"exempt_func"`
+	// LocalExemption lists the local address ranges which are exempt, e.g. "loopback,private"
+	LocalExemption string `json:"local_exemption,omitempty"`
+	// LimitChangeApprover tells whether connections may request limit changes
+	LimitChangeApprover bool `json:"limit_change_approver,omitempty"`
+	// LimitHints tells whether a handler is told about changes of the effective per connection limits
+	LimitHints        bool  `json:"limit_hints,omitempty"`
+	PreambleExemption int64 `json:"preamble_exemption,omitempty"`
+	WarmupExemption   int64 `json:"warmup_exemption,omitempty"`
+
+	HealthCheck   *HealthCheckSnapshot `json:"health_check,omitempty"`
+	PacingSpin    time.Duration        `json:"pacing_spin,omitempty"`
+	PrecisionMode bool                 `json:"precision_mode,omitempty"`
+	WaitJitter    time.Duration        `json:"wait_jitter,omitempty"`
+	SharingMode   string               `json:"sharing_mode"`
+	EvenSplit     *EvenSplit           `json:"even_split,omitempty"`
+
+	RetroactiveCharging time.Duration     `json:"retroactive_charging,omitempty"`
+	BurstDebt           time.Duration     `json:"burst_debt,omitempty"`
+	StrictMode          time.Duration     `json:"strict_mode,omitempty"`
+	TransferProgress    *TransferProgress `json:"transfer_progress,omitempty"`
+	MaxConns            int64             `json:"max_conns,omitempty"`
+	MaxConnsPerIP       int               `json:"max_conns_per_ip,omitempty"`
+	ThroughputHistory   time.Duration     `json:"throughput_history,omitempty"`
+	// ThroughputEstimation is the window of the throughput estimates of the connections, zero when they are disabled
+	ThroughputEstimation time.Duration `json:"throughput_estimation,omitempty"`
+	QueuePacing          bool          `json:"queue_pacing,omitempty"`
+	ProfilerLabels       bool          `json:"profiler_labels,omitempty"`
+	ConnTracing          bool          `json:"conn_tracing,omitempty"`
+	// InstrumentationSampling is omitted while everything is instrumented
+	InstrumentationSampling *InstrumentationSampling `json:"instrumentation_sampling,omitempty"`
+	WriteDeadlinePolicy     string                   `json:"write_deadline_policy"`
+	TLSAccounting           string                   `json:"tls_accounting"`
+
+	TCPKeepAlive  *net.KeepAliveConfig `json:"tcp_keep_alive,omitempty"`
+	LivenessProbe *LivenessProbe       `json:"liveness_probe,omitempty"`
+	ReverseDNS    *ReverseDNS          `json:"reverse_dns,omitempty"`
+	// OriginalDst tells whether the original destination of redirected connections is passed to the classifier
+	OriginalDst bool `json:"original_dst,omitempty"`
+	// Accounting is the AccountingMode, AccountingInterval the interval of sampled accounting
+	Accounting         string        `json:"accounting"`
+	AccountingInterval time.Duration `json:"accounting_interval,omitempty"`
+	// AbuseDetection detects many distinct sources being rejected, nil if it is disabled
+	AbuseDetection *AbuseDetection `json:"abuse_detection,omitempty"`
+	// PeerPrefix aggregates remote IPs into prefixes, nil if single addresses are kept
+	PeerPrefix *PeerPrefix `json:"peer_prefix,omitempty"`
+	// PeerEviction bounds the per IP state, nil if all peers are kept
+	PeerEviction *PeerEviction `json:"peer_eviction,omitempty"`
+	// StatsBatching batches the bytes counted in the stats, nil if every operation is counted right away
+	StatsBatching *StatsBatching `json:"stats_batching,omitempty"`
+	// TimerWheel wakes the waits for the limiters, nil if runtime timers do
+	TimerWheel *TimerWheel `json:"timer_wheel,omitempty"`
+	// LimitRamp is the duration changes of the global and per connection limits are spread over
+	LimitRamp time.Duration `json:"limit_ramp,omitempty"`
+	// OffPeakUnlimited is the daily window all limits are lifted in, e.g. "22:00-06:00 Europe/Berlin"
+	OffPeakUnlimited string `json:"off_peak_unlimited,omitempty"`
+	// Boost lifts the per connection limit at the start of connections
+	Boost *Boost `json:"boost,omitempty"`
+	// Mirror tells whether the data of a sample of the connections is mirrored to a secondary sink
+	Mirror bool `json:"mirror,omitempty"`
+	// Clock is the type of the time source of the limiters, empty for SystemClock
+	Clock string `json:"clock,omitempty"`
+
+	PeerTracking  bool           `json:"peer_tracking"`
+	PenaltyPolicy *PenaltyPolicy `json:"penalty_policy,omitempty"`
+	// PeerQuota caps the bytes of each peer, nil if there is none
+	PeerQuota *PeerQuota `json:"peer_quota,omitempty"`
+
+	Classes      []ClassConfig `json:"classes,omitempty"`
+	DefaultClass string        `json:"default_class,omitempty"`
+	// Profiles are the built-in and registered profiles
+	Profiles       []Profile         `json:"profiles,omitempty"`
+	DefaultProfile string            `json:"default_profile,omitempty"`
+	ALPNClasses    map[string]string `json:"alpn_classes,omitempty"`
+	ClassDSCP      map[string]int    `json:"class_dscp,omitempty"`
+
+	// Classifier describes the type of the configured classifier, the rules are included if it is a Policy
+	Classifier string  `json:"classifier,omitempty"`
+	Policy     *Policy `json:"policy,omitempty"`
+}
+
+type HealthCheckSnapshot struct {
+	MaxDuration time.Duration `json:"max_duration"`
+	MaxBytes    int64         `json:"max_bytes"`
+}
+
+// Snapshot returns the current configuration
+func (c *BandwidthConfig) Snapshot() ConfigSnapshot {
+	c.mu.RLock()
+	snapshot := ConfigSnapshot{
+		GlobalReadLimit:   limitToInt(c.globalReadLimiter.Limit()),
+		GlobalWriteLimit:  limitToInt(c.globalWriteLimiter.Limit()),
+		PerConnReadLimit:  limitToInt(c.perConnReadLimit),
+		PerConnWriteLimit: limitToInt(c.perConnWriteLimit),
+	}
+	classifier := c.classifier
+	snapshot.PreambleExemption = c.preambleExemption
+	snapshot.WarmupExemption = c.warmupExemption
+	snapshot.PacingSpin = c.pacingSpin
+	snapshot.PrecisionMode = c.precisionMode
+	snapshot.WaitJitter = c.waitJitter
+	snapshot.SharingMode = c.sharing.String()
+	if c.evenSplit != nil {
+		split := *c.evenSplit
+		snapshot.EvenSplit = &split
+	}
+	snapshot.RetroactiveCharging = c.retroactiveWindow
+	snapshot.BurstDebt = c.burstDebtPayback
+	snapshot.StrictMode = c.strictInterval
+	snapshot.ThroughputEstimation = c.estimationWindow
+	if c.progress != nil {
+		progress := *c.progress
+		snapshot.TransferProgress = &progress
+	}
+	snapshot.QueuePacing = c.queuePacing
+	snapshot.LimitChangeApprover = c.limitChangeApprover != nil
+	snapshot.LimitHints = c.limitHintHandler != nil
+	snapshot.ProfilerLabels = c.profilerLabels
+	snapshot.ConnTracing = c.connTracer != nil
+	if c.sampling != (InstrumentationSampling{}) {
+		sampling := c.sampling
+		snapshot.InstrumentationSampling = &sampling
+	}
+	snapshot.WriteDeadlinePolicy = c.writeDeadlinePolicy.String()
+	snapshot.TLSAccounting = c.tlsAccounting.String()
+	if c.clock != nil && c.clock != SystemClock {
+		snapshot.Clock = fmt.Sprintf("%T", c.clock)
+	}
+	snapshot.OriginalDst = c.originalDst
+	snapshot.Mirror = c.mirror != nil
+	snapshot.Boost = cloneBoost(c.boost)
+	snapshot.LimitRamp = c.rampDuration
+	snapshot.StatsBatching = c.StatsBatching()
+	snapshot.PeerEviction = c.PeerEviction()
+	snapshot.PeerPrefix = c.PeerPrefix()
+	snapshot.AbuseDetection = c.AbuseDetection()
+	mode, interval := c.AccountingMode()
+	snapshot.Accounting, snapshot.AccountingInterval = mode.String(), interval
+	if c.wheel != nil {
+		wheel := c.wheel.config
+		snapshot.TimerWheel = &wheel
+	}
+	if c.offPeak != nil {
+		snapshot.OffPeakUnlimited = c.offPeak.String()
+	}
+	if c.keepAlive != nil {
+		keepAlive := *c.keepAlive
+		snapshot.TCPKeepAlive = &keepAlive
+	}
+	if c.liveness != nil {
+		liveness := *c.liveness
+		snapshot.LivenessProbe = &liveness
+	}
+	snapshot.ALPNClasses = maps.Clone(c.alpnClasses)
+	snapshot.ClassDSCP = maps.Clone(c.classDSCP)
+	c.mu.RUnlock()
+
+	snapshot.FamilyLimits = c.families.Limits()
+	if limits := c.InterfaceLimits(); limits != nil {
+		snapshot.InterfaceLimits = limits.Limits()
+	}
+	snapshot.ReverseDNS = c.ReverseDNS()
+	snapshot.MaxConns, snapshot.MaxConnsPerIP = c.caps.Get()
+	snapshot.ThroughputHistory = c.throughput.Retention()
+	snapshot.ExemptCIDRs = c.exemptions.CIDRs()
+	snapshot.ExemptFunc = c.exemptions.HasFunc()
+	snapshot.LocalExemption = c.exemptions.Local().String()
+
+	if maxDuration, maxBytes, enabled := c.healthCheck.Get(); enabled {
+		snapshot.HealthCheck = &HealthCheckSnapshot{MaxDuration: maxDuration, MaxBytes: maxBytes}
+	}
+
+	snapshot.Classes, snapshot.DefaultClass = c.classes.Configs()
+	snapshot.Profiles, snapshot.DefaultProfile = c.profiles.Profiles(), c.profiles.Default()
+	snapshot.PeerTracking = c.peers.Enabled()
+	if policy := c.penalties.Policy(); policy != nil {
+		penalty := *policy
+		snapshot.PenaltyPolicy = &penalty
+	}
+	snapshot.PeerQuota = c.PeerQuota()
+
+	if classifier != nil {
+		snapshot.Classifier = fmt.Sprintf("%T", classifier)
+		if policy, ok := classifier.(*Policy); ok {
+			snapshot.Policy = policy
+		}
+	}
+
+	return snapshot
+}
+
+// limitToInt converts a limit to bytes per second, returning nil for unlimited
+func limitToInt(limit rate.Limit) *int {
+	if limit == rate.Inf {
+		return nil
+	}
+
+	if limit >= rate.Limit(math.MaxInt) {
+		value := math.MaxInt
+		return &value
+	}
+
+	value := int(limit)
+
+	return &value
+}
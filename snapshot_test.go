@@ -0,0 +1,47 @@
+package netlistener
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestBandwithConfig_Snapshot(t *testing.T) {
+	config := NewBandwithConfig(ptr(1000), nil)
+	if err := config.SetExemptCIDRs("10.0.0.0/8"); err != nil {
+		t.Fatal(err)
+	}
+	config.SetHealthCheckDetection(100*time.Millisecond, 10)
+	config.SetPenaltyPolicy(&PenaltyPolicy{Threshold: 1, Window: time.Second, Limit: 10, Cooldown: time.Minute})
+
+	policy, err := ParsePolicy([]byte(testPolicy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.SetClassifier(policy)
+
+	snapshot := config.Snapshot()
+
+	if snapshot.GlobalReadLimit == nil || *snapshot.GlobalReadLimit != 1000 {
+		t.Errorf("expected global read limit of 1000, got %v", snapshot.GlobalReadLimit)
+	}
+	if snapshot.PerConnReadLimit != nil {
+		t.Errorf("expected unlimited per conn read limit, got %v", *snapshot.PerConnReadLimit)
+	}
+	if len(snapshot.ExemptCIDRs) != 1 || snapshot.ExemptCIDRs[0] != "10.0.0.0/8" {
+		t.Errorf("unexpected exempt CIDRs: %v", snapshot.ExemptCIDRs)
+	}
+	if snapshot.HealthCheck == nil || snapshot.HealthCheck.MaxBytes != 10 {
+		t.Errorf("unexpected health check: %+v", snapshot.HealthCheck)
+	}
+	if !snapshot.PeerTracking || snapshot.PenaltyPolicy == nil {
+		t.Error("expected peer tracking and penalty policy to be part of the snapshot")
+	}
+	if snapshot.Policy == nil || len(snapshot.Policy.Rules) != len(policy.Rules) {
+		t.Error("expected policy rules to be part of the snapshot")
+	}
+
+	if _, err := json.Marshal(snapshot); err != nil {
+		t.Errorf("expected snapshot to be serializable: %v", err)
+	}
+}
@@ -0,0 +1,324 @@
+package netlistener
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+const (
+	socks5Version = 0x05
+
+	socks5AuthNone         = 0x00
+	socks5AuthPassword     = 0x02
+	socks5AuthNoAcceptable = 0xff
+	socks5PasswordVersion  = 0x01
+
+	socks5CmdConnect = 0x01
+
+	socks5AddrIPv4   = 0x01
+	socks5AddrDomain = 0x03
+	socks5AddrIPv6   = 0x04
+
+	socks5ReplySucceeded           = 0x00
+	socks5ReplyNotAllowed          = 0x02
+	socks5ReplyHostUnreachable     = 0x04
+	socks5ReplyCommandNotSupported = 0x07
+	socks5ReplyAddrNotSupported    = 0x08
+)
+
+// SOCKS5User is a user allowed to authenticate with username and password
+type SOCKS5User struct {
+	Password string
+	// Class is the traffic class the connections of the user are assigned to, so they share its limiters
+	Class string
+	// PerConnLimit overrides the per connection limit for the user in bytes per second, nil keeps the configured one
+	PerConnLimit *int
+}
+
+// SOCKS5Config configures a SOCKS5Server
+type SOCKS5Config struct {
+	// Users enables username/password authentication, when empty no authentication is required
+	Users map[string]SOCKS5User
+	// Dial connects to the requested destination, defaults to net.Dialer
+	Dial func(ctx context.Context, network, address string) (net.Conn, error)
+	// Allow decides whether the user, empty without authentication, may connect to the requested destination, a host and port
+	// as sent by the client. Denied requests get the reply "connection not allowed by ruleset". Nil allows every destination,
+	// so the server is an open proxy for everyone who can reach it and authenticate, e.g. to hosts of the internal network
+	Allow func(user, address string) bool
+	// HandshakeTimeout limits the time a client has to authenticate and send its request, defaults to 10 seconds
+	HandshakeTimeout time.Duration
+	// IdleTimeout closes relayed connections without traffic, zero disables it
+	IdleTimeout time.Duration
+}
+
+// SOCKS5Server is a throttled forward proxy supporting the CONNECT command.
+// Client connections are accepted from the throttled listener, so all of its limits apply, and relayed to the destination
+type SOCKS5Server struct {
+	listener *Listener
+	cfg      SOCKS5Config
+}
+
+func NewSOCKS5Server(l *Listener, cfg *SOCKS5Config) *SOCKS5Server {
+	if cfg == nil {
+		cfg = &SOCKS5Config{}
+	}
+
+	s := &SOCKS5Server{
+		listener: l,
+		cfg:      *cfg,
+	}
+
+	if s.cfg.Dial == nil {
+		s.cfg.Dial = (&net.Dialer{}).DialContext
+	}
+	if s.cfg.HandshakeTimeout <= 0 {
+		s.cfg.HandshakeTimeout = 10 * time.Second
+	}
+
+	return s
+}
+
+// Serve accepts connections until the listener is closed
+func (s *SOCKS5Server) Serve() error {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return err
+		}
+
+		go s.ServeConn(conn)
+	}
+}
+
+// ServeConn handles a single client connection, it is closed when the function returns
+func (s *SOCKS5Server) ServeConn(conn net.Conn) error {
+	upstream, err := s.handshake(conn)
+	if err != nil {
+		conn.Close()
+		recordHandshakeTimeout(conn, err)
+		return err
+	}
+
+	return NewRelay(conn, upstream, &RelayConfig{IdleTimeout: s.cfg.IdleTimeout, HalfClose: true}).Run()
+}
+
+// handshake authenticates the client, connects to the requested destination and sends the reply
+func (s *SOCKS5Server) handshake(conn net.Conn) (net.Conn, error) {
+	conn.SetDeadline(time.Now().Add(s.cfg.HandshakeTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return nil, fmt.Errorf("reading socks5 greeting: %w", err)
+	}
+	if header[0] != socks5Version {
+		return nil, fmt.Errorf("unsupported socks version %d", header[0])
+	}
+
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return nil, fmt.Errorf("reading socks5 methods: %w", err)
+	}
+
+	method := byte(socks5AuthNone)
+	if len(s.cfg.Users) > 0 {
+		method = socks5AuthPassword
+	}
+
+	if !containsByte(methods, method) {
+		conn.Write([]byte{socks5Version, socks5AuthNoAcceptable})
+		return nil, errors.New("client does not support required socks5 authentication method")
+	}
+
+	if _, err := conn.Write([]byte{socks5Version, method}); err != nil {
+		return nil, err
+	}
+
+	var username string
+	if method == socks5AuthPassword {
+		name, user, err := s.authenticate(conn)
+		if err != nil {
+			return nil, err
+		}
+		username = name
+
+		if throttled, ok := conn.(*ThrottledConn); ok {
+			classification := throttled.config.Classification()
+			if user.Class != "" {
+				classification.Class = user.Class
+			}
+			if user.PerConnLimit != nil {
+				classification.PerConnLimit = user.PerConnLimit
+			}
+
+			throttled.reclassify(classification)
+		}
+	}
+
+	address, err := readSOCKS5Request(conn)
+	if err != nil {
+		var replyErr *socks5ReplyError
+		if errors.As(err, &replyErr) {
+			writeSOCKS5Reply(conn, replyErr.reply, nil)
+		}
+
+		return nil, err
+	}
+
+	if s.cfg.Allow != nil && !s.cfg.Allow(username, address) {
+		writeSOCKS5Reply(conn, socks5ReplyNotAllowed, nil)
+		return nil, fmt.Errorf("socks5 destination %s is not allowed for user %q", address, username)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.cfg.HandshakeTimeout)
+	defer cancel()
+
+	upstream, err := s.cfg.Dial(ctx, "tcp", address)
+	if err != nil {
+		writeSOCKS5Reply(conn, socks5ReplyHostUnreachable, nil)
+		return nil, fmt.Errorf("connecting to %s: %w", address, err)
+	}
+
+	if err := writeSOCKS5Reply(conn, socks5ReplySucceeded, upstream.LocalAddr()); err != nil {
+		upstream.Close()
+		return nil, err
+	}
+
+	return upstream, nil
+}
+
+// authenticate performs username/password authentication as described in RFC 1929 and returns the name of the user
+func (s *SOCKS5Server) authenticate(conn net.Conn) (string, SOCKS5User, error) {
+	version := make([]byte, 1)
+	if _, err := io.ReadFull(conn, version); err != nil {
+		return "", SOCKS5User{}, fmt.Errorf("reading socks5 authentication: %w", err)
+	}
+	if version[0] != socks5PasswordVersion {
+		return "", SOCKS5User{}, fmt.Errorf("unsupported socks5 authentication version %d", version[0])
+	}
+
+	username, err := readSOCKS5String(conn)
+	if err != nil {
+		return "", SOCKS5User{}, err
+	}
+
+	password, err := readSOCKS5String(conn)
+	if err != nil {
+		return "", SOCKS5User{}, err
+	}
+
+	// the passwords are compared in constant time, so the time of a failed attempt tells nothing about the password
+	user, ok := s.cfg.Users[username]
+	if subtle.ConstantTimeCompare([]byte(user.Password), []byte(password)) != 1 || !ok {
+		conn.Write([]byte{socks5PasswordVersion, 0x01})
+		return "", SOCKS5User{}, fmt.Errorf("socks5 authentication failed for user %q", username)
+	}
+
+	if _, err := conn.Write([]byte{socks5PasswordVersion, 0x00}); err != nil {
+		return "", SOCKS5User{}, err
+	}
+
+	return username, user, nil
+}
+
+type socks5ReplyError struct {
+	reply byte
+	err   error
+}
+
+func (e *socks5ReplyError) Error() string {
+	return e.err.Error()
+}
+
+// readSOCKS5Request reads the client request and returns the destination address
+func readSOCKS5Request(conn net.Conn) (string, error) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return "", fmt.Errorf("reading socks5 request: %w", err)
+	}
+
+	if header[1] != socks5CmdConnect {
+		return "", &socks5ReplyError{reply: socks5ReplyCommandNotSupported, err: fmt.Errorf("unsupported socks5 command %d", header[1])}
+	}
+
+	var host string
+	switch header[3] {
+	case socks5AddrIPv4, socks5AddrIPv6:
+		size := net.IPv4len
+		if header[3] == socks5AddrIPv6 {
+			size = net.IPv6len
+		}
+
+		ip := make([]byte, size)
+		if _, err := io.ReadFull(conn, ip); err != nil {
+			return "", fmt.Errorf("reading socks5 address: %w", err)
+		}
+		host = net.IP(ip).String()
+	case socks5AddrDomain:
+		domain, err := readSOCKS5String(conn)
+		if err != nil {
+			return "", err
+		}
+		host = domain
+	default:
+		return "", &socks5ReplyError{reply: socks5ReplyAddrNotSupported, err: fmt.Errorf("unsupported socks5 address type %d", header[3])}
+	}
+
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(conn, port); err != nil {
+		return "", fmt.Errorf("reading socks5 port: %w", err)
+	}
+
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))), nil
+}
+
+func writeSOCKS5Reply(conn net.Conn, reply byte, bound net.Addr) error {
+	ip := net.IPv4zero.To4()
+	port := 0
+	if tcpAddr, ok := bound.(*net.TCPAddr); ok {
+		ip, port = tcpAddr.IP, tcpAddr.Port
+	}
+
+	addrType := byte(socks5AddrIPv6)
+	if ip4 := ip.To4(); ip4 != nil {
+		addrType, ip = socks5AddrIPv4, ip4
+	}
+
+	response := append([]byte{socks5Version, reply, 0x00, addrType}, ip...)
+	response = binary.BigEndian.AppendUint16(response, uint16(port))
+
+	_, err := conn.Write(response)
+
+	return err
+}
+
+func readSOCKS5String(conn net.Conn) (string, error) {
+	size := make([]byte, 1)
+	if _, err := io.ReadFull(conn, size); err != nil {
+		return "", fmt.Errorf("reading socks5 string: %w", err)
+	}
+
+	value := make([]byte, size[0])
+	if _, err := io.ReadFull(conn, value); err != nil {
+		return "", fmt.Errorf("reading socks5 string: %w", err)
+	}
+
+	return string(value), nil
+}
+
+func containsByte(values []byte, value byte) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
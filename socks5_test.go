@@ -0,0 +1,210 @@
+package netlistener
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// startEchoServer returns the address of a TCP server echoing everything back
+func startEchoServer(t *testing.T) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Failed to create listener", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	return listener.Addr().String()
+}
+
+func startSOCKS5Server(t *testing.T, cfg *SOCKS5Config) (string, *Listener) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Failed to create listener", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	throttledListener, _ := NewListener(listener, nil, nil)
+	go NewSOCKS5Server(throttledListener, cfg).Serve()
+
+	return listener.Addr().String(), throttledListener
+}
+
+// socks5Connect performs the client side of the handshake, returning the reply code
+func socks5Connect(t *testing.T, conn net.Conn, username, password string, command byte, target string) byte {
+	t.Helper()
+
+	if username == "" {
+		conn.Write([]byte{socks5Version, 1, socks5AuthNone})
+	} else {
+		conn.Write([]byte{socks5Version, 1, socks5AuthPassword})
+	}
+
+	method := make([]byte, 2)
+	if _, err := io.ReadFull(conn, method); err != nil {
+		t.Fatal(err)
+	}
+	if method[1] == socks5AuthNoAcceptable {
+		return socks5AuthNoAcceptable
+	}
+
+	if method[1] == socks5AuthPassword {
+		auth := append([]byte{socks5PasswordVersion, byte(len(username))}, username...)
+		auth = append(append(auth, byte(len(password))), password...)
+		conn.Write(auth)
+
+		status := make([]byte, 2)
+		if _, err := io.ReadFull(conn, status); err != nil {
+			t.Fatal(err)
+		}
+		if status[1] != 0 {
+			return status[1]
+		}
+	}
+
+	addr, _ := net.ResolveTCPAddr("tcp", target)
+	request := append([]byte{socks5Version, command, 0x00, socks5AddrIPv4}, addr.IP.To4()...)
+	request = binary.BigEndian.AppendUint16(request, uint16(addr.Port))
+	conn.Write(request)
+
+	reply := make([]byte, 10)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		t.Fatal(err)
+	}
+
+	return reply[1]
+}
+
+func TestSOCKS5Server_Connect(t *testing.T) {
+	echoAddr := startEchoServer(t)
+	proxyAddr, _ := startSOCKS5Server(t, &SOCKS5Config{
+		Users: map[string]SOCKS5User{"alice": {Password: "secret", PerConnLimit: ptr(1000)}},
+	})
+
+	conn, err := net.Dial("tcp", proxyAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if reply := socks5Connect(t, conn, "alice", "secret", socks5CmdConnect, echoAddr); reply != socks5ReplySucceeded {
+		t.Fatalf("expected success reply, got %d", reply)
+	}
+
+	start := time.Now()
+	go conn.Write(make([]byte, 2000))
+
+	if _, err := io.ReadFull(conn, make([]byte, 2000)); err != nil {
+		t.Fatal(err)
+	}
+
+	// the per connection limit of the user applies to the relayed data
+	if elapsed := time.Since(start); elapsed < 900*time.Millisecond || elapsed > 3*time.Second {
+		t.Errorf("expected relayed data to be throttled to the user limit, took %s", elapsed)
+	}
+}
+
+func TestSOCKS5Server_Rejected(t *testing.T) {
+	echoAddr := startEchoServer(t)
+
+	tests := []struct {
+		name     string
+		users    map[string]SOCKS5User
+		allow    func(user, address string) bool
+		username string
+		password string
+		command  byte
+		expected byte
+	}{
+		{
+			name:     "Wrong password",
+			users:    map[string]SOCKS5User{"alice": {Password: "secret"}},
+			username: "alice",
+			password: "wrong",
+			command:  socks5CmdConnect,
+			expected: 0x01,
+		},
+		{
+			name:     "Authentication required",
+			users:    map[string]SOCKS5User{"alice": {Password: "secret"}},
+			command:  socks5CmdConnect,
+			expected: socks5AuthNoAcceptable,
+		},
+		{
+			name:     "Destination not allowed",
+			users:    map[string]SOCKS5User{"alice": {Password: "secret"}},
+			allow:    func(user, address string) bool { return user != "alice" },
+			username: "alice",
+			password: "secret",
+			command:  socks5CmdConnect,
+			expected: socks5ReplyNotAllowed,
+		},
+		{
+			name:     "Unsupported command",
+			command:  0x02,
+			expected: socks5ReplyCommandNotSupported,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proxyAddr, _ := startSOCKS5Server(t, &SOCKS5Config{Users: tt.users, Allow: tt.allow})
+
+			conn, err := net.Dial("tcp", proxyAddr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+
+			if reply := socks5Connect(t, conn, tt.username, tt.password, tt.command, echoAddr); reply != tt.expected {
+				t.Errorf("expected reply %d, got %d", tt.expected, reply)
+			}
+		})
+	}
+}
+
+func TestSOCKS5Server_LowRateIsNotIdle(t *testing.T) {
+	echoAddr := startEchoServer(t)
+	proxyAddr, _ := startSOCKS5Server(t, &SOCKS5Config{IdleTimeout: 400 * time.Millisecond})
+
+	conn, err := net.Dial("tcp", proxyAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if reply := socks5Connect(t, conn, "", "", socks5CmdConnect, echoAddr); reply != socks5ReplySucceeded {
+		t.Fatalf("expected success reply, got %d", reply)
+	}
+
+	// a few bytes every 100ms keep the connection open for more than twice the idle timeout
+	for i := 0; i < 10; i++ {
+		if _, err := conn.Write([]byte("hello")); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.ReadFull(conn, make([]byte, 5)); err != nil {
+			t.Fatalf("expected the echo after %d writes, got %v", i, err)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
@@ -0,0 +1,78 @@
+package netlistener
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// StateStore persists per peer state, so it survives restarts of the process.
+// Implementations backed by bolt, Redis or anything else only need to provide these two methods
+type StateStore interface {
+	Load() (map[string]PeerState, error)
+	Save(state map[string]PeerState) error
+}
+
+// fileStateStore keeps the state as a JSON document on the local disk
+type fileStateStore struct {
+	path string
+}
+
+func NewFileStateStore(path string) StateStore {
+	return &fileStateStore{path: path}
+}
+
+// Load returns an empty state if the file does not exist yet
+func (s *fileStateStore) Load() (map[string]PeerState, error) {
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return map[string]PeerState{}, nil
+		}
+
+		return nil, fmt.Errorf("reading state file: %w", err)
+	}
+
+	state := map[string]PeerState{}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("decoding state file: %w", err)
+	}
+
+	return state, nil
+}
+
+// Save writes the state to a temporary file first, so a crash in the middle does not leave a corrupted file behind
+func (s *fileStateStore) Save(state map[string]PeerState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("encoding state: %w", err)
+	}
+
+	return writeFileAtomic(s.path, data)
+}
+
+// writeFileAtomic writes the data to a temporary file next to the path and renames it over the path
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("creating temporary state file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("writing state file: %w", err)
+	}
+
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("writing state file: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("replacing state file: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,49 @@
+package netlistener
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileStateStore_SaveAndRestore(t *testing.T) {
+	store := NewFileStateStore(filepath.Join(t.TempDir(), "state.json"))
+
+	config := NewBandwithConfig(nil, nil)
+	config.SetPeerTracking(true)
+
+	connRead, connWrite := net.Pipe()
+	conn := NewThrottledConnection(&addrConn{Conn: connWrite, remoteAddr: &net.TCPAddr{IP: net.ParseIP("192.0.2.1")}}, NewConnectionBandwithConfig(config))
+	go readDataFromConn(connRead)
+	conn.Write(make([]byte, 42))
+	conn.Close()
+
+	if err := config.SaveState(store); err != nil {
+		t.Fatal("failed to save state", err)
+	}
+
+	restored := NewBandwithConfig(nil, nil)
+	if err := restored.RestoreState(store); err != nil {
+		t.Fatal("failed to restore state", err)
+	}
+
+	state, ok := restored.PeerStates()["192.0.2.1"]
+	if !ok {
+		t.Fatal("expected peer state to be restored")
+	}
+	if state.BytesWritten != 42 || state.Connections != 1 {
+		t.Errorf("unexpected restored state: %+v", state)
+	}
+}
+
+func TestFileStateStore_LoadMissingFile(t *testing.T) {
+	store := NewFileStateStore(filepath.Join(t.TempDir(), "missing.json"))
+
+	state, err := store.Load()
+	if err != nil {
+		t.Fatal("expected no error for missing file", err)
+	}
+	if len(state) != 0 {
+		t.Errorf("expected empty state, got %v", state)
+	}
+}
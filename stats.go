@@ -0,0 +1,85 @@
+package netlistener
+
+import "sync/atomic"
+
+// Stats is a point in time view of the counters collected for all connections sharing a config
+type Stats struct {
+	AcceptedConns int64 `json:"accepted_conns"`
+	ActiveConns   int64 `json:"active_conns"`
+	ExemptConns   int64 `json:"exempt_conns"`
+	// HealthChecks is the number of connections recognised as health checks, they are not included in other counters
+	HealthChecks int64 `json:"health_checks"`
+	// DeniedConns is the number of connections rejected for any reason, Rejections breaks it down by RejectReason
+	DeniedConns int64            `json:"denied_conns"`
+	Rejections  map[string]int64 `json:"rejections,omitempty"`
+	// DeadPeers is the number of connections closed because their peer failed the liveness probe
+	DeadPeers int64 `json:"dead_peers"`
+
+	BytesRead    int64 `json:"bytes_read"`
+	BytesWritten int64 `json:"bytes_written"`
+
+	// Unaccounted bytes are the ones kernel counted but the wrapper did not, collected when kernel accounting is enabled
+	UnaccountedBytesRead    int64 `json:"unaccounted_bytes_read"`
+	UnaccountedBytesWritten int64 `json:"unaccounted_bytes_written"`
+
+	// WaitTimes is the distribution of the time operations waited for the limiters in nanoseconds.
+	// The distributions are kept for the listener as a whole only, they are empty in the stats of classes
+	WaitTimes Percentiles `json:"wait_times"`
+	// ConnThroughput is the distribution of the average throughput of closed connections in bytes per second
+	ConnThroughput Percentiles `json:"conn_throughput"`
+
+	// EstimatedReadRate and EstimatedWriteRate are the throughput the open connections achieve in bytes per second,
+	// the Unshaped rates the part of it of exempt and unlimited connections. Set when throughput estimation is enabled
+	EstimatedReadRate  int64 `json:"estimated_read_rate,omitempty"`
+	EstimatedWriteRate int64 `json:"estimated_write_rate,omitempty"`
+	UnshapedReadRate   int64 `json:"unshaped_read_rate,omitempty"`
+	UnshapedWriteRate  int64 `json:"unshaped_write_rate,omitempty"`
+
+	// Accounting is the AccountingMode the bytes were recorded with, the byte counters lag behind by up to an interval
+	// when they are sampled. It is set for the listener as a whole only
+	Accounting string `json:"accounting,omitempty"`
+}
+
+// statsCounters are updated by connections on every operation, so they are kept lock free
+type statsCounters struct {
+	acceptedConns atomic.Int64
+	activeConns   atomic.Int64
+	exemptConns   atomic.Int64
+	healthChecks  atomic.Int64
+	deniedConns   atomic.Int64
+	rejections    [rejectReasons]atomic.Int64
+	deadPeers     atomic.Int64
+
+	bytesRead    atomic.Int64
+	bytesWritten atomic.Int64
+
+	unaccountedBytesRead    atomic.Int64
+	unaccountedBytesWritten atomic.Int64
+}
+
+func (s *statsCounters) snapshot() Stats {
+	var rejections map[string]int64
+	for reason := range rejectReasons {
+		if n := s.rejections[reason].Load(); n > 0 {
+			if rejections == nil {
+				rejections = make(map[string]int64)
+			}
+			rejections[reason.String()] = n
+		}
+	}
+
+	return Stats{
+		Rejections:    rejections,
+		AcceptedConns: s.acceptedConns.Load(),
+		ActiveConns:   s.activeConns.Load(),
+		ExemptConns:   s.exemptConns.Load(),
+		HealthChecks:  s.healthChecks.Load(),
+		DeniedConns:   s.deniedConns.Load(),
+		DeadPeers:     s.deadPeers.Load(),
+		BytesRead:     s.bytesRead.Load(),
+		BytesWritten:  s.bytesWritten.Load(),
+
+		UnaccountedBytesRead:    s.unaccountedBytesRead.Load(),
+		UnaccountedBytesWritten: s.unaccountedBytesWritten.Load(),
+	}
+}
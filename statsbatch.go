@@ -0,0 +1,121 @@
+package netlistener
+
+import (
+	"fmt"
+	"time"
+)
+
+// StatsBatching makes connections count their bytes locally and add them to the stats of the listener and their class
+// in batches, so the shared counters are not updated by every operation of every connection, which makes them
+// a point of contention at high core counts. The stats lag behind by up to the batch of each open connection,
+// connections are flushed when they close and all of them by FlushStats
+type StatsBatching struct {
+	// Bytes is the number of bytes a connection counts locally before it flushes them, zero flushes on the interval only
+	Bytes int64 `json:"bytes,omitempty"`
+	// Interval is the period all open connections are flushed at, zero flushes at the threshold only
+	Interval time.Duration `json:"interval,omitempty"`
+}
+
+func (b StatsBatching) validate() error {
+	if b.Bytes < 0 || b.Interval < 0 || b.Bytes == 0 && b.Interval == 0 {
+		return fmt.Errorf("stats batching needs a threshold or an interval which are not negative, got %d bytes and %s", b.Bytes, b.Interval)
+	}
+
+	return nil
+}
+
+// SetStatsBatching counts the bytes of the connections in batches, see StatsBatching. Nil flushes all connections
+// and counts every operation right away again
+func (c *BandwidthConfig) SetStatsBatching(batching *StatsBatching) error {
+	if batching != nil {
+		if err := batching.validate(); err != nil {
+			return err
+		}
+		batching = &StatsBatching{Bytes: batching.Bytes, Interval: batching.Interval}
+	}
+
+	c.statsBatching.Store(batching)
+	if batching == nil {
+		c.FlushStats()
+	} else if batching.Interval > 0 {
+		go c.runStatsFlush(batching)
+	}
+
+	return nil
+}
+
+// StatsBatching returns how the bytes of the connections are batched, nil if every operation is counted right away
+func (c *BandwidthConfig) StatsBatching() *StatsBatching {
+	if batching := c.statsBatching.Load(); batching != nil {
+		copied := *batching
+		return &copied
+	}
+
+	return nil
+}
+
+// FlushStats adds the bytes the open connections counted locally to the stats, e.g. before reading exact stats in tests
+func (c *BandwidthConfig) FlushStats() {
+	for _, conn := range c.conns.all() {
+		conn.flushStats()
+	}
+}
+
+// runStatsFlush flushes all connections every interval until the batching is replaced
+func (c *BandwidthConfig) runStatsFlush(batching *StatsBatching) {
+	ticker := time.NewTicker(batching.Interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if c.statsBatching.Load() != batching {
+			return
+		}
+		c.FlushStats()
+	}
+}
+
+// countBytes adds the bytes of an operation to the stats of the listener and the class, or to the batch of the connection
+func (c *ThrottledConn) countBytes(n int, read bool) {
+	pending := &c.pendingWritten
+	if read {
+		pending = &c.pendingRead
+	}
+
+	if batching := c.config.globalConfig.statsBatching.Load(); batching != nil {
+		if batched := pending.Add(int64(n)); batching.Bytes > 0 && batched >= batching.Bytes {
+			c.flushStats()
+		}
+		return
+	}
+
+	if read {
+		c.addStats(int64(n), 0)
+	} else {
+		c.addStats(0, int64(n))
+	}
+}
+
+// flushStats adds the batched bytes of the connection to the stats
+func (c *ThrottledConn) flushStats() {
+	read, written := c.pendingRead.Swap(0), c.pendingWritten.Swap(0)
+	if read != 0 || written != 0 {
+		c.addStats(read, written)
+	}
+}
+
+func (c *ThrottledConn) addStats(read, written int64) {
+	stats := &c.config.globalConfig.stats
+	class := c.classCounters()
+	if read != 0 {
+		stats.bytesRead.Add(read)
+		if class != nil {
+			class.bytesRead.Add(read)
+		}
+	}
+	if written != 0 {
+		stats.bytesWritten.Add(written)
+		if class != nil {
+			class.bytesWritten.Add(written)
+		}
+	}
+}
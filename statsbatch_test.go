@@ -0,0 +1,111 @@
+package netlistener
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestThrottledConn_StatsBatching(t *testing.T) {
+	tests := []struct {
+		name     string
+		batching StatsBatching
+		writes   []int
+		// wait is how long the stats are read after the writes
+		wait time.Duration
+		// expected are the bytes written in the stats before and after FlushStats
+		expected        int64
+		expectedFlushed int64
+	}{
+		{name: "Below the threshold", batching: StatsBatching{Bytes: 1000}, writes: []int{100, 200}, expected: 0, expectedFlushed: 300},
+		{name: "Threshold reached", batching: StatsBatching{Bytes: 250}, writes: []int{100, 200, 100}, expected: 300, expectedFlushed: 400},
+		{name: "Interval", batching: StatsBatching{Interval: 10 * time.Millisecond}, writes: []int{100}, wait: 50 * time.Millisecond, expected: 100, expectedFlushed: 100},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := NewBandwithConfig(nil, nil)
+			if err := config.SetStatsBatching(&tt.batching); err != nil {
+				t.Fatal(err)
+			}
+			defer config.SetStatsBatching(nil)
+
+			connRead, connWrite := net.Pipe()
+			throttledConn := NewThrottledConnection(connWrite, NewConnectionBandwithConfig(config))
+			defer throttledConn.Close()
+			go readDataFromConn(connRead)
+
+			for _, n := range tt.writes {
+				if _, err := throttledConn.Write(make([]byte, n)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			time.Sleep(tt.wait)
+
+			if written := config.Stats().BytesWritten; written != tt.expected {
+				t.Errorf("expected %d bytes written before the flush, got %d", tt.expected, written)
+			}
+			config.FlushStats()
+			if written := config.Stats().BytesWritten; written != tt.expectedFlushed {
+				t.Errorf("expected %d bytes written after the flush, got %d", tt.expectedFlushed, written)
+			}
+			if written := throttledConn.bytesWritten.Load(); written != tt.expectedFlushed {
+				t.Errorf("expected the connection to count %d bytes right away, got %d", tt.expectedFlushed, written)
+			}
+		})
+	}
+}
+
+func TestThrottledConn_StatsBatchingClose(t *testing.T) {
+	config := NewBandwithConfig(nil, nil)
+	if err := config.SetStatsBatching(&StatsBatching{Bytes: 1 << 20}); err != nil {
+		t.Fatal(err)
+	}
+
+	connRead, connWrite := net.Pipe()
+	throttledConn := NewThrottledConnection(connWrite, NewConnectionBandwithConfig(config))
+	go readDataFromConn(connRead)
+
+	if _, err := throttledConn.Write(make([]byte, 100)); err != nil {
+		t.Fatal(err)
+	}
+	throttledConn.Close()
+
+	if written := config.Stats().BytesWritten; written != 100 {
+		t.Errorf("expected the bytes to be flushed on close, got %d", written)
+	}
+}
+
+func TestBandwidthConfig_SetStatsBatching(t *testing.T) {
+	tests := []struct {
+		name     string
+		batching *StatsBatching
+		wantErr  bool
+	}{
+		{name: "Threshold", batching: &StatsBatching{Bytes: 64 << 10}},
+		{name: "Threshold and interval", batching: &StatsBatching{Bytes: 64 << 10, Interval: time.Second}},
+		{name: "Disabled"},
+		{name: "Neither threshold nor interval", batching: &StatsBatching{}, wantErr: true},
+		{name: "Negative interval", batching: &StatsBatching{Bytes: 1, Interval: -time.Second}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := NewBandwidthConfig(nil, nil)
+			err := config.SetStatsBatching(tt.batching)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SetStatsBatching() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			defer config.SetStatsBatching(nil)
+
+			got := config.StatsBatching()
+			if expected := tt.batching; tt.wantErr || expected == nil {
+				if got != nil {
+					t.Errorf("StatsBatching() = %+v, expected nil", got)
+				}
+			} else if got == nil || *got != *expected {
+				t.Errorf("StatsBatching() = %+v, expected %+v", got, expected)
+			}
+		})
+	}
+}
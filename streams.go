@@ -0,0 +1,117 @@
+package netlistener
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync"
+
+	"golang.org/x/time/rate"
+)
+
+// StreamLimiters subdivides the per connection write limit of a throttled connection among its streams,
+// e.g. the streams of an HTTP/2 connection, so one greedy stream does not starve the others.
+// Every open stream gets an equal share, recalculated when streams are opened or closed and when the connection limit changes
+type StreamLimiters struct {
+	conn    *ThrottledConn
+	streams map[*StreamLimiter]struct{}
+
+	mu sync.RWMutex
+}
+
+// NewStreamLimiters returns the per stream limiter factory for a connection accepted by the throttled listener
+func NewStreamLimiters(conn net.Conn) (*StreamLimiters, error) {
+	throttled, ok := conn.(*ThrottledConn)
+	if !ok {
+		return nil, ErrNotThrottled
+	}
+
+	return &StreamLimiters{
+		conn:    throttled,
+		streams: make(map[*StreamLimiter]struct{}),
+	}, nil
+}
+
+// Open returns the limiter for a new stream, it has to be closed when the stream is done
+func (s *StreamLimiters) Open() *StreamLimiter {
+	stream := &StreamLimiter{parent: s}
+
+	s.mu.Lock()
+	s.streams[stream] = struct{}{}
+	s.mu.Unlock()
+
+	// new stream starts with a full burst of its share
+	share := s.share()
+	stream.limiter = rate.NewLimiter(share, parseBurstFromRateLimit(share))
+
+	return stream
+}
+
+// share returns the limit of a single stream
+func (s *StreamLimiters) share() rate.Limit {
+	connLimit := s.conn.perConnLimit(s.conn.config.globalConfig.PerConnWriteLimit(), false)
+	if connLimit == rate.Inf {
+		return rate.Inf
+	}
+
+	s.mu.RLock()
+	open := len(s.streams)
+	s.mu.RUnlock()
+
+	return connLimit / rate.Limit(max(open, 1))
+}
+
+func (s *StreamLimiters) close(stream *StreamLimiter) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.streams, stream)
+}
+
+// StreamLimiter limits a single stream to its share of the connection budget
+type StreamLimiter struct {
+	parent  *StreamLimiters
+	limiter *rate.Limiter
+}
+
+// WaitN blocks until the stream is allowed to send n bytes. n bigger than the share of the stream is allowed,
+// the stream is then charged in several steps
+func (l *StreamLimiter) WaitN(ctx context.Context, n int) error {
+	for n > 0 {
+		if share := l.parent.share(); share != l.limiter.Limit() {
+			updateLimiter(l.limiter, share)
+		}
+
+		chunk := maxChunk([]*rate.Limiter{l.limiter}, n)
+		if err := l.limiter.WaitN(ctx, chunk); err != nil {
+			return err
+		}
+
+		n -= chunk
+	}
+
+	return nil
+}
+
+// Writer wraps the writer of the stream, e.g. an http.ResponseWriter, so that everything written is limited
+func (l *StreamLimiter) Writer(w io.Writer) io.Writer {
+	return &streamWriter{stream: l, w: w}
+}
+
+// Close releases the share of the stream to the other streams of the connection
+func (l *StreamLimiter) Close() {
+	l.parent.close(l)
+}
+
+type streamWriter struct {
+	stream *StreamLimiter
+	w      io.Writer
+}
+
+func (w *streamWriter) Write(b []byte) (int, error) {
+	if err := w.stream.WaitN(context.Background(), len(b)); err != nil {
+		return 0, err
+	}
+
+	return w.w.Write(b)
+}
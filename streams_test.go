@@ -0,0 +1,86 @@
+package netlistener
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+func TestStreamLimiters_Share(t *testing.T) {
+	connRead, connWrite := net.Pipe()
+	defer connRead.Close()
+
+	config := NewBandwithConfig(nil, ptr(1000))
+	conn := NewThrottledConnection(connWrite, NewConnectionBandwithConfig(config))
+
+	streams, err := NewStreamLimiters(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first := streams.Open()
+	if share := streams.share(); share != 1000 {
+		t.Errorf("expected single stream to get the whole budget, got %v", share)
+	}
+
+	second := streams.Open()
+	if share := streams.share(); share != 500 {
+		t.Errorf("expected two streams to split the budget, got %v", share)
+	}
+
+	first.Close()
+	second.Close()
+
+	config.SetPerConnLimit(nil)
+	if share := streams.share(); share != rate.Inf {
+		t.Errorf("expected unlimited share for unlimited connection, got %v", share)
+	}
+}
+
+func TestStreamLimiter_Writer(t *testing.T) {
+	connRead, connWrite := net.Pipe()
+	defer connRead.Close()
+
+	config := NewBandwithConfig(nil, ptr(100))
+	conn := NewThrottledConnection(connWrite, NewConnectionBandwithConfig(config))
+
+	streams, _ := NewStreamLimiters(conn)
+	greedy := streams.Open()
+	defer greedy.Close()
+	other := streams.Open()
+	defer other.Close()
+
+	// each stream gets 50 B/s, the first 50 bytes are the burst
+	buf := &bytes.Buffer{}
+	start := time.Now()
+	if _, err := other.Writer(buf).Write(make([]byte, 100)); err != nil {
+		t.Fatal(err)
+	}
+
+	if elapsed := time.Since(start); elapsed < 900*time.Millisecond || elapsed > 2*time.Second {
+		t.Errorf("expected stream to be limited to its share, took %s", elapsed)
+	}
+	if buf.Len() != 100 {
+		t.Errorf("expected 100 bytes written, got %d", buf.Len())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := greedy.WaitN(ctx, 10); err == nil {
+		t.Error("expected error for cancelled context")
+	}
+}
+
+func TestNewStreamLimiters_NotThrottled(t *testing.T) {
+	connRead, connWrite := net.Pipe()
+	defer connRead.Close()
+	defer connWrite.Close()
+
+	if _, err := NewStreamLimiters(connWrite); err != ErrNotThrottled {
+		t.Errorf("expected ErrNotThrottled, got %v", err)
+	}
+}
@@ -0,0 +1,159 @@
+package netlistener
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// strictWindow counts the tokens taken from a limiter within the current accounting interval
+type strictWindow struct {
+	start time.Time
+	used  int64
+	mu    sync.Mutex
+}
+
+// strictWindows layers a hard ceiling of limit×interval per accounting interval on the limiters, see SetStrictMode
+type strictWindows struct {
+	windows sync.Map
+}
+
+// ceiling returns the tokens a limiter allows within an interval, at least one so operations can make progress
+func ceiling(limiter *rate.Limiter, interval time.Duration) int64 {
+	return max(int64(float64(limiter.Limit())*interval.Seconds()), 1)
+}
+
+// window returns the window of the limiter, rolled over to the interval now is in. The lock of the window is held on return
+func (s *strictWindows) window(limiter *rate.Limiter, interval time.Duration, now time.Time) *strictWindow {
+	value, _ := s.windows.LoadOrStore(limiter, &strictWindow{})
+	window := value.(*strictWindow)
+	window.mu.Lock()
+
+	if start := now.Truncate(interval); !start.Equal(window.start) {
+		window.start, window.used = start, 0
+	}
+
+	return window
+}
+
+// reserve counts the tokens in the windows of all limiters, or none of them if one of the windows has no room left.
+// It returns how long to wait for the next interval then, zero if the tokens were counted
+func (s *strictWindows) reserve(limiters []*rate.Limiter, tokens []int, interval time.Duration, now time.Time) time.Duration {
+	for i, limiter := range limiters {
+		if limiter.Limit() == rate.Inf {
+			continue
+		}
+
+		window := s.window(limiter, interval, now)
+		if window.used > 0 && window.used+int64(tokens[i]) > ceiling(limiter, interval) {
+			wait := window.start.Add(interval).Sub(now)
+			window.mu.Unlock()
+			s.release(limiters[:i], tokens, interval, now)
+
+			return max(wait, time.Millisecond)
+		}
+
+		window.used += int64(tokens[i])
+		window.mu.Unlock()
+	}
+
+	return 0
+}
+
+// release gives the tokens of an operation which did not take place back to the windows, unless they were rolled over since
+func (s *strictWindows) release(limiters []*rate.Limiter, tokens []int, interval time.Duration, now time.Time) {
+	for i, limiter := range limiters {
+		value, ok := s.windows.Load(limiter)
+		if !ok || limiter.Limit() == rate.Inf {
+			continue
+		}
+
+		window := value.(*strictWindow)
+		window.mu.Lock()
+		if window.start.Equal(now.Truncate(interval)) {
+			window.used = max(window.used-int64(tokens[i]), 0)
+		}
+		window.mu.Unlock()
+	}
+}
+
+// clear forgets the windows of limiters which are not used anymore, e.g. the ones of a closed connection
+func (s *strictWindows) clear(limiters ...*rate.Limiter) {
+	for _, limiter := range limiters {
+		s.windows.Delete(limiter)
+	}
+}
+
+// maxChunk shrinks the chunk size to the ceiling of the limiters, a bigger chunk would never fit an interval
+func (s *strictWindows) maxChunk(limiters []*rate.Limiter, chunkSize int, interval time.Duration) int {
+	for _, limiter := range limiters {
+		if limiter.Limit() == rate.Inf {
+			continue
+		}
+
+		if size := ceiling(limiter, interval); size < int64(chunkSize) {
+			chunkSize = int(size)
+		}
+	}
+
+	return chunkSize
+}
+
+// SetStrictMode guarantees that no more than limit×interval bytes pass any limiter within an accounting interval,
+// e.g. for billing or regulatory caps. The limiters alone let a burst saved up while idle through on top of the rate,
+// strict mode counts the bytes of each interval on top of them and holds operations back until the next interval
+// once the ceiling is reached, so nothing carries over between intervals. The intervals are aligned to multiples of
+// the interval on the clock of the config. An operation bigger than the ceiling is split into chunks fitting it. Zero disables it
+func (c *BandwidthConfig) SetStrictMode(interval time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.strictInterval = max(interval, 0)
+}
+
+func (c *BandwidthConfig) StrictMode() time.Duration {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.strictInterval
+}
+
+// waitStrict waits until the tokens fit the current interval of all limiters in strict mode, it returns whether they were counted.
+// It fails like paceTokens when the wait would pass the deadline, the context is done, the connection is closed or the limits changed
+func (c *ThrottledConn) waitStrict(ctx context.Context, changed <-chan struct{}, limiters []*rate.Limiter, tokens []int, deadline time.Time) (bool, error) {
+	config := c.config.globalConfig
+	interval := config.StrictMode()
+	if interval <= 0 || len(limiters) == 0 {
+		return false, nil
+	}
+
+	for {
+		wait := config.strict.reserve(limiters, tokens, interval, config.now())
+		if wait == 0 {
+			return true, nil
+		}
+
+		if !deadline.IsZero() && wait > time.Until(deadline) {
+			return false, os.ErrDeadlineExceeded
+		}
+
+		expired, stop := sleepTimer(config.timerWheel(), wait)
+		select {
+		case <-expired:
+		case <-ctx.Done():
+			stop()
+			return false, fmt.Errorf("%w: %w", ErrThrottleCancelled, ctx.Err())
+		case <-c.closed:
+			stop()
+			return false, net.ErrClosed
+		case <-changed:
+			stop()
+			return false, errLimitsChanged
+		}
+	}
+}
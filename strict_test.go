@@ -0,0 +1,91 @@
+package netlistener
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+func TestStrictWindows(t *testing.T) {
+	limiter := rate.NewLimiter(100, 100)
+	lower := rate.NewLimiter(50, 50)
+	start := time.Unix(1000, 0)
+
+	tests := []struct {
+		name     string
+		limiters []*rate.Limiter
+		tokens   int
+		at       time.Duration
+		// expected is the wait for the next interval, zero if the tokens were counted
+		expected time.Duration
+	}{
+		{name: "Within the ceiling", limiters: []*rate.Limiter{limiter}, tokens: 60},
+		{name: "Over the ceiling", limiters: []*rate.Limiter{limiter}, tokens: 60, expected: time.Second},
+		{name: "Rest of the interval", limiters: []*rate.Limiter{limiter}, tokens: 40, at: 500 * time.Millisecond},
+		{name: "Full interval", limiters: []*rate.Limiter{limiter}, tokens: 1, at: 500 * time.Millisecond, expected: 500 * time.Millisecond},
+		{name: "No carryover to the next interval", limiters: []*rate.Limiter{limiter}, tokens: 100, at: time.Second},
+		{name: "Unlimited limiters are skipped", limiters: []*rate.Limiter{rate.NewLimiter(rate.Inf, 0), lower}, tokens: 50, at: time.Second},
+		{name: "Counted on none of the limiters", limiters: []*rate.Limiter{rate.NewLimiter(100, 100), lower}, tokens: 10, at: 1500 * time.Millisecond,
+			expected: 500 * time.Millisecond},
+	}
+
+	var windows strictWindows
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokens := make([]int, len(tt.limiters))
+			for i := range tokens {
+				tokens[i] = tt.tokens
+			}
+
+			if wait := windows.reserve(tt.limiters, tokens, time.Second, start.Add(tt.at)); wait != tt.expected {
+				t.Errorf("expected to wait %v, got %v", tt.expected, wait)
+			}
+		})
+	}
+
+	// the limiter which had room was released when the other one was full
+	first := tests[len(tests)-1].limiters[0]
+	if value, ok := windows.windows.Load(first); ok && value.(*strictWindow).used != 0 {
+		t.Errorf("expected the tokens to be released, got %d", value.(*strictWindow).used)
+	}
+}
+
+func TestRateLimitedConnection_StrictMode(t *testing.T) {
+	tests := []struct {
+		name     string
+		interval time.Duration
+		// minimum and maximum bound the duration of the write
+		minimum time.Duration
+		maximum time.Duration
+	}{
+		// the burst of 100 passes right away, the rest at 100 bytes per second
+		{name: "Burst carries over", minimum: 900 * time.Millisecond, maximum: 1200 * time.Millisecond},
+		// 25 bytes per 250ms interval take 7 more intervals after the first one
+		{name: "Hard ceiling per interval", interval: 250 * time.Millisecond, minimum: 1500 * time.Millisecond, maximum: 2 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			config := NewBandwithConfig(ptr(100), nil)
+			config.SetStrictMode(tt.interval)
+
+			connRead, connWrite := net.Pipe()
+			conn := NewThrottledConnection(connWrite, NewConnectionBandwithConfig(config))
+			defer conn.Close()
+			go readDataFromConn(connRead)
+
+			start := time.Now()
+			if _, err := conn.Write(make([]byte, 200)); err != nil {
+				t.Fatal(err)
+			}
+
+			if elapsed := time.Since(start); elapsed < tt.minimum || elapsed > tt.maximum {
+				t.Errorf("expected the write to take between %v and %v, took %v", tt.minimum, tt.maximum, elapsed)
+			}
+		})
+	}
+}
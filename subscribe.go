@@ -0,0 +1,44 @@
+package netlistener
+
+// ConfigSubscriber is told about a change of the declarative configuration with the configuration before and after it
+type ConfigSubscriber func(old, new ListenerConfig)
+
+// SubscribeConfig calls the subscriber after every change made by Apply and ApplyVersion, e.g. through the admin handler
+// or when a configuration file is reloaded, so other parts of the application can react to changed limits.
+// Subscribers are called one after another in the order the changes were applied, before Apply returns, so they
+// should not block and must not call Apply themselves. Changes made through the setters are not reported.
+// The returned function removes the subscriber
+func (c *BandwidthConfig) SubscribeConfig(subscriber ConfigSubscriber) (unsubscribe func()) {
+	c.applyMu.Lock()
+	defer c.applyMu.Unlock()
+
+	if c.configSubscribers == nil {
+		c.configSubscribers = make(map[uint64]ConfigSubscriber)
+	}
+	c.nextSubscriber++
+	id := c.nextSubscriber
+	c.configSubscribers[id] = subscriber
+
+	return func() {
+		c.applyMu.Lock()
+		defer c.applyMu.Unlock()
+
+		delete(c.configSubscribers, id)
+	}
+}
+
+// notifyConfigLocked calls the subscribers in the order they subscribed, applyMu has to be held
+func (c *BandwidthConfig) notifyConfigLocked(old, new ListenerConfig) {
+	for id := uint64(1); id <= c.nextSubscriber; id++ {
+		if subscriber, ok := c.configSubscribers[id]; ok {
+			c.notifySubscriber(subscriber, old, new)
+		}
+	}
+}
+
+// notifySubscriber calls a subscriber, a panic is passed to the error handler instead of failing Apply
+func (c *BandwidthConfig) notifySubscriber(subscriber ConfigSubscriber, old, new ListenerConfig) {
+	defer c.recoverPanic("config subscriber")
+
+	subscriber(old, new)
+}
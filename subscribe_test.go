@@ -0,0 +1,78 @@
+package netlistener
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestBandwidthConfig_SubscribeConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		config ListenerConfig
+		// expected is the number of calls of the subscriber
+		expected int
+	}{
+		{name: "Changed limit", config: ListenerConfig{GlobalLimit: ptr(500), PerConnLimit: ptr(100)}, expected: 1},
+		{name: "Unchanged", config: ListenerConfig{GlobalLimit: ptr(1000), PerConnLimit: ptr(100)}},
+		{name: "Not applied", config: ListenerConfig{GlobalLimit: ptr(500), ExemptCIDRs: []string{"invalid"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := NewBandwithConfig(ptr(1000), ptr(100))
+
+			var calls []ListenerConfig
+			config.SubscribeConfig(func(old, new ListenerConfig) {
+				if *old.GlobalLimit != 1000 {
+					t.Errorf("expected the old global limit 1000, got %d", *old.GlobalLimit)
+				}
+				calls = append(calls, new)
+			})
+
+			config.Apply(tt.config)
+
+			if len(calls) != tt.expected {
+				t.Fatalf("expected %d calls, got %d", tt.expected, len(calls))
+			}
+			if tt.expected > 0 && *calls[0].GlobalLimit != *tt.config.GlobalLimit {
+				t.Errorf("expected the new global limit %d, got %d", *tt.config.GlobalLimit, *calls[0].GlobalLimit)
+			}
+		})
+	}
+}
+
+func TestBandwidthConfig_SubscribeConfig_Unsubscribe(t *testing.T) {
+	config := NewBandwithConfig(ptr(1000), nil)
+
+	var reported error
+	config.SetErrorHandler(func(err error) {
+		reported = err
+	})
+
+	var order []string
+	config.SubscribeConfig(func(old, new ListenerConfig) {
+		order = append(order, "first")
+		panic("subscriber failed")
+	})
+	unsubscribe := config.SubscribeConfig(func(old, new ListenerConfig) {
+		order = append(order, "second")
+	})
+
+	if _, err := config.Apply(ListenerConfig{GlobalLimit: ptr(500)}); err != nil {
+		t.Fatal(err)
+	}
+	var panicErr *PanicError
+	if !errors.As(reported, &panicErr) {
+		t.Errorf("expected the panic of the subscriber to be reported, got %v", reported)
+	}
+
+	unsubscribe()
+	if _, err := config.Apply(ListenerConfig{GlobalLimit: ptr(250)}); err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := []string{"first", "second", "first"}; !slices.Equal(order, expected) {
+		t.Errorf("expected the subscribers to be called %v, got %v", expected, order)
+	}
+}
@@ -0,0 +1,107 @@
+package netlistener
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// TalkerGrouping decides what TopTalkers ranks
+type TalkerGrouping int
+
+const (
+	// TalkersByConn ranks single connections, keyed by their remote address
+	TalkersByConn TalkerGrouping = iota
+	// TalkersByPeer ranks remote IPs, summing all of their connections
+	TalkersByPeer
+	// TalkersByClass ranks classes, e.g. tenants, summing all of their connections. Connections without a class are grouped under ""
+	TalkersByClass
+)
+
+func (g TalkerGrouping) String() string {
+	switch g {
+	case TalkersByConn:
+		return "conn"
+	case TalkersByPeer:
+		return "peer"
+	case TalkersByClass:
+		return "class"
+	}
+
+	return "unknown"
+}
+
+// ParseTalkerGrouping parses the name of a grouping as returned by String
+func ParseTalkerGrouping(name string) (TalkerGrouping, error) {
+	for _, grouping := range []TalkerGrouping{TalkersByConn, TalkersByPeer, TalkersByClass} {
+		if grouping.String() == name {
+			return grouping, nil
+		}
+	}
+
+	return 0, fmt.Errorf("unknown talker grouping %q", name)
+}
+
+// Talker is the throughput of a connection, peer or class over the window of a TopTalkers query
+type Talker struct {
+	Key          string `json:"key"`
+	Connections  int    `json:"connections"`
+	BytesRead    int64  `json:"bytes_read"`
+	BytesWritten int64  `json:"bytes_written"`
+	// Rate is the average of both directions together in bytes per second
+	Rate float64 `json:"rate"`
+}
+
+// TopTalkers returns the n open connections, peers or classes which transferred the most within the window, highest first.
+// The window is rounded up to whole seconds and covers at most the last 15 seconds
+func (c *BandwidthConfig) TopTalkers(n int, window time.Duration, by TalkerGrouping) []Talker {
+	now := time.Now()
+	seconds := float64(min(max((window+time.Second-1)/time.Second, 1), usageWindowSlots))
+
+	talkers := make(map[string]*Talker)
+	for _, conn := range c.conns.all() {
+		key := conn.talkerKey(by)
+		talker, ok := talkers[key]
+		if !ok {
+			talker = &Talker{Key: key}
+			talkers[key] = talker
+		}
+
+		talker.Connections++
+		talker.BytesRead += conn.readUsage.sum(now, window)
+		talker.BytesWritten += conn.writeUsage.sum(now, window)
+	}
+
+	top := make([]Talker, 0, len(talkers))
+	for _, talker := range talkers {
+		talker.Rate = float64(talker.BytesRead+talker.BytesWritten) / seconds
+		top = append(top, *talker)
+	}
+
+	slices.SortFunc(top, func(a, b Talker) int {
+		if a.Rate != b.Rate {
+			if a.Rate > b.Rate {
+				return -1
+			}
+			return 1
+		}
+		return strings.Compare(a.Key, b.Key)
+	})
+
+	return top[:min(max(n, 0), len(top))]
+}
+
+func (c *ThrottledConn) talkerKey(by TalkerGrouping) string {
+	switch by {
+	case TalkersByPeer:
+		return peerKey(c.Conn)
+	case TalkersByClass:
+		if class := c.config.class(); class != nil {
+			return class.config.Name
+		}
+		return ""
+	}
+
+	return c.RemoteAddr().String()
+}
@@ -0,0 +1,78 @@
+package netlistener
+
+import (
+	"io"
+	"testing"
+	"time"
+)
+
+func TestBandwithConfig_TopTalkers(t *testing.T) {
+	config := NewBandwithConfig(nil, nil)
+	if err := config.SetClasses([]ClassConfig{{Name: "tenant-a"}, {Name: "tenant-b"}}, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	conns := make([]*ThrottledConn, 3)
+	for i, write := range []struct {
+		class string
+		bytes int
+	}{{"tenant-a", 100}, {"tenant-b", 300}, {"tenant-a", 250}} {
+		client, server := tcpPair(t)
+		defer client.Close()
+		go io.Copy(io.Discard, client)
+
+		connConfig := NewConnectionBandwithConfig(config)
+		connConfig.SetClassification(Classification{Class: write.class})
+		conns[i] = NewThrottledConnection(server, connConfig)
+		defer conns[i].Close()
+
+		if _, err := conns[i].Write(make([]byte, write.bytes)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		n        int
+		by       TalkerGrouping
+		expected []string
+		bytes    []int64
+	}{
+		{
+			name:     "Connections",
+			n:        2,
+			by:       TalkersByConn,
+			expected: []string{conns[1].RemoteAddr().String(), conns[2].RemoteAddr().String()},
+			bytes:    []int64{300, 250},
+		},
+		{
+			name:     "Classes",
+			n:        5,
+			by:       TalkersByClass,
+			expected: []string{"tenant-a", "tenant-b"},
+			bytes:    []int64{350, 300},
+		},
+		{
+			name:     "Peers",
+			n:        5,
+			by:       TalkersByPeer,
+			expected: []string{"127.0.0.1"},
+			bytes:    []int64{650},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			top := config.TopTalkers(tt.n, 5*time.Second, tt.by)
+			if len(top) != len(tt.expected) {
+				t.Fatalf("expected %d talkers, got %+v", len(tt.expected), top)
+			}
+
+			for i, talker := range top {
+				if talker.Key != tt.expected[i] || talker.BytesWritten != tt.bytes[i] {
+					t.Errorf("expected %s with %d bytes at %d, got %+v", tt.expected[i], tt.bytes[i], i, talker)
+				}
+			}
+		})
+	}
+}
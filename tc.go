@@ -0,0 +1,160 @@
+package netlistener
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// TCPolicy is a class hierarchy described in a tc/HTB inspired syntax, one statement per line:
+//
+//	# comments start with a hash
+//	class root rate 100mbit
+//	class web parent root rate 40mbit ceil 80mbit
+//	class bulk parent root rate 10mbit ceil 100mbit perconn 512kbit
+//	default bulk
+//
+// Rates follow tc units: bit, kbit, mbit, gbit (a bare number is bits per second too)
+// and bps, kbps, mbps, gbps for bytes per second. Prefixes are decimal, kibit, mibit, kibps etc. are binary
+type TCPolicy struct {
+	Classes []ClassConfig
+	Default string
+}
+
+// Apply loads the classes into the listener
+func (p *TCPolicy) Apply(l *Listener) error {
+	return l.SetClasses(p.Classes, p.Default)
+}
+
+// ParseTCPolicy reads a TCPolicy, it validates the syntax only, the class hierarchy is validated when applied
+func ParseTCPolicy(r io.Reader) (*TCPolicy, error) {
+	policy := &TCPolicy{}
+	scanner := bufio.NewScanner(r)
+
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch fields[0] {
+		case "class":
+			class, err := parseTCClass(fields[1:])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNumber, err)
+			}
+
+			policy.Classes = append(policy.Classes, class)
+		case "default":
+			if len(fields) != 2 {
+				return nil, fmt.Errorf("line %d: expected \"default <class>\"", lineNumber)
+			}
+
+			policy.Default = fields[1]
+		default:
+			return nil, fmt.Errorf("line %d: unknown statement %q", lineNumber, fields[0])
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading tc policy: %w", err)
+	}
+
+	return policy, nil
+}
+
+func parseTCClass(fields []string) (ClassConfig, error) {
+	if len(fields) == 0 {
+		return ClassConfig{}, fmt.Errorf("class without a name")
+	}
+
+	class := ClassConfig{Name: fields[0]}
+	hasRate := false
+
+	for i := 1; i < len(fields); i += 2 {
+		if i+1 >= len(fields) {
+			return ClassConfig{}, fmt.Errorf("class %q: missing value for %q", class.Name, fields[i])
+		}
+
+		keyword, value := fields[i], fields[i+1]
+		if keyword == "parent" {
+			class.Parent = value
+			continue
+		}
+
+		bytesPerSecond, err := ParseTCRate(value)
+		if err != nil {
+			return ClassConfig{}, fmt.Errorf("class %q: %w", class.Name, err)
+		}
+		// a zero rate or ceil makes the class unlimited, so a class meant to be almost blocked must not end up with it
+		if bytesPerSecond < 1 {
+			return ClassConfig{}, fmt.Errorf("class %q: %s %q is below 1 byte per second", class.Name, keyword, value)
+		}
+
+		switch keyword {
+		case "rate":
+			class.Rate = bytesPerSecond
+			hasRate = true
+		case "ceil":
+			class.Ceil = bytesPerSecond
+		case "perconn":
+			class.PerConnLimit = &bytesPerSecond
+		default:
+			return ClassConfig{}, fmt.Errorf("class %q: unknown keyword %q", class.Name, keyword)
+		}
+	}
+
+	if !hasRate {
+		return ClassConfig{}, fmt.Errorf("class %q: rate is required", class.Name)
+	}
+
+	return class, nil
+}
+
+var tcRateUnits = []struct {
+	suffix string
+	// bytes per second for a single unit
+	multiplier float64
+}{
+	// longer suffixes go first, so "kbit" is not taken for "bit"
+	{"kibit", 1024.0 / 8}, {"mibit", 1024.0 * 1024 / 8}, {"gibit", 1024.0 * 1024 * 1024 / 8},
+	{"kibps", 1024}, {"mibps", 1024 * 1024}, {"gibps", 1024 * 1024 * 1024},
+	{"kbit", 1e3 / 8}, {"mbit", 1e6 / 8}, {"gbit", 1e9 / 8},
+	{"kbps", 1e3}, {"mbps", 1e6}, {"gbps", 1e9},
+	{"bit", 1.0 / 8}, {"bps", 1},
+}
+
+// ParseTCRate converts a rate in tc notation, e.g. "512kbit" or "10mbps", to bytes per second, fractions of a byte are truncated
+func ParseTCRate(value string) (int, error) {
+	lower := strings.ToLower(value)
+	multiplier := 1.0 / 8
+
+	for _, unit := range tcRateUnits {
+		if strings.HasSuffix(lower, unit.suffix) {
+			lower = strings.TrimSuffix(lower, unit.suffix)
+			multiplier = unit.multiplier
+			break
+		}
+	}
+
+	number, err := strconv.ParseFloat(lower, 64)
+	if err != nil || number < 0 || math.IsNaN(number) || math.IsInf(number, 0) {
+		return 0, fmt.Errorf("invalid rate %q", value)
+	}
+
+	// float64(math.MaxInt) rounds up to 2^63, which does not fit into an int either
+	rate := number * multiplier
+	if rate >= float64(math.MaxInt) {
+		return 0, fmt.Errorf("rate %q is out of range", value)
+	}
+
+	return int(rate), nil
+}
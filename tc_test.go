@@ -0,0 +1,102 @@
+package netlistener
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseTCRate(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected int
+		wantErr  bool
+	}{
+		{value: "8000", expected: 1000},
+		{value: "8kbit", expected: 1000},
+		{value: "1mbit", expected: 125000},
+		{value: "1gbit", expected: 125000000},
+		{value: "100bps", expected: 100},
+		{value: "2kbps", expected: 2000},
+		{value: "1kibps", expected: 1024},
+		{value: "8kibit", expected: 1024},
+		{value: "1.5mbps", expected: 1500000},
+		{value: "10MBit", expected: 1250000},
+		{value: "4bit", expected: 0},
+		{value: "0.5bps", expected: 0},
+		{value: "-1kbit", wantErr: true},
+		{value: "nan", wantErr: true},
+		{value: "NaNkbit", wantErr: true},
+		{value: "inf", wantErr: true},
+		{value: "+Infmbps", wantErr: true},
+		{value: "1e30gbit", wantErr: true},
+		{value: "9223372036854775807bps", wantErr: true},
+		{value: "fast", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := ParseTCRate(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+			}
+			if got != tt.expected {
+				t.Errorf("expected %d, got %d", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestParseTCPolicy(t *testing.T) {
+	policy, err := ParseTCPolicy(strings.NewReader(`
+# shaping for the office uplink
+class root rate 100mbit
+class web parent root rate 40mbit ceil 80mbit
+class bulk parent root rate 10mbit ceil 100mbit perconn 512kbit # downloads
+default bulk
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(policy.Classes) != 3 || policy.Default != "bulk" {
+		t.Fatalf("unexpected policy: %+v", policy)
+	}
+
+	bulk := policy.Classes[2]
+	if bulk.Parent != "root" || bulk.Rate != 1250000 || bulk.Ceil != 12500000 || bulk.PerConnLimit == nil || *bulk.PerConnLimit != 64000 {
+		t.Errorf("unexpected bulk class: %+v", bulk)
+	}
+
+	config := NewBandwithConfig(nil, nil)
+	if err := config.SetClasses(policy.Classes, policy.Default); err != nil {
+		t.Errorf("expected policy to be valid: %v", err)
+	}
+}
+
+func TestParseTCPolicy_Invalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy string
+	}{
+		{name: "Unknown statement", policy: "qdisc htb"},
+		{name: "Class without rate", policy: "class web ceil 10mbit"},
+		{name: "Invalid rate", policy: "class web rate fast"},
+		{name: "Missing value", policy: "class web rate"},
+		{name: "Unknown keyword", policy: "class web rate 1mbit burst 10k"},
+		{name: "Default without class", policy: "default"},
+		{name: "Zero rate", policy: "class web rate 0"},
+		{name: "Rate below a byte", policy: "class web rate 4bit"},
+		{name: "Fractional rate below a byte", policy: "class web rate 0.5bps"},
+		{name: "Zero ceil", policy: "class web rate 1mbit ceil 0"},
+		{name: "Zero per connection limit", policy: "class web rate 1mbit perconn 0"},
+		{name: "Per connection limit below a byte", policy: "class web rate 1mbit perconn 7bit"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseTCPolicy(strings.NewReader(tt.policy)); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
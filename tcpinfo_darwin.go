@@ -0,0 +1,84 @@
+//go:build darwin
+
+package netlistener
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// TCP_CONNECTION_INFO from netinet/tcp.h
+const darwinTCPConnectionInfo = 0x106
+
+// darwinTCPInfo mirrors struct tcp_connection_info from netinet/tcp.h
+type darwinTCPInfo struct {
+	State     uint8
+	SndWscale uint8
+	RcvWscale uint8
+	_         uint8
+	Options   uint32
+	Flags     uint32
+	Rto       uint32
+	Maxseg    uint32
+
+	SndSsthresh uint32
+	SndCwnd     uint32
+	SndWnd      uint32
+	SndSbbytes  uint32
+	RcvWnd      uint32
+	Rttcur      uint32
+	Srtt        uint32
+	Rttvar      uint32
+	TfoFlags    uint32
+
+	Txpackets           uint64
+	Txbytes             uint64
+	Txretransmitbytes   uint64
+	Rxpackets           uint64
+	Rxbytes             uint64
+	Rxoutoforderbytes   uint64
+	Txretransmitpackets uint64
+}
+
+func tcpInfoOf(conn net.Conn) (TCPInfo, error) {
+	rawConn, err := syscallConn(conn)
+	if err != nil {
+		return TCPInfo{}, err
+	}
+
+	info := &darwinTCPInfo{}
+	var sockErr error
+
+	err = rawConn.Control(func(fd uintptr) {
+		size := uint32(unsafe.Sizeof(*info))
+		_, _, errno := syscall.Syscall6(
+			syscall.SYS_GETSOCKOPT,
+			fd,
+			syscall.IPPROTO_TCP,
+			darwinTCPConnectionInfo,
+			uintptr(unsafe.Pointer(info)),
+			uintptr(unsafe.Pointer(&size)),
+			0,
+		)
+		if errno != 0 {
+			sockErr = errno
+		}
+	})
+	if err != nil {
+		return TCPInfo{}, fmt.Errorf("accessing socket: %w", err)
+	}
+	if sockErr != nil {
+		return TCPInfo{}, fmt.Errorf("getsockopt TCP_CONNECTION_INFO: %w", sockErr)
+	}
+
+	return TCPInfo{
+		RTT:         time.Duration(info.Srtt) * time.Millisecond,
+		RTTVar:      time.Duration(info.Rttvar) * time.Millisecond,
+		SndCwnd:     info.SndCwnd,
+		SndMSS:      info.Maxseg,
+		Retransmits: info.Txretransmitpackets,
+	}, nil
+}
@@ -0,0 +1,159 @@
+//go:build linux
+
+package netlistener
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// linuxTCPInfo mirrors struct tcp_info from linux/tcp.h up to tcpi_snd_wnd.
+// Older kernels fill in only a prefix of it, the rest stays zero
+type linuxTCPInfo struct {
+	State       uint8
+	CaState     uint8
+	Retransmits uint8
+	Probes      uint8
+	Backoff     uint8
+	Options     uint8
+	Wscale      uint8
+	Flags       uint8
+
+	Rto     uint32
+	Ato     uint32
+	SndMss  uint32
+	RcvMss  uint32
+	Unacked uint32
+	Sacked  uint32
+	Lost    uint32
+	Retrans uint32
+	Fackets uint32
+
+	LastDataSent uint32
+	LastAckSent  uint32
+	LastDataRecv uint32
+	LastAckRecv  uint32
+
+	Pmtu        uint32
+	RcvSsthresh uint32
+	Rtt         uint32
+	Rttvar      uint32
+	SndSsthresh uint32
+	SndCwnd     uint32
+	Advmss      uint32
+	Reordering  uint32
+
+	RcvRtt   uint32
+	RcvSpace uint32
+
+	TotalRetrans uint32
+
+	PacingRate    uint64
+	MaxPacingRate uint64
+	BytesAcked    uint64
+	BytesReceived uint64
+	SegsOut       uint32
+	SegsIn        uint32
+
+	NotsentBytes uint32
+	MinRtt       uint32
+	DataSegsIn   uint32
+	DataSegsOut  uint32
+
+	DeliveryRate uint64
+
+	BusyTime      uint64
+	RwndLimited   uint64
+	SndbufLimited uint64
+
+	Delivered   uint32
+	DeliveredCe uint32
+
+	BytesSent    uint64
+	BytesRetrans uint64
+	DsackDups    uint32
+	ReordSeen    uint32
+
+	RcvOoopack uint32
+	SndWnd     uint32
+}
+
+// getTCPInfo reads TCP_INFO of the socket underlying the connection
+func getTCPInfo(conn net.Conn) (*linuxTCPInfo, error) {
+	rawConn, err := syscallConn(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &linuxTCPInfo{}
+	var sockErr error
+
+	err = rawConn.Control(func(fd uintptr) {
+		size := uint32(unsafe.Sizeof(*info))
+		_, _, errno := syscall.Syscall6(
+			syscall.SYS_GETSOCKOPT,
+			fd,
+			syscall.IPPROTO_TCP,
+			syscall.TCP_INFO,
+			uintptr(unsafe.Pointer(info)),
+			uintptr(unsafe.Pointer(&size)),
+			0,
+		)
+		if errno != 0 {
+			sockErr = errno
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("accessing socket: %w", err)
+	}
+	if sockErr != nil {
+		return nil, fmt.Errorf("getsockopt TCP_INFO: %w", sockErr)
+	}
+
+	return info, nil
+}
+
+func kernelCounters(conn net.Conn) (KernelCounters, error) {
+	info, err := getTCPInfo(conn)
+	if err != nil {
+		return KernelCounters{}, err
+	}
+
+	return KernelCounters{
+		BytesReceived: int64(info.BytesReceived),
+		BytesSent:     int64(info.BytesAcked),
+	}, nil
+}
+
+func socketQueueOf(conn net.Conn) (socketQueue, error) {
+	info, err := getTCPInfo(conn)
+	if err != nil {
+		return socketQueue{}, err
+	}
+
+	return socketQueue{
+		DeliveryRate: info.DeliveryRate,
+		MinRTT:       time.Duration(info.MinRtt) * time.Microsecond,
+		Queued:       int64(info.Unacked)*int64(info.SndMss) + int64(info.NotsentBytes),
+	}, nil
+}
+
+func tcpInfoOf(conn net.Conn) (TCPInfo, error) {
+	info, err := getTCPInfo(conn)
+	if err != nil {
+		return TCPInfo{}, err
+	}
+
+	return TCPInfo{
+		RTT:          time.Duration(info.Rtt) * time.Microsecond,
+		RTTVar:       time.Duration(info.Rttvar) * time.Microsecond,
+		SndCwnd:      info.SndCwnd,
+		SndMSS:       info.SndMss,
+		Retransmits:  uint64(info.TotalRetrans),
+		PacingRate:   info.PacingRate,
+		DeliveryRate: info.DeliveryRate,
+	}, nil
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package netlistener
+
+import "net"
+
+func kernelCounters(conn net.Conn) (KernelCounters, error) {
+	return KernelCounters{}, ErrKernelAccountingUnsupported
+}
+
+func socketQueueOf(conn net.Conn) (socketQueue, error) {
+	return socketQueue{}, ErrKernelAccountingUnsupported
+}
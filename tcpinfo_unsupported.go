@@ -0,0 +1,9 @@
+//go:build !linux && !darwin
+
+package netlistener
+
+import "net"
+
+func tcpInfoOf(conn net.Conn) (TCPInfo, error) {
+	return TCPInfo{}, ErrKernelAccountingUnsupported
+}
@@ -0,0 +1,222 @@
+package netlistener
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// TimerWheel configures a hashed timer wheel the waits for the limiters are woken up by, instead of a runtime timer each.
+// With hundreds of thousands of throttled connections the timers of their waits add up, the wheel keeps them in slots
+// of a tick and wakes all waits of a slot at once from a few goroutines, at the cost of waits ending up to a tick late
+type TimerWheel struct {
+	// Tick is the resolution of the wheel
+	Tick time.Duration `json:"tick"`
+	// Slots is the number of ticks a revolution of the wheel has, waits longer than a revolution stay in their slot
+	// for several revolutions. Zero uses 512
+	Slots int `json:"slots,omitempty"`
+	// Shards is the number of wheels the waits are spread over, each served by its own goroutine while it has waits. Zero uses 1
+	Shards int `json:"shards,omitempty"`
+}
+
+const (
+	defaultWheelSlots  = 512
+	defaultWheelShards = 1
+)
+
+func (w TimerWheel) validate() error {
+	if w.Tick <= 0 || w.Slots < 0 || w.Shards < 0 {
+		return fmt.Errorf("timer wheel needs a positive tick and slots and shards which are not negative, got a tick of %s, %d slots and %d shards", w.Tick, w.Slots, w.Shards)
+	}
+
+	return nil
+}
+
+// timerWheel spreads the timers over its shards round robin
+type timerWheel struct {
+	config TimerWheel
+	// now is the time source the ticks are counted in, so waits computed from the limiters end on the clock of the limiters
+	now    func() time.Time
+	shards []*wheelShard
+	next   atomic.Uint64
+}
+
+// wheelShard is a single wheel, its goroutine advances the cursor every tick and fires the due timers of the slot.
+// The ticks are counted from start, so a late goroutine catches up instead of delaying all timers
+type wheelShard struct {
+	tick  time.Duration
+	start time.Time
+	now   func() time.Time
+
+	mu    sync.Mutex
+	slots []map[*wheelTimer]struct{}
+	// cursor is the last tick whose slot was fired
+	cursor  int64
+	pending int
+	running bool
+}
+
+type wheelTimer struct {
+	c chan struct{}
+	// due is the tick the timer fires at
+	due int64
+}
+
+func newTimerWheel(config TimerWheel, now func() time.Time) *timerWheel {
+	if config.Slots == 0 {
+		config.Slots = defaultWheelSlots
+	}
+	if config.Shards == 0 {
+		config.Shards = defaultWheelShards
+	}
+
+	wheel := &timerWheel{config: config, now: now, shards: make([]*wheelShard, config.Shards)}
+	start := now()
+	for i := range wheel.shards {
+		shard := &wheelShard{tick: config.Tick, start: start, now: now, slots: make([]map[*wheelTimer]struct{}, config.Slots)}
+		for j := range shard.slots {
+			shard.slots[j] = make(map[*wheelTimer]struct{})
+		}
+		wheel.shards[i] = shard
+	}
+
+	return wheel
+}
+
+// after returns a channel which is closed once d passed, never earlier and up to a tick later,
+// and a function removing the timer if the wait ends otherwise
+func (w *timerWheel) after(d time.Duration) (<-chan struct{}, func()) {
+	shard := w.shards[(w.next.Add(1)-1)%uint64(len(w.shards))]
+	timer := shard.add(w.now(), d)
+
+	return timer.c, func() { shard.remove(timer) }
+}
+
+func (s *wheelShard) add(now time.Time, d time.Duration) *wheelTimer {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	elapsed := now.Sub(s.start)
+	if !s.running {
+		// the cursor stood still while the wheel was idle
+		s.cursor = int64(elapsed / s.tick)
+	}
+
+	// the slot of a tick is fired at the end of the tick, rounding up never fires early
+	due := int64((elapsed + d + s.tick - 1) / s.tick)
+	timer := &wheelTimer{c: make(chan struct{}), due: due}
+	if due <= s.cursor {
+		close(timer.c)
+		return timer
+	}
+
+	s.slots[due%int64(len(s.slots))][timer] = struct{}{}
+	s.pending++
+	if !s.running {
+		s.running = true
+		go s.run()
+	}
+
+	return timer
+}
+
+func (s *wheelShard) remove(timer *wheelTimer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	slot := s.slots[timer.due%int64(len(s.slots))]
+	if _, ok := slot[timer]; ok {
+		delete(slot, timer)
+		s.pending--
+	}
+}
+
+// run advances the wheel every tick until it has no timers left
+func (s *wheelShard) run() {
+	ticker := time.NewTicker(s.tick)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if !s.advance(s.now()) {
+			return
+		}
+	}
+}
+
+// advance fires the slots of the ticks which ended by now, it returns false and stops the wheel when no timers are left
+func (s *wheelShard) advance(now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for target := int64(now.Sub(s.start) / s.tick); s.cursor < target && s.pending > 0; {
+		s.cursor++
+		slot := s.slots[s.cursor%int64(len(s.slots))]
+		for timer := range slot {
+			if timer.due <= s.cursor {
+				close(timer.c)
+				delete(slot, timer)
+				s.pending--
+			}
+		}
+	}
+
+	if s.pending == 0 {
+		s.running = false
+	}
+
+	return s.running
+}
+
+// sleepTimer returns a channel which is closed after d, from the wheel if there is one and from a runtime timer otherwise,
+// and a function stopping the timer
+func sleepTimer(wheel *timerWheel, d time.Duration) (<-chan struct{}, func()) {
+	if wheel != nil {
+		return wheel.after(d)
+	}
+
+	expired := make(chan struct{})
+	timer := time.AfterFunc(d, func() { close(expired) })
+
+	return expired, func() { timer.Stop() }
+}
+
+// SetTimerWheel wakes the waits for the limiters from a timer wheel instead of a runtime timer each, see TimerWheel.
+// Waits in progress finish on the timers they started with. Nil goes back to runtime timers
+func (c *BandwidthConfig) SetTimerWheel(wheel *TimerWheel) error {
+	if wheel != nil {
+		if err := wheel.validate(); err != nil {
+			return err
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.wheel = nil
+	if wheel != nil {
+		c.wheel = newTimerWheel(*wheel, c.lockedClock().Now)
+	}
+
+	return nil
+}
+
+// TimerWheel returns the configuration of the timer wheel with the defaults filled in, nil if waits use runtime timers
+func (c *BandwidthConfig) TimerWheel() *TimerWheel {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.wheel == nil {
+		return nil
+	}
+	config := c.wheel.config
+
+	return &config
+}
+
+func (c *BandwidthConfig) timerWheel() *timerWheel {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.wheel
+}
@@ -0,0 +1,168 @@
+package netlistener
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+func TestTimerWheel_After(t *testing.T) {
+	tests := []struct {
+		name  string
+		wheel TimerWheel
+		delay time.Duration
+	}{
+		{name: "Within a revolution", wheel: TimerWheel{Tick: 5 * time.Millisecond}, delay: 30 * time.Millisecond},
+		{name: "Several revolutions", wheel: TimerWheel{Tick: 5 * time.Millisecond, Slots: 4}, delay: 50 * time.Millisecond},
+		{name: "Shorter than a tick", wheel: TimerWheel{Tick: 20 * time.Millisecond, Shards: 2}, delay: time.Millisecond},
+		{name: "No delay", wheel: TimerWheel{Tick: 5 * time.Millisecond}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wheel := newTimerWheel(tt.wheel, time.Now)
+
+			start := time.Now()
+			expired, stop := wheel.after(tt.delay)
+			defer stop()
+
+			select {
+			case <-expired:
+			case <-time.After(time.Second):
+				t.Fatal("expected the timer to fire")
+			}
+
+			elapsed := time.Since(start)
+			if elapsed < tt.delay || elapsed > tt.delay+tt.wheel.Tick+20*time.Millisecond {
+				t.Errorf("expected the timer to fire after %s and up to a tick later, fired after %s", tt.delay, elapsed)
+			}
+		})
+	}
+}
+
+func TestTimerWheel_Stop(t *testing.T) {
+	wheel := newTimerWheel(TimerWheel{Tick: 5 * time.Millisecond}, time.Now)
+	shard := wheel.shards[0]
+
+	stopped, stop := wheel.after(20 * time.Millisecond)
+	expired, _ := wheel.after(40 * time.Millisecond)
+	stop()
+
+	<-expired
+	select {
+	case <-stopped:
+		t.Error("expected the stopped timer not to fire")
+	default:
+	}
+
+	// the goroutine of the wheel stops once it has no timers left
+	time.Sleep(20 * time.Millisecond)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	if shard.pending != 0 || shard.running {
+		t.Errorf("expected an idle wheel, %d timers pending, running %v", shard.pending, shard.running)
+	}
+}
+
+func TestTimerWheel_Clock(t *testing.T) {
+	// the clock jumps an hour ahead, e.g. a suspend a boot clock accounts for
+	var offset atomic.Int64
+	now := func() time.Time { return time.Now().Add(time.Duration(offset.Load())) }
+	wheel := newTimerWheel(TimerWheel{Tick: 5 * time.Millisecond}, now)
+
+	expired, _ := wheel.after(time.Minute)
+	offset.Store(int64(time.Hour))
+
+	select {
+	case <-expired:
+	case <-time.After(time.Second):
+		t.Error("expected the timer to fire once its time passed on the clock of the wheel")
+	}
+}
+
+func TestPace_TimerWheel(t *testing.T) {
+	wheel := newTimerWheel(TimerWheel{Tick: 2 * time.Millisecond}, time.Now)
+	limiter := rate.NewLimiter(1000, 1000)
+	limiter.AllowN(time.Now(), 1000)
+
+	start := time.Now()
+	if err := pace(context.Background(), SystemClock, wheel, nil, nil, []*rate.Limiter{limiter}, 50, 0, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// 50 bytes at 1000 B/s take 50ms
+	if elapsed := time.Since(start); elapsed < 49*time.Millisecond || elapsed > 70*time.Millisecond {
+		t.Errorf("expected to wait 50ms, waited %s", elapsed)
+	}
+}
+
+func TestBandwidthConfig_SetTimerWheel(t *testing.T) {
+	tests := []struct {
+		name     string
+		wheel    *TimerWheel
+		expected *TimerWheel
+		wantErr  bool
+	}{
+		{name: "Defaults", wheel: &TimerWheel{Tick: time.Millisecond}, expected: &TimerWheel{Tick: time.Millisecond, Slots: 512, Shards: 1}},
+		{name: "Configured", wheel: &TimerWheel{Tick: time.Millisecond, Slots: 64, Shards: 4}, expected: &TimerWheel{Tick: time.Millisecond, Slots: 64, Shards: 4}},
+		{name: "Disabled"},
+		{name: "No tick", wheel: &TimerWheel{}, wantErr: true},
+		{name: "Negative shards", wheel: &TimerWheel{Tick: time.Millisecond, Shards: -1}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := NewBandwidthConfig(nil, nil)
+			err := config.SetTimerWheel(tt.wheel)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SetTimerWheel() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			got := config.TimerWheel()
+			if (got == nil) != (tt.expected == nil) || got != nil && *got != *tt.expected {
+				t.Errorf("TimerWheel() = %+v, expected %+v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestListener_TimerWheel(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Failed to create listener", err)
+	}
+	defer listener.Close()
+
+	throttledListener, err := NewListener(listener, nil, ptr(100), WithTimerWheel(TimerWheel{Tick: 5 * time.Millisecond}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		readDataFromConn(conn)
+	}()
+
+	conn, err := throttledListener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// the burst of a second is spent first, the second 100 bytes wait for a second
+	start := time.Now()
+	if _, err := conn.Write(make([]byte, 200)); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 900*time.Millisecond || elapsed > 1300*time.Millisecond {
+		t.Errorf("expected the write to take about a second, took %s", elapsed)
+	}
+}
@@ -0,0 +1,146 @@
+package netlistener
+
+import (
+	"crypto/tls"
+	"net"
+)
+
+// TLSAccounting decides which bytes of TLS connections are charged to the limiters
+type TLSAccounting int
+
+const (
+	// TLSAccountingWire charges the bytes on the wire, including the handshake and the record overhead,
+	// TLS runs on top of the throttled connection
+	TLSAccountingWire TLSAccounting = iota
+	// TLSAccountingApplication charges only the plaintext bytes read and written by the application,
+	// the throttled connection wraps the TLS connection
+	TLSAccountingApplication
+)
+
+func (a TLSAccounting) String() string {
+	switch a {
+	case TLSAccountingWire:
+		return "wire"
+	case TLSAccountingApplication:
+		return "application"
+	}
+
+	return "unknown"
+}
+
+// NewTLSListener wraps the throttled listener with TLS, charging wire or application bytes according to SetTLSAccounting.
+// During the handshake connections are classified again with the server name of the hello, see ConnMetadata.SNI,
+// and moved to the class configured for the negotiated ALPN protocol, see SetALPNClasses
+func NewTLSListener(l *Listener, config *tls.Config) net.Listener {
+	if l.config.TLSAccounting() == TLSAccountingApplication {
+		return NewApplicationTLSListener(l, config)
+	}
+
+	return NewWireTLSListener(l, config)
+}
+
+// NewWireTLSListener runs TLS on top of the throttled connections, so the limits apply to the encrypted bytes
+func NewWireTLSListener(l *Listener, config *tls.Config) net.Listener {
+	return tls.NewListener(l, l.tlsConfig(config))
+}
+
+// NewApplicationTLSListener throttles the TLS connections, so the limits apply to the plaintext bytes only.
+// The accepted connections are throttled connections wrapping a *tls.Conn, the handshake happens on the first Read or Write
+// and is not charged
+func NewApplicationTLSListener(l *Listener, config *tls.Config) net.Listener {
+	return &applicationTLSListener{Listener: l, config: config}
+}
+
+type applicationTLSListener struct {
+	*Listener
+	config *tls.Config
+}
+
+func (l *applicationTLSListener) Accept() (net.Conn, error) {
+	return l.accept(func(conn net.Conn, throttled **ThrottledConn) net.Conn {
+		return tls.Server(conn, l.connTLSConfig(l.config, throttled))
+	})
+}
+
+// tlsConfig hooks into the handshake of every connection, the hello gives access to the throttled connection and its server name,
+// and VerifyConnection is the first place where the negotiated protocol is known
+func (l *Listener) tlsConfig(base *tls.Config) *tls.Config {
+	config := base.Clone()
+
+	config.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		connConfig := base
+		if base.GetConfigForClient != nil {
+			custom, err := base.GetConfigForClient(hello)
+			if err != nil {
+				return nil, err
+			}
+			if custom != nil {
+				connConfig = custom
+			}
+		}
+
+		if err := l.config.applySNI(hello.Conn, hello.ServerName); err != nil {
+			return nil, err
+		}
+
+		return l.withALPNClasses(connConfig, hello.Conn), nil
+	}
+
+	return config
+}
+
+// connTLSConfig is tlsConfig for a TLS connection wrapped by a throttled connection, which is not reachable from the hello.
+// The throttled connection is set once the connection is wrapped, before the handshake can start
+func (l *Listener) connTLSConfig(base *tls.Config, throttled **ThrottledConn) *tls.Config {
+	config := base.Clone()
+
+	config.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		connConfig := base
+		if base.GetConfigForClient != nil {
+			custom, err := base.GetConfigForClient(hello)
+			if err != nil {
+				return nil, err
+			}
+			if custom != nil {
+				connConfig = custom
+			}
+		}
+
+		if err := l.config.applySNI(*throttled, hello.ServerName); err != nil {
+			return nil, err
+		}
+
+		return l.withALPNClasses(connConfig, *throttled), nil
+	}
+
+	return config
+}
+
+// withALPNClasses returns a copy of the config moving the connection to the class of the negotiated protocol during the handshake
+func (l *Listener) withALPNClasses(base *tls.Config, conn net.Conn) *tls.Config {
+	config := base.Clone()
+	verify := config.VerifyConnection
+
+	config.VerifyConnection = func(state tls.ConnectionState) error {
+		if verify != nil {
+			if err := verify(state); err != nil {
+				return err
+			}
+		}
+
+		l.config.applyALPN(conn, state.NegotiatedProtocol)
+
+		return nil
+	}
+
+	return config
+}
+
+// socket returns the connection the socket options can be read from, the one below TLS in application accounting
+func (c *ThrottledConn) socket() net.Conn {
+	if tlsConn, ok := c.Conn.(*tls.Conn); ok {
+		return tlsConn.NetConn()
+	}
+
+	return c.Conn
+}
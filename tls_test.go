@@ -0,0 +1,257 @@
+package netlistener
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+// selfSignedCertificate returns a certificate for localhost, valid for an hour
+func selfSignedCertificate(t *testing.T) tls.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestTLSListener_ALPNClasses(t *testing.T) {
+	tests := []struct {
+		name          string
+		protocols     []string
+		expectedClass string
+	}{
+		{name: "HTTP/2", protocols: []string{"h2"}, expectedClass: "http2"},
+		{name: "HTTP/1.1", protocols: []string{"http/1.1"}, expectedClass: "http1"},
+		{name: "No ALPN", expectedClass: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal("Failed to create listener", err)
+			}
+			defer listener.Close()
+
+			throttledListener, _ := NewListener(listener, nil, nil)
+			if err := throttledListener.SetClasses([]ClassConfig{
+				{Name: "http1", PerConnLimit: ptr(1000)},
+				{Name: "http2", PerConnLimit: ptr(10000)},
+			}, ""); err != nil {
+				t.Fatal(err)
+			}
+			throttledListener.SetALPNClasses(map[string]string{"h2": "http2", "http/1.1": "http1"})
+
+			tlsListener := NewTLSListener(throttledListener, &tls.Config{
+				Certificates: []tls.Certificate{selfSignedCertificate(t)},
+				NextProtos:   []string{"h2", "http/1.1"},
+			})
+
+			go func() {
+				conn, err := tls.Dial("tcp", listener.Addr().String(), &tls.Config{InsecureSkipVerify: true, NextProtos: tt.protocols})
+				if err == nil {
+					defer conn.Close()
+					conn.Read(make([]byte, 1))
+				}
+			}()
+
+			conn, err := tlsListener.Accept()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+
+			tlsConn := conn.(*tls.Conn)
+			if err := tlsConn.Handshake(); err != nil {
+				t.Fatal(err)
+			}
+
+			throttled := tlsConn.NetConn().(*ThrottledConn)
+			if class := throttled.config.Classification().Class; class != tt.expectedClass {
+				t.Errorf("expected class %q, got %q", tt.expectedClass, class)
+			}
+		})
+	}
+}
+
+func TestTLSListener_SNIPolicy(t *testing.T) {
+	policy := &Policy{Rules: []Rule{
+		{Name: "blocked", Match: RuleMatch{SNI: []string{"blocked.example.com"}}, Action: RuleAction{Deny: true}},
+		{Name: "api", Match: RuleMatch{SNI: []string{"*.API.example.com"}}, Action: RuleAction{Class: "api"}},
+	}}
+	if err := policy.Compile(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name          string
+		accounting    TLSAccounting
+		serverName    string
+		expectedClass string
+		denied        bool
+	}{
+		{name: "Wire accounting", accounting: TLSAccountingWire, serverName: "v1.api.example.com", expectedClass: "api"},
+		{name: "Application accounting", accounting: TLSAccountingApplication, serverName: "v1.Api.Example.com", expectedClass: "api"},
+		{name: "No matching rule", accounting: TLSAccountingWire, serverName: "www.example.com"},
+		{name: "Denied", accounting: TLSAccountingWire, serverName: "blocked.example.com", denied: true},
+		{name: "Denied with application accounting", accounting: TLSAccountingApplication, serverName: "blocked.example.com", denied: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal("Failed to create listener", err)
+			}
+			defer listener.Close()
+
+			throttledListener, _ := NewListener(listener, nil, nil)
+			if err := throttledListener.SetClasses([]ClassConfig{{Name: "api", PerConnLimit: ptr(1000)}}, ""); err != nil {
+				t.Fatal(err)
+			}
+			throttledListener.SetTLSAccounting(tt.accounting)
+			throttledListener.SetClassifier(policy)
+
+			tlsListener := NewTLSListener(throttledListener, &tls.Config{Certificates: []tls.Certificate{selfSignedCertificate(t)}})
+
+			go func() {
+				conn, err := tls.Dial("tcp", listener.Addr().String(), &tls.Config{InsecureSkipVerify: true, ServerName: tt.serverName})
+				if err == nil {
+					defer conn.Close()
+					conn.Read(make([]byte, 1))
+				}
+			}()
+
+			conn, err := tlsListener.Accept()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+
+			var throttled *ThrottledConn
+			var handshakeErr error
+			if tt.accounting == TLSAccountingApplication {
+				throttled = conn.(*ThrottledConn)
+				handshakeErr = throttled.Conn.(*tls.Conn).Handshake()
+			} else {
+				tlsConn := conn.(*tls.Conn)
+				throttled = tlsConn.NetConn().(*ThrottledConn)
+				handshakeErr = tlsConn.Handshake()
+			}
+
+			if tt.denied {
+				if handshakeErr == nil {
+					t.Fatal("expected the handshake to fail")
+				}
+				if rejected := throttledListener.Stats().Rejections[RejectDenied.String()]; rejected != 1 {
+					t.Errorf("expected 1 rejection, got %d", rejected)
+				}
+				return
+			}
+			if handshakeErr != nil {
+				t.Fatal(handshakeErr)
+			}
+			if class := throttled.config.Classification().Class; class != tt.expectedClass {
+				t.Errorf("expected class %q, got %q", tt.expectedClass, class)
+			}
+		})
+	}
+}
+
+func TestTLSListener_Accounting(t *testing.T) {
+	tests := []struct {
+		name       string
+		accounting TLSAccounting
+		// written checks the bytes charged for 1000 bytes of plaintext
+		written func(written int64) bool
+	}{
+		{
+			name:       "Wire bytes include the handshake and record overhead",
+			accounting: TLSAccountingWire,
+			written:    func(written int64) bool { return written > 1000 },
+		},
+		{
+			name:       "Application bytes are the plaintext only",
+			accounting: TLSAccountingApplication,
+			written:    func(written int64) bool { return written == 1000 },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal("Failed to create listener", err)
+			}
+			defer listener.Close()
+
+			throttledListener, _ := NewListener(listener, nil, nil)
+			if err := throttledListener.SetClasses([]ClassConfig{{Name: "http2", PerConnLimit: ptr(10000)}}, ""); err != nil {
+				t.Fatal(err)
+			}
+			throttledListener.SetALPNClasses(map[string]string{"h2": "http2"})
+			throttledListener.SetTLSAccounting(tt.accounting)
+
+			tlsListener := NewTLSListener(throttledListener, &tls.Config{
+				Certificates: []tls.Certificate{selfSignedCertificate(t)},
+				NextProtos:   []string{"h2"},
+			})
+
+			go func() {
+				conn, err := tls.Dial("tcp", listener.Addr().String(), &tls.Config{InsecureSkipVerify: true, NextProtos: []string{"h2"}})
+				if err == nil {
+					defer conn.Close()
+					io.ReadFull(conn, make([]byte, 1000))
+				}
+			}()
+
+			conn, err := tlsListener.Accept()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+
+			if _, err := conn.Write(make([]byte, 1000)); err != nil {
+				t.Fatal(err)
+			}
+
+			var throttled *ThrottledConn
+			if tlsConn, ok := conn.(*tls.Conn); ok {
+				throttled = tlsConn.NetConn().(*ThrottledConn)
+			} else {
+				throttled = conn.(*ThrottledConn)
+			}
+
+			if written := throttled.bytesWritten.Load(); !tt.written(written) {
+				t.Errorf("unexpected bytes charged for 1000 bytes of plaintext: %d", written)
+			}
+			if class := throttled.config.Classification().Class; class != "http2" {
+				t.Errorf("expected class %q, got %q", "http2", class)
+			}
+		})
+	}
+}
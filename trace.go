@@ -0,0 +1,198 @@
+package netlistener
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ConnTrace receives the trace records of a connection: accept, classification, first byte, throttled waits and close.
+// It is the subset of trace.Trace of golang.org/x/net/trace used here, so those traces can be used directly:
+//
+//	l.SetConnTracer(func(conn net.Conn) netlistener.ConnTrace { return trace.New("netlistener", conn.RemoteAddr().String()) })
+type ConnTrace interface {
+	LazyPrintf(format string, a ...any)
+	SetError()
+	Finish()
+}
+
+// ConnTracer starts the trace of a connection when it is wrapped, nil disables tracing of the connection
+type ConnTracer func(conn net.Conn) ConnTrace
+
+// SetConnTracer traces every connection wrapped afterwards with the tracer, nil disables tracing.
+// Throttled waits are traced for the operations sampled by the instrumentation sampling only
+func (c *BandwidthConfig) SetConnTracer(tracer ConnTracer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.connTracer = tracer
+}
+
+func (c *BandwidthConfig) ConnTracer() ConnTracer {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.connTracer
+}
+
+// startTrace starts the trace of a new connection if a tracer is set
+func (c *ThrottledConn) startTrace() {
+	tracer := c.config.globalConfig.ConnTracer()
+	if tracer == nil {
+		return
+	}
+
+	c.trace = tracer(c.Conn)
+	if c.trace != nil {
+		c.trace.LazyPrintf("accepted from %v on %v", c.Conn.RemoteAddr(), c.Conn.LocalAddr())
+	}
+}
+
+// traceState records the transitions of the connection which are part of its trace
+func (c *ThrottledConn) traceState(state ConnState) {
+	if c.trace == nil {
+		return
+	}
+
+	switch state {
+	case ConnClassified:
+		classification := c.config.Classification()
+		c.trace.LazyPrintf("classified as class %q, profile %q, tags %v", classification.Class, classification.Profile, classification.Tags)
+	case ConnActive:
+		if !c.tracedFirstByte.Swap(true) {
+			c.trace.LazyPrintf("first byte")
+		}
+	}
+}
+
+// traceWait records a throttled wait of the connection
+func (c *ThrottledConn) traceWait(waited time.Duration, n int) {
+	if c.trace != nil {
+		c.trace.LazyPrintf("throttled for %v waiting for %d bytes", waited, n)
+	}
+}
+
+// finishTrace records the close of the connection and finishes its trace
+func (c *ThrottledConn) finishTrace() {
+	if c.trace == nil {
+		return
+	}
+
+	c.trace.LazyPrintf("closed after %v, read %d bytes, written %d bytes", time.Since(c.acceptedAt).Round(time.Millisecond), c.bytesRead.Load(), c.bytesWritten.Load())
+	c.trace.Finish()
+}
+
+// TraceRecorder keeps the traces of the open connections and of the recently closed ones in memory, for environments without
+// golang.org/x/net/trace. It is an http.Handler rendering them as text like /debug/requests, e.g.
+//
+//	recorder := netlistener.NewTraceRecorder(100)
+//	l.SetConnTracer(recorder.Tracer())
+//	mux.Handle("/debug/conns", recorder)
+type TraceRecorder struct {
+	active   map[*recordedTrace]struct{}
+	finished []*recordedTrace
+	// next is the position in finished the next finished trace is stored at once it is full
+	next int
+	max  int
+
+	mu sync.Mutex
+}
+
+type recordedTrace struct {
+	recorder *TraceRecorder
+	title    string
+	start    time.Time
+	events   []traceEvent
+	failed   bool
+}
+
+type traceEvent struct {
+	time    time.Time
+	message string
+}
+
+// NewTraceRecorder keeps the traces of up to max recently closed connections
+func NewTraceRecorder(max int) *TraceRecorder {
+	return &TraceRecorder{active: make(map[*recordedTrace]struct{}), max: max}
+}
+
+// Tracer returns the tracer recording into the recorder
+func (r *TraceRecorder) Tracer() ConnTracer {
+	return func(conn net.Conn) ConnTrace {
+		trace := &recordedTrace{recorder: r, title: fmt.Sprint(conn.RemoteAddr()), start: time.Now()}
+
+		r.mu.Lock()
+		r.active[trace] = struct{}{}
+		r.mu.Unlock()
+
+		return trace
+	}
+}
+
+// LazyPrintf formats right away, the recorder is meant for debugging and the arguments may change afterwards
+func (t *recordedTrace) LazyPrintf(format string, a ...any) {
+	message := fmt.Sprintf(format, a...)
+
+	t.recorder.mu.Lock()
+	defer t.recorder.mu.Unlock()
+
+	t.events = append(t.events, traceEvent{time: time.Now(), message: message})
+}
+
+func (t *recordedTrace) SetError() {
+	t.recorder.mu.Lock()
+	defer t.recorder.mu.Unlock()
+
+	t.failed = true
+}
+
+func (t *recordedTrace) Finish() {
+	r := t.recorder
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.active, t)
+	if r.max <= 0 {
+		return
+	}
+	if len(r.finished) < r.max {
+		r.finished = append(r.finished, t)
+		return
+	}
+	r.finished[r.next] = t
+	r.next = (r.next + 1) % r.max
+}
+
+// ServeHTTP renders the open connections followed by the recently closed ones, each with the time since its start for every record
+func (r *TraceRecorder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+
+	fmt.Fprintf(w, "Open connections: %d\n\n", len(r.active))
+	for trace := range r.active {
+		trace.render(w)
+	}
+
+	fmt.Fprintf(w, "Recently closed connections: %d\n\n", len(r.finished))
+	// the most recently closed connection first
+	for i := range len(r.finished) {
+		r.finished[(r.next-1-i+2*len(r.finished))%len(r.finished)].render(w)
+	}
+}
+
+func (t *recordedTrace) render(w http.ResponseWriter) {
+	status := ""
+	if t.failed {
+		status = " (error)"
+	}
+
+	fmt.Fprintf(w, "%s %s%s\n", t.start.Format(time.RFC3339Nano), t.title, status)
+	for _, event := range t.events {
+		fmt.Fprintf(w, "\t%12v %s\n", event.time.Sub(t.start).Round(time.Microsecond), event.message)
+	}
+	fmt.Fprintln(w)
+}
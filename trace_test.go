@@ -0,0 +1,81 @@
+package netlistener
+
+import (
+	"io"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTraceRecorder(t *testing.T) {
+	recorder := NewTraceRecorder(1)
+	config := NewBandwithConfig(nil, ptr(100))
+	config.SetConnTracer(recorder.Tracer())
+
+	connConfig := NewConnectionBandwithConfig(config)
+	connConfig.SetClassification(Classification{Class: "acme"})
+	if err := config.SetClasses([]ClassConfig{{Name: "acme"}}, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	connRead, connWrite := net.Pipe()
+	conn := NewThrottledConnection(connWrite, connConfig)
+	go readDataFromConn(connRead)
+
+	// the second write waits for the per connection limiter
+	for _, n := range []int{100, 50} {
+		if _, err := conn.Write(make([]byte, n)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	render := func() string {
+		response := httptest.NewRecorder()
+		recorder.ServeHTTP(response, httptest.NewRequest("GET", "/debug/conns", nil))
+		body, _ := io.ReadAll(response.Body)
+		return string(body)
+	}
+
+	if page := render(); !strings.Contains(page, "Open connections: 1") {
+		t.Errorf("expected the connection to be open, got\n%s", page)
+	}
+	conn.Close()
+
+	page := render()
+	tests := []struct {
+		name     string
+		expected string
+	}{
+		{name: "Accept", expected: "accepted from"},
+		{name: "Classification", expected: `classified as class "acme"`},
+		{name: "First byte", expected: "first byte"},
+		{name: "Throttled wait", expected: "throttled for"},
+		{name: "Close", expected: "closed after"},
+		{name: "Closed connections", expected: "Recently closed connections: 1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !strings.Contains(page, tt.expected) {
+				t.Errorf("expected %q in\n%s", tt.expected, page)
+			}
+		})
+	}
+}
+
+func TestTraceRecorder_KeepsRecent(t *testing.T) {
+	recorder := NewTraceRecorder(2)
+	config := NewBandwithConfig(nil, nil)
+	config.SetConnTracer(recorder.Tracer())
+
+	for range 3 {
+		connRead, connWrite := net.Pipe()
+		NewThrottledConnection(connWrite, NewConnectionBandwithConfig(config)).Close()
+		connRead.Close()
+	}
+
+	if len(recorder.finished) != 2 || len(recorder.active) != 0 {
+		t.Errorf("expected 2 closed and no open connections, got %d and %d", len(recorder.finished), len(recorder.active))
+	}
+}
@@ -0,0 +1,69 @@
+package netlistener
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"golang.org/x/time/rate"
+)
+
+// errLimitsChanged is returned by pace when the limits were raised during the wait, the caller picks up the new limits and waits again
+var errLimitsChanged = errors.New("limits changed during the wait")
+
+// LimitUpdates broadcasts changes of the limits to the operations waiting for the limiters.
+// Every change closes the current channel and starts a new one, so a waiter has to take the channel before looking at the limits.
+// The zero value is ready to use
+type LimitUpdates struct {
+	ch chan struct{}
+	mu sync.Mutex
+}
+
+// Changed returns a channel which is closed on the next change of the limits
+func (u *LimitUpdates) Changed() <-chan struct{} {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.ch == nil {
+		u.ch = make(chan struct{})
+	}
+
+	return u.ch
+}
+
+// Notify wakes the operations waiting for the limiters, it should be called after the limits were changed
+func (u *LimitUpdates) Notify() {
+	invalidateFastPath()
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.ch != nil {
+		close(u.ch)
+		u.ch = nil
+	}
+}
+
+// WaitNUpdatable blocks until all limiters returned by the limiters function allow n bytes.
+// The tokens are reserved on all of them at once, and when updates are notified during the wait,
+// the reservations are refunded and the wait starts over with the limiters returned by a new call,
+// instead of sleeping out the delay computed with the old limits. A nil updates never interrupts the wait.
+// It fails with ErrThrottleCancelled when the context is done, right away with os.ErrDeadlineExceeded
+// if the bytes would not be allowed before the deadline of the context, and with ErrLimitExceededBurst
+// if n exceeds the burst of a limiter
+func WaitNUpdatable(ctx context.Context, updates *LimitUpdates, n int, limiters func() []*rate.Limiter) error {
+	deadline, _ := ctx.Deadline()
+
+	for {
+		// the channel is taken before the limiters, so a change in between is not missed
+		var changed <-chan struct{}
+		if updates != nil {
+			changed = updates.Changed()
+		}
+
+		err := pace(ctx, SystemClock, nil, nil, changed, limiters(), n, 0, deadline)
+		if err != errLimitsChanged {
+			return err
+		}
+	}
+}
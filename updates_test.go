@@ -0,0 +1,172 @@
+package netlistener
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+func TestRateLimitedConnection_WakeOnRaisedLimit(t *testing.T) {
+	tests := []struct {
+		name  string
+		raise func(config *BandwidthConfig)
+	}{
+		{
+			name:  "Raised per connection limit",
+			raise: func(config *BandwidthConfig) { config.SetPerConnLimit(ptr(1000)) },
+		},
+		{
+			name:  "Removed per connection limit",
+			raise: func(config *BandwidthConfig) { config.SetPerConnLimit(nil) },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := NewBandwithConfig(nil, ptr(10))
+
+			connRead, connWrite := net.Pipe()
+			defer connWrite.Close()
+			conn := NewThrottledConnection(connRead, NewConnectionBandwithConfig(config))
+			defer conn.Close()
+			go connWrite.Write(make([]byte, 20))
+
+			if _, err := conn.Read(make([]byte, 10)); err != nil {
+				t.Fatal(err)
+			}
+
+			time.AfterFunc(50*time.Millisecond, func() { tt.raise(config) })
+
+			start := time.Now()
+			if _, err := conn.Read(make([]byte, 10)); err != nil {
+				t.Fatal(err)
+			}
+
+			// with the old limit the read would wait for a second
+			if elapsed := time.Since(start); elapsed > 300*time.Millisecond {
+				t.Errorf("expected the blocked read to pick up the raised limit, took %v", elapsed)
+			}
+		})
+	}
+}
+
+func TestRateLimitedConnection_WakeOnRaisedGlobalLimit(t *testing.T) {
+	config := NewBandwithConfig(ptr(10), nil)
+
+	connRead, connWrite := net.Pipe()
+	defer connRead.Close()
+	conn := NewThrottledConnection(connWrite, NewConnectionBandwithConfig(config))
+	defer conn.Close()
+	go func() {
+		buf := make([]byte, 100)
+		for {
+			if _, err := connRead.Read(buf); err != nil {
+				return
+			}
+		}
+	}()
+
+	if _, err := conn.Write(make([]byte, 10)); err != nil {
+		t.Fatal(err)
+	}
+
+	time.AfterFunc(50*time.Millisecond, func() { config.SetGlobalLimit(ptr(1000)) })
+
+	start := time.Now()
+	if _, err := conn.Write(make([]byte, 10)); err != nil {
+		t.Fatal(err)
+	}
+
+	if elapsed := time.Since(start); elapsed > 300*time.Millisecond {
+		t.Errorf("expected the blocked write to pick up the raised limit, took %v", elapsed)
+	}
+}
+
+func TestWaitNUpdatable(t *testing.T) {
+	tests := []struct {
+		name string
+		n    int
+		// update is called after 50ms with the updates and the pointer to the limit used by the next call of the limiters function
+		update  func(updates *LimitUpdates, limit *atomic.Int64)
+		ctx     func() (context.Context, context.CancelFunc)
+		err     error
+		maxWait time.Duration
+	}{
+		{
+			name: "Raised limit ends the wait",
+			n:    10,
+			update: func(updates *LimitUpdates, limit *atomic.Int64) {
+				limit.Store(1000)
+				updates.Notify()
+			},
+			ctx:     func() (context.Context, context.CancelFunc) { return context.WithCancel(context.Background()) },
+			maxWait: 300 * time.Millisecond,
+		},
+		{
+			name:   "Cancelled context",
+			n:      10,
+			update: func(*LimitUpdates, *atomic.Int64) {},
+			ctx: func() (context.Context, context.CancelFunc) {
+				ctx, cancel := context.WithCancel(context.Background())
+				time.AfterFunc(50*time.Millisecond, cancel)
+				return ctx, cancel
+			},
+			err:     ErrThrottleCancelled,
+			maxWait: 300 * time.Millisecond,
+		},
+		{
+			name:   "Deadline before the tokens are available",
+			n:      10,
+			update: func(*LimitUpdates, *atomic.Int64) {},
+			ctx: func() (context.Context, context.CancelFunc) {
+				return context.WithTimeout(context.Background(), 500*time.Millisecond)
+			},
+			err:     os.ErrDeadlineExceeded,
+			maxWait: 50 * time.Millisecond,
+		},
+		{
+			name:    "More than the burst",
+			n:       11,
+			update:  func(*LimitUpdates, *atomic.Int64) {},
+			ctx:     func() (context.Context, context.CancelFunc) { return context.WithCancel(context.Background()) },
+			err:     ErrLimitExceededBurst,
+			maxWait: 50 * time.Millisecond,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var updates LimitUpdates
+			var limit atomic.Int64
+			limit.Store(10)
+
+			limiter := rate.NewLimiter(10, 10)
+			limiter.AllowN(time.Now(), 10)
+			limiters := func() []*rate.Limiter {
+				updateLimiter(limiter, rate.Limit(limit.Load()))
+				return []*rate.Limiter{limiter}
+			}
+
+			ctx, cancel := tt.ctx()
+			defer cancel()
+			time.AfterFunc(50*time.Millisecond, func() { tt.update(&updates, &limit) })
+
+			start := time.Now()
+			err := WaitNUpdatable(ctx, &updates, tt.n, limiters)
+			elapsed := time.Since(start)
+
+			if tt.err == nil && err != nil || tt.err != nil && !errors.Is(err, tt.err) {
+				t.Errorf("expected %v, got %v", tt.err, err)
+			}
+			if elapsed > tt.maxWait {
+				t.Errorf("expected to return within %v, took %v", tt.maxWait, elapsed)
+			}
+		})
+	}
+}
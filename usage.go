@@ -0,0 +1,87 @@
+package netlistener
+
+import (
+	"sync"
+	"time"
+)
+
+// usageWindowSlots is the number of one second slots kept by usageWindow, so windows up to 15 seconds can be queried
+const usageWindowSlots = 16
+
+// usageWindow counts the bytes transferred in each of the last seconds, for questions about recent usage
+type usageWindow struct {
+	bytes [usageWindowSlots]int64
+	// seconds holds the monotonic second each slot was last used for, slots of older seconds are stale
+	seconds [usageWindowSlots]int64
+
+	mu sync.Mutex
+}
+
+func (w *usageWindow) add(now time.Time, n int64) {
+	second := monotonicSecond(now)
+	slot := (second%usageWindowSlots + usageWindowSlots) % usageWindowSlots
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.seconds[slot] != second {
+		w.seconds[slot] = second
+		w.bytes[slot] = 0
+	}
+	w.bytes[slot] += n
+}
+
+// sum returns the bytes transferred within the window before now, rounded up to whole seconds
+func (w *usageWindow) sum(now time.Time, window time.Duration) int64 {
+	second := monotonicSecond(now)
+	seconds := min(int64((window+time.Second-1)/time.Second), usageWindowSlots)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var sum int64
+	for i := range w.bytes {
+		if age := second - w.seconds[i]; age >= 0 && age < seconds {
+			sum += w.bytes[i]
+		}
+	}
+
+	return sum
+}
+
+// connRegistry is the set of open connections of a config
+type connRegistry struct {
+	conns map[*ThrottledConn]struct{}
+
+	mu sync.RWMutex
+}
+
+func (r *connRegistry) add(conn *ThrottledConn) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.conns == nil {
+		r.conns = make(map[*ThrottledConn]struct{})
+	}
+	r.conns[conn] = struct{}{}
+}
+
+func (r *connRegistry) remove(conn *ThrottledConn) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.conns, conn)
+}
+
+// all returns the open connections at the time of the call
+func (r *connRegistry) all() []*ThrottledConn {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	conns := make([]*ThrottledConn, 0, len(r.conns))
+	for conn := range r.conns {
+		conns = append(conns, conn)
+	}
+
+	return conns
+}
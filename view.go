@@ -0,0 +1,108 @@
+package netlistener
+
+import (
+	"slices"
+
+	"golang.org/x/time/rate"
+)
+
+// ViewOption overrides a per connection default of a listener view, see Listener.WithOptions
+type ViewOption func(d *connDefaults)
+
+// connDefaults are the per connection defaults of a listener view, applied to the connections it wraps
+type connDefaults struct {
+	// perConnLimit replaces the per connection limit of the listener if perConnLimitSet, nil means unlimited
+	perConnLimit    *int
+	perConnLimitSet bool
+	class           string
+	profile         string
+	tags            []string
+}
+
+// WithDefaultPerConnLimit replaces the per connection limit of the listener for the connections of the view, nil means unlimited.
+// Limits of the classification, the profile and the class still take precedence
+func WithDefaultPerConnLimit(limit *int) ViewOption {
+	return func(d *connDefaults) {
+		d.perConnLimit, d.perConnLimitSet = limit, true
+	}
+}
+
+// WithDefaultClass assigns the class to the connections of the view the classifier did not assign one
+func WithDefaultClass(class string) ViewOption {
+	return func(d *connDefaults) {
+		d.class = class
+	}
+}
+
+// WithDefaultProfile assigns the profile to the connections of the view the classifier did not assign one
+func WithDefaultProfile(profile string) ViewOption {
+	return func(d *connDefaults) {
+		d.profile = profile
+	}
+}
+
+// WithDefaultTags adds the tags to the classification of the connections of the view, e.g. the name of the logical service
+func WithDefaultTags(tags ...string) ViewOption {
+	return func(d *connDefaults) {
+		d.tags = append(d.tags, tags...)
+	}
+}
+
+// WithOptions returns a view of the listener sharing its underlying listener, limits, classes, stats and registry,
+// whose connections get different per connection defaults, e.g. when one accept loop serves two logical services.
+// Connections accepted or wrapped through the view get its defaults, the options of a view of a view add to the ones of its parent.
+// Closing a view closes the shared listener. A view accepts directly, without the accept pool of the listener
+func (l *Listener) WithOptions(opts ...ViewOption) *Listener {
+	defaults := &connDefaults{}
+	if l.defaults != nil {
+		*defaults = *l.defaults
+		defaults.tags = slices.Clone(l.defaults.tags)
+	}
+	for _, opt := range opts {
+		opt(defaults)
+	}
+
+	return &Listener{
+		Listener: l.Listener,
+		config:   l.config,
+		defaults: defaults,
+	}
+}
+
+// apply binds the connection to the defaults and assigns them to its classification
+func (d *connDefaults) apply(config *ConnConfig) {
+	if d == nil {
+		return
+	}
+
+	config.defaults = d
+	config.SetClassification(d.fill(config.Classification()))
+}
+
+// fill assigns the defaults to a classification where the classifier left them empty
+func (d *connDefaults) fill(classification Classification) Classification {
+	if d == nil {
+		return classification
+	}
+
+	if classification.Class == "" {
+		classification.Class = d.class
+	}
+	if classification.Profile == "" {
+		classification.Profile = d.profile
+	}
+	if len(d.tags) > 0 {
+		classification.Tags = append(slices.Clone(d.tags), classification.Tags...)
+	}
+
+	return classification
+}
+
+// defaultPerConnLimit returns the per connection limit of the view the connection was wrapped by, if it replaces the one of the listener
+func (c *ConnConfig) defaultPerConnLimit() (rate.Limit, bool) {
+	if c.defaults == nil || !c.defaults.perConnLimitSet {
+		return 0, false
+	}
+
+	return formatRateLimit(c.defaults.perConnLimit), true
+}
@@ -0,0 +1,87 @@
+package netlistener
+
+import (
+	"net"
+	"slices"
+	"testing"
+)
+
+func TestListener_WithOptions(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Failed to create listener", err)
+	}
+	defer listener.Close()
+
+	throttledListener, _ := NewListener(listener, nil, ptr(100))
+	if err := throttledListener.SetClasses([]ClassConfig{{Name: "api", Rate: 1000}, {Name: "video", Rate: 1000}}, ""); err != nil {
+		t.Fatal(err)
+	}
+	throttledListener.SetClassifier(ClassifierFunc(func(meta ConnMetadata) Classification {
+		if meta.RemoteAddr.String() == "192.0.2.1:1234" {
+			return Classification{Class: "video"}
+		}
+		return Classification{}
+	}))
+
+	api := throttledListener.WithOptions(WithDefaultClass("api"), WithDefaultPerConnLimit(ptr(500)), WithDefaultTags("api"))
+
+	tests := []struct {
+		name     string
+		listener *Listener
+		remote   net.Addr
+		// expected are the class, the tags and the per connection limit of the wrapped connection
+		expectedClass string
+		expectedTags  []string
+		expectedLimit *int
+	}{
+		{name: "Parent is unaffected", listener: throttledListener, expectedLimit: ptr(100)},
+		{name: "View defaults", listener: api, expectedClass: "api", expectedTags: []string{"api"}, expectedLimit: ptr(500)},
+		{name: "Classifier overrides the default class", listener: api,
+			remote: &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 1234}, expectedClass: "video", expectedTags: []string{"api"}, expectedLimit: ptr(500)},
+		{name: "View of a view", listener: api.WithOptions(WithDefaultPerConnLimit(NoLimit()), WithDefaultTags("internal")),
+			expectedClass: "api", expectedTags: []string{"api", "internal"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			connRead, connWrite := net.Pipe()
+			go readDataFromConn(connRead)
+
+			var conn net.Conn = connWrite
+			if tt.remote != nil {
+				conn = &addrConn{Conn: connWrite, remoteAddr: tt.remote}
+			}
+			wrapped, err := tt.listener.WrapConn(conn)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer wrapped.Close()
+
+			throttled := wrapped.(*ThrottledConn)
+			if _, err := throttled.Write(make([]byte, 10)); err != nil {
+				t.Fatal(err)
+			}
+
+			classification := throttled.config.Classification()
+			if classification.Class != tt.expectedClass {
+				t.Errorf("expected the class %q, got %q", tt.expectedClass, classification.Class)
+			}
+			if class := throttled.config.class(); tt.expectedClass != "" && (class == nil || class.config.Name != tt.expectedClass) {
+				t.Errorf("expected the connection to be assigned to the class %q", tt.expectedClass)
+			}
+			if !slices.Equal(classification.Tags, tt.expectedTags) {
+				t.Errorf("expected the tags %v, got %v", tt.expectedTags, classification.Tags)
+			}
+
+			limit := throttled.ConnInfo().WriteLimit
+			if (limit == nil) != (tt.expectedLimit == nil) || (limit != nil && *limit != *tt.expectedLimit) {
+				t.Errorf("expected the limit %v, got %v", tt.expectedLimit, limit)
+			}
+		})
+	}
+
+	if accepted := throttledListener.Stats().AcceptedConns; accepted != int64(len(tests)) {
+		t.Errorf("expected the views to share the stats of the listener, got %d accepted connections", accepted)
+	}
+}
@@ -0,0 +1,64 @@
+package netlistener
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+// inWarmup reports whether the connection is still within the warm-up exemption, in which case it is not throttled.
+// Once the connection transferred more than the exemption in total, the bytes transferred so far are charged
+// retroactively, so only connections staying below the exemption for their whole lifetime are never throttled.
+// Each direction is charged by its next operation within the deadline and the context of the operation,
+// a charge ended early is continued by the operation after it
+func (c *ThrottledConn) inWarmup(ctx context.Context, read bool, deadline time.Time) (bool, error) {
+	exemption := c.config.globalConfig.WarmupExemption()
+	if exemption <= 0 {
+		return false, nil
+	}
+
+	if !c.warmedUp.Load() {
+		bytesRead, bytesWritten := c.bytesRead.Load(), c.bytesWritten.Load()
+		if bytesRead+bytesWritten < exemption {
+			return true, nil
+		}
+
+		if c.warmedUp.CompareAndSwap(false, true) {
+			preamble := c.config.globalConfig.PreambleExemption()
+			c.warmupRead.Store(max(bytesRead-preamble, 0))
+			c.warmupWritten.Store(max(bytesWritten-preamble, 0))
+		}
+	}
+
+	return false, c.chargeWarmup(ctx, read, deadline)
+}
+
+// chargeWarmup charges the bytes of the direction transferred during the warm-up which were not charged yet,
+// in chunks not exceeding the burst of the limiters
+func (c *ThrottledConn) chargeWarmup(ctx context.Context, read bool, deadline time.Time) error {
+	uncharged := &c.warmupWritten
+	if read {
+		uncharged = &c.warmupRead
+	}
+
+	for {
+		n := uncharged.Load()
+		if n <= 0 {
+			return nil
+		}
+
+		changed := c.config.globalConfig.limitUpdates.Changed()
+		limiters := c.activeLimiters(read)
+		chunk := c.maxChunk(limiters, int(min(n, math.MaxInt32)))
+
+		err := c.waitContext(ctx, changed, limiters, chunk, deadline)
+		if err == errLimitsChanged {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		uncharged.Add(-int64(chunk))
+	}
+}
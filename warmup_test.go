@@ -0,0 +1,122 @@
+package netlistener
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestRateLimitedConnection_WarmupExemption(t *testing.T) {
+	tests := []struct {
+		name          string
+		exemption     int64
+		writes        int
+		assertionFunc func(t *testing.T, elapsed time.Duration)
+	}{
+		{
+			name:      "Connection below the exemption is not throttled",
+			exemption: 500,
+			writes:    8,
+			assertionFunc: func(t *testing.T, elapsed time.Duration) {
+				if elapsed > 500*time.Millisecond {
+					t.Errorf("expected connection below the exemption not to be throttled, took %s", elapsed)
+				}
+			},
+		},
+		{
+			name:      "Connection exceeding the exemption is charged retroactively",
+			exemption: 100,
+			writes:    4,
+			assertionFunc: func(t *testing.T, elapsed time.Duration) {
+				if elapsed < 2500*time.Millisecond || elapsed > 4*time.Second {
+					t.Errorf("expected bytes below the exemption to be charged once it was exceeded, took %s", elapsed)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			config := NewBandwithConfig(nil, ptr(50))
+			config.SetWarmupExemption(tt.exemption)
+
+			connRead, connWrite := net.Pipe()
+			conn := NewThrottledConnection(connWrite, NewConnectionBandwithConfig(config))
+			defer conn.Close()
+			go readDataFromConn(connRead)
+
+			// 50 bytes per write at 50 B/s with a burst of 50, when exceeding the exemption before the third write
+			// its 100 bytes are charged first, so the remaining 200 bytes take 3 seconds
+			start := time.Now()
+			for i := 0; i < tt.writes; i++ {
+				if _, err := conn.Write(make([]byte, 50)); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			tt.assertionFunc(t, time.Since(start))
+		})
+	}
+}
+
+func TestRateLimitedConnection_WarmupChargeInterrupted(t *testing.T) {
+	tests := []struct {
+		name  string
+		write func(conn *ThrottledConn) error
+		err   error
+	}{
+		{
+			name: "Deadline ends the charge",
+			write: func(conn *ThrottledConn) error {
+				if err := conn.SetWriteDeadline(time.Now().Add(100 * time.Millisecond)); err != nil {
+					return err
+				}
+				_, err := conn.Write(make([]byte, 50))
+				return err
+			},
+			err: os.ErrDeadlineExceeded,
+		},
+		{
+			name: "Cancelled context ends the charge",
+			write: func(conn *ThrottledConn) error {
+				ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+				defer cancel()
+				_, err := conn.WriteContext(ctx, make([]byte, 50))
+				return err
+			},
+			err: ErrThrottleCancelled,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			config := NewBandwidthConfig(nil, ptr(50))
+			config.SetWarmupExemption(1000)
+
+			connRead, connWrite := net.Pipe()
+			conn := NewThrottledConnection(connWrite, NewConnConfig(config))
+			defer conn.Close()
+			go readDataFromConn(connRead)
+
+			if _, err := conn.Write(make([]byte, 1000)); err != nil {
+				t.Fatal(err)
+			}
+
+			// charging the 1000 bytes of the warm-up at 50 B/s takes about 19 seconds
+			start := time.Now()
+			if err := tt.write(conn); !errors.Is(err, tt.err) {
+				t.Fatalf("expected %v, got %v", tt.err, err)
+			}
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Errorf("expected the charge to end with the operation, took %s", elapsed)
+			}
+		})
+	}
+}